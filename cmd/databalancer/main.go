@@ -1,37 +1,239 @@
 package main
 
 import (
+	"context"
 	"flag"
+	"fmt"
 	"log"
+	"log/slog"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
 
 	"github.com/kolide/databalancer-logan/pkg/logs"
+	"github.com/kolide/databalancer-logan/pkg/memdb"
 	"github.com/kolide/databalancer-logan/pkg/mysql"
+	"github.com/kolide/databalancer-logan/pkg/postgres"
 	"github.com/kolide/databalancer-logan/pkg/server"
 )
 
 func main() {
 	// Key variables are set as command-line flags
+	driver := flag.String("driver", "mysql", "The database to store logs in: mysql, postgres (which uses the mysql_* connection flags) or memory (which keeps the logs in memory, for demos)")
 	dbUsername := flag.String("mysql_username", "root", "The MySQL user account username")
 	dbPassword := flag.String("mysql_password", "", "The MySQL user account password")
 	dbAddress := flag.String("mysql_address", "localhost:3306", "The MySQL server address")
+	dbReplicaAddress := flag.String("mysql_replica_address", "", "The address of a MySQL read replica that queries and table descriptions are sent to, with the other connection flags (empty to read from the server)")
 	dbName := flag.String("mysql_database", "databalancer", "The MySQL database to use")
+	dbTLS := flag.String("mysql_tls", "", "Connect to MySQL with TLS: true, or skip-verify to trust any certificate (empty for no TLS)")
+	dbTLSCA := flag.String("mysql_tls_ca", "", "The PEM file of the CA certificates trusted to sign the certificate of MySQL, which enables TLS")
+	dbBatchSize := flag.Int("mysql_batch_size", mysql.DefaultBatchSize, "The maximum number of logs inserted per MySQL statement")
+	dbConnectTimeout := flag.Duration("mysql_connect_timeout", mysql.DefaultConnectTimeout, "The maximum time to wait for MySQL to answer on startup (0 to try once)")
+	dbMaxOpenConns := flag.Int("mysql_max_open_conns", mysql.DefaultConnPool.MaxOpen, "The maximum number of open connections to MySQL (0 for no limit)")
+	dbMaxIdleConns := flag.Int("mysql_max_idle_conns", mysql.DefaultConnPool.MaxIdle, "The maximum number of idle connections to MySQL kept open")
+	dbConnMaxLifetime := flag.Duration("mysql_conn_max_lifetime", mysql.DefaultConnPool.MaxLifetime, "The maximum time a MySQL connection is reused, which should be under the wait_timeout of the server (0 for no limit)")
+	dbStmtCacheSize := flag.Int("mysql_statement_cache_size", mysql.DefaultStatementCacheSize, "The maximum number of prepared statements reused for repeated queries and inserts (0 to disable)")
+	dbSharedTable := flag.String("mysql_shared_table", "", "Store all log families in this single table, rather than a table per family")
+	dbSchemaTable := flag.String("mysql_schema_table", "", "Record the schema of the table of each log family in this table, so that a family ingested again with the same schema skips checking its columns (empty to check them on every ingest)")
+	dbShards := flag.String("mysql_shards", "", "Comma-separated prefix=address pairs, storing the log families whose names start with the prefix on the MySQL server at the address, with the other connection flags, ie: audit_=localhost:3307 (empty to store every family on the server)")
+	dbTablePrefix := flag.String("mysql_table_prefix", "", "Prefix the table of each log family with this prefix, ie: prod_ (empty for no prefix)")
 	serverAddress := flag.String("server_address", ":8080", "The address and port to serve the local HTTP server")
+	corsOrigins := flag.String("cors_origins", "", "Comma-separated origins allowed to call the API from a browser, ie: https://ui.example.com, or * for any origin (empty to disable CORS)")
+	corsMethods := flag.String("cors_methods", "", "Comma-separated methods allowed in cross-origin requests (empty for the methods of each endpoint)")
+	corsHeaders := flag.String("cors_headers", strings.Join(server.DefaultCORSHeaders, ","), "Comma-separated request headers allowed in cross-origin requests")
+	bodyMaxBytes := flag.Int64("body_max_bytes", server.DefaultMaxBodyBytes, "The maximum size in bytes of a request body (0 for no limit)")
+	jsonMaxDepth := flag.Int("json_max_depth", server.DefaultMaxJSONDepth, "The maximum nesting depth of a JSON request body (0 for no limit)")
+	jsonMaxArray := flag.Int("json_max_array", server.DefaultMaxJSONArray, "The maximum number of elements in any array of a JSON request body (0 for no limit)")
+	healthzTimeout := flag.Duration("healthz_timeout", logs.DefaultPingTimeout, "The maximum time the health check waits for MySQL to answer")
+	ingestDedupWindow := flag.Duration("ingest_dedup_window", 0, "Skip logs identical to a log of the same family ingested within this window (0 to disable)")
+	ingestDedupMaxLogs := flag.Int("ingest_dedup_max_logs", logs.DefaultDedupMaxLogs, "The maximum number of logs remembered by the dedup window, forgetting the least recently ingested first (0 for no limit)")
+	ingestAllErrors := flag.Bool("ingest_all_errors", false, "Report every field of the logs that doesn't match the schema, rather than the first one")
+	ingestBatchSize := flag.Int("ingest_batch_size", server.DefaultIngestBatchSize, "The number of logs of an ingest request decoded and ingested at once, streaming larger requests in batches (0 to decode every request at once)")
+	ingestMaxLogs := flag.Int("ingest_max_logs", server.DefaultMaxIngestLogs, "The maximum number of logs of an ingest request (0 for no limit)")
+	ingestEventTimeField := flag.String("ingest_event_time_field", "", "The log field with the event time (unix seconds or RFC 3339), used to report ingest lag")
+	ingestOverflow := flag.Bool("ingest_overflow", false, "Keep the log fields missing from the schema in an extra json column, rather than rejecting the logs")
+	ingestReturnIDs := flag.Bool("ingest_return_ids", false, "Return the generated ids of ingested logs (inserts logs one at a time)")
+	queryInternalColumns := flag.String("query_internal_columns", strings.Join(logs.DefaultInternalColumns, ","), "Comma-separated columns hidden from query results unless selected by name")
+	queryMaxRows := flag.Int("query_max_rows", logs.DefaultMaxRows, "The maximum number of rows a query can return (0 for no limit)")
+	querySlowThreshold := flag.Duration("query_slow_threshold", logs.DefaultSlowQueryThreshold, "Log a warning with the SQL of each query running for longer than this threshold (0 to disable)")
+	queryTimeout := flag.Duration("query_timeout", logs.DefaultQueryTimeout, "The maximum time a query can run before it's cancelled (0 for no limit)")
 
 	flag.Parse()
 
-	// Using data from command-line flags, we create a MySQL client
-	dbClient, err := mysql.CreateClient(*dbUsername, *dbPassword, *dbAddress, *dbName)
+	// the logger shared by the database client, the service and the server
+	logger := slog.New(slog.NewTextHandler(os.Stderr, nil))
+
+	shardAddresses, err := parseShards(*dbShards)
 	if err != nil {
-		log.Fatalf("Failed connecting to MySQL: %+v", err)
+		log.Fatalf("Invalid shards: %v", err)
+	}
+
+	// Using data from command-line flags, we create a database client, and a
+	// client per shard
+	var dbClient logs.DBClient
+	var shards []logs.Shard
+	switch *driver {
+	case "mysql":
+		dbOpts := []mysql.Option{
+			mysql.WithBatchSize(*dbBatchSize),
+			mysql.WithConnectTimeout(*dbConnectTimeout),
+			mysql.WithConnPool(mysql.ConnPool{
+				MaxOpen:     *dbMaxOpenConns,
+				MaxIdle:     *dbMaxIdleConns,
+				MaxLifetime: *dbConnMaxLifetime,
+			}),
+			mysql.WithStatementCacheSize(*dbStmtCacheSize),
+			mysql.WithLogger(logger),
+		}
+		if *dbSharedTable != "" {
+			dbOpts = append(dbOpts, mysql.WithSharedTable(*dbSharedTable))
+		}
+		if *dbSchemaTable != "" {
+			dbOpts = append(dbOpts, mysql.WithSchemaTable(*dbSchemaTable))
+		}
+		if *dbTablePrefix != "" {
+			if *dbSharedTable != "" {
+				log.Fatalf("The table prefix can't be used with a shared table")
+			}
+			dbOpts = append(dbOpts, mysql.WithTablePrefix(*dbTablePrefix))
+		}
+		config := mysql.Config{
+			Username:       *dbUsername,
+			Password:       *dbPassword,
+			Address:        *dbAddress,
+			Database:       *dbName,
+			TLS:            *dbTLS,
+			TLSCAFile:      *dbTLSCA,
+			ReplicaAddress: *dbReplicaAddress,
+		}
+		client, err := mysql.NewClient(config, dbOpts...)
+		if err != nil {
+			log.Fatalf("Failed connecting to MySQL: %+v", err)
+		}
+		dbClient = client
+
+		// the shards don't have the read replica of the server
+		config.ReplicaAddress = ""
+		for _, shard := range shardAddresses {
+			config.Address = shard.address
+			client, err := mysql.NewClient(config, dbOpts...)
+			if err != nil {
+				log.Fatalf("Failed connecting to MySQL shard %s: %+v", shard.address, err)
+			}
+			shards = append(shards, logs.Shard{Prefix: shard.prefix, DB: client})
+		}
+	case "postgres":
+		if *dbSharedTable != "" {
+			log.Fatalf("The shared table mode is only supported by the mysql driver")
+		}
+		if *dbTablePrefix != "" {
+			log.Fatalf("The table prefix is only supported by the mysql driver")
+		}
+		if *dbSchemaTable != "" {
+			log.Fatalf("The schema table is only supported by the mysql driver")
+		}
+		if *dbReplicaAddress != "" {
+			log.Fatalf("The read replica is only supported by the mysql driver")
+		}
+		if len(shardAddresses) > 0 {
+			log.Fatalf("The shards are only supported by the mysql driver")
+		}
+		if *dbTLS != "" || *dbTLSCA != "" {
+			log.Fatalf("TLS is only supported by the mysql driver")
+		}
+		client, err := postgres.CreateClient(*dbUsername, *dbPassword, *dbAddress, *dbName,
+			postgres.WithBatchSize(*dbBatchSize),
+			postgres.WithLogger(logger),
+		)
+		if err != nil {
+			log.Fatalf("Failed connecting to Postgres: %+v", err)
+		}
+		dbClient = client
+	case "memory":
+		if len(shardAddresses) > 0 {
+			log.Fatalf("The shards are only supported by the mysql driver")
+		}
+		dbClient = memdb.New()
+	default:
+		log.Fatalf("Unknown driver %q, expected mysql, postgres or memory", *driver)
 	}
 
 	// create the logs service with the database client
-	logSvc := logs.CreateService(dbClient)
+	logSvc := logs.CreateService(dbClient,
+		logs.WithMaxRows(*queryMaxRows),
+		logs.WithQueryTimeout(*queryTimeout),
+		logs.WithSlowQueryThreshold(*querySlowThreshold),
+		logs.WithReturnIDs(*ingestReturnIDs),
+		logs.WithOverflow(*ingestOverflow),
+		logs.WithAllFieldErrors(*ingestAllErrors),
+		logs.WithDedupWindow(*ingestDedupWindow),
+		logs.WithDedupMaxLogs(*ingestDedupMaxLogs),
+		logs.WithPingTimeout(*healthzTimeout),
+		logs.WithEventTimeField(*ingestEventTimeField),
+		logs.WithInternalColumns(splitList(*queryInternalColumns)...),
+		logs.WithShards(shards...),
+		logs.WithLogger(logger),
+	)
+
+	// serve until interrupted, so that the requests in progress can finish
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
 
 	// Now that we have performed all required flag parsing and state
 	// initialization, we create and launch our HTTP web server for our
 	// micro-service
-	if err := server.HTTP(*serverAddress, logSvc); err != nil {
+	if err := server.HTTP(ctx, *serverAddress, logSvc,
+		server.WithJSONLimits(*jsonMaxDepth, *jsonMaxArray),
+		server.WithMaxBodyBytes(*bodyMaxBytes),
+		server.WithMaxIngestLogs(*ingestMaxLogs),
+		server.WithIngestBatchSize(*ingestBatchSize),
+		server.WithCORS(server.CORS{
+			Origins: splitList(*corsOrigins),
+			Methods: splitList(*corsMethods),
+			Headers: splitList(*corsHeaders),
+		}),
+		server.WithLogger(logger),
+	); err != nil {
+		logSvc.Close()
 		log.Fatalf("Failed to start server: %+v", err)
 	}
+
+	// the server is done, so release the connections to the database
+	if err := logSvc.Close(); err != nil {
+		log.Fatalf("Failed to close the database client: %+v", err)
+	}
+}
+
+// splitList splits a comma-separated flag value, ignoring empty values
+func splitList(list string) []string {
+	var values []string
+	for _, value := range strings.Split(list, ",") {
+		if value = strings.TrimSpace(value); value != "" {
+			values = append(values, value)
+		}
+	}
+	return values
+}
+
+// shardAddress is the address of the MySQL server storing the log families
+// whose names start with the prefix
+type shardAddress struct {
+	prefix  string
+	address string
+}
+
+// parseShards parses the comma-separated prefix=address pairs of the shards
+// flag
+func parseShards(list string) ([]shardAddress, error) {
+	var shards []shardAddress
+	for _, pair := range splitList(list) {
+		prefix, address, ok := strings.Cut(pair, "=")
+		prefix, address = strings.TrimSpace(prefix), strings.TrimSpace(address)
+		if !ok || prefix == "" || address == "" {
+			return nil, fmt.Errorf("expected prefix=address, got %q", pair)
+		}
+		shards = append(shards, shardAddress{prefix: prefix, address: address})
+	}
+	return shards, nil
 }
@@ -0,0 +1,49 @@
+package logs
+
+import (
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+	"github.com/xwb1989/sqlparser"
+)
+
+// ErrQueryArgs is returned when the args of a query don't match its `?`
+// placeholders
+var ErrQueryArgs = errors.New("query args don't match its placeholders")
+
+// FormatQuery formats a parsed statement back into SQL with `?` placeholders,
+// since the parser turns them into `:v1`, `:v2`, etc. It returns the args in
+// the order of the placeholders of the formatted query, and an error wrapping
+// `ErrQueryArgs` if there isn't exactly one arg per placeholder, or if the
+// statement has named placeholders (ie: `:name`).
+func FormatQuery(stmt sqlparser.SQLNode, args []interface{}) (string, []interface{}, error) {
+	var ordered []interface{}
+	var formatErr error
+	buf := sqlparser.NewTrackedBuffer(func(buf *sqlparser.TrackedBuffer, node sqlparser.SQLNode) {
+		val, ok := node.(*sqlparser.SQLVal)
+		if !ok || val.Type != sqlparser.ValArg {
+			node.Format(buf)
+			return
+		}
+		buf.WriteString("?")
+		name := strings.TrimPrefix(string(val.Val), ":")
+		n, err := strconv.Atoi(strings.TrimPrefix(name, "v"))
+		switch {
+		case !strings.HasPrefix(name, "v") || err != nil:
+			formatErr = errors.Wrapf(ErrQueryArgs, "named placeholder :%s isn't supported, use ?", name)
+		case n < 1 || n > len(args):
+			formatErr = errors.Wrapf(ErrQueryArgs, "query has more placeholders than the %d args", len(args))
+		default:
+			ordered = append(ordered, args[n-1])
+		}
+	})
+	buf.Myprintf("%v", stmt)
+	if formatErr != nil {
+		return "", nil, formatErr
+	}
+	if len(ordered) != len(args) {
+		return "", nil, errors.Wrapf(ErrQueryArgs, "query has %d placeholders for %d args", len(ordered), len(args))
+	}
+	return buf.String(), ordered, nil
+}
@@ -0,0 +1,40 @@
+package logs
+
+import "database/sql"
+
+// Column describes a column of the results of a query
+type Column struct {
+	Name string `json:"name"` // column name, or alias if it was selected with one
+	Type string `json:"type"` // database type name, ie: VARCHAR or BIGINT
+}
+
+// ColumnsOf returns the columns of rows, in the order they were selected
+func ColumnsOf(rows *sql.Rows) ([]Column, error) {
+	types, err := rows.ColumnTypes()
+	if err != nil {
+		return nil, err
+	}
+	columns := make([]Column, 0, len(types))
+	for _, t := range types {
+		columns = append(columns, Column{Name: t.Name(), Type: t.DatabaseTypeName()})
+	}
+	return columns, nil
+}
+
+// withoutColumns returns the columns except for the named ones
+func withoutColumns(columns []Column, names []string) []Column {
+	if len(names) == 0 {
+		return columns
+	}
+	kept := make([]Column, 0, len(columns))
+columns:
+	for _, column := range columns {
+		for _, name := range names {
+			if column.Name == name {
+				continue columns
+			}
+		}
+		kept = append(kept, column)
+	}
+	return kept
+}
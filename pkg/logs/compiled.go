@@ -0,0 +1,91 @@
+package logs
+
+import (
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/pkg/errors"
+)
+
+// compiledSchema is a schema with the types of its fields parsed, so that
+// the logs of a batch are validated and normalized without parsing the
+// schema again. It isn't modified once compiled.
+type compiledSchema struct {
+	schema   Schema               // schema as sent by the client
+	types    map[string]FieldType // field -> parsed type
+	required []string             // sorted fields logs must have
+}
+
+// compileSchema parses the types of the fields of a schema. A field with an
+// empty or unknown type is an error, rather than a column the table is
+// created without.
+func compileSchema(schema Schema) (*compiledSchema, error) {
+	compiled := &compiledSchema{
+		schema: schema,
+		types:  make(map[string]FieldType, len(schema)),
+	}
+	for field, fieldType := range schema {
+		if strings.TrimSpace(fieldType) == "" {
+			return nil, errors.Errorf("field %s has an empty type", field)
+		}
+		parsed, err := ParseFieldType(fieldType)
+		if err != nil {
+			return nil, errors.Wrapf(err, "parsing type of field %s", field)
+		}
+		if !SupportedType(parsed.Name) {
+			return nil, errors.Errorf("field %s has unknown type %q", field, fieldType)
+		}
+		compiled.types[field] = parsed
+		if parsed.Required {
+			compiled.required = append(compiled.required, field)
+		}
+	}
+	sort.Strings(compiled.required)
+	return compiled, nil
+}
+
+// matches reports whether the schema was compiled from the same fields and
+// types as the given schema
+func (c *compiledSchema) matches(schema Schema) bool {
+	if len(c.schema) != len(schema) {
+		return false
+	}
+	for field, fieldType := range schema {
+		if compiledType, ok := c.schema[field]; !ok || compiledType != fieldType {
+			return false
+		}
+	}
+	return true
+}
+
+// schemaCache keeps the last compiled schema of each family, since clients
+// send the same schema with every batch of a family. A family's schema is
+// compiled again when it changes.
+type schemaCache struct {
+	mu       sync.Mutex
+	schemas  map[Family]*compiledSchema
+	compiles int // number of schemas compiled
+}
+
+// newSchemaCache creates an empty schema cache
+func newSchemaCache() *schemaCache {
+	return &schemaCache{schemas: make(map[Family]*compiledSchema)}
+}
+
+// get returns the compiled schema of the family, compiling it if the family
+// has no compiled schema yet or if its schema changed
+func (c *schemaCache) get(family Family, schema Schema) (*compiledSchema, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if compiled, ok := c.schemas[family]; ok && compiled.matches(schema) {
+		return compiled, nil
+	}
+	compiled, err := compileSchema(schema)
+	if err != nil {
+		return nil, err
+	}
+	c.compiles++
+	c.schemas[family] = compiled
+	return compiled, nil
+}
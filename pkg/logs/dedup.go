@@ -0,0 +1,109 @@
+package logs
+
+import (
+	"container/list"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// dedup remembers the content hashes of ingested logs for a window of time,
+// so that logs resent within the window (ie: by a client retrying a request)
+// can be skipped rather than inserted twice.
+// NOTE: there's no metadata store shared by the instances of the service yet,
+// so the hashes are kept in memory. A log resent to another instance, or
+// after a restart, is inserted again. To bound the memory of the hashes, at
+// most `maxLogs` are remembered, forgetting the least recently ingested first.
+type dedup struct {
+	mu      sync.Mutex
+	window  time.Duration
+	maxLogs int                      // most hashes remembered, 0 for no limit
+	seen    map[string]*list.Element // log hash -> its entry in recent
+	recent  *list.List               // entries of the remembered hashes, least recently ingested first
+}
+
+// dedupEntry is a hash remembered by a dedup
+type dedupEntry struct {
+	hash     string
+	ingested time.Time // time the log was ingested
+}
+
+// newDedup creates a dedup remembering at most maxLogs logs for the window
+func newDedup(window time.Duration, maxLogs int) *dedup {
+	return &dedup{window: window, maxLogs: maxLogs, seen: make(map[string]*list.Element), recent: list.New()}
+}
+
+// filter returns the logs that weren't ingested within the window, along
+// with their hashes, which should be marked once they're ingested. Logs
+// repeated within the batch are only returned once.
+func (d *dedup) filter(family Family, logs JSON, now time.Time) (JSON, []string, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	var unseen JSON
+	var hashes []string
+	batch := make(map[string]bool)
+	for _, logEvent := range logs {
+		hash, err := logHash(family, logEvent)
+		if err != nil {
+			return nil, nil, err
+		}
+		if seen, ok := d.seen[hash]; ok && now.Sub(seen.Value.(*dedupEntry).ingested) < d.window {
+			continue
+		}
+		if batch[hash] {
+			continue
+		}
+		batch[hash] = true
+		unseen = append(unseen, logEvent)
+		hashes = append(hashes, hash)
+	}
+	return unseen, hashes, nil
+}
+
+// mark remembers the hashes as ingested now, and forgets the hashes that
+// are outside of the window, or the least recently ingested ones past the
+// maximum number of hashes
+func (d *dedup) mark(hashes []string, now time.Time) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	for front := d.recent.Front(); front != nil && now.Sub(front.Value.(*dedupEntry).ingested) >= d.window; front = d.recent.Front() {
+		d.forget(front)
+	}
+	for _, hash := range hashes {
+		if seen, ok := d.seen[hash]; ok {
+			seen.Value.(*dedupEntry).ingested = now
+			d.recent.MoveToBack(seen)
+			continue
+		}
+		d.seen[hash] = d.recent.PushBack(&dedupEntry{hash: hash, ingested: now})
+	}
+	for d.maxLogs > 0 && d.recent.Len() > d.maxLogs {
+		d.forget(d.recent.Front())
+	}
+}
+
+// forget forgets the hash of an entry
+func (d *dedup) forget(entry *list.Element) {
+	delete(d.seen, entry.Value.(*dedupEntry).hash)
+	d.recent.Remove(entry)
+}
+
+// logHash returns a hash of the family and content of a log. Maps are
+// encoded with sorted keys, so the order of the fields doesn't matter.
+func logHash(family Family, logEvent map[string]interface{}) (string, error) {
+	content, err := json.Marshal(logEvent)
+	if err != nil {
+		return "", errors.Wrapf(err, "hashing %s log", family)
+	}
+	hash := sha256.New()
+	hash.Write([]byte(family))
+	hash.Write([]byte{0})
+	hash.Write(content)
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
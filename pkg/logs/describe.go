@@ -0,0 +1,74 @@
+package logs
+
+import "strings"
+
+// UnknownSchemaType is the schema type of a column whose database type
+// doesn't match any type logs can be ingested with, ie: a `DATETIME`
+// column. It isn't a supported type, so ingesting logs with it
+// fails rather than silently converting their values.
+const UnknownSchemaType = "unknown"
+
+// AnyColumnComment is the comment of the columns of `any` fields. They're
+// stored in JSON columns like `json` fields, so the comment tells them apart
+// when a table is described, or its schema is read to ingest logs again.
+const AnyColumnComment = "databalancer:any"
+
+// schemaTypes are the schema types of the database column types, for MySQL
+// and Postgres
+var schemaTypes = map[string]string{
+	// strings
+	"text":              "string",
+	"tinytext":          "string",
+	"mediumtext":        "string",
+	"longtext":          "string",
+	"varchar":           "string",
+	"char":              "string",
+	"character varying": "string",
+	"character":         "string",
+
+	// integers
+	"int":       "int",
+	"integer":   "int",
+	"tinyint":   "int",
+	"smallint":  "int",
+	"mediumint": "int",
+	"bigint":    "bigint",
+
+	// decimals
+	"decimal": "decimal",
+	"numeric": "decimal",
+
+	// json
+	"json":  "json",
+	"jsonb": "json",
+}
+
+// SchemaType returns the schema type logs are ingested with for a database
+// column type, ie: "string" for `TEXT` or `VARCHAR`, or `UnknownSchemaType`
+// if no schema type matches it
+func SchemaType(columnType string) string {
+	if schemaType, ok := schemaTypes[strings.ToLower(strings.TrimSpace(columnType))]; ok {
+		return schemaType
+	}
+	return UnknownSchemaType
+}
+
+// withSchemaTypes adds the schema type of each column of the described
+// tables as its `schema_type`, next to its database `type`, so that a family
+// can be ingested again with the types it was described with. A column the
+// database already gave a schema type, like the column of an `any` field,
+// keeps it.
+func withSchemaTypes(tables JSON) JSON {
+	for _, table := range tables {
+		columns, _ := table["columns"].([]map[string]interface{})
+		for _, column := range columns {
+			if _, ok := column["schema_type"]; ok {
+				continue
+			}
+			if columnType, ok := column["type"].(string); ok {
+				column["schema_type"] = SchemaType(columnType)
+			}
+		}
+	}
+	return tables
+}
@@ -0,0 +1,81 @@
+package logs_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/kolide/databalancer-logan/pkg/logs"
+	"github.com/stretchr/testify/assert"
+)
+
+// describedDB is a database with the described tables
+type describedDB struct {
+	*mockDB
+	tables logs.JSON
+}
+
+func (d *describedDB) DescribeDatabase(ctx context.Context) (logs.JSON, error) {
+	return d.tables, nil
+}
+
+func TestSchemaType(t *testing.T) {
+	cases := []struct {
+		columnType string
+		schemaType string
+	}{
+		// MySQL
+		{columnType: "text", schemaType: "string"},
+		{columnType: "mediumtext", schemaType: "string"},
+		{columnType: "varchar", schemaType: "string"},
+		{columnType: "char", schemaType: "string"},
+		{columnType: "int", schemaType: "int"},
+		{columnType: "tinyint", schemaType: "int"},
+		{columnType: "bigint", schemaType: "bigint"},
+		// Postgres
+		{columnType: "integer", schemaType: "int"},
+		{columnType: "character varying", schemaType: "string"},
+		// database type names are case insensitive
+		{columnType: "TEXT", schemaType: "string"},
+		{columnType: "INT", schemaType: "int"},
+		{columnType: "decimal", schemaType: "decimal"},
+		{columnType: "numeric", schemaType: "decimal"},
+		{columnType: "json", schemaType: "json"},
+		{columnType: "jsonb", schemaType: "json"},
+		// types logs can't be ingested with
+		{columnType: "datetime", schemaType: logs.UnknownSchemaType},
+		{columnType: "double", schemaType: logs.UnknownSchemaType},
+	}
+
+	for _, tt := range cases {
+		t.Run(tt.columnType, func(t *testing.T) {
+			assert.Equal(t, tt.schemaType, logs.SchemaType(tt.columnType))
+		})
+	}
+}
+
+func TestDescribeLogsSchemaTypes(t *testing.T) {
+	// GIVEN a table with columns of several types
+	column := func(name, columnType string) map[string]interface{} {
+		return map[string]interface{}{"name": name, "nullable": true, "type": columnType}
+	}
+	db := &describedDB{mockDB: &mockDB{}, tables: logs.JSON{
+		{
+			"name":    "dog_registry",
+			"columns": []map[string]interface{}{column("name", "text"), column("weight", "int"), column("born", "datetime")},
+		},
+	}}
+	service := logs.CreateService(db)
+
+	// WHEN
+	tables, err := service.DescribeLogs(context.Background())
+
+	// THEN each column has the schema type it's ingested with
+	assert.NoError(t, err)
+	if assert.Len(t, tables, 1) {
+		columns := tables[0]["columns"].([]map[string]interface{})
+		assert.Equal(t, "text", columns[0]["type"])
+		assert.Equal(t, "string", columns[0]["schema_type"])
+		assert.Equal(t, "int", columns[1]["schema_type"])
+		assert.Equal(t, logs.UnknownSchemaType, columns[2]["schema_type"])
+	}
+}
@@ -0,0 +1,63 @@
+package logs
+
+import "github.com/pkg/errors"
+
+// ErrValidation is the kind of the errors returned when a request to the
+// service is invalid, ie: a log not matching its schema or a query which
+// isn't a single SELECT. Retrying the request won't help.
+var ErrValidation = errors.New("invalid request")
+
+// ErrNotFound is the kind of the errors returned when a request to the
+// service references something that doesn't exist, ie: a log family or a
+// saved query
+var ErrNotFound = errors.New("not found")
+
+// ErrUnavailable is the kind of the errors returned when the database is
+// temporarily unable to serve a request, ie: it has too many connections.
+// Retrying the request later may help.
+var ErrUnavailable = errors.New("database unavailable")
+
+// ErrDatabase is the kind of any other error returned by the service, which
+// comes from the database, ie: it can't be reached or rejected a statement
+var ErrDatabase = errors.New("database error")
+
+// Kind returns the kind of an error returned by the service, `ErrValidation`,
+// `ErrNotFound`, `ErrUnavailable` or `ErrDatabase`, so that the error can be
+// reported without knowing each of the errors of the service. It returns nil
+// for a nil error.
+func Kind(err error) error {
+	if err == nil {
+		return nil
+	}
+	cause := errors.Cause(err)
+	switch cause.(type) {
+	case *FieldError, FieldErrors:
+		return ErrValidation
+	}
+	switch cause {
+	case ErrValidation, ErrReadOnly, ErrEmptyQuery, ErrMultipleStatements, ErrQueryArgs, ErrInvalidFamily, ErrInvalidPage, ErrInvalidMerge, ErrInvalidSearch, ErrIncompatibleSchemas, ErrCrossShardQuery:
+		return ErrValidation
+	case ErrNotFound, ErrFamilyNotFound, ErrSavedQueryNotFound, ErrLogNotFound:
+		return ErrNotFound
+	case ErrUnavailable:
+		return ErrUnavailable
+	default:
+		return ErrDatabase
+	}
+}
+
+// invalid marks an error as a validation error, keeping its message, so that
+// its cause is `ErrValidation`
+func invalid(err error) error {
+	return &validationError{err}
+}
+
+// validationError is an error caused by an invalid request to the service
+type validationError struct {
+	error
+}
+
+// Cause implements the causer interface of `github.com/pkg/errors`
+func (e *validationError) Cause() error {
+	return ErrValidation
+}
@@ -0,0 +1,244 @@
+package logs_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/kolide/databalancer-logan/pkg/logs"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+)
+
+// downDB is a database which can't be reached
+type downDB struct {
+	*mockDB
+}
+
+var errConnectionRefused = errors.New("connection refused")
+
+func (d *downDB) QueryJSON(ctx context.Context, query string, args ...interface{}) (logs.JSON, []logs.Column, error) {
+	return nil, nil, errConnectionRefused
+}
+
+func (d *downDB) DescribeDatabase(ctx context.Context) (logs.JSON, error) {
+	return nil, errConnectionRefused
+}
+
+func (d *downDB) CountRows(ctx context.Context, family logs.Family) (int64, error) {
+	return 0, errConnectionRefused
+}
+
+func (d *downDB) CreateTable(ctx context.Context, family logs.Family, schema logs.Schema, unique []string) (logs.Table, error) {
+	return nil, errConnectionRefused
+}
+
+func (d *downDB) DropTable(ctx context.Context, family logs.Family) error {
+	return errConnectionRefused
+}
+
+func (d *downDB) TruncateTable(ctx context.Context, family logs.Family) error {
+	return errConnectionRefused
+}
+
+// describes a test case for the kind of an error returned by the service
+type errorKindCase struct {
+	name string
+	db   logs.DBClient
+	call func(service *logs.Service) error
+	kind error
+}
+
+func TestErrorKinds(t *testing.T) {
+	ctx := context.Background()
+	cases := []errorKindCase{
+		{
+			name: "ingesting a family with an invalid name is a validation error",
+			call: func(service *logs.Service) error {
+				_, err := service.Ingest(ctx, "dog registry", logs.Schema{"name": "string"}, nil, logs.JSON{{"name": "max"}})
+				return err
+			},
+			kind: logs.ErrValidation,
+		},
+		{
+			name: "ingesting logs not matching their schema is a validation error",
+			call: func(service *logs.Service) error {
+				_, err := service.Ingest(ctx, "dog_registry", logs.Schema{"weight": "int"}, nil, logs.JSON{{"weight": "heavy"}})
+				return err
+			},
+			kind: logs.ErrValidation,
+		},
+		{
+			name: "ingesting with an unknown type is a validation error",
+			call: func(service *logs.Service) error {
+				_, err := service.Ingest(ctx, "dog_registry", logs.Schema{"name": "blob"}, nil, logs.JSON{{"name": "max"}})
+				return err
+			},
+			kind: logs.ErrValidation,
+		},
+		{
+			name: "a query which doesn't parse is a validation error",
+			call: func(service *logs.Service) error {
+				_, _, err := service.Query(ctx, "SELECT FROM WHERE")
+				return err
+			},
+			kind: logs.ErrValidation,
+		},
+		{
+			name: "a query which isn't a SELECT is a validation error",
+			call: func(service *logs.Service) error {
+				_, _, err := service.Query(ctx, "DELETE FROM dog_registry")
+				return err
+			},
+			kind: logs.ErrValidation,
+		},
+		{
+			name: "saving a query without a name is a validation error",
+			call: func(service *logs.Service) error {
+				return service.SaveQuery(ctx, "", "SELECT * FROM dog_registry")
+			},
+			kind: logs.ErrValidation,
+		},
+		{
+			name: "running a missing saved query is a not found error",
+			call: func(service *logs.Service) error {
+				_, _, err := service.QuerySaved(ctx, "top_dogs", nil)
+				return err
+			},
+			kind: logs.ErrNotFound,
+		},
+		{
+			name: "dropping a missing family is a not found error",
+			call: func(service *logs.Service) error {
+				return service.DropFamily(ctx, "cat_registry")
+			},
+			kind: logs.ErrNotFound,
+		},
+		{
+			name: "truncating a missing family is a not found error",
+			call: func(service *logs.Service) error {
+				return service.TruncateFamily(ctx, "cat_registry")
+			},
+			kind: logs.ErrNotFound,
+		},
+		{
+			name: "describing a missing family is a not found error",
+			call: func(service *logs.Service) error {
+				_, err := service.DescribeFamily(ctx, "cat_registry")
+				return err
+			},
+			kind: logs.ErrNotFound,
+		},
+		{
+			name: "querying a database which is down is a database error",
+			db:   &downDB{&mockDB{}},
+			call: func(service *logs.Service) error {
+				_, _, err := service.Query(ctx, "SELECT * FROM dog_registry")
+				return err
+			},
+			kind: logs.ErrDatabase,
+		},
+		{
+			name: "ingesting into a database which is down is a database error",
+			db:   &downDB{&mockDB{}},
+			call: func(service *logs.Service) error {
+				_, err := service.Ingest(ctx, "dog_registry", logs.Schema{"name": "string"}, nil, logs.JSON{{"name": "max"}})
+				return err
+			},
+			kind: logs.ErrDatabase,
+		},
+		{
+			name: "ingesting a schema conflicting with the table is a validation error",
+			db:   &mockDB{createErr: errors.Wrap(logs.ErrIncompatibleSchemas, "field name of type int conflicts with existing column of type text")},
+			call: func(service *logs.Service) error {
+				_, err := service.Ingest(ctx, "dog_registry", logs.Schema{"name": "int"}, nil, logs.JSON{{"name": float64(3)}})
+				return err
+			},
+			kind: logs.ErrValidation,
+		},
+		{
+			name: "inserting into a database with too many connections is an unavailable error",
+			db:   &mockDB{insertErr: errors.Wrap(logs.ErrUnavailable, "too many connections")},
+			call: func(service *logs.Service) error {
+				_, err := service.Ingest(ctx, "dog_registry", logs.Schema{"name": "string"}, nil, logs.JSON{{"name": "max"}})
+				return err
+			},
+			kind: logs.ErrUnavailable,
+		},
+		{
+			name: "inserting into a database which rejects the statement is a database error",
+			db:   &mockDB{insertErr: errors.New("Error 1406: Data too long for column 'name'")},
+			call: func(service *logs.Service) error {
+				_, err := service.Ingest(ctx, "dog_registry", logs.Schema{"name": "string"}, nil, logs.JSON{{"name": "max"}})
+				return err
+			},
+			kind: logs.ErrDatabase,
+		},
+		{
+			name: "describing a database which is down is a database error",
+			db:   &downDB{&mockDB{}},
+			call: func(service *logs.Service) error {
+				_, err := service.DescribeLogs(ctx)
+				return err
+			},
+			kind: logs.ErrDatabase,
+		},
+		{
+			name: "counting a family of a database which is down is a database error",
+			db:   &downDB{&mockDB{}},
+			call: func(service *logs.Service) error {
+				_, err := service.CountFamily(ctx, "dog_registry")
+				return err
+			},
+			kind: logs.ErrDatabase,
+		},
+		{
+			name: "dropping a family of a database which is down is a database error",
+			db:   &downDB{&mockDB{}},
+			call: func(service *logs.Service) error {
+				return service.DropFamily(ctx, "dog_registry")
+			},
+			kind: logs.ErrDatabase,
+		},
+		{
+			name: "truncating a family of a database which is down is a database error",
+			db:   &downDB{&mockDB{}},
+			call: func(service *logs.Service) error {
+				return service.TruncateFamily(ctx, "dog_registry")
+			},
+			kind: logs.ErrDatabase,
+		},
+	}
+
+	for _, tt := range cases {
+		t.Run(tt.name, func(t *testing.T) {
+			// GIVEN
+			db := tt.db
+			if db == nil {
+				db = &mockDB{}
+			}
+			service := logs.CreateService(db)
+
+			// WHEN
+			err := tt.call(service)
+
+			// THEN
+			assert.Error(t, err)
+			assert.Equal(t, tt.kind, logs.Kind(err))
+		})
+	}
+}
+
+func TestErrorKindKeepsMessage(t *testing.T) {
+	// GIVEN
+	service := logs.CreateService(&mockDB{})
+
+	// WHEN a unique field isn't in the schema
+	_, err := service.Ingest(context.Background(), "dog_registry", logs.Schema{"name": "string"}, []string{"breed"}, logs.JSON{{"name": "max"}})
+
+	// THEN the error is a validation error, with its own message
+	assert.Equal(t, logs.ErrValidation, errors.Cause(err))
+	assert.Equal(t, "unique field breed of dog_registry is not in the schema", err.Error())
+
+	// AND a nil error has no kind
+	assert.Nil(t, logs.Kind(nil))
+}
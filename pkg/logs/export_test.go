@@ -0,0 +1,9 @@
+package logs
+
+// CompiledSchemas returns the number of schemas the service compiled, so that
+// tests can check that the schemas of repeated batches are reused
+func CompiledSchemas(s *Service) int {
+	s.schemas.mu.Lock()
+	defer s.schemas.mu.Unlock()
+	return s.schemas.compiles
+}
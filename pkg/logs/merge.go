@@ -0,0 +1,138 @@
+package logs
+
+import (
+	"context"
+	"path"
+	"sort"
+
+	"github.com/pkg/errors"
+)
+
+// DefaultSourceColumn is the column of a merged family with the family each
+// log was merged from, unless configured otherwise in the `MergeRequest`
+const DefaultSourceColumn = "source_family"
+
+// ErrInvalidMerge is returned when a merge request is missing its target or
+// sources, or merges a family into itself
+var ErrInvalidMerge = errors.New("invalid merge request")
+
+// ErrIncompatibleSchemas is returned when the families merged have a field of
+// conflicting types
+var ErrIncompatibleSchemas = errors.New("schemas of the families are incompatible")
+
+// MergeRequest describes families merged into a target family, which has the
+// union of their fields along with a column of the family of each log
+type MergeRequest struct {
+	Target       Family   `json:"target"`        // family the logs are merged into, created if needed
+	Sources      []Family `json:"sources"`       // families merged, along with those matching the pattern
+	Pattern      string   `json:"pattern"`       // glob of the names of the families merged, ie: `tmp_*`
+	SourceColumn string   `json:"source_column"` // column of the family of each log, defaults to DefaultSourceColumn
+	DropSources  bool     `json:"drop_sources"`  // whether the sources are dropped once merged
+}
+
+// MergeResult describes the outcome of merging families
+type MergeResult struct {
+	Merged  []Family `json:"merged"`            // families merged into the target
+	Dropped []Family `json:"dropped,omitempty"` // families dropped once merged
+	Missing []Family `json:"missing,omitempty"` // sources that don't exist, ie: dropped by a previous merge
+}
+
+// MergeFamilies merges the logs of the source families into the target
+// family, after checking that the fields of all the families are compatible.
+// Each source is merged on its own, replacing any logs of the source merged
+// previously, so a merge that failed midway can be resumed by sending the
+// same request again.
+func (s *Service) MergeFamilies(ctx context.Context, req MergeRequest) (MergeResult, error) {
+	var result MergeResult
+	if req.Target == "" {
+		return result, errors.Wrap(ErrInvalidMerge, "target family is empty")
+	}
+	if len(req.Sources) == 0 && req.Pattern == "" {
+		return result, errors.Wrap(ErrInvalidMerge, "no sources or pattern given")
+	}
+	if req.SourceColumn == "" {
+		req.SourceColumn = DefaultSourceColumn
+	}
+
+	sources, missing, err := s.mergeSources(ctx, req)
+	if err != nil {
+		return result, err
+	}
+	result.Missing = missing
+	if len(sources) == 0 {
+		return result, nil
+	}
+
+	// the tables are merged by the database, so they must be on the same one
+	db := s.dbFor(req.Target)
+	for _, source := range sources {
+		if s.dbFor(source) != db {
+			return result, errors.Wrapf(ErrInvalidMerge, "%s and %s are stored on different shards", source, req.Target)
+		}
+	}
+
+	if err := db.MergeTables(ctx, req.Target, sources, req.SourceColumn); err != nil {
+		if errors.Cause(err) == ErrIncompatibleSchemas {
+			return result, err
+		}
+		return result, errors.Wrapf(err, "merging families into %s", req.Target)
+	}
+	result.Merged = sources
+
+	if req.DropSources {
+		for _, source := range sources {
+			if err := db.DropTable(ctx, source); err != nil {
+				return result, errors.Wrapf(err, "dropping merged family %s", source)
+			}
+			result.Dropped = append(result.Dropped, source)
+		}
+	}
+	return result, nil
+}
+
+// mergeSources returns the existing families merged by the request, sorted by
+// name, along with the sources given that don't exist
+func (s *Service) mergeSources(ctx context.Context, req MergeRequest) ([]Family, []Family, error) {
+	tables, err := s.describeDatabases(ctx)
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "listing families")
+	}
+	existing := make(map[Family]bool)
+	for _, table := range tables {
+		if name, ok := table["name"].(string); ok {
+			existing[Family(name)] = true
+		}
+	}
+
+	merged := make(map[Family]bool)
+	var missing []Family
+	for _, source := range req.Sources {
+		if !existing[source] {
+			missing = append(missing, source)
+			continue
+		}
+		merged[source] = true
+	}
+	if req.Pattern != "" {
+		for family := range existing {
+			match, err := path.Match(req.Pattern, family.String())
+			if err != nil {
+				return nil, nil, errors.Wrapf(ErrInvalidMerge, "invalid pattern %s", req.Pattern)
+			}
+			// the target may match the pattern of its sources
+			if match && family != req.Target {
+				merged[family] = true
+			}
+		}
+	}
+	if merged[req.Target] {
+		return nil, nil, errors.Wrapf(ErrInvalidMerge, "can't merge %s into itself", req.Target)
+	}
+
+	var sources []Family
+	for family := range merged {
+		sources = append(sources, family)
+	}
+	sort.Slice(sources, func(i, j int) bool { return sources[i] < sources[j] })
+	return sources, missing, nil
+}
@@ -0,0 +1,69 @@
+package logs
+
+import "github.com/pkg/errors"
+
+// OverflowField is the field of the logs that keeps their fields missing
+// from the schema, when the service is configured with `WithOverflow`
+const OverflowField = "extra"
+
+// WithOverflow makes `Ingest` keep the fields of the logs missing from their
+// schema in the `extra` field of each log, which is added to the schema as a
+// json field, rather than rejecting the logs. A log that already has an
+// `extra` object keeps its fields along with the missing ones. Without it,
+// which is the default, a log with a field missing from the schema is
+// rejected.
+func WithOverflow(overflow bool) Option {
+	return func(s *Service) {
+		s.overflow = overflow
+	}
+}
+
+// overflowSchema returns the schema with the overflow field, which must be
+// json if the schema already has it, ie: the schema of an existing table
+func overflowSchema(family Family, schema Schema) (Schema, error) {
+	if fieldType, ok := schema[OverflowField]; ok {
+		if parsed, err := ParseFieldType(fieldType); err != nil || parsed.Name != "json" {
+			return nil, invalid(errors.Errorf("field %s of %s must be json, since it keeps the fields missing from the schema", OverflowField, family))
+		}
+		return schema, nil
+	}
+	overflowed := make(Schema, len(schema)+1)
+	for field, fieldType := range schema {
+		overflowed[field] = fieldType
+	}
+	overflowed[OverflowField] = "json"
+	return overflowed, nil
+}
+
+// overflowLogs moves the fields of the logs missing from the schema into the
+// overflow field of each log, along with an overflow value that isn't an
+// object
+func overflowLogs(schema *compiledSchema, logs JSON) JSON {
+	overflowed := make(JSON, 0, len(logs))
+	for _, logEvent := range logs {
+		existing, isObject := logEvent[OverflowField].(map[string]interface{})
+		kept := make(map[string]interface{}, len(logEvent))
+		extra := make(map[string]interface{}, len(existing))
+		for field, value := range existing {
+			extra[field] = value
+		}
+		for field, value := range logEvent {
+			if field == OverflowField {
+				if !isObject && value != nil {
+					extra[field] = value
+				}
+				continue
+			}
+			if _, ok := schema.types[field]; ok {
+				kept[field] = value
+			} else {
+				extra[field] = value
+			}
+		}
+		if len(extra) > 0 || isObject {
+			kept[OverflowField] = extra
+		}
+		overflowed = append(overflowed, kept)
+	}
+	return overflowed
+}
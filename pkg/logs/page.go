@@ -0,0 +1,55 @@
+package logs
+
+import (
+	"strconv"
+
+	"github.com/pkg/errors"
+	"github.com/xwb1989/sqlparser"
+)
+
+// ErrInvalidPage is returned when the page of a query can't be applied to it
+var ErrInvalidPage = errors.New("invalid page")
+
+// Page is a page of the results of a query, for `QueryPage`
+type Page struct {
+	Limit  int `json:"limit"`  // maximum number of rows of the page
+	Offset int `json:"offset"` // number of rows skipped before the page
+}
+
+// paginate adds the LIMIT clause of the page to the statement. A query with a
+// LIMIT of its own can't be paginated, since the pages would conflict with
+// it. A page with an offset but no limit gets the maximum rows of the
+// service as its limit, since MySQL requires a LIMIT with an OFFSET.
+func paginate(stmt *sqlparser.Select, page Page, maxRows int) error {
+	if page.Limit < 0 || page.Offset < 0 {
+		return errors.Wrap(ErrInvalidPage, "limit and offset can't be negative")
+	}
+	if stmt.Limit != nil {
+		return errors.Wrap(ErrInvalidPage, "query already has a LIMIT")
+	}
+	limit := page.Limit
+	if limit == 0 {
+		if maxRows <= 0 {
+			return errors.Wrap(ErrInvalidPage, "offset requires a limit")
+		}
+		limit = maxRows
+	}
+	stmt.Limit = &sqlparser.Limit{Rowcount: sqlparser.NewIntVal([]byte(strconv.Itoa(limit)))}
+	if page.Offset > 0 {
+		stmt.Limit.Offset = sqlparser.NewIntVal([]byte(strconv.Itoa(page.Offset)))
+	}
+	return nil
+}
+
+// appliedPage returns the page of a paginated statement, once its rows are
+// capped
+func appliedPage(stmt *sqlparser.Select) Page {
+	var page Page
+	if val, ok := stmt.Limit.Rowcount.(*sqlparser.SQLVal); ok {
+		page.Limit, _ = strconv.Atoi(string(val.Val))
+	}
+	if val, ok := stmt.Limit.Offset.(*sqlparser.SQLVal); ok {
+		page.Offset, _ = strconv.Atoi(string(val.Val))
+	}
+	return page
+}
@@ -0,0 +1,41 @@
+package logs
+
+import (
+	"github.com/pkg/errors"
+	"github.com/xwb1989/sqlparser"
+)
+
+// unsafeFunctions are functions that have side effects, read from the
+// server, take or wait on locks, so they're rejected even inside of a SELECT
+var unsafeFunctions = map[string]bool{
+	"sleep":             true,
+	"benchmark":         true,
+	"load_file":         true,
+	"get_lock":          true,
+	"release_lock":      true,
+	"release_all_locks": true,
+	"is_free_lock":      true,
+	"is_used_lock":      true,
+	"master_pos_wait":   true,
+	"source_pos_wait":   true,
+}
+
+// checkReadOnly walks a parsed SELECT, including its subqueries, and returns
+// an error wrapping `ErrReadOnly` if it calls an unsafe function or takes
+// locks on the rows it reads. `INTO OUTFILE` and `INTO DUMPFILE` aren't
+// accepted by the parser, so they never get here.
+func checkReadOnly(stmt *sqlparser.Select) error {
+	return sqlparser.Walk(func(node sqlparser.SQLNode) (bool, error) {
+		switch node := node.(type) {
+		case *sqlparser.FuncExpr:
+			if name := node.Name.Lowered(); unsafeFunctions[name] {
+				return false, errors.Wrapf(ErrReadOnly, "function %s() isn't allowed", name)
+			}
+		case *sqlparser.Select:
+			if node.Lock != "" {
+				return false, errors.Wrap(ErrReadOnly, "locking reads aren't allowed")
+			}
+		}
+		return true, nil
+	}, stmt)
+}
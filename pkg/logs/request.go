@@ -0,0 +1,31 @@
+package logs
+
+import (
+	"context"
+	"log/slog"
+)
+
+// requestIDKey is the context key of the ID of the request being handled
+type requestIDKey struct{}
+
+// WithRequestID returns a copy of the context with the ID of the request it
+// belongs to, which is added to the lines logged by the service for it
+func WithRequestID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, requestIDKey{}, id)
+}
+
+// RequestID returns the ID of the request of the context, or an empty
+// string if it doesn't belong to a request
+func RequestID(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey{}).(string)
+	return id
+}
+
+// loggerFor returns the logger of the service for the request of the
+// context, which adds its ID to each line
+func (s *Service) loggerFor(ctx context.Context) *slog.Logger {
+	if id := RequestID(ctx); id != "" {
+		return s.logger.With("request_id", id)
+	}
+	return s.logger
+}
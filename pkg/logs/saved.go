@@ -0,0 +1,77 @@
+package logs
+
+import (
+	"context"
+	"sync"
+
+	"github.com/pkg/errors"
+	"github.com/xwb1989/sqlparser"
+	"github.com/xwb1989/sqlparser/dependency/sqltypes"
+)
+
+// ErrSavedQueryNotFound is returned when no query was saved with a name
+var ErrSavedQueryNotFound = errors.New("saved query not found")
+
+// savedQueries is a registry of named queries, which can reference
+// parameters with `:name` placeholders.
+// NOTE: there's no metadata store shared by the instances of the service yet,
+// so the queries are kept in memory. A query has to be saved again on each
+// instance, and after a restart.
+type savedQueries struct {
+	mu      sync.RWMutex
+	queries map[string]*sqlparser.ParsedQuery // name -> parsed query
+}
+
+// newSavedQueries creates an empty registry of saved queries
+func newSavedQueries() *savedQueries {
+	return &savedQueries{queries: make(map[string]*sqlparser.ParsedQuery)}
+}
+
+// SaveQuery saves a query with a name, replacing any query previously saved
+// with it. The query is validated like in `Query`, so only a single SELECT
+// can be saved.
+func (s *Service) SaveQuery(ctx context.Context, name string, query string) error {
+	if name == "" {
+		return invalid(errors.New("saved query name can't be empty"))
+	}
+	stmt, err := parseQuery(query)
+	if err != nil {
+		return err
+	}
+	sel, ok := stmt.(*sqlparser.Select)
+	if !ok {
+		return ErrReadOnly
+	}
+	if err := checkReadOnly(sel); err != nil {
+		return err
+	}
+
+	s.saved.mu.Lock()
+	defer s.saved.mu.Unlock()
+	s.saved.queries[name] = sqlparser.NewParsedQuery(stmt)
+	return nil
+}
+
+// QuerySaved runs the query saved with the name, replacing its `:name`
+// placeholders with the escaped values of the params. A missing param, or a
+// param that isn't a string, a number or a boolean, is a validation error.
+func (s *Service) QuerySaved(ctx context.Context, name string, params map[string]interface{}) (JSON, []Column, error) {
+	s.saved.mu.RLock()
+	parsed, ok := s.saved.queries[name]
+	s.saved.mu.RUnlock()
+	if !ok {
+		return nil, nil, ErrSavedQueryNotFound
+	}
+
+	bindVars, err := sqltypes.BuildBindVariables(params)
+	if err != nil {
+		return nil, nil, errors.Wrapf(invalid(err), "binding params of saved query %s", name)
+	}
+	query, err := parsed.GenerateQuery(bindVars, nil)
+	if err != nil {
+		return nil, nil, errors.Wrapf(invalid(err), "generating saved query %s", name)
+	}
+
+	// the generated query goes through the same validation as any other
+	return s.Query(ctx, string(query))
+}
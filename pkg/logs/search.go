@@ -0,0 +1,206 @@
+package logs
+
+import (
+	"context"
+	"strconv"
+
+	"github.com/pkg/errors"
+	"github.com/xwb1989/sqlparser"
+)
+
+// ErrInvalidSearch is returned when a search has an unknown operator, a
+// condition without a field, or a value the operator can't compare with
+var ErrInvalidSearch = errors.New("invalid search")
+
+// ErrLogNotFound is returned when a family doesn't have a log with an id
+var ErrLogNotFound = errors.New("log not found")
+
+// SearchRequest is a structured query on the logs of a family, which is
+// translated into a SELECT, so that clients can find logs without writing SQL
+type SearchRequest struct {
+	Family Family      `json:"family"` // family searched
+	Where  []Condition `json:"where"`  // conditions the logs match, all of them
+	Limit  int         `json:"limit"`  // maximum number of logs, 0 for the maximum rows of the service
+}
+
+// Condition compares a field of the logs with a value, ie:
+// `{"field": "breed", "op": "=", "value": "husky"}`. The value of the `IN`
+// operator is an array of the values the field can be, and the `is_null`
+// and `is_not_null` operators don't have a value.
+type Condition struct {
+	Field string      `json:"field"`
+	Op    string      `json:"op"`
+	Value interface{} `json:"value"`
+}
+
+// searchOperators are the operators of the conditions of a search, with the
+// operator of the SQL comparison they're translated into
+var searchOperators = map[string]string{
+	"=":  sqlparser.EqualStr,
+	"!=": sqlparser.NotEqualStr,
+	"<":  sqlparser.LessThanStr,
+	">":  sqlparser.GreaterThanStr,
+	"<=": sqlparser.LessEqualStr,
+	">=": sqlparser.GreaterEqualStr,
+	"IN": sqlparser.InStr,
+}
+
+// searchNullOperators are the operators of the conditions of a search on
+// whether a field has a value, with the SQL test they're translated into
+var searchNullOperators = map[string]string{
+	"is_null":     sqlparser.IsNullStr,
+	"is_not_null": sqlparser.IsNotNullStr,
+}
+
+// Search runs the search on the logs of its family. It's translated into a
+// SELECT with a `?` placeholder per value, which then runs like any other
+// `Query`, so its rows are capped and its internal columns hidden.
+func (s *Service) Search(ctx context.Context, req SearchRequest) (JSON, []Column, error) {
+	query, args, err := SearchQuery(req)
+	if err != nil {
+		return nil, nil, err
+	}
+	return s.Query(ctx, query, args...)
+}
+
+// GetLog returns the log of a family with the id, which is the id generated
+// for each log when it's ingested. It's read with `select * from family
+// where id = ? limit 1`, which runs like any other `Query`, so its internal
+// columns are hidden. It returns `ErrFamilyNotFound` if the family doesn't exist, and
+// `ErrLogNotFound` if it doesn't have a log with the id.
+func (s *Service) GetLog(ctx context.Context, family Family, id int64) (map[string]interface{}, error) {
+	if err := family.Validate(); err != nil {
+		return nil, err
+	}
+	if _, err := s.DescribeFamily(ctx, family); err != nil {
+		return nil, err
+	}
+
+	where := &sqlparser.ComparisonExpr{
+		Operator: sqlparser.EqualStr,
+		Left:     &sqlparser.ColName{Name: sqlparser.NewColIdent("id")},
+		Right:    sqlparser.NewValArg([]byte(":v1")),
+	}
+	stmt := selectLogs(family, where)
+	stmt.Limit = &sqlparser.Limit{Rowcount: sqlparser.NewIntVal([]byte("1"))}
+	query, args, err := FormatQuery(stmt, []interface{}{id})
+	if err != nil {
+		return nil, err
+	}
+	results, _, err := s.Query(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	if len(results) == 0 {
+		return nil, errors.Wrapf(ErrLogNotFound, "%s has no log with id %d", family, id)
+	}
+	return results[0], nil
+}
+
+// SearchQuery translates a search into a SELECT of the logs of its family,
+// along with the args of its `?` placeholders, ie: a search of `dog_registry`
+// where `breed` is `husky` becomes `select * from dog_registry where breed = ?`
+// with the `husky` arg. The query is built from its parsed form rather than
+// from strings, so the family and fields are escaped when formatted, and
+// values are only ever args.
+func SearchQuery(req SearchRequest) (string, []interface{}, error) {
+	if err := req.Family.Validate(); err != nil {
+		return "", nil, err
+	}
+	if req.Limit < 0 {
+		return "", nil, errors.Wrapf(ErrInvalidSearch, "limit must be positive, got %d", req.Limit)
+	}
+
+	var args []interface{}
+	// arg adds a value to the args, returning its placeholder
+	arg := func(value interface{}) sqlparser.Expr {
+		args = append(args, value)
+		return sqlparser.NewValArg([]byte(":v" + strconv.Itoa(len(args))))
+	}
+
+	var where sqlparser.Expr
+	for i, condition := range req.Where {
+		if condition.Field == "" {
+			return "", nil, errors.Wrapf(ErrInvalidSearch, "where[%d]: field is required", i)
+		}
+		column := &sqlparser.ColName{Name: sqlparser.NewColIdent(condition.Field)}
+
+		// a test of whether the field has a value doesn't have an arg
+		if operator, ok := searchNullOperators[condition.Op]; ok {
+			if condition.Value != nil {
+				return "", nil, errors.Wrapf(ErrInvalidSearch, "where[%d]: %s doesn't take a value, got %s", i, condition.Op, describeValue(condition.Value))
+			}
+			where = andWhere(where, &sqlparser.IsExpr{Operator: operator, Expr: column})
+			continue
+		}
+
+		operator, ok := searchOperators[condition.Op]
+		if !ok {
+			return "", nil, errors.Wrapf(ErrInvalidSearch, "where[%d]: unknown operator %q", i, condition.Op)
+		}
+
+		var value sqlparser.Expr
+		if operator == sqlparser.InStr {
+			values, ok := condition.Value.([]interface{})
+			if !ok || len(values) == 0 {
+				return "", nil, errors.Wrapf(ErrInvalidSearch, "where[%d]: value of IN must be a non-empty array", i)
+			}
+			var tuple sqlparser.ValTuple
+			for _, v := range values {
+				if !isSearchValue(v) {
+					return "", nil, errors.Wrapf(ErrInvalidSearch, "where[%d]: expected strings, numbers or booleans, got %s", i, describeValue(v))
+				}
+				tuple = append(tuple, arg(v))
+			}
+			value = tuple
+		} else {
+			if !isSearchValue(condition.Value) {
+				return "", nil, errors.Wrapf(ErrInvalidSearch, "where[%d]: expected a string, number or boolean, got %s", i, describeValue(condition.Value))
+			}
+			value = arg(condition.Value)
+		}
+
+		where = andWhere(where, &sqlparser.ComparisonExpr{
+			Operator: operator,
+			Left:     column,
+			Right:    value,
+		})
+	}
+
+	stmt := selectLogs(req.Family, where)
+	if req.Limit > 0 {
+		stmt.Limit = &sqlparser.Limit{Rowcount: sqlparser.NewIntVal([]byte(strconv.Itoa(req.Limit)))}
+	}
+	return FormatQuery(stmt, args)
+}
+
+// andWhere returns the condition combined with the conditions before it, or
+// the condition alone if it's the first one
+func andWhere(where, condition sqlparser.Expr) sqlparser.Expr {
+	if where == nil {
+		return condition
+	}
+	return &sqlparser.AndExpr{Left: where, Right: condition}
+}
+
+// selectLogs returns a SELECT of the logs of a family matching the condition,
+// or of every log without a condition
+func selectLogs(family Family, where sqlparser.Expr) *sqlparser.Select {
+	return &sqlparser.Select{
+		SelectExprs: sqlparser.SelectExprs{&sqlparser.StarExpr{}},
+		From: sqlparser.TableExprs{&sqlparser.AliasedTableExpr{
+			Expr: sqlparser.TableName{Name: sqlparser.NewTableIdent(family.String())},
+		}},
+		Where: sqlparser.NewWhere(sqlparser.WhereStr, where),
+	}
+}
+
+// isSearchValue reports whether a value can be compared with a field in a
+// search, which excludes nulls, objects and arrays
+func isSearchValue(value interface{}) bool {
+	switch value.(type) {
+	case string, float64, bool:
+		return true
+	}
+	return false
+}
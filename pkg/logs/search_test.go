@@ -0,0 +1,216 @@
+package logs_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/kolide/databalancer-logan/pkg/logs"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSearchQuery(t *testing.T) {
+	cases := []struct {
+		name  string
+		where []logs.Condition
+		limit int
+		query string
+		args  []interface{}
+	}{
+		{
+			name:  "a search without conditions selects every log",
+			query: "select * from dog_registry",
+		},
+		{
+			name:  "an equality is a placeholder",
+			where: []logs.Condition{{Field: "breed", Op: "=", Value: "husky"}},
+			limit: 100,
+			query: "select * from dog_registry where breed = ? limit 100",
+			args:  []interface{}{"husky"},
+		},
+		{
+			name: "the comparisons are translated and combined",
+			where: []logs.Condition{
+				{Field: "breed", Op: "!=", Value: "husky"},
+				{Field: "weight", Op: "<", Value: float64(10)},
+				{Field: "weight", Op: ">", Value: float64(2)},
+				{Field: "age", Op: "<=", Value: float64(12)},
+				{Field: "age", Op: ">=", Value: float64(1)},
+			},
+			query: "select * from dog_registry where breed != ? and weight < ? and weight > ? and age <= ? and age >= ?",
+			args:  []interface{}{"husky", float64(10), float64(2), float64(12), float64(1)},
+		},
+		{
+			name:  "IN has a placeholder per value",
+			where: []logs.Condition{{Field: "breed", Op: "IN", Value: []interface{}{"husky", "beagle"}}},
+			query: "select * from dog_registry where breed in (?, ?)",
+			args:  []interface{}{"husky", "beagle"},
+		},
+		{
+			name: "a null test doesn't have a placeholder",
+			where: []logs.Condition{
+				{Field: "owner", Op: "is_null"},
+				{Field: "breed", Op: "=", Value: "husky"},
+				{Field: "weight", Op: "is_not_null"},
+			},
+			query: "select * from dog_registry where owner is null and breed = ? and weight is not null",
+			args:  []interface{}{"husky"},
+		},
+		{
+			name:  "a field that's a keyword is quoted",
+			where: []logs.Condition{{Field: "order", Op: "=", Value: true}},
+			query: "select * from dog_registry where `order` = ?",
+			args:  []interface{}{true},
+		},
+		{
+			name:  "an injection in a field is escaped into the name of a column",
+			where: []logs.Condition{{Field: "breed` = 1 OR 1=1 -- ", Op: "=", Value: "husky"}},
+			query: "select * from dog_registry where `breed`` = 1 OR 1=1 -- ` = ?",
+			args:  []interface{}{"husky"},
+		},
+		{
+			name:  "an injection in a value is an arg",
+			where: []logs.Condition{{Field: "breed", Op: "=", Value: "husky' OR '1'='1"}},
+			query: "select * from dog_registry where breed = ?",
+			args:  []interface{}{"husky' OR '1'='1"},
+		},
+	}
+
+	for _, tt := range cases {
+		t.Run(tt.name, func(t *testing.T) {
+			query, args, err := logs.SearchQuery(logs.SearchRequest{Family: "dog_registry", Where: tt.where, Limit: tt.limit})
+			assert.NoError(t, err)
+			assert.Equal(t, tt.query, query)
+			assert.Equal(t, tt.args, args)
+		})
+	}
+}
+
+func TestSearchQueryInvalid(t *testing.T) {
+	cases := []struct {
+		name string
+		req  logs.SearchRequest
+		err  error
+	}{
+		{
+			name: "an injection in the family",
+			req:  logs.SearchRequest{Family: "dog_registry; DROP TABLE dog_registry"},
+			err:  logs.ErrInvalidFamily,
+		},
+		{
+			name: "an unknown operator",
+			req:  logs.SearchRequest{Family: "dog_registry", Where: []logs.Condition{{Field: "breed", Op: "LIKE", Value: "h%"}}},
+			err:  logs.ErrInvalidSearch,
+		},
+		{
+			name: "a condition without a field",
+			req:  logs.SearchRequest{Family: "dog_registry", Where: []logs.Condition{{Op: "=", Value: "husky"}}},
+			err:  logs.ErrInvalidSearch,
+		},
+		{
+			name: "a null value",
+			req:  logs.SearchRequest{Family: "dog_registry", Where: []logs.Condition{{Field: "breed", Op: "=", Value: nil}}},
+			err:  logs.ErrInvalidSearch,
+		},
+		{
+			name: "an array compared with =",
+			req:  logs.SearchRequest{Family: "dog_registry", Where: []logs.Condition{{Field: "breed", Op: "=", Value: []interface{}{"husky"}}}},
+			err:  logs.ErrInvalidSearch,
+		},
+		{
+			name: "an empty IN",
+			req:  logs.SearchRequest{Family: "dog_registry", Where: []logs.Condition{{Field: "breed", Op: "IN", Value: []interface{}{}}}},
+			err:  logs.ErrInvalidSearch,
+		},
+		{
+			name: "an object in an IN",
+			req:  logs.SearchRequest{Family: "dog_registry", Where: []logs.Condition{{Field: "breed", Op: "IN", Value: []interface{}{map[string]interface{}{}}}}},
+			err:  logs.ErrInvalidSearch,
+		},
+		{
+			name: "a null test with a value",
+			req:  logs.SearchRequest{Family: "dog_registry", Where: []logs.Condition{{Field: "owner", Op: "is_null", Value: "max"}}},
+			err:  logs.ErrInvalidSearch,
+		},
+		{
+			name: "a null test without a field",
+			req:  logs.SearchRequest{Family: "dog_registry", Where: []logs.Condition{{Op: "is_not_null"}}},
+			err:  logs.ErrInvalidSearch,
+		},
+		{
+			name: "a negative limit",
+			req:  logs.SearchRequest{Family: "dog_registry", Limit: -1},
+			err:  logs.ErrInvalidSearch,
+		},
+	}
+
+	for _, tt := range cases {
+		t.Run(tt.name+" is rejected", func(t *testing.T) {
+			_, _, err := logs.SearchQuery(tt.req)
+			assert.Equal(t, tt.err, errors.Cause(err))
+			assert.Equal(t, logs.ErrValidation, logs.Kind(err))
+		})
+	}
+}
+
+func TestSearch(t *testing.T) {
+	// GIVEN a service returning at most 10 rows
+	db := &mockDB{}
+	service := newService(db, logs.WithMaxRows(10))
+
+	// WHEN a search asks for more
+	_, _, err := service.Search(context.Background(), logs.SearchRequest{
+		Family: "dog_registry",
+		Where:  []logs.Condition{{Field: "breed", Op: "=", Value: "husky"}},
+		Limit:  100,
+	})
+
+	// THEN it runs like a query, with its rows capped
+	assert.NoError(t, err)
+	assert.Equal(t, "select * from dog_registry where breed = ? limit 10", db.query)
+	assert.Equal(t, []interface{}{"husky"}, db.args)
+}
+
+func TestGetLog(t *testing.T) {
+	t.Run("the log with the id is read with a placeholder", func(t *testing.T) {
+		// GIVEN
+		db := &mockDB{results: logs.JSON{{"id": int64(42), "name": "max"}}}
+		service := newService(db)
+
+		// WHEN
+		record, err := service.GetLog(context.Background(), "dog_registry", 42)
+
+		// THEN its internal columns are hidden, like in a query
+		assert.NoError(t, err)
+		assert.Equal(t, map[string]interface{}{"name": "max"}, record)
+		assert.Equal(t, "select * from dog_registry where id = ? limit 1", db.query)
+		assert.Equal(t, []interface{}{int64(42)}, db.args)
+	})
+
+	t.Run("a missing log is not found", func(t *testing.T) {
+		// WHEN
+		_, err := newService(&mockDB{}).GetLog(context.Background(), "dog_registry", 42)
+
+		// THEN
+		assert.Equal(t, logs.ErrLogNotFound, errors.Cause(err))
+		assert.Equal(t, logs.ErrNotFound, logs.Kind(err))
+	})
+
+	t.Run("a missing family is not found", func(t *testing.T) {
+		// WHEN
+		db := &mockDB{}
+		_, err := newService(db).GetLog(context.Background(), "cat_registry", 42)
+
+		// THEN it's not queried
+		assert.Equal(t, logs.ErrFamilyNotFound, err)
+		assert.Empty(t, db.query)
+	})
+
+	t.Run("an invalid family is rejected", func(t *testing.T) {
+		// WHEN
+		_, err := newService(&mockDB{}).GetLog(context.Background(), "dog_registry; DROP TABLE dog_registry", 42)
+
+		// THEN
+		assert.Equal(t, logs.ErrInvalidFamily, errors.Cause(err))
+	})
+}
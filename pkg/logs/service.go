@@ -1,7 +1,16 @@
 package logs
 
 import (
-	"log"
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
 
 	"github.com/pkg/errors"
 	"github.com/xwb1989/sqlparser"
@@ -9,21 +18,86 @@ import (
 
 // DBClient is the interface that defines methods for creating tables in a database
 type DBClient interface {
-	CreateTable(family Family, schema Schema) (Table, error)
-	QueryJSON(query string) (JSON, error)
-	DescribeDatabase() (JSON, error)
+	CreateTable(ctx context.Context, family Family, schema Schema, unique []string) (Table, error)
+	CreateTableDDL(family Family, schema Schema, unique []string) (string, error)
+	QueryJSON(ctx context.Context, query string, args ...interface{}) (JSON, []Column, error)
+	QueryRows(ctx context.Context, query string, args []interface{}, fn func(row map[string]interface{}) error) error
+	DescribeDatabase(ctx context.Context) (JSON, error)
+	DescribeTable(ctx context.Context, family Family) (JSON, error)
+	TableSchema(ctx context.Context, family Family) (Schema, error)
+	ListTables(ctx context.Context) ([]string, error)
+	DropTable(ctx context.Context, family Family) error
+	TruncateTable(ctx context.Context, family Family) error
+	CountRows(ctx context.Context, family Family) (int64, error)
+	MergeTables(ctx context.Context, target Family, sources []Family, sourceColumn string) error
+	PingContext(ctx context.Context) error
+	Close() error
 }
 
 // Table is an interface for inserting records into a table
 type Table interface {
-	Insert(records JSON) error
+	Insert(ctx context.Context, records JSON) (int64, error)
+	InsertReturningIDs(ctx context.Context, records JSON) ([]int64, error)
 }
 
 // Service contains the databases to ingest logs into
 type Service struct {
-	db DBClient
+	db              DBClient
+	shards          []Shard       // databases of the families with their prefix, longest prefix first
+	maxRows         int           // maximum rows returned by a query, 0 for no limit
+	returnIDs       bool          // whether ingest returns the ids of the inserted records
+	overflow        bool          // whether the fields missing from the schema are kept in OverflowField
+	allFieldErrors  bool          // whether ingest reports every invalid field rather than the first
+	internalColumns []string      // columns hidden from results unless selected by name
+	dedup           *dedup        // skips logs ingested recently, if set
+	dedupWindow     time.Duration // window logs are remembered for by the dedup, 0 for no dedup
+	dedupMaxLogs    int           // maximum logs remembered by the dedup, 0 for no limit
+	pingTimeout     time.Duration // maximum time to wait for the database to answer a ping
+	queryTimeout    time.Duration // maximum time a query can run, 0 for no limit
+	slowQuery       time.Duration // time after which a query is logged as slow, 0 to not log them
+	stats           *ingestStats  // ingest rate and lag of each family
+	eventTimeField  string        // field of the logs with their event time, if set
+	saved           *savedQueries // queries saved by name
+	schemas         *schemaCache  // compiled schema of each family
+	logger          *slog.Logger  // logs a summary of each ingest
+	now             func() time.Time
 }
 
+// IngestResult describes the outcome of ingesting logs
+type IngestResult struct {
+	Inserted int64   `json:"inserted"`          // rows affected by the insert
+	IDs      []int64 `json:"ids,omitempty"`     // generated ids, if `WithReturnIDs` is set
+	Skipped  int     `json:"skipped,omitempty"` // logs skipped as duplicates, if `WithDedupWindow` is set
+}
+
+// Option configures optional behavior of a `Service`
+type Option func(*Service)
+
+// DefaultMaxRows is the maximum number of rows a query returns unless
+// configured otherwise with `WithMaxRows`
+const DefaultMaxRows = 10000
+
+// DefaultPingTimeout is the maximum time to wait for the database to answer
+// a ping, unless configured otherwise with `WithPingTimeout`
+const DefaultPingTimeout = 2 * time.Second
+
+// DefaultQueryTimeout is the maximum time a query can run, unless configured
+// otherwise with `WithQueryTimeout`
+const DefaultQueryTimeout = 30 * time.Second
+
+// DefaultSlowQueryThreshold is the time after which a query is logged as
+// slow, unless configured otherwise with `WithSlowQueryThreshold`
+const DefaultSlowQueryThreshold = time.Second
+
+// DefaultDedupMaxLogs is the default maximum number of logs remembered by the
+// dedup window
+const DefaultDedupMaxLogs = 1000000
+
+// DefaultInternalColumns are the columns added by the database rather than
+// ingested, which are hidden from query results unless configured otherwise
+// with `WithInternalColumns`
+var DefaultInternalColumns = []string{"id"}
+
 // Family is the table name for a group of logs
 type Family string
 
@@ -36,95 +110,819 @@ type JSON []map[string]interface{}
 // ErrReadOnly is returned when valid SQL other than a SELECT is sent
 var ErrReadOnly = errors.New("service can only be used to query records")
 
+// ErrEmptyQuery is returned when a query is empty or only whitespace
+var ErrEmptyQuery = errors.New("query is empty")
+
+// ErrMultipleStatements is returned when a query has more than one statement,
+// ie: `SELECT 1; SELECT 2`
+var ErrMultipleStatements = errors.New("query must be a single statement")
+
+// ErrQueryTimeout is returned when a query runs longer than the query timeout
+var ErrQueryTimeout = errors.New("query timed out")
+
+// ErrFamilyNotFound is returned when a log family doesn't exist
+var ErrFamilyNotFound = errors.New("log family not found")
+
+// ErrInvalidFamily is returned when the name of a log family isn't a safe
+// table name
+var ErrInvalidFamily = errors.New("family must start with a letter, followed by at most 63 letters, digits or underscores")
+
+// familyPattern matches the names of log families, which are used as table
+// names, so that they're valid and portable across databases
+var familyPattern = regexp.MustCompile(`^[a-zA-Z][a-zA-Z0-9_]{0,63}$`)
+
 // CreateService returns a `Service`, backed by a `DB`
-func CreateService(db DBClient) *Service {
-	return &Service{db: db}
+func CreateService(db DBClient, opts ...Option) *Service {
+	s := &Service{
+		db:              db,
+		maxRows:         DefaultMaxRows,
+		internalColumns: DefaultInternalColumns,
+		pingTimeout:     DefaultPingTimeout,
+		queryTimeout:    DefaultQueryTimeout,
+		slowQuery:       DefaultSlowQueryThreshold,
+		dedupMaxLogs:    DefaultDedupMaxLogs,
+		stats:           newIngestStats(),
+		saved:           newSavedQueries(),
+		schemas:         newSchemaCache(),
+		logger:          slog.Default(),
+		now:             time.Now,
+	}
+	for _, opt := range opts {
+		opt(s)
+	}
+	if s.dedupWindow > 0 {
+		s.dedup = newDedup(s.dedupWindow, s.dedupMaxLogs)
+	}
+	return s
+}
+
+// WithMaxRows limits the number of rows a query can return, so that a
+// `SELECT *` on a huge table can't exhaust the memory of the service.
+// A limit of 0 or less disables the limit.
+func WithMaxRows(n int) Option {
+	return func(s *Service) {
+		if n < 0 {
+			n = 0
+		}
+		s.maxRows = n
+	}
+}
+
+// WithReturnIDs makes `Ingest` return the generated ids of the inserted
+// records. Note that this is slower, since the records can't be inserted with
+// a single multi-row statement.
+func WithReturnIDs(returnIDs bool) Option {
+	return func(s *Service) {
+		s.returnIDs = returnIDs
+	}
+}
+
+// WithAllFieldErrors makes `Ingest` report every field of the logs that
+// doesn't match the schema as `FieldErrors`, rather than the first one as a
+// `*FieldError`, so that a client can fix them all before sending the logs
+// again
+func WithAllFieldErrors(all bool) Option {
+	return func(s *Service) {
+		s.allFieldErrors = all
+	}
 }
 
 // Ingest parses and stores logs into the database.
 // It validates the logs match the schema, creates the database table,
-// and then writes the logs to it.
-func (s *Service) Ingest(family Family, schema Schema, logs JSON) error {
-	// validate that the logs match the given schema and contain valid types
-	if err := checkLogSchema(schema, logs); err != nil {
-		// TODO: check for specific error types, wrap in error type that
-		// any exposing interface can use to create nicer error messaging
-		return errors.Wrapf(err, "validating %s logs against schema", family)
+// and then writes the logs to it. With unique fields, a log with the same
+// values for them as a log already stored replaces it, rather than being
+// stored again, so that logs can be replayed. A nil schema is read from the
+// table of the family, so it's only required the first time a family is
+// ingested.
+func (s *Service) Ingest(ctx context.Context, family Family, schema Schema, unique []string, logs JSON) (IngestResult, error) {
+	ingested := false
+	return s.IngestBatches(ctx, family, schema, unique, func() (JSON, error) {
+		if ingested {
+			return nil, io.EOF
+		}
+		ingested = true
+		return logs, nil
+	})
+}
+
+// IngestBatches ingests the logs returned by next like `Ingest`, one batch at
+// a time until next returns `io.EOF`, so that the logs of a large request
+// don't have to be held at once. Any other error returned by next is returned
+// as is. Each batch is validated and inserted on its own, so the batches
+// before a batch that fails are stored, and are counted by the result
+// returned along with the error. The index of a `FieldError` is the index of
+// the log among all the batches.
+func (s *Service) IngestBatches(ctx context.Context, family Family, schema Schema, unique []string, next func() (JSON, error)) (IngestResult, error) {
+	var result IngestResult
+	start := s.now()
+
+	if err := family.Validate(); err != nil {
+		return result, err
+	}
+	if schema == nil {
+		var err error
+		if schema, err = s.tableSchema(ctx, family); err != nil {
+			return result, err
+		}
+	}
+	for _, field := range unique {
+		if _, ok := schema[field]; !ok {
+			return result, invalid(errors.Errorf("unique field %s of %s is not in the schema", field, family))
+		}
+	}
+	if s.overflow {
+		var err error
+		if schema, err = overflowSchema(family, schema); err != nil {
+			return result, err
+		}
 	}
 
-	table, err := s.db.CreateTable(family, schema)
+	// parse the schema, unless it's the same as the family's last batch
+	compiled, err := s.schemas.get(family, schema)
 	if err != nil {
-		// TODO: check and convert errors
-		return errors.Wrapf(err, "creating table %s", family)
+		return result, invalid(errors.Wrapf(err, "compiling %s schema", family))
+	}
+
+	var table Table
+	rows, offset := 0, 0
+	for {
+		logs, err := next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return result, err
+		}
+
+		// keep the fields missing from the schema, rather than rejecting them
+		if s.overflow {
+			logs = overflowLogs(compiled, logs)
+		}
+
+		// validate that the logs match the given schema and contain valid
+		// types, reporting the index of a log among all the batches
+		if err := checkLogSchema(compiled, logs, s.allFieldErrors); err != nil {
+			switch cause := errors.Cause(err).(type) {
+			case *FieldError:
+				cause.Index += offset
+			case FieldErrors:
+				for _, fieldErr := range cause {
+					fieldErr.Index += offset
+				}
+			}
+			return result, errors.Wrapf(err, "validating %s logs against schema", family)
+		}
+		offset += len(logs)
+
+		// normalize the string values, so that they're deduplicated and
+		// stored the same way
+		logs = normalizeLogs(compiled, logs)
+
+		// skip the logs that were ingested recently
+		var hashes []string
+		if s.dedup != nil {
+			unseen, unseenHashes, err := s.dedup.filter(family, logs, s.now())
+			if err != nil {
+				return result, err
+			}
+			result.Skipped += len(logs) - len(unseen)
+			logs, hashes = unseen, unseenHashes
+		}
+
+		// create the table with the first batch. The errors of the database
+		// keep their cause, so that `Kind` tells a schema conflicting with
+		// the table or a database with too many connections apart.
+		if table == nil {
+			table, err = s.dbFor(family).CreateTable(ctx, family, schema, unique)
+			if err != nil {
+				return result, errors.Wrapf(err, "creating table %s", family)
+			}
+		}
+
+		if s.returnIDs {
+			ids, err := table.InsertReturningIDs(ctx, logs)
+			if err != nil {
+				return result, errors.Wrapf(err, "inserting %s logs", family)
+			}
+			result.IDs = append(result.IDs, ids...)
+			result.Inserted += int64(len(ids))
+		} else {
+			inserted, err := table.Insert(ctx, logs)
+			if err != nil {
+				return result, errors.Wrapf(err, "inserting %s logs", family)
+			}
+			result.Inserted += inserted
+		}
+
+		// only remember the logs once they're inserted, so a failed request
+		// can be retried
+		now := s.now()
+		if s.dedup != nil {
+			s.dedup.mark(hashes, now)
+		}
+		s.recordStats(family, logs, now)
+		rows += len(logs)
 	}
 
-	if err := table.Insert(logs); err != nil {
-		// TODO: check and convert errors
+	s.loggerFor(ctx).Info("ingested logs", "family", family, "rows", rows, "duration", s.now().Sub(start))
+	return result, nil
+}
+
+// IngestDDL returns the statement creating the table of a family that the
+// database client generates when logs are ingested with the schema, ie: to
+// debug the drift of a schema. Like with `Ingest`, a nil schema is read from
+// the table of the family.
+func (s *Service) IngestDDL(ctx context.Context, family Family, schema Schema, unique []string) (string, error) {
+	if err := family.Validate(); err != nil {
+		return "", err
+	}
+	if schema == nil {
+		var err error
+		if schema, err = s.tableSchema(ctx, family); err != nil {
+			return "", err
+		}
+	}
+	if s.overflow {
+		var err error
+		if schema, err = overflowSchema(family, schema); err != nil {
+			return "", err
+		}
+	}
+	if _, err := compileSchema(schema); err != nil {
+		return "", invalid(errors.Wrapf(err, "compiling %s schema", family))
+	}
+	ddl, err := s.dbFor(family).CreateTableDDL(family, schema, unique)
+	if err != nil {
+		return "", errors.Wrapf(err, "generating DDL of %s table", family)
+	}
+	return ddl, nil
+}
+
+// tableSchema returns the schema of the existing table of a family, for an
+// ingest without a schema
+func (s *Service) tableSchema(ctx context.Context, family Family) (Schema, error) {
+	schema, err := s.dbFor(family).TableSchema(ctx, family)
+	if err != nil {
+		return nil, errors.Wrapf(err, "reading schema of family %s", family)
+	}
+	if schema == nil {
+		return nil, invalid(errors.Errorf("schema is required, since family %s has no table to read it from", family))
+	}
+	return schema, nil
+}
+
+// recordStats tracks the ingestion of the logs of a family
+func (s *Service) recordStats(family Family, logs JSON, now time.Time) {
+	var eventTimes []time.Time
+	if s.eventTimeField != "" {
+		for _, logEvent := range logs {
+			if t, ok := eventTime(logEvent, s.eventTimeField); ok {
+				eventTimes = append(eventTimes, t)
+			}
+		}
+	}
+	s.stats.record(family, len(logs), eventTimes, now)
+}
+
+// Stats returns the ingest rate and lag of each log family ingested by this
+// instance of the service
+func (s *Service) Stats() []FamilyStats {
+	return s.stats.snapshot(s.now())
+}
+
+// Query receives a SQL query that it sends to the database
+// as long as it is a SELECT. The args are bound to the `?` placeholders
+// of the query, in order. The columns of the results are returned in the
+// order they were selected.
+func (s *Service) Query(ctx context.Context, query string, args ...interface{}) (JSON, []Column, error) {
+	results, columns, _, err := s.QueryPage(ctx, query, args, Page{})
+	return results, columns, err
+}
+
+// QueryPage runs the query like `Query`, returning a page of its results. The
+// query can't have a LIMIT of its own. It returns the page that was applied,
+// whose limit is lowered to the maximum rows of the service if it's over it.
+func (s *Service) QueryPage(ctx context.Context, query string, args []interface{}, page Page) (JSON, []Column, Page, error) {
+	prepared, err := s.prepareQuery(query, args, page)
+	if err != nil {
+		return nil, nil, Page{}, err
+	}
+	ctx, cancel := s.queryContext(ctx)
+	defer cancel()
+	started := s.now()
+	results, columns, err := prepared.db.QueryJSON(ctx, prepared.query, prepared.args...)
+	s.logSlowQuery(ctx, prepared.query, started)
+	if err != nil {
+		return nil, nil, Page{}, queryError(ctx, err)
+	}
+	for _, row := range results {
+		for _, column := range prepared.hidden {
+			delete(row, column)
+		}
+	}
+	return results, withoutColumns(columns, prepared.hidden), prepared.page, nil
+}
+
+// QueryStream validates the query like `Query`, and calls fn with each row
+// of its results as it's read from the database, rather than returning all
+// of the rows at once. It stops at the first error returned by fn.
+func (s *Service) QueryStream(ctx context.Context, query string, args []interface{}, fn func(row map[string]interface{}) error) error {
+	prepared, err := s.prepareQuery(query, args, Page{})
+	if err != nil {
 		return err
 	}
+	ctx, cancel := s.queryContext(ctx)
+	defer cancel()
+	started := s.now()
+	err = prepared.db.QueryRows(ctx, prepared.query, prepared.args, func(row map[string]interface{}) error {
+		for _, column := range prepared.hidden {
+			delete(row, column)
+		}
+		return fn(row)
+	})
+	s.logSlowQuery(ctx, prepared.query, started)
+	if err != nil {
+		return queryError(ctx, err)
+	}
 	return nil
 }
 
-// Query receives a SQL query that it sends to the database
-// as long as it is a SELECT
-func (s *Service) Query(query string) (JSON, error) {
+// Explain validates the query like `Query`, and returns the plan the database
+// would execute it with, one row per step of the plan. Only a SELECT can be
+// explained, since the database runs the statement it explains.
+func (s *Service) Explain(ctx context.Context, query string, args ...interface{}) (JSON, error) {
+	prepared, err := s.prepareQuery(query, args, Page{})
+	if err != nil {
+		return nil, err
+	}
+	ctx, cancel := s.queryContext(ctx)
+	defer cancel()
+	plan, _, err := prepared.db.QueryJSON(ctx, "EXPLAIN "+prepared.query, prepared.args...)
+	if err != nil {
+		return nil, queryError(ctx, err)
+	}
+	return plan, nil
+}
+
+// queryContext derives a context that's cancelled once the query timeout
+// passes, if there is one
+func (s *Service) queryContext(ctx context.Context) (context.Context, context.CancelFunc) {
+	if s.queryTimeout <= 0 {
+		return context.WithCancel(ctx)
+	}
+	return context.WithTimeout(ctx, s.queryTimeout)
+}
+
+// logSlowQuery logs a query that ran for longer than the slow query
+// threshold since it started, with its SQL and how long it ran, so that the
+// queries worth optimizing can be found
+func (s *Service) logSlowQuery(ctx context.Context, query string, started time.Time) {
+	if s.slowQuery <= 0 {
+		return
+	}
+	if elapsed := s.now().Sub(started); elapsed > s.slowQuery {
+		s.loggerFor(ctx).Warn("slow query", "query", query, "duration", elapsed)
+	}
+}
+
+// queryError wraps an error returned by the database, turning it into
+// `ErrQueryTimeout` if the query was cut short by its deadline. The drivers
+// don't agree on the error they return when a query is cancelled, so the
+// context is checked rather than the error.
+func queryError(ctx context.Context, err error) error {
+	if ctx.Err() == context.DeadlineExceeded {
+		return errors.Wrap(ErrQueryTimeout, "querying database client")
+	}
+	return errors.Wrap(err, "querying database client")
+}
+
+// preparedQuery is a query ready to be sent to the database
+type preparedQuery struct {
+	db     DBClient      // database storing the families read by the query
+	query  string        // the query, with its rows capped
+	args   []interface{} // args of the placeholders of the query, in order
+	hidden []string      // columns to hide from the results
+	page   Page          // page of the results applied to the query, if any
+}
+
+// prepareQuery validates that the query is a single SELECT with an arg per
+// placeholder, and returns it with the page applied and its rows capped, along
+// with its args, the columns to hide from its results and the database storing
+// the families it reads
+func (s *Service) prepareQuery(query string, args []interface{}, page Page) (preparedQuery, error) {
 	// parse the query, also verifies that it's a valid
 	// single statement query
-	stmt, err := sqlparser.Parse(query)
+	stmt, err := parseQuery(query)
 	if err != nil {
-		return nil, errors.Wrapf(err, "parsing query '%s'", query)
+		return preparedQuery{}, err
 	}
-	switch stmt.(type) {
+	switch stmt := stmt.(type) {
 	case *sqlparser.Select:
-		// statement is good, and a select, so pass it through
-		results, err := s.db.QueryJSON(query)
+		if err := checkReadOnly(stmt); err != nil {
+			return preparedQuery{}, err
+		}
+		paged := page != Page{}
+		if paged {
+			if err := paginate(stmt, page, s.maxRows); err != nil {
+				return preparedQuery{}, err
+			}
+		}
+		// statement is good, and a select, so cap the rows it can return
+		// and pass it through
+		if s.maxRows > 0 {
+			limitRows(stmt, s.maxRows)
+		}
+		formatted, ordered, err := FormatQuery(stmt, args)
+		if err != nil {
+			return preparedQuery{}, err
+		}
+		if s.maxRows > 0 || len(args) > 0 || paged {
+			query = formatted
+		}
+		db, err := s.queryDB(stmt)
 		if err != nil {
-			return nil, errors.Wrap(err, "querying database client")
+			return preparedQuery{}, err
 		}
-		return results, nil
+		prepared := preparedQuery{db: db, query: query, args: ordered, hidden: s.hiddenColumns(stmt)}
+		if paged {
+			prepared.page = appliedPage(stmt)
+		}
+		return prepared, nil
 	default:
 		// query wasn't really a query, so return readonly error
-		return nil, ErrReadOnly
+		return preparedQuery{}, ErrReadOnly
 	}
 }
 
-// DescribeLogs describes the database tables and columns as JSON
-func (s *Service) DescribeLogs() (JSON, error) {
-	// TODO: right now this just returns the same format as the database,
-	// but it would be better if this service defined a structure that
-	// the databases should use describe their data, in the same
-	// language that the ingestion uses for schema and family etc
-	results, err := s.db.DescribeDatabase()
+// parseQuery parses a query, returning `ErrEmptyQuery` or
+// `ErrMultipleStatements` rather than the syntax error of the parser for an
+// empty query or one with more than one statement. A trailing semicolon is
+// allowed.
+func parseQuery(query string) (sqlparser.Statement, error) {
+	if strings.TrimSpace(query) == "" {
+		return nil, ErrEmptyQuery
+	}
+	stmt, err := sqlparser.Parse(query)
+	if err != nil {
+		if _, rest, splitErr := sqlparser.SplitStatement(query); splitErr == nil && strings.TrimSpace(rest) != "" {
+			return nil, errors.Wrapf(ErrMultipleStatements, "parsing query '%s'", query)
+		}
+		return nil, invalid(errors.Wrapf(err, "parsing query '%s'", query))
+	}
+	return stmt, nil
+}
+
+// DescribeLogs describes the database tables and columns as JSON. Each
+// column has its database `type`, and the `schema_type` that logs are
+// ingested with (see `SchemaType`).
+func (s *Service) DescribeLogs(ctx context.Context) (JSON, error) {
+	results, err := s.describeDatabases(ctx)
 	if err != nil {
 		return nil, errors.Wrap(err, "describing logs")
 	}
-	return results, nil
+	return withSchemaTypes(results), nil
+}
+
+// DescribeFamily describes the table of a log family and its columns as
+// JSON, in the same format as `DescribeLogs`
+func (s *Service) DescribeFamily(ctx context.Context, family Family) (JSON, error) {
+	results, err := s.dbFor(family).DescribeTable(ctx, family)
+	if err != nil {
+		return nil, errors.Wrapf(err, "describing family %s", family)
+	}
+	if len(results) == 0 {
+		return nil, ErrFamilyNotFound
+	}
+	return withSchemaTypes(results), nil
+}
+
+// ListFamilies returns the names of the log families, in order. Unlike
+// `DescribeLogs`, the columns of their tables aren't read.
+func (s *Service) ListFamilies(ctx context.Context) ([]string, error) {
+	var families []string
+	for _, db := range s.databases() {
+		tables, err := db.ListTables(ctx)
+		if err != nil {
+			return nil, errors.Wrap(err, "listing families")
+		}
+		families = append(families, tables...)
+	}
+	if len(s.shards) > 0 {
+		sort.Strings(families)
+	}
+	if families == nil {
+		families = []string{}
+	}
+	return families, nil
+}
+
+// WithInternalColumns sets the columns hidden from query results, unless the
+// query selects them by name (ie: `SELECT id, name` rather than `SELECT *`).
+// Without any columns, nothing is hidden.
+func WithInternalColumns(columns ...string) Option {
+	return func(s *Service) {
+		s.internalColumns = columns
+	}
+}
+
+// Ping checks that the database, and the database of each shard, can be
+// reached, waiting at most for the ping timeout of the service
+func (s *Service) Ping(ctx context.Context) error {
+	ctx, cancel := context.WithTimeout(ctx, s.pingTimeout)
+	defer cancel()
+	for _, db := range s.databases() {
+		if err := db.PingContext(ctx); err != nil {
+			return errors.Wrap(err, "pinging database")
+		}
+	}
+	return nil
+}
+
+// DropFamily deletes a log family and all of its logs
+func (s *Service) DropFamily(ctx context.Context, family Family) error {
+	if err := s.dbFor(family).DropTable(ctx, family); err != nil {
+		if err == ErrFamilyNotFound {
+			return err
+		}
+		return errors.Wrapf(err, "dropping family %s", family)
+	}
+	return nil
+}
+
+// TruncateFamily deletes all of the logs of a log family, keeping the family
+// and the columns of its table, so that logs can be ingested into it again
+// with the same schema
+func (s *Service) TruncateFamily(ctx context.Context, family Family) error {
+	if err := s.dbFor(family).TruncateTable(ctx, family); err != nil {
+		if err == ErrFamilyNotFound {
+			return err
+		}
+		return errors.Wrapf(err, "truncating family %s", family)
+	}
+	return nil
+}
+
+// CountFamily returns the number of logs of a log family
+func (s *Service) CountFamily(ctx context.Context, family Family) (int64, error) {
+	count, err := s.dbFor(family).CountRows(ctx, family)
+	if err != nil {
+		if err == ErrFamilyNotFound {
+			return 0, err
+		}
+		return 0, errors.Wrapf(err, "counting family %s", family)
+	}
+	return count, nil
+}
+
+// hiddenColumns returns the internal columns that the statement doesn't
+// select by name, and so should be removed from its results
+func (s *Service) hiddenColumns(stmt *sqlparser.Select) []string {
+	var hidden []string
+	for _, column := range s.internalColumns {
+		if !selectsColumn(stmt, column) {
+			hidden = append(hidden, column)
+		}
+	}
+	return hidden
+}
+
+// selectsColumn reports whether the statement selects the column by name,
+// or has an expression aliased to it
+func selectsColumn(stmt *sqlparser.Select, column string) bool {
+	for _, selectExpr := range stmt.SelectExprs {
+		expr, ok := selectExpr.(*sqlparser.AliasedExpr)
+		if !ok {
+			continue
+		}
+		if !expr.As.IsEmpty() {
+			if expr.As.EqualString(column) {
+				return true
+			}
+			continue
+		}
+		if col, ok := expr.Expr.(*sqlparser.ColName); ok && col.Name.EqualString(column) {
+			return true
+		}
+	}
+	return false
 }
 
-// checkLogSchema validates that all logs match the given schema
-func checkLogSchema(schema Schema, logs JSON) error {
-	for _, logEvent := range logs {
+// WithDedupWindow skips ingesting logs with the same content as a log of the
+// same family ingested within the window, so that retried requests don't
+// create duplicate rows. A window of 0 or less disables it.
+func WithDedupWindow(window time.Duration) Option {
+	return func(s *Service) {
+		s.dedupWindow = window
+	}
+}
+
+// WithDedupMaxLogs limits the number of logs remembered by the dedup window,
+// which is `DefaultDedupMaxLogs` by default, so that a burst of logs can't
+// exhaust the memory of the service. Past the limit, the least recently
+// ingested logs are forgotten, even if they're within the window. A limit of
+// 0 or less disables it.
+func WithDedupMaxLogs(n int) Option {
+	return func(s *Service) {
+		if n < 0 {
+			n = 0
+		}
+		s.dedupMaxLogs = n
+	}
+}
+
+// Close closes the database client of the service and of each shard, once
+// it's done serving requests
+func (s *Service) Close() error {
+	var err error
+	for _, db := range s.databases() {
+		if closeErr := db.Close(); closeErr != nil && err == nil {
+			err = closeErr
+		}
+	}
+	return errors.Wrap(err, "closing database client")
+}
+
+// WithQueryTimeout sets the maximum time a query can run before it's
+// cancelled, so an expensive query can't tie up the database indefinitely.
+// A timeout of 0 or less disables the limit.
+func WithQueryTimeout(timeout time.Duration) Option {
+	return func(s *Service) {
+		s.queryTimeout = timeout
+	}
+}
+
+// WithSlowQueryThreshold logs a warning for each query that runs for longer
+// than the threshold, with its SQL and how long it ran. A streamed query
+// runs until its last row is sent. A threshold of 0 or less disables it.
+func WithSlowQueryThreshold(threshold time.Duration) Option {
+	return func(s *Service) {
+		s.slowQuery = threshold
+	}
+}
+
+// WithPingTimeout sets the maximum time to wait for the database to answer
+// a ping, so a hung database doesn't hang health checks
+func WithPingTimeout(timeout time.Duration) Option {
+	return func(s *Service) {
+		s.pingTimeout = timeout
+	}
+}
+
+// WithEventTimeField sets the field of the logs holding the time of the
+// event, either as seconds since the unix epoch or as an RFC 3339 string,
+// which is used to report the lag between events and their ingestion
+func WithEventTimeField(field string) Option {
+	return func(s *Service) {
+		s.eventTimeField = field
+	}
+}
+
+// WithLogger sets the logger of the service, which is `slog.Default()` by
+// default. A nil logger disables the logging of the service.
+func WithLogger(logger *slog.Logger) Option {
+	return func(s *Service) {
+		if logger == nil {
+			logger = slog.New(slog.NewTextHandler(io.Discard, nil))
+		}
+		s.logger = logger
+	}
+}
+
+// WithClock sets the function used to get the current time, which is
+// `time.Now` by default
+func WithClock(now func() time.Time) Option {
+	return func(s *Service) {
+		s.now = now
+	}
+}
+
+// limitRows adds a LIMIT clause to the statement, or lowers the existing
+// one if it allows more than max rows. A LIMIT that isn't an integer
+// literal (ie: a bindvar) can't be checked, so it's replaced.
+func limitRows(stmt *sqlparser.Select, max int) {
+	maxVal := sqlparser.NewIntVal([]byte(strconv.Itoa(max)))
+	if stmt.Limit == nil {
+		stmt.Limit = &sqlparser.Limit{Rowcount: maxVal}
+		return
+	}
+	if val, ok := stmt.Limit.Rowcount.(*sqlparser.SQLVal); ok && val.Type == sqlparser.IntVal {
+		if n, err := strconv.Atoi(string(val.Val)); err == nil && n <= max {
+			return
+		}
+	}
+	stmt.Limit.Rowcount = maxVal
+}
+
+// checkLogSchema validates that all logs match the given schema, returning
+// the first field that doesn't, or every one of them as `FieldErrors` if all
+// is set
+func checkLogSchema(schema *compiledSchema, logs JSON, all bool) error {
+	var fieldErrs FieldErrors
+	for i, logEvent := range logs {
 		for field, value := range logEvent {
-			columnType, ok := schema[field]
-			if !ok {
-				return errors.Errorf("field %s was not specified in the schema", field)
+			fieldErr, err := checkLogField(schema, i, field, value)
+			if err != nil {
+				return err
+			}
+			if fieldErr == nil {
+				continue
 			}
-			switch columnType {
-			case "string":
-				log.Printf("The value of the %s field is %s\n", field, value.(string))
-			case "int":
-				log.Printf("The value of the %s field is %d\n", field, int(value.(float64)))
-			default:
-				// TODO: convert to error that can be used to convery more information to
-				// any exposing interfaces (http, grpc, etc)
-				return errors.Errorf("Unsupported data type in log for the field %s: %s\n", field, columnType)
+			if !all {
+				return fieldErr
+			}
+			fieldErrs = append(fieldErrs, fieldErr)
+		}
+		for _, field := range schema.required {
+			if _, ok := logEvent[field]; !ok {
+				fieldErr := &FieldError{Index: i, Field: field, Message: "required field is missing"}
+				if !all {
+					return fieldErr
+				}
+				fieldErrs = append(fieldErrs, fieldErr)
 			}
 		}
 	}
-	return nil
+	if len(fieldErrs) == 0 {
+		return nil
+	}
+	// the fields of a log are checked in no particular order
+	sort.SliceStable(fieldErrs, func(i, j int) bool {
+		if fieldErrs[i].Index != fieldErrs[j].Index {
+			return fieldErrs[i].Index < fieldErrs[j].Index
+		}
+		return fieldErrs[i].Field < fieldErrs[j].Field
+	})
+	return fieldErrs
+}
+
+// checkLogField returns a `*FieldError` if a value of the log at the index
+// doesn't match the type of its field in the schema, or an error if the type
+// itself isn't supported
+func checkLogField(schema *compiledSchema, i int, field string, value interface{}) (*FieldError, error) {
+	fieldType, ok := schema.types[field]
+	if !ok {
+		return &FieldError{Index: i, Field: field, Message: "field was not specified in the schema"}, nil
+	}
+	columnType := schema.schema[field]
+	// a null is stored as the NULL of an optional field, whatever its type
+	if value == nil && !fieldType.Required {
+		return nil, nil
+	}
+	switch fieldType.Name {
+	case "string":
+		s, ok := value.(string)
+		if !ok {
+			return mismatchError(i, field, fieldType.Name, value), nil
+		}
+		if fieldType.Length > 0 && utf8.RuneCountInString(fieldType.normalizeString(s)) > fieldType.Length {
+			return &FieldError{Index: i, Field: field, Message: fmt.Sprintf("value is longer than %d characters", fieldType.Length)}, nil
+		}
+	case "int":
+		n, ok := value.(float64)
+		if !ok {
+			return mismatchError(i, field, fieldType.Name, value), nil
+		}
+		if message := checkIntRange(n); message != "" {
+			return &FieldError{Index: i, Field: field, Message: message}, nil
+		}
+	case "bigint":
+		if _, message := bigintValue(value); message != "" {
+			return &FieldError{Index: i, Field: field, Message: message}, nil
+		}
+	case "decimal":
+		decimal, ok := decimalString(value)
+		if !ok {
+			return mismatchError(i, field, fieldType.Name, value), nil
+		}
+		if message := fieldType.checkDecimal(decimal); message != "" {
+			return &FieldError{Index: i, Field: field, Message: message}, nil
+		}
+	case "json":
+		switch value.(type) {
+		case map[string]interface{}, []interface{}:
+		default:
+			return mismatchError(i, field, "an object or an array", value), nil
+		}
+	case "any":
+		if value == nil {
+			return mismatchError(i, field, "a value", value), nil
+		}
+	default:
+		return nil, invalid(errors.Errorf("Unsupported data type in log for the field %s: %s\n", field, columnType))
+	}
+	return nil, nil
 }
 
 // String method for Family in case underlying type changes
 func (f Family) String() string {
 	return string(f)
 }
+
+// Validate returns an `ErrInvalidFamily` unless the family is a letter
+// followed by at most 63 letters, digits or underscores
+func (f Family) Validate() error {
+	if !familyPattern.MatchString(string(f)) {
+		return errors.Wrapf(ErrInvalidFamily, "invalid family %q", f)
+	}
+	return nil
+}
@@ -1,31 +1,216 @@
 package logs_test
 
 import (
+	"bytes"
+	"context"
+	"io"
 	"io/ioutil"
-	"log"
+	"log/slog"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/kolide/databalancer-logan/pkg/logs"
+	"github.com/pkg/errors"
 	"github.com/stretchr/testify/assert"
 )
 
+// newService creates a service backed by the database that doesn't log,
+// unless the options set a logger
+func newService(db logs.DBClient, opts ...logs.Option) *logs.Service {
+	return logs.CreateService(db, append([]logs.Option{logs.WithLogger(nil)}, opts...)...)
+}
+
 // MOCKS
-type mockDB struct{}
-type mockTable struct{}
+type mockDB struct {
+	query     string                      // the last query received
+	args      []interface{}               // the args of the last query received
+	block     bool                        // whether queries block until their context is done
+	delay     time.Duration               // time queries take to return
+	results   logs.JSON                   // results returned by queries
+	columns   []logs.Column               // columns returned by queries
+	inserted  logs.JSON                   // records inserted into any table
+	created   []logs.Family               // tables created
+	unique    []string                    // unique fields of the last table created
+	families  []string                    // tables described by the database, besides dog_registry
+	schemas   map[logs.Family]logs.Schema // schemas of the tables created
+	merged    []logs.Family               // sources of the last merge
+	dropped   []logs.Family               // tables dropped
+	truncated []logs.Family               // tables truncated
+	mergeErr  error                       // error returned by merges
+	createErr error                       // error returned by creating tables
+	insertErr error                       // error returned by inserts, once insertOK inserts succeeded
+	insertOK  int                         // number of inserts that succeed before insertErr
+	inserts   int                         // number of inserts attempted
+	closed    bool                        // whether the database was closed
+}
+type mockTable struct {
+	db *mockDB
+}
+
+func (m *mockDB) CreateTable(ctx context.Context, family logs.Family, schema logs.Schema, unique []string) (logs.Table, error) {
+	if m.createErr != nil {
+		return nil, m.createErr
+	}
+	m.created = append(m.created, family)
+	m.unique = unique
+	if m.schemas == nil {
+		m.schemas = make(map[logs.Family]logs.Schema)
+	}
+	m.schemas[family] = schema
+	return &mockTable{db: m}, nil
+}
+
+func (m *mockDB) CreateTableDDL(family logs.Family, schema logs.Schema, unique []string) (string, error) {
+	var fields []string
+	for field, fieldType := range schema {
+		fields = append(fields, field+" "+fieldType)
+	}
+	sort.Strings(fields)
+	return "CREATE TABLE " + family.String() + "(" + strings.Join(fields, ", ") + ");", nil
+}
+
+func (m *mockDB) Close() error {
+	m.closed = true
+	return nil
+}
+
+func (m *mockDB) QueryJSON(ctx context.Context, query string, args ...interface{}) (logs.JSON, []logs.Column, error) {
+	m.query = query
+	m.args = args
+	if m.block {
+		<-ctx.Done()
+		return nil, nil, ctx.Err()
+	}
+	time.Sleep(m.delay)
+	if m.results != nil {
+		return m.results, m.columns, nil
+	}
+	return logs.JSON{}, m.columns, nil
+}
+
+func (m *mockDB) QueryRows(ctx context.Context, query string, args []interface{}, fn func(row map[string]interface{}) error) error {
+	results, _, err := m.QueryJSON(ctx, query, args...)
+	if err != nil {
+		return err
+	}
+	for _, row := range results {
+		if err := fn(row); err != nil {
+			return err
+		}
+	}
+	return nil
+}
 
-func (m *mockDB) CreateTable(family logs.Family, schema logs.Schema) (logs.Table, error) {
-	return &mockTable{}, nil
+func (m *mockDB) DescribeDatabase(ctx context.Context) (logs.JSON, error) {
+	tables := logs.JSON{{"name": "dog_registry"}}
+	for _, family := range m.families {
+		tables = append(tables, map[string]interface{}{"name": family})
+	}
+	return tables, nil
 }
 
-func (m *mockDB) QueryJSON(query string) (logs.JSON, error) {
+func (m *mockDB) DescribeTable(ctx context.Context, family logs.Family) (logs.JSON, error) {
+	tables, _ := m.DescribeDatabase(ctx)
+	for _, table := range tables {
+		if table["name"] == family.String() {
+			return logs.JSON{table}, nil
+		}
+	}
 	return logs.JSON{}, nil
 }
 
-func (m *mockDB) DescribeDatabase() (logs.JSON, error) {
-	panic("not implemented")
+func (m *mockDB) TableSchema(ctx context.Context, family logs.Family) (logs.Schema, error) {
+	return m.schemas[family], nil
+}
+
+func (m *mockDB) ListTables(ctx context.Context) ([]string, error) {
+	tables, _ := m.DescribeDatabase(ctx)
+	var names []string
+	for _, table := range tables {
+		names = append(names, table["name"].(string))
+	}
+	return names, nil
+}
+
+func (m *mockDB) DropTable(ctx context.Context, family logs.Family) error {
+	tables, _ := m.DescribeDatabase(ctx)
+	for _, table := range tables {
+		if table["name"] == family.String() {
+			m.dropped = append(m.dropped, family)
+			return nil
+		}
+	}
+	return logs.ErrFamilyNotFound
+}
+
+func (m *mockDB) TruncateTable(ctx context.Context, family logs.Family) error {
+	tables, _ := m.DescribeDatabase(ctx)
+	for _, table := range tables {
+		if table["name"] == family.String() {
+			m.truncated = append(m.truncated, family)
+			return nil
+		}
+	}
+	return logs.ErrFamilyNotFound
+}
+
+func (m *mockDB) CountRows(ctx context.Context, family logs.Family) (int64, error) {
+	tables, _ := m.DescribeDatabase(ctx)
+	for _, table := range tables {
+		if table["name"] == family.String() {
+			return int64(len(m.results)), nil
+		}
+	}
+	return 0, logs.ErrFamilyNotFound
+}
+
+func (m *mockDB) MergeTables(ctx context.Context, target logs.Family, sources []logs.Family, sourceColumn string) error {
+	if m.mergeErr != nil {
+		return m.mergeErr
+	}
+	m.merged = sources
+	return nil
+}
+
+func (m *mockDB) PingContext(ctx context.Context) error {
+	if m.block {
+		<-ctx.Done()
+		return ctx.Err()
+	}
+	return nil
+}
+
+func (m *mockTable) Insert(ctx context.Context, records logs.JSON) (int64, error) {
+	if err := m.db.insertError(); err != nil {
+		return 0, err
+	}
+	m.db.inserted = append(m.db.inserted, records...)
+	return int64(len(records)), nil
+}
+
+func (m *mockTable) InsertReturningIDs(ctx context.Context, records logs.JSON) ([]int64, error) {
+	if err := m.db.insertError(); err != nil {
+		return nil, err
+	}
+	m.db.inserted = append(m.db.inserted, records...)
+	var ids []int64
+	for i := range records {
+		ids = append(ids, int64(i+1))
+	}
+	return ids, nil
 }
 
-func (m *mockTable) Insert(records logs.JSON) error {
+// insertError counts an insert, and returns the insert error once the
+// inserts that succeed before it are done
+func (m *mockDB) insertError() error {
+	m.inserts++
+	if m.inserts > m.insertOK {
+		return m.insertErr
+	}
 	return nil
 }
 
@@ -42,11 +227,8 @@ type ingestCase struct {
 type rawLog map[string]interface{}
 
 func TestIngest(t *testing.T) {
-	// disable logging
-	log.SetOutput(ioutil.Discard)
-
 	// GIVEN
-	service := logs.CreateService(&mockDB{})
+	service := newService(&mockDB{})
 
 	// THEN
 	successCases := []ingestCase{
@@ -74,7 +256,8 @@ func TestIngest(t *testing.T) {
 
 	for _, tt := range successCases {
 		t.Run(tt.name, func(t *testing.T) {
-			assert.NoError(t, service.Ingest(tt.family, tt.schema, tt.logs))
+			_, err := service.Ingest(context.Background(), tt.family, tt.schema, nil, tt.logs)
+			assert.NoError(t, err)
 		})
 	}
 
@@ -113,72 +296,2062 @@ func TestIngest(t *testing.T) {
 
 	for _, tt := range failureCases {
 		t.Run(tt.name, func(t *testing.T) {
-			assert.Error(t, service.Ingest(tt.family, tt.schema, tt.logs))
+			_, err := service.Ingest(context.Background(), tt.family, tt.schema, nil, tt.logs)
+			assert.Error(t, err)
 		})
 	}
 }
 
-// describes a test case for queryCase
-type queryCase struct {
-	name   string
-	query  string
-	result error
+func TestIngestExtraFields(t *testing.T) {
+	// GIVEN
+	db := &mockDB{}
+	service := newService(db)
+
+	// WHEN a log deep in the batch has a field missing from the schema
+	_, err := service.Ingest(context.Background(), "dog_registry", logs.Schema{"name": "string", "weight": "int"}, nil, logs.JSON{
+		rawLog{"name": "max", "weight": float64(3)},
+		rawLog{"name": "spot", "weight": float64(130)},
+		rawLog{"name": "spike", "weight": float64(80), "age": float64(10)},
+	})
+
+	// THEN the error names the field and the index of the log
+	fieldErr, ok := errors.Cause(err).(*logs.FieldError)
+	if assert.True(t, ok, "expected a field error, got %v", err) {
+		assert.Equal(t, 2, fieldErr.Index)
+		assert.Equal(t, "age", fieldErr.Field)
+		assert.Contains(t, err.Error(), "log 2: field age")
+	}
+	// AND the batch is rejected before the table is created
+	assert.Empty(t, db.created)
+	assert.Empty(t, db.inserted)
 }
 
-func TestQuery(t *testing.T) {
+func TestIngestAllFieldErrors(t *testing.T) {
+	schema := logs.Schema{"name": "string!", "weight": "int"}
+	records := logs.JSON{
+		rawLog{"name": "max", "weight": "heavy"},
+		rawLog{"name": "spot", "weight": float64(130)},
+		rawLog{"weight": float64(80), "age": float64(10)},
+	}
+	expected := logs.FieldErrors{
+		{Index: 0, Field: "weight", Message: `expected int, got string "heavy"`},
+		{Index: 2, Field: "age", Message: "field was not specified in the schema"},
+		{Index: 2, Field: "name", Message: "required field is missing"},
+	}
+
+	t.Run("every invalid field of the logs is reported", func(t *testing.T) {
+		// GIVEN
+		db := &mockDB{}
+		service := newService(db, logs.WithAllFieldErrors(true))
+
+		// WHEN
+		_, err := service.Ingest(context.Background(), "dog_registry", schema, nil, records)
+
+		// THEN they're sorted by log and field
+		assert.Equal(t, expected, errors.Cause(err))
+		assert.Equal(t, logs.ErrValidation, logs.Kind(err))
+		assert.Contains(t, err.Error(), "3 invalid fields: log 0: field weight")
+		assert.Empty(t, db.created)
+		assert.Empty(t, db.inserted)
+	})
+
+	t.Run("the invalid fields of a batch are indexed among all the batches", func(t *testing.T) {
+		// GIVEN
+		db := &mockDB{}
+		service := newService(db, logs.WithAllFieldErrors(true))
+
+		// WHEN
+		batches := []logs.JSON{{rawLog{"name": "spike", "weight": float64(3)}}, records}
+		_, err := service.IngestBatches(context.Background(), "dog_registry", schema, nil, func() (logs.JSON, error) {
+			if len(batches) == 0 {
+				return nil, io.EOF
+			}
+			batch := batches[0]
+			batches = batches[1:]
+			return batch, nil
+		})
+
+		// THEN
+		fieldErrs, ok := errors.Cause(err).(logs.FieldErrors)
+		if assert.True(t, ok, "expected field errors, got %v", err) && assert.Len(t, fieldErrs, 3) {
+			assert.Equal(t, 1, fieldErrs[0].Index)
+			assert.Equal(t, 3, fieldErrs[1].Index)
+			assert.Equal(t, 3, fieldErrs[2].Index)
+		}
+	})
+
+	t.Run("by default, only the first invalid field is reported", func(t *testing.T) {
+		// WHEN
+		_, err := newService(&mockDB{}).Ingest(context.Background(), "dog_registry", schema, nil, records)
+
+		// THEN
+		assert.Equal(t, expected[0], errors.Cause(err))
+	})
+}
+
+func TestIngestOverflow(t *testing.T) {
+	schema := logs.Schema{"name": "string", "weight": "int"}
+	records := logs.JSON{
+		rawLog{"name": "max", "weight": float64(3)},
+		rawLog{"name": "spike", "weight": float64(80), "age": float64(10), "toys": []interface{}{"ball"}},
+	}
+
+	t.Run("by default, a field missing from the schema is rejected", func(t *testing.T) {
+		// GIVEN
+		db := &mockDB{}
+		service := newService(db)
+
+		// WHEN
+		_, err := service.Ingest(context.Background(), "dog_registry", schema, nil, records)
+
+		// THEN
+		_, ok := errors.Cause(err).(*logs.FieldError)
+		assert.True(t, ok, "expected a field error, got %v", err)
+		assert.Empty(t, db.inserted)
+	})
+
+	t.Run("with overflow, the fields missing from the schema are kept in extra", func(t *testing.T) {
+		// GIVEN
+		db := &mockDB{}
+		service := newService(db, logs.WithOverflow(true))
+
+		// WHEN
+		result, err := service.Ingest(context.Background(), "dog_registry", schema, nil, records)
+
+		// THEN the table has a json extra column
+		assert.NoError(t, err)
+		assert.Equal(t, int64(2), result.Inserted)
+		assert.Equal(t, logs.Schema{"name": "string", "weight": "int", "extra": "json"}, db.schemas["dog_registry"])
+		assert.Equal(t, logs.JSON{
+			{"name": "max", "weight": float64(3)},
+			{"name": "spike", "weight": float64(80), "extra": map[string]interface{}{"age": float64(10), "toys": []interface{}{"ball"}}},
+		}, db.inserted)
+	})
+
+	t.Run("with overflow, an extra object of the log is kept", func(t *testing.T) {
+		// GIVEN
+		db := &mockDB{}
+		service := newService(db, logs.WithOverflow(true))
+
+		// WHEN
+		_, err := service.Ingest(context.Background(), "dog_registry", schema, nil, logs.JSON{
+			rawLog{"name": "max", "extra": map[string]interface{}{"color": "brown"}, "age": float64(3)},
+			rawLog{"name": "spot", "extra": "good boy"},
+		})
+
+		// THEN
+		assert.NoError(t, err)
+		assert.Equal(t, logs.JSON{
+			{"name": "max", "extra": map[string]interface{}{"color": "brown", "age": float64(3)}},
+			{"name": "spot", "extra": map[string]interface{}{"extra": "good boy"}},
+		}, db.inserted)
+	})
+
+	t.Run("with overflow, an extra field of another type is rejected", func(t *testing.T) {
+		// GIVEN
+		service := newService(&mockDB{}, logs.WithOverflow(true))
+
+		// WHEN
+		_, err := service.Ingest(context.Background(), "dog_registry", logs.Schema{"name": "string", "extra": "string"}, nil, records)
+
+		// THEN
+		assert.Equal(t, logs.ErrValidation, logs.Kind(err))
+		assert.Contains(t, err.Error(), "field extra of dog_registry must be json")
+	})
+}
+
+func TestIngestMismatchedTypes(t *testing.T) {
 	// GIVEN
-	service := logs.CreateService(&mockDB{})
+	service := newService(&mockDB{})
+	schema := logs.Schema{"name": "string", "weight": "int"}
 
 	// THEN
-	successCases := []queryCase{
+	cases := []struct {
+		name    string
+		logs    logs.JSON
+		field   string
+		message string
+	}{
 		{
-			name:  "a select query should be executed without problems",
-			query: "SELECT * FROM `dog_registry`;",
+			name:    "a number in a string field",
+			logs:    logs.JSON{rawLog{"name": float64(3), "weight": float64(3)}},
+			field:   "name",
+			message: "expected string, got number 3",
+		},
+		{
+			name:    "an object in a string field",
+			logs:    logs.JSON{rawLog{"name": map[string]interface{}{"first": "max"}, "weight": float64(3)}},
+			field:   "name",
+			message: "expected string, got object",
+		},
+		{
+			name:    "a string in an int field",
+			logs:    logs.JSON{rawLog{"name": "max", "weight": float64(3)}, rawLog{"name": "spot", "weight": "heavy"}},
+			field:   "weight",
+			message: `expected int, got string "heavy"`,
+		},
+		{
+			name:    "a boolean in an int field",
+			logs:    logs.JSON{rawLog{"name": "max", "weight": true}},
+			field:   "weight",
+			message: "expected int, got boolean true",
 		},
 	}
 
-	for _, tt := range successCases {
+	for _, tt := range cases {
 		t.Run(tt.name, func(t *testing.T) {
-			_, err := service.Query(tt.query)
-			assert.NoError(t, err)
+			_, err := service.Ingest(context.Background(), "dog_registry", schema, nil, tt.logs)
+			fieldErr, ok := errors.Cause(err).(*logs.FieldError)
+			if assert.True(t, ok, "expected a field error, got %v", err) {
+				assert.Equal(t, len(tt.logs)-1, fieldErr.Index)
+				assert.Equal(t, tt.field, fieldErr.Field)
+				assert.Equal(t, tt.message, fieldErr.Message)
+			}
 		})
 	}
+}
 
-	failureCases := []queryCase{
-		{
-			name:  "multiple select statements should return an error",
-			query: "SELECT * FROM `dog_registry`; SELECT * FROM `users`;",
-		},
-		{
-			name:  "invalid statements should return an error",
-			query: "SELECT * FROMa;",
-		},
+func TestIngestStringLength(t *testing.T) {
+	t.Run("strings within the length are ingested", func(t *testing.T) {
+		db := &mockDB{}
+		service := newService(db)
+		_, err := service.Ingest(context.Background(), "request_log", logs.Schema{"status": "string(5)"}, nil, logs.JSON{
+			rawLog{"status": "ok"},
+			rawLog{"status": "échec"},
+		})
+		assert.NoError(t, err)
+		assert.Len(t, db.inserted, 2)
+	})
+
+	t.Run("strings longer than the length are rejected", func(t *testing.T) {
+		service := newService(&mockDB{})
+		_, err := service.Ingest(context.Background(), "request_log", logs.Schema{"status": "string(5)"}, nil, logs.JSON{
+			rawLog{"status": "ok"},
+			rawLog{"status": "failed"},
+		})
+		fieldErr, ok := errors.Cause(err).(*logs.FieldError)
+		if assert.True(t, ok, "expected a field error, got %v", err) {
+			assert.Equal(t, 1, fieldErr.Index)
+			assert.Equal(t, "status", fieldErr.Field)
+		}
+	})
+
+	invalid := []string{"string(0)", "string(-1)", "string(abc)", "string()", "string(16384)", "int(11)"}
+	for _, fieldType := range invalid {
+		t.Run("the invalid type "+fieldType+" is rejected", func(t *testing.T) {
+			_, err := logs.ParseFieldType(fieldType)
+			assert.Error(t, err)
+		})
 	}
 
-	for _, tt := range failureCases {
-		t.Run(tt.name, func(t *testing.T) {
-			_, err := service.Query(tt.query)
+	t.Run("the length is parsed along with the options", func(t *testing.T) {
+		parsed, err := logs.ParseFieldType("string(32):trim")
+		assert.NoError(t, err)
+		assert.Equal(t, logs.FieldType{Name: "string", Length: 32, Normalize: []string{logs.NormalizeTrim}}, parsed)
+	})
+}
+
+func TestIngestBigints(t *testing.T) {
+	schema := logs.Schema{"timestamp": "bigint"}
+
+	t.Run("integers beyond 32 bits are ingested as int64", func(t *testing.T) {
+		db := &mockDB{}
+		service := newService(db)
+		_, err := service.Ingest(context.Background(), "events", schema, nil, logs.JSON{
+			rawLog{"timestamp": float64(1514764800000)},
+			rawLog{"timestamp": float64(-3000000000)},
+			rawLog{"timestamp": float64(1 << 53)},
+			rawLog{"timestamp": "1514764800123456789"},
+			rawLog{"timestamp": "9223372036854775807"},
+		})
+		assert.NoError(t, err)
+		assert.Equal(t, logs.JSON{
+			rawLog{"timestamp": int64(1514764800000)},
+			rawLog{"timestamp": int64(-3000000000)},
+			rawLog{"timestamp": int64(1 << 53)},
+			rawLog{"timestamp": int64(1514764800123456789)},
+			rawLog{"timestamp": int64(9223372036854775807)},
+		}, db.inserted)
+	})
+
+	cases := []struct {
+		name    string
+		value   interface{}
+		message string
+	}{
+		{name: "a number past the precision of a float", value: float64(1<<53 + 2), message: "value is larger than 9007199254740992, which may lose precision as a number, so send it as a string"},
+		{name: "a fraction", value: float64(1.5), message: "value is not an integer"},
+		{name: "a string past 64 bits", value: "9223372036854775808", message: "value doesn't fit in a 64-bit integer"},
+		{name: "a string that isn't an integer", value: "12.5", message: `expected bigint, got string "12.5"`},
+		{name: "a boolean", value: true, message: "expected bigint, got boolean true"},
+	}
+	for _, tt := range cases {
+		t.Run(tt.name+" is rejected", func(t *testing.T) {
+			service := newService(&mockDB{})
+			_, err := service.Ingest(context.Background(), "events", schema, nil, logs.JSON{rawLog{"timestamp": tt.value}})
+			fieldErr, ok := errors.Cause(err).(*logs.FieldError)
+			if assert.True(t, ok, "expected a field error, got %v", err) {
+				assert.Equal(t, "timestamp", fieldErr.Field)
+				assert.Equal(t, tt.message, fieldErr.Message)
+			}
+		})
+	}
+}
+
+func TestIngestIntRange(t *testing.T) {
+	schema := logs.Schema{"weight": "int"}
+
+	t.Run("integers within 32 bits are ingested", func(t *testing.T) {
+		db := &mockDB{}
+		service := newService(db)
+		_, err := service.Ingest(context.Background(), "dog_registry", schema, nil, logs.JSON{
+			rawLog{"weight": float64(1<<31 - 1)},
+			rawLog{"weight": float64(-1 << 31)},
+		})
+		assert.NoError(t, err)
+		assert.Len(t, db.inserted, 2)
+	})
+
+	cases := []struct {
+		name  string
+		value float64
+	}{
+		{name: "2^31", value: 1 << 31},
+		{name: "-2^31-1", value: -1<<31 - 1},
+		{name: "a fraction rounding past 2^31-1", value: 1<<31 - 0.5},
+		{name: "5000000000", value: 5000000000},
+	}
+	for _, tt := range cases {
+		t.Run(tt.name+" is rejected", func(t *testing.T) {
+			service := newService(&mockDB{})
+			_, err := service.Ingest(context.Background(), "dog_registry", schema, nil, logs.JSON{rawLog{"weight": tt.value}})
+			fieldErr, ok := errors.Cause(err).(*logs.FieldError)
+			if assert.True(t, ok, "expected a field error, got %v", err) {
+				assert.Equal(t, "weight", fieldErr.Field)
+				assert.Equal(t, "value is outside the range of int, from -2147483648 to 2147483647, so use the bigint type for larger values", fieldErr.Message)
+			}
+		})
+	}
+}
+
+func TestIngestDecimals(t *testing.T) {
+	schema := logs.Schema{"price": "decimal(6,2)"}
+
+	t.Run("decimal numbers and numeric strings are ingested as strings", func(t *testing.T) {
+		db := &mockDB{}
+		service := newService(db)
+		_, err := service.Ingest(context.Background(), "orders", schema, nil, logs.JSON{
+			rawLog{"price": float64(19.99)},
+			rawLog{"price": "1234.50"},
+			rawLog{"price": "-0.5"},
+			rawLog{"price": float64(42)},
+		})
+		assert.NoError(t, err)
+		assert.Equal(t, logs.JSON{
+			rawLog{"price": "19.99"},
+			rawLog{"price": "1234.50"},
+			rawLog{"price": "-0.5"},
+			rawLog{"price": "42"},
+		}, db.inserted)
+	})
+
+	cases := []struct {
+		name    string
+		value   interface{}
+		message string
+	}{
+		{name: "a value with more digits than the scale", value: "1.999", message: "value has more than 2 digits after the decimal point"},
+		{name: "a number with more digits than the scale", value: float64(0.125), message: "value has more than 2 digits after the decimal point"},
+		{name: "a value with more digits than the precision", value: "12345.5", message: "value has more than 4 digits before the decimal point"},
+		{name: "a string that isn't a number", value: "12 dollars", message: `expected decimal, got string "12 dollars"`},
+		{name: "a number in exponent notation", value: "1e3", message: `expected decimal, got string "1e3"`},
+		{name: "a boolean", value: true, message: "expected decimal, got boolean true"},
+	}
+	for _, tt := range cases {
+		t.Run(tt.name+" is rejected", func(t *testing.T) {
+			service := newService(&mockDB{})
+			_, err := service.Ingest(context.Background(), "orders", schema, nil, logs.JSON{rawLog{"price": tt.value}})
+			fieldErr, ok := errors.Cause(err).(*logs.FieldError)
+			if assert.True(t, ok, "expected a field error, got %v", err) {
+				assert.Equal(t, "price", fieldErr.Field)
+				assert.Equal(t, tt.message, fieldErr.Message)
+			}
+		})
+	}
+
+	t.Run("a decimal without a precision holds integers", func(t *testing.T) {
+		parsed, err := logs.ParseFieldType("decimal")
+		assert.NoError(t, err)
+		assert.Equal(t, logs.FieldType{Name: "decimal", Precision: logs.DefaultDecimalPrecision, Scale: logs.DefaultDecimalScale}, parsed)
+	})
+
+	t.Run("a decimal without a scale has a scale of 0", func(t *testing.T) {
+		parsed, err := logs.ParseFieldType("decimal(12)!")
+		assert.NoError(t, err)
+		assert.Equal(t, logs.FieldType{Name: "decimal", Precision: 12, Required: true}, parsed)
+	})
+
+	invalid := []string{"decimal(0,0)", "decimal(66,2)", "decimal(10,31)", "decimal(2,3)", "decimal(a,b)", "decimal(10,2):trim"}
+	for _, fieldType := range invalid {
+		t.Run("the invalid type "+fieldType+" is rejected", func(t *testing.T) {
+			_, err := logs.ParseFieldType(fieldType)
 			assert.Error(t, err)
 		})
 	}
+}
+
+func TestIngestJSON(t *testing.T) {
+	schema := logs.Schema{"geo": "json"}
+
+	t.Run("objects and arrays are ingested as is", func(t *testing.T) {
+		db := &mockDB{}
+		service := newService(db)
+		records := logs.JSON{
+			rawLog{"geo": map[string]interface{}{"lat": 1.0, "lng": 2.0}},
+			rawLog{"geo": []interface{}{1.0, 2.0}},
+			rawLog{"geo": map[string]interface{}{"city": map[string]interface{}{"name": "Boston"}}},
+		}
+		_, err := service.Ingest(context.Background(), "request_log", schema, nil, records)
+		assert.NoError(t, err)
+		assert.Equal(t, records, db.inserted)
+	})
+
+	cases := []struct {
+		name    string
+		value   interface{}
+		message string
+	}{
+		{name: "a string", value: "Boston", message: `expected an object or an array, got string "Boston"`},
+		{name: "a number", value: float64(1), message: "expected an object or an array, got number 1"},
+	}
+	for _, tt := range cases {
+		t.Run(tt.name+" is rejected", func(t *testing.T) {
+			service := newService(&mockDB{})
+			_, err := service.Ingest(context.Background(), "request_log", schema, nil, logs.JSON{rawLog{"geo": tt.value}})
+			fieldErr, ok := errors.Cause(err).(*logs.FieldError)
+			if assert.True(t, ok, "expected a field error, got %v", err) {
+				assert.Equal(t, "geo", fieldErr.Field)
+				assert.Equal(t, tt.message, fieldErr.Message)
+			}
+		})
+	}
+}
 
-	errCases := []queryCase{
+func TestIngestAny(t *testing.T) {
+	t.Run("values of every type are ingested as is", func(t *testing.T) {
+		db := &mockDB{}
+		service := newService(db)
+		records := logs.JSON{
+			rawLog{"meta": "Boston"},
+			rawLog{"meta": float64(3)},
+			rawLog{"meta": true},
+			rawLog{"meta": nil},
+			rawLog{"meta": map[string]interface{}{"lat": 1.0, "lng": 2.0}},
+			rawLog{"meta": []interface{}{"a", 1.0}},
+		}
+		_, err := service.Ingest(context.Background(), "request_log", logs.Schema{"meta": "any"}, nil, records)
+		assert.NoError(t, err)
+		assert.Equal(t, records, db.inserted)
+	})
+
+	t.Run("a null value of a required field is rejected", func(t *testing.T) {
+		service := newService(&mockDB{})
+		_, err := service.Ingest(context.Background(), "request_log", logs.Schema{"meta": "any!"}, nil, logs.JSON{rawLog{"meta": nil}})
+		fieldErr, ok := errors.Cause(err).(*logs.FieldError)
+		if assert.True(t, ok, "expected a field error, got %v", err) {
+			assert.Equal(t, "meta", fieldErr.Field)
+			assert.Equal(t, "expected a value, got null", fieldErr.Message)
+		}
+	})
+}
+
+func TestIngestWithoutSchema(t *testing.T) {
+	// GIVEN
+	db := &mockDB{}
+	service := newService(db)
+
+	t.Run("the first ingest of a family requires a schema", func(t *testing.T) {
+		_, err := service.Ingest(context.Background(), "request_log", nil, nil, logs.JSON{rawLog{"path": "/"}})
+		assert.Equal(t, logs.ErrValidation, errors.Cause(err))
+		assert.Empty(t, db.inserted)
+	})
+
+	t.Run("the first ingest with a schema creates the family", func(t *testing.T) {
+		schema := logs.Schema{"path": "string(255)!", "status": "int"}
+		_, err := service.Ingest(context.Background(), "request_log", schema, nil, logs.JSON{rawLog{"path": "/", "status": float64(200)}})
+		assert.NoError(t, err)
+	})
+
+	t.Run("a later ingest without a schema is validated against the family's", func(t *testing.T) {
+		_, err := service.Ingest(context.Background(), "request_log", nil, nil, logs.JSON{rawLog{"path": "/about", "status": float64(404)}})
+		assert.NoError(t, err)
+		assert.Equal(t, logs.JSON{
+			rawLog{"path": "/", "status": float64(200)},
+			rawLog{"path": "/about", "status": float64(404)},
+		}, db.inserted)
+
+		_, err = service.Ingest(context.Background(), "request_log", nil, nil, logs.JSON{rawLog{"path": "/", "status": "ok"}})
+		fieldErr, ok := errors.Cause(err).(*logs.FieldError)
+		if assert.True(t, ok, "expected a field error, got %v", err) {
+			assert.Equal(t, "status", fieldErr.Field)
+		}
+
+		_, err = service.Ingest(context.Background(), "request_log", nil, nil, logs.JSON{rawLog{"status": float64(500)}})
+		fieldErr, ok = errors.Cause(err).(*logs.FieldError)
+		if assert.True(t, ok, "expected a field error, got %v", err) {
+			assert.Equal(t, "path", fieldErr.Field)
+			assert.Equal(t, "required field is missing", fieldErr.Message)
+		}
+	})
+}
+
+func TestIngestRequiredFields(t *testing.T) {
+	schema := logs.Schema{"name": "string!", "breed": "string(32)!:trim", "weight": "int"}
+
+	t.Run("logs with the required fields are ingested", func(t *testing.T) {
+		db := &mockDB{}
+		service := newService(db)
+		_, err := service.Ingest(context.Background(), "dog_registry", schema, nil, logs.JSON{
+			rawLog{"name": "max", "breed": "chihuahua", "weight": float64(3)},
+		})
+		assert.NoError(t, err)
+		assert.Len(t, db.inserted, 1)
+	})
+
+	t.Run("optional fields can be left out", func(t *testing.T) {
+		db := &mockDB{}
+		service := newService(db)
+		_, err := service.Ingest(context.Background(), "dog_registry", schema, nil, logs.JSON{
+			rawLog{"name": "max", "breed": "chihuahua"},
+		})
+		assert.NoError(t, err)
+		assert.Len(t, db.inserted, 1)
+	})
+
+	t.Run("logs missing a required field are rejected", func(t *testing.T) {
+		db := &mockDB{}
+		service := newService(db)
+		_, err := service.Ingest(context.Background(), "dog_registry", schema, nil, logs.JSON{
+			rawLog{"name": "max", "breed": "chihuahua"},
+			rawLog{"name": "spot", "weight": float64(130)},
+		})
+		fieldErr, ok := errors.Cause(err).(*logs.FieldError)
+		if assert.True(t, ok, "expected a field error, got %v", err) {
+			assert.Equal(t, 1, fieldErr.Index)
+			assert.Equal(t, "breed", fieldErr.Field)
+		}
+		assert.Empty(t, db.inserted)
+	})
+
+	t.Run("the required mark is parsed along with the length and options", func(t *testing.T) {
+		parsed, err := logs.ParseFieldType("string(32)!:trim")
+		assert.NoError(t, err)
+		assert.Equal(t, logs.FieldType{Name: "string", Length: 32, Required: true, Normalize: []string{logs.NormalizeTrim}}, parsed)
+	})
+}
+
+func TestIngestNullFields(t *testing.T) {
+	// THEN a null is ingested in an optional field of any type, and is
+	// rejected in a required one
+	cases := []struct {
+		fieldType string
+		message   string
+	}{
+		{fieldType: "string", message: "expected string, got null"},
+		{fieldType: "string(32):trim", message: "expected string, got null"},
+		{fieldType: "int", message: "expected int, got null"},
+		{fieldType: "bigint", message: "expected bigint, got null"},
+		{fieldType: "decimal(10,2)", message: "expected decimal, got null"},
+		{fieldType: "json", message: "expected an object or an array, got null"},
+		{fieldType: "any", message: "expected a value, got null"},
+	}
+
+	for _, tt := range cases {
+		t.Run("a null in an optional "+tt.fieldType+" field is ingested", func(t *testing.T) {
+			// GIVEN
+			db := &mockDB{}
+			service := newService(db)
+
+			// WHEN
+			_, err := service.Ingest(context.Background(), "request_log", logs.Schema{"path": "string", "value": tt.fieldType}, nil, logs.JSON{
+				rawLog{"path": "/", "value": nil},
+			})
+
+			// THEN
+			assert.NoError(t, err)
+			if assert.Len(t, db.inserted, 1) {
+				value, ok := db.inserted[0]["value"]
+				assert.True(t, ok)
+				assert.Nil(t, value)
+			}
+		})
+
+		t.Run("a null in a required "+tt.fieldType+" field is rejected", func(t *testing.T) {
+			// GIVEN
+			db := &mockDB{}
+			service := newService(db)
+			fieldType := strings.Replace(tt.fieldType, ":", "!:", 1)
+			if !strings.Contains(fieldType, "!") {
+				fieldType += "!"
+			}
+
+			// WHEN
+			_, err := service.Ingest(context.Background(), "request_log", logs.Schema{"path": "string", "value": fieldType}, nil, logs.JSON{
+				rawLog{"path": "/", "value": nil},
+			})
+
+			// THEN
+			fieldErr, ok := errors.Cause(err).(*logs.FieldError)
+			if assert.True(t, ok, "expected a field error, got %v", err) {
+				assert.Equal(t, 0, fieldErr.Index)
+				assert.Equal(t, "value", fieldErr.Field)
+				assert.Equal(t, tt.message, fieldErr.Message)
+			}
+			assert.Empty(t, db.inserted)
+		})
+	}
+}
+
+func TestIngestUnique(t *testing.T) {
+	schema := logs.Schema{"request_id": "string(36)", "status": "int"}
+
+	t.Run("the unique fields are passed to the table", func(t *testing.T) {
+		// GIVEN
+		db := &mockDB{}
+		service := newService(db)
+
+		// WHEN
+		_, err := service.Ingest(context.Background(), "request_log", schema, []string{"request_id"}, logs.JSON{
+			rawLog{"request_id": "a1", "status": float64(200)},
+		})
+
+		// THEN
+		assert.NoError(t, err)
+		assert.Equal(t, []string{"request_id"}, db.unique)
+	})
+
+	t.Run("a unique field outside of the schema is rejected", func(t *testing.T) {
+		// GIVEN
+		db := &mockDB{}
+		service := newService(db)
+
+		// WHEN
+		_, err := service.Ingest(context.Background(), "request_log", schema, []string{"path"}, logs.JSON{
+			rawLog{"request_id": "a1", "status": float64(200)},
+		})
+
+		// THEN
+		assert.EqualError(t, err, "unique field path of request_log is not in the schema")
+		assert.Empty(t, db.created)
+	})
+}
+
+func TestIngestLogger(t *testing.T) {
+	// GIVEN a service logging to a buffer
+	var buf bytes.Buffer
+	service := newService(&mockDB{}, logs.WithLogger(slog.New(slog.NewTextHandler(&buf, nil))))
+
+	// WHEN logs are ingested
+	_, err := service.Ingest(context.Background(), "dog_registry", logs.Schema{"name": "string"}, nil, logs.JSON{
+		rawLog{"name": "max"},
+		rawLog{"name": "spot"},
+	})
+	assert.NoError(t, err)
+
+	// THEN a single line summarizes the ingest
+	assert.Equal(t, 1, bytes.Count(buf.Bytes(), []byte("\n")))
+	assert.Contains(t, buf.String(), "family=dog_registry")
+	assert.Contains(t, buf.String(), "rows=2")
+	assert.Contains(t, buf.String(), "duration=")
+}
+
+func TestIngestLoggerRequestID(t *testing.T) {
+	// GIVEN a service logging to a buffer
+	var buf bytes.Buffer
+	service := newService(&mockDB{}, logs.WithLogger(slog.New(slog.NewTextHandler(&buf, nil))))
+
+	// WHEN logs are ingested for a request
+	ctx := logs.WithRequestID(context.Background(), "req-1234")
+	_, err := service.Ingest(ctx, "dog_registry", logs.Schema{"name": "string"}, nil, logs.JSON{rawLog{"name": "max"}})
+	assert.NoError(t, err)
+
+	// THEN the ID of the request is logged
+	assert.Contains(t, buf.String(), "request_id=req-1234")
+}
+
+func TestIngestDDL(t *testing.T) {
+	// GIVEN
+	service := newService(&mockDB{})
+
+	t.Run("the DDL of a family without a table needs its schema", func(t *testing.T) {
+		_, err := service.IngestDDL(context.Background(), "dog_registry", nil, nil)
+		assert.Equal(t, logs.ErrValidation, logs.Kind(err))
+	})
+
+	t.Run("the DDL is generated by the database client for the schema", func(t *testing.T) {
+		ddl, err := service.IngestDDL(context.Background(), "dog_registry", logs.Schema{"name": "string", "weight": "int"}, nil)
+		assert.NoError(t, err)
+		assert.Equal(t, "CREATE TABLE dog_registry(name string, weight int);", ddl)
+	})
+
+	t.Run("the DDL of an existing family reads its schema", func(t *testing.T) {
+		_, err := service.Ingest(context.Background(), "dog_registry", logs.Schema{"name": "string"}, nil, logs.JSON{rawLog{"name": "max"}})
+		assert.NoError(t, err)
+		ddl, err := service.IngestDDL(context.Background(), "dog_registry", nil, nil)
+		assert.NoError(t, err)
+		assert.Equal(t, "CREATE TABLE dog_registry(name string);", ddl)
+	})
+
+	t.Run("an invalid family is rejected", func(t *testing.T) {
+		_, err := service.IngestDDL(context.Background(), "dog registry", logs.Schema{"name": "string"}, nil)
+		assert.Equal(t, logs.ErrInvalidFamily, errors.Cause(err))
+	})
+}
+
+func TestIngestSchemaTypeCase(t *testing.T) {
+	// GIVEN
+	db := &mockDB{}
+	service := newService(db)
+
+	// WHEN the types of the schema are mixed-case or padded
+	_, err := service.Ingest(context.Background(), "dog_registry",
+		logs.Schema{"name": "String", "weight": " int ", "age": "INT", "nickname": "STRING:Trim"}, nil,
+		logs.JSON{rawLog{"name": "max", "weight": float64(30), "age": float64(3), "nickname": " maxy "}})
+
+	// THEN they're validated like their lowercase types
+	assert.NoError(t, err)
+	assert.Equal(t, logs.JSON{rawLog{"name": "max", "weight": float64(30), "age": float64(3), "nickname": "maxy"}}, db.inserted)
+
+	// AND a genuinely unknown type is still rejected
+	_, err = service.Ingest(context.Background(), "dog_registry", logs.Schema{"name": " Strng "}, nil, logs.JSON{rawLog{"name": "max"}})
+	assert.Equal(t, logs.ErrValidation, logs.Kind(err))
+}
+
+func TestIngestInvalidSchemaTypes(t *testing.T) {
+	cases := []struct {
+		name   string
+		schema logs.Schema
+		field  string
+	}{
 		{
-			name:   "an INSERT statement should return a read only error",
-			query:  "INSERT INTO `dog_registry`(`name`, `weight`) VALUES('spot', 130);",
-			result: logs.ErrReadOnly,
+			name:   "an empty type is rejected",
+			schema: logs.Schema{"name": "string", "weight": ""},
+			field:  "weight",
 		},
 		{
-			name:   "a DROP statement should return a read only error",
-			query:  "DROP TABLE `dog_registry`;",
-			result: logs.ErrReadOnly,
+			name:   "a blank type is rejected",
+			schema: logs.Schema{"name": "string", "weight": "  "},
+			field:  "weight",
+		},
+		{
+			name:   "a misspelled type is rejected",
+			schema: logs.Schema{"name": "strng", "weight": "int"},
+			field:  "name",
+		},
+		{
+			name:   "a misspelled required type is rejected",
+			schema: logs.Schema{"name": "string", "weight": "innt!"},
+			field:  "weight",
 		},
 	}
-
-	for _, tt := range errCases {
+	for _, tt := range cases {
 		t.Run(tt.name, func(t *testing.T) {
-			_, err := service.Query(tt.query)
-			assert.Equal(t, tt.result, err)
+			// GIVEN
+			db := &mockDB{}
+			service := newService(db)
+
+			// WHEN the logs don't have the field with the bad type
+			_, err := service.Ingest(context.Background(), "dog_registry", tt.schema, nil, logs.JSON{rawLog{"name": "max"}})
+
+			// THEN the ingest is invalid, names the field, and no table is created
+			assert.Equal(t, logs.ErrValidation, logs.Kind(err))
+			assert.Contains(t, err.Error(), "field "+tt.field)
+			assert.Empty(t, db.created)
+
+			// AND the DDL isn't generated either
+			_, err = service.IngestDDL(context.Background(), "dog_registry", tt.schema, nil)
+			assert.Equal(t, logs.ErrValidation, logs.Kind(err))
 		})
 	}
 }
+
+func TestIngestWithoutLogger(t *testing.T) {
+	// GIVEN stdout and the default logger writing to buffers
+	var buf bytes.Buffer
+	defaultLogger := slog.Default()
+	slog.SetDefault(slog.New(slog.NewTextHandler(&buf, nil)))
+	defer slog.SetDefault(defaultLogger)
+	stdout := os.Stdout
+	r, w, err := os.Pipe()
+	assert.NoError(t, err)
+	os.Stdout = w
+	defer func() { os.Stdout = stdout }()
+
+	// WHEN logs are ingested by a service with a nil logger
+	service := logs.CreateService(&mockDB{}, logs.WithLogger(nil))
+	_, err = service.Ingest(context.Background(), "dog_registry", logs.Schema{"name": "string"}, nil, logs.JSON{rawLog{"name": "max"}})
+	assert.NoError(t, err)
+	w.Close()
+	os.Stdout = stdout
+	out, err := ioutil.ReadAll(r)
+	assert.NoError(t, err)
+
+	// THEN nothing is logged
+	assert.Empty(t, string(out))
+	assert.Empty(t, buf.String())
+}
+
+func TestIngestReturningIDs(t *testing.T) {
+	records := logs.JSON{
+		rawLog{"name": "max", "breed": "chihuahua", "weight": float64(3)},
+		rawLog{"name": "spot", "breed": "husky", "weight": float64(130)},
+	}
+	schema := logs.Schema{"name": "string", "breed": "string", "weight": "int"}
+
+	t.Run("ids are not returned by default", func(t *testing.T) {
+		service := newService(&mockDB{})
+		result, err := service.Ingest(context.Background(), "dog_registry", schema, nil, records)
+		assert.NoError(t, err)
+		assert.Nil(t, result.IDs)
+		assert.Equal(t, int64(2), result.Inserted)
+	})
+
+	t.Run("ids of the inserted records are returned when enabled", func(t *testing.T) {
+		service := newService(&mockDB{}, logs.WithReturnIDs(true))
+		result, err := service.Ingest(context.Background(), "dog_registry", schema, nil, records)
+		assert.NoError(t, err)
+		assert.Equal(t, []int64{1, 2}, result.IDs)
+		assert.Equal(t, int64(2), result.Inserted)
+	})
+}
+
+// describes a test case for queryCase
+type queryCase struct {
+	name   string
+	query  string
+	result error
+}
+
+func TestIngestBatches(t *testing.T) {
+	// batchesOf returns the batches one after the other
+	batchesOf := func(batches ...logs.JSON) func() (logs.JSON, error) {
+		return func() (logs.JSON, error) {
+			if len(batches) == 0 {
+				return nil, io.EOF
+			}
+			batch := batches[0]
+			batches = batches[1:]
+			return batch, nil
+		}
+	}
+	schema := logs.Schema{"name": "string", "weight": "int"}
+
+	t.Run("each batch is inserted into the table created once", func(t *testing.T) {
+		// GIVEN
+		db := &mockDB{}
+		service := newService(db, logs.WithReturnIDs(true))
+
+		// WHEN
+		result, err := service.IngestBatches(context.Background(), "dog_registry", schema, nil, batchesOf(
+			logs.JSON{rawLog{"name": "max"}, rawLog{"name": "spot"}},
+			logs.JSON{rawLog{"name": "rex"}},
+		))
+
+		// THEN
+		assert.NoError(t, err)
+		assert.Equal(t, int64(3), result.Inserted)
+		assert.Equal(t, []int64{1, 2, 1}, result.IDs)
+		assert.Equal(t, []logs.Family{"dog_registry"}, db.created)
+		assert.Len(t, db.inserted, 3)
+	})
+
+	t.Run("an invalid log is reported with its index among the batches", func(t *testing.T) {
+		// GIVEN
+		db := &mockDB{}
+		service := newService(db)
+
+		// WHEN
+		_, err := service.IngestBatches(context.Background(), "dog_registry", schema, nil, batchesOf(
+			logs.JSON{rawLog{"name": "max"}, rawLog{"name": "spot"}},
+			logs.JSON{rawLog{"name": "rex"}, rawLog{"name": "spike", "weight": "heavy"}},
+		))
+
+		// THEN the batch before it is inserted
+		fieldErr, ok := errors.Cause(err).(*logs.FieldError)
+		if assert.True(t, ok) {
+			assert.Equal(t, 3, fieldErr.Index)
+			assert.Equal(t, "weight", fieldErr.Field)
+		}
+		assert.Len(t, db.inserted, 2)
+	})
+
+	t.Run("a failure inserting a batch returns the logs inserted before it", func(t *testing.T) {
+		// GIVEN a database failing the second insert
+		db := &mockDB{insertOK: 1, insertErr: errors.New("Error 1205: Lock wait timeout exceeded")}
+		service := newService(db, logs.WithReturnIDs(true))
+
+		// WHEN
+		result, err := service.IngestBatches(context.Background(), "dog_registry", schema, nil, batchesOf(
+			logs.JSON{rawLog{"name": "max"}, rawLog{"name": "spot"}},
+			logs.JSON{rawLog{"name": "rex"}},
+		))
+
+		// THEN the result has the first batch, which is stored
+		assert.Error(t, err)
+		assert.Equal(t, int64(2), result.Inserted)
+		assert.Equal(t, []int64{1, 2}, result.IDs)
+		assert.Len(t, db.inserted, 2)
+	})
+
+	t.Run("an error of the batches is returned as is", func(t *testing.T) {
+		// GIVEN
+		failed := errors.New("body too large")
+		service := newService(&mockDB{})
+
+		// WHEN
+		_, err := service.IngestBatches(context.Background(), "dog_registry", schema, nil, func() (logs.JSON, error) {
+			return nil, failed
+		})
+
+		// THEN
+		assert.Equal(t, failed, err)
+	})
+}
+
+func TestQuery(t *testing.T) {
+	// GIVEN
+	service := newService(&mockDB{})
+
+	// THEN
+	successCases := []queryCase{
+		{
+			name:  "a select query should be executed without problems",
+			query: "SELECT * FROM `dog_registry`;",
+		},
+	}
+
+	for _, tt := range successCases {
+		t.Run(tt.name, func(t *testing.T) {
+			_, _, err := service.Query(context.Background(), tt.query)
+			assert.NoError(t, err)
+		})
+	}
+
+	failureCases := []queryCase{
+		{
+			name:  "multiple select statements should return an error",
+			query: "SELECT * FROM `dog_registry`; SELECT * FROM `users`;",
+		},
+		{
+			name:  "invalid statements should return an error",
+			query: "SELECT * FROMa;",
+		},
+	}
+
+	for _, tt := range failureCases {
+		t.Run(tt.name, func(t *testing.T) {
+			_, _, err := service.Query(context.Background(), tt.query)
+			assert.Error(t, err)
+		})
+	}
+
+	errCases := []queryCase{
+		{
+			name:   "an INSERT statement should return a read only error",
+			query:  "INSERT INTO `dog_registry`(`name`, `weight`) VALUES('spot', 130);",
+			result: logs.ErrReadOnly,
+		},
+		{
+			name:   "a DROP statement should return a read only error",
+			query:  "DROP TABLE `dog_registry`;",
+			result: logs.ErrReadOnly,
+		},
+	}
+
+	for _, tt := range errCases {
+		t.Run(tt.name, func(t *testing.T) {
+			_, _, err := service.Query(context.Background(), tt.query)
+			assert.Equal(t, tt.result, err)
+		})
+	}
+}
+
+func TestQueryUnsafe(t *testing.T) {
+	// GIVEN
+	db := &mockDB{}
+	service := newService(db)
+
+	// THEN
+	successCases := []queryCase{
+		{
+			name:  "a select with a where clause and a function should pass",
+			query: "SELECT name, COUNT(*) FROM `dog_registry` WHERE weight > 20 GROUP BY name;",
+		},
+		{
+			name:  "a select with a subquery should pass",
+			query: "SELECT * FROM `dog_registry` WHERE id IN (SELECT dog_id FROM `vet_visits`);",
+		},
+	}
+
+	for _, tt := range successCases {
+		t.Run(tt.name, func(t *testing.T) {
+			_, _, err := service.Query(context.Background(), tt.query)
+			assert.NoError(t, err)
+		})
+	}
+
+	errCases := []queryCase{
+		{
+			name:   "SLEEP() should return a read only error",
+			query:  "SELECT SLEEP(10) FROM `dog_registry`;",
+			result: logs.ErrReadOnly,
+		},
+		{
+			name:   "LOAD_FILE() should return a read only error",
+			query:  "SELECT load_file('/etc/passwd');",
+			result: logs.ErrReadOnly,
+		},
+		{
+			name:   "BENCHMARK() should return a read only error",
+			query:  "SELECT BENCHMARK(1000000, MD5('dog'));",
+			result: logs.ErrReadOnly,
+		},
+		{
+			name:   "GET_LOCK() should return a read only error",
+			query:  "SELECT GET_LOCK('dogs', 10);",
+			result: logs.ErrReadOnly,
+		},
+		{
+			name:   "RELEASE_LOCK() should return a read only error",
+			query:  "SELECT RELEASE_LOCK('dogs');",
+			result: logs.ErrReadOnly,
+		},
+		{
+			name:   "RELEASE_ALL_LOCKS() should return a read only error",
+			query:  "SELECT RELEASE_ALL_LOCKS();",
+			result: logs.ErrReadOnly,
+		},
+		{
+			name:   "IS_FREE_LOCK() should return a read only error",
+			query:  "SELECT IS_FREE_LOCK('dogs');",
+			result: logs.ErrReadOnly,
+		},
+		{
+			name:   "IS_USED_LOCK() should return a read only error",
+			query:  "SELECT IS_USED_LOCK('dogs');",
+			result: logs.ErrReadOnly,
+		},
+		{
+			name:   "MASTER_POS_WAIT() should return a read only error",
+			query:  "SELECT MASTER_POS_WAIT('mysql-bin.000001', 4);",
+			result: logs.ErrReadOnly,
+		},
+		{
+			name:   "SOURCE_POS_WAIT() should return a read only error",
+			query:  "SELECT SOURCE_POS_WAIT('mysql-bin.000001', 4);",
+			result: logs.ErrReadOnly,
+		},
+		{
+			name:   "an unsafe function in a subquery should return a read only error",
+			query:  "SELECT * FROM `dog_registry` WHERE id IN (SELECT SLEEP(10));",
+			result: logs.ErrReadOnly,
+		},
+		{
+			name:   "a locking read should return a read only error",
+			query:  "SELECT * FROM `dog_registry` FOR UPDATE;",
+			result: logs.ErrReadOnly,
+		},
+	}
+
+	for _, tt := range errCases {
+		t.Run(tt.name, func(t *testing.T) {
+			db.query = ""
+			_, _, err := service.Query(context.Background(), tt.query)
+			assert.Equal(t, tt.result, errors.Cause(err))
+			assert.Empty(t, db.query, "query was sent to the database")
+		})
+	}
+
+	failureCases := []queryCase{
+		{
+			name:  "INTO OUTFILE should return an error",
+			query: "SELECT * FROM `dog_registry` INTO OUTFILE '/tmp/dogs.csv';",
+		},
+		{
+			name:  "INTO DUMPFILE should return an error",
+			query: "SELECT * FROM `dog_registry` INTO DUMPFILE '/tmp/dogs';",
+		},
+	}
+
+	for _, tt := range failureCases {
+		t.Run(tt.name, func(t *testing.T) {
+			db.query = ""
+			_, _, err := service.Query(context.Background(), tt.query)
+			assert.Error(t, err)
+			assert.Empty(t, db.query, "query was sent to the database")
+		})
+	}
+}
+
+func TestQueryCancelled(t *testing.T) {
+	// GIVEN
+	service := newService(&mockDB{block: true})
+	ctx, cancel := context.WithCancel(context.Background())
+
+	// WHEN
+	time.AfterFunc(10*time.Millisecond, cancel)
+	start := time.Now()
+	_, _, err := service.Query(ctx, "SELECT * FROM `dog_registry`;")
+
+	// THEN
+	assert.Equal(t, context.Canceled, errors.Cause(err))
+	assert.True(t, time.Since(start) < time.Second, "query was not cancelled promptly")
+}
+
+func TestQueryTimeout(t *testing.T) {
+	// GIVEN
+	service := newService(&mockDB{block: true}, logs.WithQueryTimeout(10*time.Millisecond))
+
+	t.Run("a query that runs past the timeout returns a timeout error", func(t *testing.T) {
+		// WHEN
+		start := time.Now()
+		_, _, err := service.Query(context.Background(), "SELECT * FROM `dog_registry`;")
+
+		// THEN
+		assert.Equal(t, logs.ErrQueryTimeout, errors.Cause(err))
+		assert.True(t, time.Since(start) < time.Second, "query was not cancelled promptly")
+	})
+
+	t.Run("a streamed query that runs past the timeout returns a timeout error", func(t *testing.T) {
+		// WHEN
+		err := service.QueryStream(context.Background(), "SELECT * FROM `dog_registry`;", nil, func(row map[string]interface{}) error {
+			return nil
+		})
+
+		// THEN
+		assert.Equal(t, logs.ErrQueryTimeout, errors.Cause(err))
+	})
+}
+
+func TestSlowQuery(t *testing.T) {
+	// GIVEN a service logging to a buffer the queries slower than 10ms
+	var buf bytes.Buffer
+	db := &mockDB{}
+	service := newService(db,
+		logs.WithSlowQueryThreshold(10*time.Millisecond),
+		logs.WithLogger(slog.New(slog.NewTextHandler(&buf, nil))),
+	)
+
+	t.Run("a query faster than the threshold isn't logged", func(t *testing.T) {
+		// WHEN
+		buf.Reset()
+		_, _, err := service.Query(context.Background(), "SELECT * FROM `dog_registry`")
+
+		// THEN
+		assert.NoError(t, err)
+		assert.Empty(t, buf.String())
+	})
+
+	t.Run("a query slower than the threshold is logged with its SQL and duration", func(t *testing.T) {
+		// WHEN
+		buf.Reset()
+		db.delay = 20 * time.Millisecond
+		ctx := logs.WithRequestID(context.Background(), "req-1234")
+		_, _, err := service.Query(ctx, "SELECT * FROM `dog_registry`")
+
+		// THEN
+		assert.NoError(t, err)
+		assert.Contains(t, buf.String(), "level=WARN")
+		assert.Contains(t, buf.String(), `msg="slow query"`)
+		assert.Contains(t, buf.String(), `query="select * from dog_registry limit 10000"`)
+		assert.Contains(t, buf.String(), "duration=")
+		assert.Contains(t, buf.String(), "request_id=req-1234")
+	})
+
+	t.Run("a slow streamed query is logged", func(t *testing.T) {
+		// WHEN
+		buf.Reset()
+		err := service.QueryStream(context.Background(), "SELECT * FROM `dog_registry`", nil, func(row map[string]interface{}) error {
+			return nil
+		})
+
+		// THEN
+		assert.NoError(t, err)
+		assert.Contains(t, buf.String(), `msg="slow query"`)
+	})
+
+	t.Run("a threshold of 0 logs no query", func(t *testing.T) {
+		// WHEN
+		buf.Reset()
+		_, _, err := newService(db,
+			logs.WithSlowQueryThreshold(0),
+			logs.WithLogger(slog.New(slog.NewTextHandler(&buf, nil))),
+		).Query(context.Background(), "SELECT * FROM `dog_registry`")
+
+		// THEN
+		assert.NoError(t, err)
+		assert.Empty(t, buf.String())
+	})
+}
+
+// describes a test case for the query row limit
+type limitCase struct {
+	name    string
+	maxRows int
+	query   string
+	result  string
+}
+
+func TestQueryLimit(t *testing.T) {
+	cases := []limitCase{
+		{
+			name:    "a query without a limit gets the default limit",
+			maxRows: logs.DefaultMaxRows,
+			query:   "SELECT * FROM `dog_registry`;",
+			result:  "select * from dog_registry limit 10000",
+		},
+		{
+			name:    "a query with a smaller limit keeps its limit",
+			maxRows: 100,
+			query:   "SELECT * FROM `dog_registry` LIMIT 10;",
+			result:  "select * from dog_registry limit 10",
+		},
+		{
+			name:    "a query with a larger limit is lowered to the max",
+			maxRows: 100,
+			query:   "SELECT * FROM `dog_registry` LIMIT 20, 1000;",
+			result:  "select * from dog_registry limit 20, 100",
+		},
+		{
+			name:    "a max of 0 disables the limit",
+			maxRows: 0,
+			query:   "SELECT * FROM `dog_registry`;",
+			result:  "SELECT * FROM `dog_registry`;",
+		},
+	}
+
+	for _, tt := range cases {
+		t.Run(tt.name, func(t *testing.T) {
+			db := &mockDB{}
+			service := newService(db, logs.WithMaxRows(tt.maxRows))
+			_, _, err := service.Query(context.Background(), tt.query)
+			assert.NoError(t, err)
+			assert.Equal(t, tt.result, db.query)
+		})
+	}
+}
+
+// describes a test case for paginating a query
+type pageCase struct {
+	name    string
+	maxRows int
+	query   string
+	page    logs.Page
+	result  string    // query sent to the database
+	applied logs.Page // page returned by the service
+	err     error
+}
+
+func TestQueryPage(t *testing.T) {
+	cases := []pageCase{
+		{
+			name:    "the page is added to a query without a limit",
+			maxRows: 100,
+			query:   "SELECT * FROM `dog_registry` ORDER BY `name`",
+			page:    logs.Page{Limit: 10, Offset: 20},
+			result:  "select * from dog_registry order by name asc limit 20, 10",
+			applied: logs.Page{Limit: 10, Offset: 20},
+		},
+		{
+			name:    "a limit larger than the max is lowered to the max",
+			maxRows: 100,
+			query:   "SELECT * FROM `dog_registry`",
+			page:    logs.Page{Limit: 1000},
+			result:  "select * from dog_registry limit 100",
+			applied: logs.Page{Limit: 100},
+		},
+		{
+			name:    "an offset without a limit gets the max as its limit",
+			maxRows: 100,
+			query:   "SELECT * FROM `dog_registry`",
+			page:    logs.Page{Offset: 50},
+			result:  "select * from dog_registry limit 50, 100",
+			applied: logs.Page{Limit: 100, Offset: 50},
+		},
+		{
+			name:    "a page without a max keeps its limit",
+			maxRows: 0,
+			query:   "SELECT * FROM `dog_registry`",
+			page:    logs.Page{Limit: 1000},
+			result:  "select * from dog_registry limit 1000",
+			applied: logs.Page{Limit: 1000},
+		},
+		{
+			name:    "a query with a limit of its own conflicts with the page",
+			maxRows: 100,
+			query:   "SELECT * FROM `dog_registry` LIMIT 5",
+			page:    logs.Page{Limit: 10},
+			err:     logs.ErrInvalidPage,
+		},
+		{
+			name:    "a negative offset is rejected",
+			maxRows: 100,
+			query:   "SELECT * FROM `dog_registry`",
+			page:    logs.Page{Limit: 10, Offset: -1},
+			err:     logs.ErrInvalidPage,
+		},
+		{
+			name:    "an offset without a limit or a max is rejected",
+			maxRows: 0,
+			query:   "SELECT * FROM `dog_registry`",
+			page:    logs.Page{Offset: 10},
+			err:     logs.ErrInvalidPage,
+		},
+		{
+			name:    "an omitted page leaves the query to its max",
+			maxRows: 100,
+			query:   "SELECT * FROM `dog_registry` LIMIT 5",
+			result:  "select * from dog_registry limit 5",
+		},
+	}
+
+	for _, tt := range cases {
+		t.Run(tt.name, func(t *testing.T) {
+			// GIVEN
+			db := &mockDB{}
+			service := newService(db, logs.WithMaxRows(tt.maxRows))
+
+			// WHEN
+			_, _, applied, err := service.QueryPage(context.Background(), tt.query, nil, tt.page)
+
+			// THEN
+			if tt.err != nil {
+				assert.Equal(t, tt.err, errors.Cause(err))
+				assert.Empty(t, db.query)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tt.result, db.query)
+			assert.Equal(t, tt.applied, applied)
+		})
+	}
+}
+
+// describes a test case for hiding internal columns
+type internalColumnsCase struct {
+	name   string
+	opts   []logs.Option
+	query  string
+	result logs.JSON
+}
+
+func TestQueryInternalColumns(t *testing.T) {
+	cases := []internalColumnsCase{
+		{
+			name:   "the id is hidden from SELECT * by default",
+			query:  "SELECT * FROM `dog_registry`;",
+			result: logs.JSON{rawLog{"name": "max", "weight": float64(3)}},
+		},
+		{
+			name:   "the id is included when selected by name",
+			query:  "SELECT id, name, weight FROM `dog_registry`;",
+			result: logs.JSON{rawLog{"id": int64(1), "name": "max", "weight": float64(3)}},
+		},
+		{
+			name:   "the id is included when selected along with *",
+			query:  "SELECT *, `ID` FROM `dog_registry`;",
+			result: logs.JSON{rawLog{"id": int64(1), "name": "max", "weight": float64(3)}},
+		},
+		{
+			name:   "nothing is hidden without internal columns",
+			opts:   []logs.Option{logs.WithInternalColumns()},
+			query:  "SELECT * FROM `dog_registry`;",
+			result: logs.JSON{rawLog{"id": int64(1), "name": "max", "weight": float64(3)}},
+		},
+	}
+
+	for _, tt := range cases {
+		t.Run(tt.name, func(t *testing.T) {
+			db := &mockDB{results: logs.JSON{rawLog{"id": int64(1), "name": "max", "weight": float64(3)}}}
+			service := newService(db, tt.opts...)
+			results, _, err := service.Query(context.Background(), tt.query)
+			assert.NoError(t, err)
+			assert.Equal(t, tt.result, results)
+		})
+	}
+}
+
+func TestQueryColumns(t *testing.T) {
+	// GIVEN
+	db := &mockDB{
+		results: logs.JSON{rawLog{"id": int64(1), "name": "max", "weight": float64(3)}},
+		columns: []logs.Column{{Name: "id", Type: "INT"}, {Name: "name", Type: "VARCHAR"}, {Name: "weight", Type: "DOUBLE"}},
+	}
+	service := newService(db)
+
+	t.Run("hidden columns are left out of the columns", func(t *testing.T) {
+		// WHEN
+		_, columns, err := service.Query(context.Background(), "SELECT * FROM `dog_registry`;")
+
+		// THEN
+		assert.NoError(t, err)
+		assert.Equal(t, []logs.Column{{Name: "name", Type: "VARCHAR"}, {Name: "weight", Type: "DOUBLE"}}, columns)
+	})
+
+	t.Run("columns selected by name are kept", func(t *testing.T) {
+		// WHEN
+		_, columns, err := service.Query(context.Background(), "SELECT id, name, weight FROM `dog_registry`;")
+
+		// THEN
+		assert.NoError(t, err)
+		assert.Equal(t, db.columns, columns)
+	})
+}
+
+func TestQueryStatements(t *testing.T) {
+	cases := []struct {
+		name  string
+		query string
+		err   error
+	}{
+		{name: "an empty query is rejected", query: "", err: logs.ErrEmptyQuery},
+		{name: "a whitespace only query is rejected", query: " \n\t ", err: logs.ErrEmptyQuery},
+		{name: "several statements are rejected", query: "SELECT 1; SELECT 2", err: logs.ErrMultipleStatements},
+		{name: "a statement after a select is rejected", query: "SELECT * FROM dog_registry; DROP TABLE dog_registry", err: logs.ErrMultipleStatements},
+		{name: "a trailing semicolon is allowed", query: "SELECT * FROM dog_registry;"},
+		{name: "a semicolon in a string is allowed", query: "SELECT * FROM dog_registry WHERE name = 'max; spot'"},
+	}
+	for _, tt := range cases {
+		t.Run(tt.name, func(t *testing.T) {
+			// GIVEN
+			service := newService(&mockDB{})
+
+			// WHEN the query is run
+			_, _, err := service.Query(context.Background(), tt.query)
+
+			// THEN it's rejected as a validation error, unless it's a single
+			// statement
+			if tt.err == nil {
+				assert.NoError(t, err)
+				return
+			}
+			assert.Equal(t, tt.err, errors.Cause(err))
+			assert.Equal(t, logs.ErrValidation, logs.Kind(err))
+
+			// AND it can't be saved either
+			assert.Equal(t, tt.err, errors.Cause(service.SaveQuery(context.Background(), "dogs", tt.query)))
+		})
+	}
+}
+
+func TestExplain(t *testing.T) {
+	t.Run("a select is explained with its rows capped and its args", func(t *testing.T) {
+		// GIVEN
+		db := &mockDB{results: logs.JSON{{"id": int64(1), "select_type": "SIMPLE", "table": "dog_registry"}}}
+		service := newService(db)
+
+		// WHEN the query is explained
+		plan, err := service.Explain(context.Background(), "SELECT * FROM dog_registry WHERE breed = ?", "husky")
+
+		// THEN the plan of the query that would run is returned
+		assert.NoError(t, err)
+		assert.Equal(t, "EXPLAIN select * from dog_registry where breed = ? limit 10000", db.query)
+		assert.Equal(t, []interface{}{"husky"}, db.args)
+		assert.Equal(t, db.results, plan)
+	})
+
+	readOnlyCases := []struct {
+		name  string
+		query string
+		err   error
+	}{
+		{name: "a delete can't be explained", query: "DELETE FROM dog_registry", err: logs.ErrReadOnly},
+		{name: "an insert can't be explained", query: "INSERT INTO dog_registry (name) VALUES ('max')", err: logs.ErrReadOnly},
+		{name: "a select that locks its rows can't be explained", query: "SELECT * FROM dog_registry FOR UPDATE", err: logs.ErrReadOnly},
+		{name: "an explain can't be explained", query: "EXPLAIN SELECT * FROM dog_registry", err: logs.ErrReadOnly},
+		{name: "several statements can't be explained", query: "SELECT 1; DROP TABLE dog_registry", err: logs.ErrMultipleStatements},
+	}
+	for _, tt := range readOnlyCases {
+		t.Run(tt.name, func(t *testing.T) {
+			// GIVEN
+			db := &mockDB{}
+			service := newService(db)
+
+			// WHEN the query is explained
+			_, err := service.Explain(context.Background(), tt.query)
+
+			// THEN it's rejected before it reaches the database
+			assert.Equal(t, tt.err, errors.Cause(err))
+			assert.Equal(t, logs.ErrValidation, logs.Kind(err))
+			assert.Empty(t, db.query)
+		})
+	}
+}
+
+func TestQueryArgs(t *testing.T) {
+	t.Run("args are passed with the placeholders of the query", func(t *testing.T) {
+		db := &mockDB{}
+		service := newService(db)
+		_, _, err := service.Query(context.Background(), "SELECT * FROM dog_registry WHERE breed = ? AND weight > ?", "husky", float64(50))
+		assert.NoError(t, err)
+		assert.Equal(t, "select * from dog_registry where breed = ? and weight > ? limit 10000", db.query)
+		assert.Equal(t, []interface{}{"husky", float64(50)}, db.args)
+	})
+
+	t.Run("a query without placeholders is passed as is when the limit is disabled", func(t *testing.T) {
+		db := &mockDB{}
+		service := newService(db, logs.WithMaxRows(0))
+		_, _, err := service.Query(context.Background(), "SELECT * FROM `dog_registry`")
+		assert.NoError(t, err)
+		assert.Equal(t, "SELECT * FROM `dog_registry`", db.query)
+		assert.Empty(t, db.args)
+	})
+
+	failureCases := []struct {
+		name  string
+		query string
+		args  []interface{}
+	}{
+		{name: "more args than placeholders", query: "SELECT * FROM dog_registry WHERE breed = ?", args: []interface{}{"husky", "labrador"}},
+		{name: "fewer args than placeholders", query: "SELECT * FROM dog_registry WHERE breed = ? OR breed = ?", args: []interface{}{"husky"}},
+		{name: "placeholders without args", query: "SELECT * FROM dog_registry WHERE breed = ?"},
+		{name: "named placeholders", query: "SELECT * FROM dog_registry WHERE breed = :breed", args: []interface{}{"husky"}},
+	}
+	for _, tt := range failureCases {
+		t.Run(tt.name+" return an error", func(t *testing.T) {
+			db := &mockDB{}
+			service := newService(db)
+			_, _, err := service.Query(context.Background(), tt.query, tt.args...)
+			assert.Equal(t, logs.ErrQueryArgs, errors.Cause(err))
+			assert.Empty(t, db.query)
+		})
+	}
+}
+
+func TestQueryStream(t *testing.T) {
+	// GIVEN
+	db := &mockDB{results: logs.JSON{
+		rawLog{"id": int64(1), "name": "max"},
+		rawLog{"id": int64(2), "name": "spot"},
+	}}
+	service := newService(db)
+
+	t.Run("rows are streamed without internal columns", func(t *testing.T) {
+		// WHEN
+		var rows logs.JSON
+		err := service.QueryStream(context.Background(), "SELECT * FROM `dog_registry`;", nil, func(row map[string]interface{}) error {
+			rows = append(rows, row)
+			return nil
+		})
+
+		// THEN
+		assert.NoError(t, err)
+		assert.Equal(t, logs.JSON{rawLog{"name": "max"}, rawLog{"name": "spot"}}, rows)
+		assert.Equal(t, "select * from dog_registry limit 10000", db.query)
+	})
+
+	t.Run("streaming stops at the first error of the callback", func(t *testing.T) {
+		// WHEN
+		calls := 0
+		err := service.QueryStream(context.Background(), "SELECT * FROM `dog_registry`;", nil, func(row map[string]interface{}) error {
+			calls++
+			return errors.New("connection reset")
+		})
+
+		// THEN
+		assert.Error(t, err)
+		assert.Equal(t, 1, calls)
+	})
+
+	t.Run("only a select can be streamed", func(t *testing.T) {
+		err := service.QueryStream(context.Background(), "DROP TABLE `dog_registry`;", nil, func(row map[string]interface{}) error {
+			return nil
+		})
+		assert.Equal(t, logs.ErrReadOnly, err)
+	})
+}
+
+func TestSavedQuery(t *testing.T) {
+	// GIVEN
+	db := &mockDB{}
+	service := newService(db, logs.WithMaxRows(0))
+
+	// WHEN
+	err := service.SaveQuery(context.Background(), "top_dogs",
+		"SELECT name FROM `dog_registry` WHERE breed = :breed AND weight > :weight;")
+
+	// THEN
+	assert.NoError(t, err)
+
+	t.Run("the saved query runs with its params escaped", func(t *testing.T) {
+		params := map[string]interface{}{"breed": "o'hare hound", "weight": float64(20)}
+		_, _, err := service.QuerySaved(context.Background(), "top_dogs", params)
+		assert.NoError(t, err)
+		assert.Equal(t, "select name from dog_registry where breed = 'o\\'hare hound' and weight > 20", db.query)
+	})
+
+	t.Run("a missing param is a validation error", func(t *testing.T) {
+		_, _, err := service.QuerySaved(context.Background(), "top_dogs", map[string]interface{}{"breed": "beagle"})
+		assert.Contains(t, err.Error(), "missing bind var weight")
+		assert.Equal(t, logs.ErrValidation, logs.Kind(err))
+	})
+
+	t.Run("an object param is a validation error", func(t *testing.T) {
+		params := map[string]interface{}{"breed": map[string]interface{}{"name": "beagle"}, "weight": float64(20)}
+		_, _, err := service.QuerySaved(context.Background(), "top_dogs", params)
+		assert.Error(t, err)
+		assert.Equal(t, logs.ErrValidation, logs.Kind(err))
+	})
+
+	t.Run("an unknown saved query is not found", func(t *testing.T) {
+		_, _, err := service.QuerySaved(context.Background(), "bottom_dogs", nil)
+		assert.Equal(t, logs.ErrSavedQueryNotFound, err)
+	})
+
+	t.Run("only a select can be saved", func(t *testing.T) {
+		err := service.SaveQuery(context.Background(), "drop_dogs", "DROP TABLE `dog_registry`;")
+		assert.Equal(t, logs.ErrReadOnly, err)
+	})
+
+	t.Run("a query calling an unsafe function can't be saved", func(t *testing.T) {
+		err := service.SaveQuery(context.Background(), "slow_dogs", "SELECT SLEEP(10) FROM `dog_registry`;")
+		assert.Equal(t, logs.ErrReadOnly, errors.Cause(err))
+	})
+
+	t.Run("an invalid query can't be saved", func(t *testing.T) {
+		err := service.SaveQuery(context.Background(), "bad_dogs", "SELECT * FROMa;")
+		assert.Error(t, err)
+	})
+}
+
+func TestIngestReusesCompiledSchema(t *testing.T) {
+	// GIVEN
+	service := newService(&mockDB{})
+	batch := logs.JSON{rawLog{"name": " max ", "weight": float64(3)}}
+
+	// WHEN repeated batches of a family are ingested with the same schema
+	for i := 0; i < 3; i++ {
+		_, err := service.Ingest(context.Background(), "dog_registry", logs.Schema{"name": "string:trim", "weight": "int"}, nil, batch)
+		assert.NoError(t, err)
+	}
+
+	// THEN the schema is only compiled once
+	assert.Equal(t, 1, logs.CompiledSchemas(service))
+
+	// WHEN another family, and a changed schema, are ingested
+	_, err := service.Ingest(context.Background(), "cat_registry", logs.Schema{"name": "string:trim", "weight": "int"}, nil, batch)
+	assert.NoError(t, err)
+	_, err = service.Ingest(context.Background(), "dog_registry", logs.Schema{"name": "string", "weight": "int"}, nil, batch)
+	assert.NoError(t, err)
+	_, err = service.Ingest(context.Background(), "dog_registry", logs.Schema{"name": "string", "weight": "int"}, nil, batch)
+	assert.NoError(t, err)
+
+	// THEN each family's schema is compiled again only when it changed
+	assert.Equal(t, 3, logs.CompiledSchemas(service))
+}
+
+func BenchmarkIngest(b *testing.B) {
+	schema := logs.Schema{}
+	logEvent := rawLog{}
+	for i := 0; i < 20; i++ {
+		field := "field_" + strconv.Itoa(i)
+		schema[field] = "string:trim,lower"
+		logEvent[field] = " Value "
+	}
+	var batch logs.JSON
+	for i := 0; i < 100; i++ {
+		batch = append(batch, logEvent)
+	}
+	db := &mockDB{}
+	service := newService(db)
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		db.inserted = nil
+		if _, err := service.Ingest(context.Background(), "dog_registry", schema, nil, batch); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func TestIngestFamilyName(t *testing.T) {
+	// GIVEN
+	schema := logs.Schema{"name": "string"}
+	records := logs.JSON{rawLog{"name": "max"}}
+
+	// THEN
+	cases := []struct {
+		name   string
+		family logs.Family
+		valid  bool
+	}{
+		{name: "a snake case name is valid", family: "dog_registry", valid: true},
+		{name: "a name with capitals and digits is valid", family: "Dogs2017", valid: true},
+		{name: "a name of 64 characters is valid", family: logs.Family("d" + strings.Repeat("o", 63)), valid: true},
+		{name: "an empty name is invalid", family: ""},
+		{name: "a name with spaces is invalid", family: "dog registry"},
+		{name: "a name with punctuation is invalid", family: "dog-registry"},
+		{name: "a name with a backtick is invalid", family: "dog`registry"},
+		{name: "a name starting with a digit is invalid", family: "2017_dogs"},
+		{name: "a name starting with an underscore is invalid", family: "_dogs"},
+		{name: "a name of 65 characters is invalid", family: logs.Family("d" + strings.Repeat("o", 64))},
+	}
+
+	for _, tt := range cases {
+		t.Run(tt.name, func(t *testing.T) {
+			db := &mockDB{}
+			service := newService(db)
+			_, err := service.Ingest(context.Background(), tt.family, schema, nil, records)
+			if tt.valid {
+				assert.NoError(t, err)
+				assert.Len(t, db.inserted, 1)
+			} else {
+				assert.Equal(t, logs.ErrInvalidFamily, errors.Cause(err))
+				assert.Empty(t, db.inserted)
+			}
+		})
+	}
+}
+
+func TestDropFamily(t *testing.T) {
+	// GIVEN
+	service := newService(&mockDB{})
+
+	// THEN
+	assert.NoError(t, service.DropFamily(context.Background(), "dog_registry"))
+	assert.Equal(t, logs.ErrFamilyNotFound, service.DropFamily(context.Background(), "cat_registry"))
+}
+
+func TestTruncateFamily(t *testing.T) {
+	// GIVEN
+	db := &mockDB{}
+	service := newService(db)
+
+	// THEN an existing family is truncated rather than dropped
+	assert.NoError(t, service.TruncateFamily(context.Background(), "dog_registry"))
+	assert.Equal(t, []logs.Family{"dog_registry"}, db.truncated)
+	assert.Empty(t, db.dropped)
+
+	// AND a missing family is not found
+	assert.Equal(t, logs.ErrFamilyNotFound, service.TruncateFamily(context.Background(), "cat_registry"))
+	assert.Equal(t, []logs.Family{"dog_registry"}, db.truncated)
+}
+
+func TestListFamilies(t *testing.T) {
+	// GIVEN a database with several tables
+	service := newService(&mockDB{families: []string{"cat_registry", "request_log"}})
+
+	// WHEN
+	families, err := service.ListFamilies(context.Background())
+
+	// THEN the names of the tables are listed
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"dog_registry", "cat_registry", "request_log"}, families)
+}
+
+func TestCountFamily(t *testing.T) {
+	// GIVEN a family with two logs
+	service := newService(&mockDB{results: logs.JSON{{"name": "max"}, {"name": "spot"}}})
+
+	// THEN the logs of the family are counted
+	count, err := service.CountFamily(context.Background(), "dog_registry")
+	assert.NoError(t, err)
+	assert.Equal(t, int64(2), count)
+
+	// AND a missing family is not found
+	_, err = service.CountFamily(context.Background(), "cat_registry")
+	assert.Equal(t, logs.ErrFamilyNotFound, err)
+}
+
+func TestDescribeFamily(t *testing.T) {
+	// GIVEN
+	service := newService(&mockDB{})
+
+	// WHEN
+	tables, err := service.DescribeFamily(context.Background(), "dog_registry")
+
+	// THEN
+	assert.NoError(t, err)
+	assert.Equal(t, logs.JSON{{"name": "dog_registry"}}, tables)
+	_, err = service.DescribeFamily(context.Background(), "cat_registry")
+	assert.Equal(t, logs.ErrFamilyNotFound, err)
+}
+
+func TestMergeFamilies(t *testing.T) {
+	t.Run("families given and matching the pattern are merged and dropped", func(t *testing.T) {
+		// GIVEN
+		db := &mockDB{families: []string{"tmp_cats", "tmp_dogs", "tmp_all", "birds"}}
+		service := newService(db)
+
+		// WHEN
+		result, err := service.MergeFamilies(context.Background(), logs.MergeRequest{
+			Target:      "tmp_all",
+			Sources:     []logs.Family{"birds", "fish"},
+			Pattern:     "tmp_*",
+			DropSources: true,
+		})
+
+		// THEN
+		assert.NoError(t, err)
+		assert.Equal(t, []logs.Family{"birds", "tmp_cats", "tmp_dogs"}, db.merged)
+		assert.Equal(t, logs.MergeResult{
+			Merged:  []logs.Family{"birds", "tmp_cats", "tmp_dogs"},
+			Dropped: []logs.Family{"birds", "tmp_cats", "tmp_dogs"},
+			Missing: []logs.Family{"fish"},
+		}, result)
+	})
+
+	t.Run("nothing is dropped when the schemas are incompatible", func(t *testing.T) {
+		// GIVEN
+		db := &mockDB{
+			families: []string{"cat_registry"},
+			mergeErr: errors.Wrap(logs.ErrIncompatibleSchemas, "column weight is int in dog_registry table but text in cat_registry table"),
+		}
+		service := newService(db)
+
+		// WHEN
+		_, err := service.MergeFamilies(context.Background(), logs.MergeRequest{
+			Target:      "pets",
+			Sources:     []logs.Family{"dog_registry", "cat_registry"},
+			DropSources: true,
+		})
+
+		// THEN
+		assert.Equal(t, logs.ErrIncompatibleSchemas, errors.Cause(err))
+		assert.Empty(t, db.dropped)
+	})
+
+	invalidCases := []struct {
+		name string
+		req  logs.MergeRequest
+	}{
+		{name: "a merge without a target is invalid", req: logs.MergeRequest{Sources: []logs.Family{"dog_registry"}}},
+		{name: "a merge without sources is invalid", req: logs.MergeRequest{Target: "pets"}},
+		{name: "a family can't be merged into itself", req: logs.MergeRequest{Target: "dog_registry", Sources: []logs.Family{"dog_registry"}}},
+		{name: "an invalid pattern is invalid", req: logs.MergeRequest{Target: "pets", Pattern: "[dog"}},
+	}
+
+	for _, tt := range invalidCases {
+		t.Run(tt.name, func(t *testing.T) {
+			service := newService(&mockDB{})
+			_, err := service.MergeFamilies(context.Background(), tt.req)
+			assert.Equal(t, logs.ErrInvalidMerge, errors.Cause(err))
+		})
+	}
+}
+
+func TestIngestDedupWindow(t *testing.T) {
+	// GIVEN a service skipping logs resent within a minute
+	now := time.Date(2018, 1, 1, 0, 0, 0, 0, time.UTC)
+	db := &mockDB{}
+	service := newService(db,
+		logs.WithDedupWindow(time.Minute),
+		logs.WithClock(func() time.Time { return now }),
+	)
+	schema := logs.Schema{"name": "string", "weight": "int"}
+	max := rawLog{"name": "max", "weight": float64(3)}
+	spot := rawLog{"name": "spot", "weight": float64(130)}
+
+	// WHEN logs are ingested for the first time
+	result, err := service.Ingest(context.Background(), "dog_registry", schema, nil, logs.JSON{max, max})
+
+	// THEN a log repeated in the batch is only inserted once
+	assert.NoError(t, err)
+	assert.Equal(t, 1, result.Skipped)
+	assert.Equal(t, logs.JSON{max}, db.inserted)
+
+	// WHEN the same log is resent within the window
+	now = now.Add(30 * time.Second)
+	result, err = service.Ingest(context.Background(), "dog_registry", schema, nil, logs.JSON{max, spot})
+
+	// THEN it's skipped
+	assert.NoError(t, err)
+	assert.Equal(t, 1, result.Skipped)
+	assert.Equal(t, logs.JSON{max, spot}, db.inserted)
+
+	// WHEN the same log is resent to another family
+	result, err = service.Ingest(context.Background(), "cat_registry", schema, nil, logs.JSON{max})
+
+	// THEN it's inserted
+	assert.NoError(t, err)
+	assert.Equal(t, 0, result.Skipped)
+	assert.Equal(t, logs.JSON{max, spot, max}, db.inserted)
+
+	// WHEN the same log is resent outside of the window
+	now = now.Add(time.Minute)
+	result, err = service.Ingest(context.Background(), "dog_registry", schema, nil, logs.JSON{max})
+
+	// THEN it's inserted again
+	assert.NoError(t, err)
+	assert.Equal(t, 0, result.Skipped)
+	assert.Equal(t, logs.JSON{max, spot, max, max}, db.inserted)
+}
+
+func TestIngestDedupMaxLogs(t *testing.T) {
+	// GIVEN a service remembering at most two logs for a minute
+	now := time.Date(2018, 1, 1, 0, 0, 0, 0, time.UTC)
+	db := &mockDB{}
+	service := newService(db,
+		logs.WithDedupWindow(time.Minute),
+		logs.WithDedupMaxLogs(2),
+		logs.WithClock(func() time.Time { return now }),
+	)
+	schema := logs.Schema{"name": "string"}
+	max, spot, rex := rawLog{"name": "max"}, rawLog{"name": "spot"}, rawLog{"name": "rex"}
+
+	// WHEN three logs are ingested, one at a time
+	for _, dog := range []rawLog{max, spot, rex} {
+		now = now.Add(time.Second)
+		_, err := service.Ingest(context.Background(), "dog_registry", schema, nil, logs.JSON{dog})
+		assert.NoError(t, err)
+	}
+
+	// THEN the most recent ones are still skipped within the window
+	result, err := service.Ingest(context.Background(), "dog_registry", schema, nil, logs.JSON{spot, rex})
+	assert.NoError(t, err)
+	assert.Equal(t, 2, result.Skipped)
+
+	// AND the least recent one was forgotten, so it's inserted again
+	result, err = service.Ingest(context.Background(), "dog_registry", schema, nil, logs.JSON{max})
+	assert.NoError(t, err)
+	assert.Equal(t, 0, result.Skipped)
+	assert.Equal(t, logs.JSON{max, spot, rex, max}, db.inserted)
+}
+
+func TestPing(t *testing.T) {
+	t.Run("a reachable database is healthy", func(t *testing.T) {
+		service := newService(&mockDB{})
+		assert.NoError(t, service.Ping(context.Background()))
+	})
+
+	t.Run("a hung database times out", func(t *testing.T) {
+		service := newService(&mockDB{block: true}, logs.WithPingTimeout(10*time.Millisecond))
+		start := time.Now()
+		err := service.Ping(context.Background())
+		assert.Equal(t, context.DeadlineExceeded, errors.Cause(err))
+		assert.True(t, time.Since(start) < time.Second, "ping did not time out promptly")
+	})
+}
+
+func TestClose(t *testing.T) {
+	// GIVEN
+	db := &mockDB{}
+	service := newService(db)
+
+	// WHEN
+	err := service.Close()
+
+	// THEN
+	assert.NoError(t, err)
+	assert.True(t, db.closed)
+}
+
+func TestStats(t *testing.T) {
+	// GIVEN a service reading event times from the time field
+	now := time.Date(2018, 1, 1, 12, 0, 0, 0, time.UTC)
+	service := newService(&mockDB{},
+		logs.WithEventTimeField("time"),
+		logs.WithClock(func() time.Time { return now }),
+	)
+	schema := logs.Schema{"name": "string", "time": "int"}
+
+	// WHEN a family is ingested with a record delayed by 5 minutes
+	_, err := service.Ingest(context.Background(), "dog_registry", schema, nil, logs.JSON{
+		rawLog{"name": "max", "time": float64(now.Add(-5 * time.Minute).Unix())},
+		rawLog{"name": "spot", "time": float64(now.Add(-time.Second).Unix())},
+	})
+	assert.NoError(t, err)
+	// AND another family is ingested without event times
+	_, err = service.Ingest(context.Background(), "cat_registry", logs.Schema{"name": "string"}, nil, logs.JSON{
+		rawLog{"name": "tom"},
+	})
+	assert.NoError(t, err)
+
+	// THEN the lag reflects the delayed record
+	now = now.Add(30 * time.Second)
+	stats := service.Stats()
+	if assert.Len(t, stats, 2) {
+		assert.Equal(t, logs.Family("cat_registry"), stats[0].Family)
+		assert.Nil(t, stats[0].LagSeconds)
+
+		assert.Equal(t, logs.Family("dog_registry"), stats[1].Family)
+		assert.Equal(t, int64(2), stats[1].Records)
+		assert.Equal(t, 2.0/60, stats[1].RecordsPerSecond)
+		if assert.NotNil(t, stats[1].LagSeconds) {
+			assert.Equal(t, 300.0, *stats[1].LagSeconds)
+		}
+	}
+
+	// AND the rate drops once the ingest is out of the rate window
+	now = now.Add(time.Minute)
+	stats = service.Stats()
+	assert.Equal(t, 0.0, stats[1].RecordsPerSecond)
+	assert.Equal(t, int64(2), stats[1].Records)
+}
+
+// describes a test case for normalizing string values
+type normalizeCase struct {
+	name     string
+	schema   logs.Schema
+	logs     logs.JSON
+	inserted logs.JSON
+}
+
+func TestIngestNormalize(t *testing.T) {
+	cases := []normalizeCase{
+		{
+			name:     "strings are stored raw by default",
+			schema:   logs.Schema{"name": "string"},
+			logs:     logs.JSON{rawLog{"name": "  Max   the Dog "}},
+			inserted: logs.JSON{rawLog{"name": "  Max   the Dog "}},
+		},
+		{
+			name:     "trim removes leading and trailing whitespace",
+			schema:   logs.Schema{"name": "string:trim"},
+			logs:     logs.JSON{rawLog{"name": "  Max   the Dog "}},
+			inserted: logs.JSON{rawLog{"name": "Max   the Dog"}},
+		},
+		{
+			name:     "collapse and lower can be combined with trim",
+			schema:   logs.Schema{"name": "string:trim,collapse,lower", "breed": "string", "weight": "int"},
+			logs:     logs.JSON{rawLog{"name": "  Max   the Dog ", "breed": " Husky", "weight": float64(3)}},
+			inserted: logs.JSON{rawLog{"name": "max the dog", "breed": " Husky", "weight": float64(3)}},
+		},
+	}
+
+	for _, tt := range cases {
+		t.Run(tt.name, func(t *testing.T) {
+			db := &mockDB{}
+			service := newService(db)
+			_, err := service.Ingest(context.Background(), "dog_registry", tt.schema, nil, tt.logs)
+			assert.NoError(t, err)
+			assert.Equal(t, tt.inserted, db.inserted)
+		})
+	}
+
+	t.Run("unknown normalizations return an error", func(t *testing.T) {
+		service := newService(&mockDB{})
+		_, err := service.Ingest(context.Background(), "dog_registry",
+			logs.Schema{"name": "string:upper"}, nil, logs.JSON{rawLog{"name": "max"}})
+		assert.Error(t, err)
+	})
+
+	t.Run("the length of strings is checked once normalized", func(t *testing.T) {
+		db := &mockDB{}
+		service := newService(db)
+		_, err := service.Ingest(context.Background(), "dog_registry",
+			logs.Schema{"name": "string(3):trim"}, nil, logs.JSON{rawLog{"name": " max "}})
+		assert.NoError(t, err)
+		assert.Equal(t, logs.JSON{rawLog{"name": "max"}}, db.inserted)
+	})
+
+	t.Run("normalized strings are deduplicated", func(t *testing.T) {
+		db := &mockDB{}
+		service := newService(db, logs.WithDedupWindow(time.Minute))
+		result, err := service.Ingest(context.Background(), "dog_registry",
+			logs.Schema{"name": "string:trim"}, nil, logs.JSON{rawLog{"name": "max "}, rawLog{"name": " max"}})
+		assert.NoError(t, err)
+		assert.Equal(t, 1, result.Skipped)
+		assert.Equal(t, logs.JSON{rawLog{"name": "max"}}, db.inserted)
+	})
+}
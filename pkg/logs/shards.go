@@ -0,0 +1,126 @@
+package logs
+
+import (
+	"context"
+	"sort"
+	"strings"
+
+	"github.com/pkg/errors"
+	"github.com/xwb1989/sqlparser"
+)
+
+// ErrCrossShardQuery is returned when a query reads families stored on
+// different shards, since a database can't join tables it doesn't have
+var ErrCrossShardQuery = errors.New("query reads families on different shards")
+
+// Shard is a database storing the log families whose names start with its
+// prefix, ie: the `audit_` families on their own MySQL server
+type Shard struct {
+	Prefix string
+	DB     DBClient
+}
+
+// WithShards routes the log families to the database of the shard with the
+// longest prefix of their name, rather than to the database of the service,
+// which keeps the families without a shard. Each family is ingested,
+// queried, described and dropped on its own database, so a query can only
+// read families of a single database. Describing and listing the logs reads
+// every database.
+func WithShards(shards ...Shard) Option {
+	return func(s *Service) {
+		s.shards = append([]Shard(nil), shards...)
+		sort.SliceStable(s.shards, func(i, j int) bool {
+			return len(s.shards[i].Prefix) > len(s.shards[j].Prefix)
+		})
+	}
+}
+
+// dbFor returns the database storing a family, which is the database of the
+// shard with the longest prefix of its name, or the database of the service
+func (s *Service) dbFor(family Family) DBClient {
+	for _, shard := range s.shards {
+		if strings.HasPrefix(family.String(), shard.Prefix) {
+			return shard.DB
+		}
+	}
+	return s.db
+}
+
+// databases returns the database of the service and the databases of its
+// shards, once each
+func (s *Service) databases() []DBClient {
+	dbs := []DBClient{s.db}
+	for _, shard := range s.shards {
+		if !containsDB(dbs, shard.DB) {
+			dbs = append(dbs, shard.DB)
+		}
+	}
+	return dbs
+}
+
+// containsDB reports whether the database is one of the databases
+func containsDB(dbs []DBClient, db DBClient) bool {
+	for _, existing := range dbs {
+		if existing == db {
+			return true
+		}
+	}
+	return false
+}
+
+// queryDB returns the database storing the families read by a statement,
+// which must all be stored on the same database. A statement that doesn't
+// read any family runs on the database of the service.
+func (s *Service) queryDB(stmt sqlparser.SQLNode) (DBClient, error) {
+	if len(s.shards) == 0 {
+		return s.db, nil
+	}
+	var db DBClient
+	var first Family
+	err := sqlparser.Walk(func(node sqlparser.SQLNode) (bool, error) {
+		table, ok := node.(*sqlparser.AliasedTableExpr)
+		if !ok {
+			return true, nil
+		}
+		name, ok := table.Expr.(sqlparser.TableName)
+		if !ok || name.Name.IsEmpty() {
+			return true, nil
+		}
+		family := Family(name.Name.String())
+		if db == nil {
+			db, first = s.dbFor(family), family
+		} else if s.dbFor(family) != db {
+			return false, errors.Wrapf(ErrCrossShardQuery, "%s and %s are stored on different shards", first, family)
+		}
+		return true, nil
+	}, stmt)
+	if err != nil {
+		return nil, err
+	}
+	if db == nil {
+		return s.db, nil
+	}
+	return db, nil
+}
+
+// describeDatabases describes the tables of every database, sorted by name
+// when there's more than one database
+func (s *Service) describeDatabases(ctx context.Context) (JSON, error) {
+	dbs := s.databases()
+	var tables JSON
+	for _, db := range dbs {
+		described, err := db.DescribeDatabase(ctx)
+		if err != nil {
+			return nil, err
+		}
+		tables = append(tables, described...)
+	}
+	if len(dbs) > 1 {
+		sort.SliceStable(tables, func(i, j int) bool {
+			name, _ := tables[i]["name"].(string)
+			other, _ := tables[j]["name"].(string)
+			return name < other
+		})
+	}
+	return tables, nil
+}
@@ -0,0 +1,136 @@
+package logs_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/kolide/databalancer-logan/pkg/logs"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestShards(t *testing.T) {
+	// GIVEN a service storing the audit families on a shard
+	primary := &mockDB{}
+	audit := &mockDB{families: []string{"audit_login"}}
+	service := newService(primary, logs.WithShards(logs.Shard{Prefix: "audit_", DB: audit}))
+
+	t.Run("logs are ingested on the database of their family", func(t *testing.T) {
+		// WHEN
+		_, err := service.Ingest(context.Background(), "audit_login", logs.Schema{"user": "string"}, nil, logs.JSON{rawLog{"user": "max"}})
+		assert.NoError(t, err)
+		_, err = service.Ingest(context.Background(), "dog_registry", logs.Schema{"name": "string"}, nil, logs.JSON{rawLog{"name": "spot"}})
+		assert.NoError(t, err)
+
+		// THEN
+		assert.Equal(t, []logs.Family{"audit_login"}, audit.created)
+		assert.Equal(t, logs.JSON{{"user": "max"}}, audit.inserted)
+		assert.Equal(t, []logs.Family{"dog_registry"}, primary.created)
+		assert.Equal(t, logs.JSON{{"name": "spot"}}, primary.inserted)
+	})
+
+	t.Run("a query runs on the database of its families", func(t *testing.T) {
+		// WHEN
+		_, _, err := service.Query(context.Background(), "SELECT a.user FROM audit_login a JOIN (SELECT user FROM audit_login) b ON a.user = b.user")
+
+		// THEN
+		assert.NoError(t, err)
+		assert.Contains(t, audit.query, "audit_login")
+		assert.Empty(t, primary.query)
+	})
+
+	t.Run("a query of families on different shards is rejected", func(t *testing.T) {
+		// WHEN
+		_, _, err := service.Query(context.Background(), "SELECT * FROM audit_login JOIN dog_registry")
+
+		// THEN
+		assert.Equal(t, logs.ErrCrossShardQuery, errors.Cause(err))
+		assert.Equal(t, logs.ErrValidation, logs.Kind(err))
+	})
+
+	t.Run("a query without families runs on the database of the service", func(t *testing.T) {
+		// WHEN
+		_, _, err := service.Query(context.Background(), "SELECT 1")
+
+		// THEN
+		assert.NoError(t, err)
+		assert.Contains(t, primary.query, "select 1")
+	})
+
+	t.Run("a family is described and dropped on its database", func(t *testing.T) {
+		// WHEN
+		described, err := service.DescribeFamily(context.Background(), "audit_login")
+
+		// THEN
+		assert.NoError(t, err)
+		assert.Equal(t, "audit_login", described[0]["name"])
+
+		// WHEN
+		err = service.DropFamily(context.Background(), "audit_login")
+
+		// THEN
+		assert.NoError(t, err)
+		assert.Equal(t, []logs.Family{"audit_login"}, audit.dropped)
+		assert.Empty(t, primary.dropped)
+	})
+
+	t.Run("the families of every database are described and listed", func(t *testing.T) {
+		// WHEN
+		described, err := service.DescribeLogs(context.Background())
+
+		// THEN they're sorted by name
+		assert.NoError(t, err)
+		var names []string
+		for _, table := range described {
+			names = append(names, table["name"].(string))
+		}
+		assert.Equal(t, []string{"audit_login", "dog_registry", "dog_registry"}, names)
+
+		// WHEN
+		families, err := service.ListFamilies(context.Background())
+
+		// THEN
+		assert.NoError(t, err)
+		assert.Equal(t, []string{"audit_login", "dog_registry", "dog_registry"}, families)
+	})
+
+	t.Run("families on different shards can't be merged", func(t *testing.T) {
+		// WHEN
+		_, err := service.MergeFamilies(context.Background(), logs.MergeRequest{Target: "dog_registry", Sources: []logs.Family{"audit_login"}})
+
+		// THEN
+		assert.Equal(t, logs.ErrInvalidMerge, errors.Cause(err))
+		assert.Nil(t, audit.merged)
+		assert.Nil(t, primary.merged)
+	})
+
+	t.Run("every database is closed", func(t *testing.T) {
+		// WHEN
+		err := service.Close()
+
+		// THEN
+		assert.NoError(t, err)
+		assert.True(t, primary.closed)
+		assert.True(t, audit.closed)
+	})
+}
+
+func TestShardsLongestPrefix(t *testing.T) {
+	// GIVEN shards with overlapping prefixes
+	primary := &mockDB{}
+	audit := &mockDB{}
+	auditAdmin := &mockDB{}
+	service := newService(primary, logs.WithShards(
+		logs.Shard{Prefix: "audit_", DB: audit},
+		logs.Shard{Prefix: "audit_admin_", DB: auditAdmin},
+	))
+
+	// WHEN
+	_, err := service.Ingest(context.Background(), "audit_admin_login", logs.Schema{"user": "string"}, nil, logs.JSON{rawLog{"user": "max"}})
+
+	// THEN the shard with the longest prefix is chosen
+	assert.NoError(t, err)
+	assert.Equal(t, []logs.Family{"audit_admin_login"}, auditAdmin.created)
+	assert.Empty(t, audit.created)
+	assert.Empty(t, primary.created)
+}
@@ -0,0 +1,123 @@
+package logs
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// rateWindow is the window of time the ingest rate is averaged over
+const rateWindow = time.Minute
+
+// FamilyStats describes the recent ingestion of a log family by this instance
+// of the service
+type FamilyStats struct {
+	Family           Family  `json:"family"`
+	Records          int64   `json:"records"`            // logs ingested since the service started
+	RecordsPerSecond float64 `json:"records_per_second"` // logs ingested per second over the last minute
+	// LagSeconds is the largest delay between the event time of a log and the
+	// time it was ingested, in the latest ingest of the family. It's only set
+	// if `WithEventTimeField` is set and the logs have that field.
+	LagSeconds *float64 `json:"lag_seconds,omitempty"`
+}
+
+// ingestStats tracks the ingestion of each log family
+// NOTE: the stats are kept in memory, so with many instances of the service,
+// the stats of each instance have to be combined by the monitoring system
+type ingestStats struct {
+	mu       sync.Mutex
+	families map[Family]*familyStats
+}
+
+// familyStats tracks the ingestion of a log family
+type familyStats struct {
+	records int64
+	recent  []ingestEvent // ingests within the rate window
+	lag     *float64
+}
+
+// ingestEvent is a number of logs ingested at a time
+type ingestEvent struct {
+	at      time.Time
+	records int
+}
+
+func newIngestStats() *ingestStats {
+	return &ingestStats{families: make(map[Family]*familyStats)}
+}
+
+// record tracks logs of a family ingested at a time, with the event times
+// of the logs that have one
+func (s *ingestStats) record(family Family, records int, eventTimes []time.Time, now time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	stats, ok := s.families[family]
+	if !ok {
+		stats = &familyStats{}
+		s.families[family] = stats
+	}
+	stats.records += int64(records)
+	stats.recent = append(stats.prune(now), ingestEvent{at: now, records: records})
+
+	if len(eventTimes) > 0 {
+		var lag float64
+		for _, eventTime := range eventTimes {
+			if eventLag := now.Sub(eventTime).Seconds(); eventLag > lag {
+				lag = eventLag
+			}
+		}
+		stats.lag = &lag
+	}
+}
+
+// snapshot returns the stats of every family, sorted by family
+func (s *ingestStats) snapshot(now time.Time) []FamilyStats {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	snapshot := make([]FamilyStats, 0, len(s.families))
+	for family, stats := range s.families {
+		stats.recent = stats.prune(now)
+		var recentRecords int
+		for _, event := range stats.recent {
+			recentRecords += event.records
+		}
+		snapshot = append(snapshot, FamilyStats{
+			Family:           family,
+			Records:          stats.records,
+			RecordsPerSecond: float64(recentRecords) / rateWindow.Seconds(),
+			LagSeconds:       stats.lag,
+		})
+	}
+	sort.Slice(snapshot, func(i, j int) bool {
+		return snapshot[i].Family < snapshot[j].Family
+	})
+	return snapshot
+}
+
+// prune returns the recent ingests that are still within the rate window
+func (f *familyStats) prune(now time.Time) []ingestEvent {
+	recent := f.recent[:0]
+	for _, event := range f.recent {
+		if now.Sub(event.at) < rateWindow {
+			recent = append(recent, event)
+		}
+	}
+	return recent
+}
+
+// eventTime returns the event time of a log from the field, which is either
+// a number of seconds since the unix epoch or an RFC 3339 string
+func eventTime(logEvent map[string]interface{}, field string) (time.Time, bool) {
+	switch value := logEvent[field].(type) {
+	case float64:
+		seconds := int64(value)
+		nanoseconds := int64((value - float64(seconds)) * float64(time.Second))
+		return time.Unix(seconds, nanoseconds), true
+	case string:
+		t, err := time.Parse(time.RFC3339, value)
+		return t, err == nil
+	}
+	return time.Time{}, false
+}
@@ -0,0 +1,338 @@
+package logs
+
+import (
+	"fmt"
+	"math"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// FieldType is a parsed schema type. A schema type is the name of the type of
+// a field, optionally followed by a colon and comma-separated options, ie:
+// `string:trim,lower`. A string type can be given a maximum length in
+// characters, ie: `string(32)` or `string(32):trim`, and a decimal type its
+// precision and scale, ie: `decimal(10,2)`. A `bigint` type holds 64-bit
+// integers, ie: timestamps in nanoseconds. A `json` type holds any JSON
+// object or array, ie: `{"lat": 1.0, "lng": 2.0}`, and an `any` type any
+// JSON value, including null, for fields whose values have no single type.
+// A type followed by an exclamation mark is required, ie: `int!` or
+// `string(32)!:trim`.
+type FieldType struct {
+	Name      string   // name of the type, ie: "string" or "int"
+	Length    int      // maximum length of string values, 0 for no limit
+	Precision int      // maximum number of digits of decimal values
+	Scale     int      // maximum number of digits after the decimal point of decimal values
+	Required  bool     // whether logs must have a value for the field
+	Normalize []string // normalizations applied to string values before insert
+}
+
+// MaxStringLength is the largest length of a string type. It's the most
+// characters a MySQL VARCHAR column can hold with the 4 bytes per character
+// of the utf8mb4 character set, within MySQL's 65535 bytes per row.
+const MaxStringLength = 16383
+
+// the precision and scale of decimal types. A `decimal` without a precision
+// has the defaults of SQL, so it only holds integers; money is usually a
+// `decimal(10,2)`. MySQL's DECIMAL holds at most 65 digits, 30 of them after
+// the decimal point.
+const (
+	DefaultDecimalPrecision = 10
+	DefaultDecimalScale     = 0
+	MaxDecimalPrecision     = 65
+	MaxDecimalScale         = 30
+)
+
+// the normalizations of string values
+const (
+	NormalizeTrim     = "trim"     // remove leading and trailing whitespace
+	NormalizeCollapse = "collapse" // replace runs of whitespace with a single space
+	NormalizeLower    = "lower"    // lowercase
+)
+
+// supportedTypes are the names of the field types logs can be ingested with
+var supportedTypes = map[string]bool{
+	"string":  true,
+	"int":     true,
+	"bigint":  true,
+	"decimal": true,
+	"json":    true,
+	"any":     true,
+}
+
+// SupportedType reports whether logs can be ingested with fields of the type
+// with the name, ie: "string"
+func SupportedType(name string) bool {
+	return supportedTypes[name]
+}
+
+// whitespace matches runs of whitespace
+var whitespace = regexp.MustCompile(`\s+`)
+
+// ParseFieldType parses a schema type. Types are case-insensitive and
+// surrounding whitespace is ignored, so ` String ` is parsed as `string`.
+// Note that the name of the type isn't checked, since what's supported
+// depends on the database.
+func ParseFieldType(fieldType string) (FieldType, error) {
+	fieldType = strings.ToLower(strings.TrimSpace(fieldType))
+	name, options := fieldType, ""
+	if i := strings.Index(fieldType, ":"); i >= 0 {
+		name, options = fieldType[:i], fieldType[i+1:]
+	}
+
+	parsed := FieldType{Name: name}
+	if strings.HasSuffix(name, "!") {
+		name = strings.TrimSuffix(name, "!")
+		parsed.Name, parsed.Required = name, true
+	}
+	if i := strings.Index(name, "("); i >= 0 && strings.HasSuffix(name, ")") {
+		parsed.Name = name[:i]
+		switch parsed.Name {
+		case "string":
+			length, err := strconv.Atoi(name[i+1 : len(name)-1])
+			if err != nil || length < 1 || length > MaxStringLength {
+				return parsed, errors.Errorf("length of type %s must be an integer from 1 to %d", name, MaxStringLength)
+			}
+			parsed.Length = length
+		case "decimal":
+			precision, scale, err := parseDecimalArgs(name[i+1 : len(name)-1])
+			if err != nil {
+				return parsed, errors.Wrapf(err, "type %s", name)
+			}
+			parsed.Precision, parsed.Scale = precision, scale
+		default:
+			return parsed, errors.Errorf("type %s doesn't support a length", parsed.Name)
+		}
+	} else if parsed.Name == "decimal" {
+		parsed.Precision, parsed.Scale = DefaultDecimalPrecision, DefaultDecimalScale
+	}
+	if options == "" {
+		return parsed, nil
+	}
+	if parsed.Name != "string" {
+		return parsed, errors.Errorf("type %s doesn't support options", name)
+	}
+	for _, option := range strings.Split(options, ",") {
+		switch option {
+		case NormalizeTrim, NormalizeCollapse, NormalizeLower:
+			parsed.Normalize = append(parsed.Normalize, option)
+		default:
+			return parsed, errors.Errorf("unknown option %s for type %s", option, name)
+		}
+	}
+	return parsed, nil
+}
+
+// parseDecimalArgs parses the precision and optional scale of a decimal
+// type, ie: `10,2` or `10`
+func parseDecimalArgs(args string) (precision, scale int, err error) {
+	precisionArg, scaleArg := args, "0"
+	if i := strings.Index(args, ","); i >= 0 {
+		precisionArg, scaleArg = args[:i], args[i+1:]
+	}
+	precision, err = strconv.Atoi(strings.TrimSpace(precisionArg))
+	if err != nil || precision < 1 || precision > MaxDecimalPrecision {
+		return 0, 0, errors.Errorf("precision must be an integer from 1 to %d", MaxDecimalPrecision)
+	}
+	scale, err = strconv.Atoi(strings.TrimSpace(scaleArg))
+	if err != nil || scale < 0 || scale > MaxDecimalScale || scale > precision {
+		return 0, 0, errors.Errorf("scale must be an integer from 0 to %d, and at most the precision", MaxDecimalScale)
+	}
+	return precision, scale, nil
+}
+
+// decimalPattern matches a decimal number as a string, ie: `-12.50`
+var decimalPattern = regexp.MustCompile(`^[+-]?[0-9]+(\.[0-9]+)?$`)
+
+// maxExactInt is the largest integer a float holds exactly. A JSON number
+// above it may have been rounded when it was decoded, so bigints above it
+// should be sent as strings.
+const maxExactInt = 1 << 53
+
+// checkIntRange returns a message describing why an int value doesn't fit in
+// the 32-bit INT column of an int field, or "" if it does. The database rounds
+// a fraction to the nearest integer, so that's the value that has to fit.
+func checkIntRange(value float64) string {
+	if n := math.Round(value); n < math.MinInt32 || n > math.MaxInt32 {
+		return fmt.Sprintf("value is outside the range of int, from %d to %d, so use the bigint type for larger values", math.MinInt32, math.MaxInt32)
+	}
+	return ""
+}
+
+// integerPattern matches the integers of numeric strings
+var integerPattern = regexp.MustCompile(`^[+-]?[0-9]+$`)
+
+// bigintValue returns a bigint value of a log as an int64, or a message
+// describing why it isn't a 64-bit integer. Values can be numbers up to
+// `maxExactInt`, or numeric strings for any 64-bit integer, so that they
+// aren't rounded through a float.
+func bigintValue(value interface{}) (int64, string) {
+	switch v := value.(type) {
+	case float64:
+		if v != math.Trunc(v) {
+			return 0, "value is not an integer"
+		}
+		if math.Abs(v) > maxExactInt {
+			return 0, fmt.Sprintf("value is larger than %d, which may lose precision as a number, so send it as a string", int64(maxExactInt))
+		}
+		return int64(v), ""
+	case string:
+		if !integerPattern.MatchString(v) {
+			return 0, fmt.Sprintf("expected bigint, got %s", describeValue(value))
+		}
+		n, err := strconv.ParseInt(v, 10, 64)
+		if err != nil {
+			return 0, "value doesn't fit in a 64-bit integer"
+		}
+		return n, ""
+	}
+	return 0, fmt.Sprintf("expected bigint, got %s", describeValue(value))
+}
+
+// decimalString returns a decimal value of a log as a string, so that it's
+// stored without losing precision, or false if the value isn't a number or a
+// numeric string. Numbers are formatted with the fewest digits that decode to
+// the same float, so a decimal with more digits than a float holds should be
+// sent as a string.
+func decimalString(value interface{}) (string, bool) {
+	switch v := value.(type) {
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64), true
+	case string:
+		return v, decimalPattern.MatchString(v)
+	}
+	return "", false
+}
+
+// checkDecimal returns a message describing why the decimal doesn't fit in
+// the precision and scale of the type, or an empty string if it fits
+func (t FieldType) checkDecimal(decimal string) string {
+	integer, fraction := strings.TrimLeft(decimal, "+-"), ""
+	if i := strings.Index(integer, "."); i >= 0 {
+		integer, fraction = integer[:i], integer[i+1:]
+	}
+	// zeros that don't change the value don't count towards the digits
+	integer = strings.TrimLeft(integer, "0")
+	fraction = strings.TrimRight(fraction, "0")
+	if len(fraction) > t.Scale {
+		return fmt.Sprintf("value has more than %d digits after the decimal point", t.Scale)
+	}
+	if len(integer) > t.Precision-t.Scale {
+		return fmt.Sprintf("value has more than %d digits before the decimal point", t.Precision-t.Scale)
+	}
+	return ""
+}
+
+// normalizeString applies the normalizations of the type to a string value
+func (t FieldType) normalizeString(value string) string {
+	for _, normalization := range t.Normalize {
+		switch normalization {
+		case NormalizeTrim:
+			value = strings.TrimSpace(value)
+		case NormalizeCollapse:
+			value = whitespace.ReplaceAllString(value, " ")
+		case NormalizeLower:
+			value = strings.ToLower(value)
+		}
+	}
+	return value
+}
+
+// normalizeLogs returns the logs with the normalizations of the schema
+// applied to their string values, their decimal values as strings, and their
+// bigint values as int64. Logs without any values to normalize are returned
+// as is, rather than copied.
+func normalizeLogs(schema *compiledSchema, logs JSON) JSON {
+	types := make(map[string]FieldType)
+	for field, fieldType := range schema.types {
+		if len(fieldType.Normalize) > 0 || fieldType.Name == "decimal" || fieldType.Name == "bigint" {
+			types[field] = fieldType
+		}
+	}
+	if len(types) == 0 {
+		return logs
+	}
+
+	normalized := make(JSON, 0, len(logs))
+	for _, logEvent := range logs {
+		normalizedEvent := make(map[string]interface{}, len(logEvent))
+		for field, value := range logEvent {
+			if fieldType, ok := types[field]; ok {
+				if fieldType.Name == "decimal" {
+					// decimals are inserted as strings, which the database
+					// converts without rounding them through a float
+					if decimal, ok := decimalString(value); ok {
+						value = decimal
+					}
+				} else if fieldType.Name == "bigint" {
+					if n, message := bigintValue(value); message == "" {
+						value = n
+					}
+				} else if s, ok := value.(string); ok {
+					value = fieldType.normalizeString(s)
+				}
+			}
+			normalizedEvent[field] = value
+		}
+		normalized = append(normalized, normalizedEvent)
+	}
+	return normalized
+}
+
+// FieldError is returned when a field of a log doesn't match the schema, ie:
+// a string value for an int field
+type FieldError struct {
+	Index   int    // index of the log in the batch
+	Field   string // name of the field
+	Message string // what's wrong with the value
+}
+
+func (e *FieldError) Error() string {
+	return fmt.Sprintf("log %d: field %s: %s", e.Index, e.Field, e.Message)
+}
+
+// FieldErrors is returned instead of a `*FieldError` when every invalid
+// field of the logs is reported (see `WithAllFieldErrors`), in the order of
+// the logs and of the names of their fields
+type FieldErrors []*FieldError
+
+func (e FieldErrors) Error() string {
+	messages := make([]string, len(e))
+	for i, fieldErr := range e {
+		messages[i] = fieldErr.Error()
+	}
+	return fmt.Sprintf("%d invalid fields: %s", len(e), strings.Join(messages, "; "))
+}
+
+// mismatchError describes a value of a log that isn't of the type of its
+// field in the schema
+func mismatchError(index int, field, expected string, value interface{}) *FieldError {
+	return &FieldError{
+		Index:   index,
+		Field:   field,
+		Message: fmt.Sprintf("expected %s, got %s", expected, describeValue(value)),
+	}
+}
+
+// describeValue returns the JSON type of a decoded value along with the
+// value, ie: `string "heavy"`
+func describeValue(value interface{}) string {
+	switch v := value.(type) {
+	case nil:
+		return "null"
+	case string:
+		return fmt.Sprintf("string %q", v)
+	case float64:
+		return fmt.Sprintf("number %v", v)
+	case bool:
+		return fmt.Sprintf("boolean %v", v)
+	case []interface{}:
+		return "array"
+	case map[string]interface{}:
+		return "object"
+	default:
+		return fmt.Sprintf("%T %v", v, v)
+	}
+}
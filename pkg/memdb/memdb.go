@@ -0,0 +1,445 @@
+// Package memdb is a database of logs held in memory, for tests and demos. It
+// implements `logs.DBClient` without a database server, so that ingested logs
+// can be queried back, and answers a subset of SELECT (see `DB.QueryJSON`).
+// The logs are lost when the process exits.
+package memdb
+
+import (
+	"context"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/kolide/databalancer-logan/pkg/logs"
+	"github.com/pkg/errors"
+)
+
+// idColumn is the column of the id generated for each row
+const idColumn = "id"
+
+// sourceFieldType is the field type of the family of each log of a merged
+// table, like the VARCHAR(255) column of the MySQL client
+const sourceFieldType = "string(255)"
+
+// DB is a database of logs held in memory, with a table per family
+type DB struct {
+	mu     sync.RWMutex
+	tables map[logs.Family]*table
+}
+
+// table is the rows of a family, along with the field type of its columns
+type table struct {
+	schema logs.Schema // field type of each column, other than the id
+	unique []string    // columns of the unique key, if any
+	rows   []row
+	lastID int64 // id of the last row added
+}
+
+// row is a row of a table, mapping its columns to their values. A column
+// without a value isn't in the map.
+type row map[string]interface{}
+
+// New makes an empty in-memory database
+func New() *DB {
+	return &DB{tables: make(map[logs.Family]*table)}
+}
+
+// Table inserts logs into the table of a family of a `DB`
+type Table struct {
+	db     *DB
+	Family logs.Family
+}
+
+// CreateTable creates the table of a family if it doesn't exist, and adds
+// the fields of the schema missing from an existing table. A field of a type
+// conflicting with the type of its existing column is an error. With unique
+// fields, a log with the same values for them as a row replaces the row.
+func (db *DB) CreateTable(ctx context.Context, family logs.Family, schema logs.Schema, unique []string) (logs.Table, error) {
+	for field, fieldType := range schema {
+		if field == idColumn {
+			return nil, errors.Errorf("field %s of %s table is the generated id column", field, family)
+		}
+		if _, ok := columnType(fieldType); !ok {
+			return nil, errors.Errorf("unsupported type %s of field %s of %s table", fieldType, field, family)
+		}
+	}
+	for _, field := range unique {
+		if _, ok := schema[field]; !ok {
+			return nil, errors.Errorf("unique field %s of %s table is not in the schema", field, family)
+		}
+	}
+
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	t, ok := db.tables[family]
+	if !ok {
+		t = &table{schema: logs.Schema{}}
+	}
+	for field, fieldType := range schema {
+		existing, ok := t.schema[field]
+		if !ok {
+			continue
+		}
+		if existingType, _ := columnType(existing); existingType != mustColumnType(fieldType) {
+			return nil, errors.Wrapf(logs.ErrIncompatibleSchemas, "field %s of type %s conflicts with existing column of type %s",
+				field, fieldType, existingType)
+		}
+	}
+	if len(unique) > 0 && len(t.unique) > 0 && strings.Join(unique, ",") != strings.Join(t.unique, ",") {
+		return nil, errors.Errorf("unique fields %s conflict with existing unique key on %s",
+			strings.Join(unique, ", "), strings.Join(t.unique, ", "))
+	}
+
+	for field, fieldType := range schema {
+		if _, ok := t.schema[field]; !ok {
+			t.schema[field] = fieldType
+		}
+	}
+	if len(t.unique) == 0 && len(unique) > 0 {
+		t.unique = append([]string(nil), unique...)
+	}
+	db.tables[family] = t
+	return &Table{db: db, Family: family}, nil
+}
+
+// CreateTableDDL returns an empty statement, since the tables are held in
+// memory rather than created with a statement
+func (db *DB) CreateTableDDL(family logs.Family, schema logs.Schema, unique []string) (string, error) {
+	return "", nil
+}
+
+// Insert adds the logs to the table, and returns the number of logs inserted.
+// A log replacing the row of its unique key counts as a single row, unlike
+// with MySQL.
+func (t *Table) Insert(ctx context.Context, records logs.JSON) (int64, error) {
+	ids, err := t.insert(records)
+	return int64(len(ids)), err
+}
+
+// InsertReturningIDs adds the logs to the table, and returns the ids of
+// their rows in the order they were given
+func (t *Table) InsertReturningIDs(ctx context.Context, records logs.JSON) ([]int64, error) {
+	return t.insert(records)
+}
+
+// insert adds the logs to the table, either all of them or none
+func (t *Table) insert(records logs.JSON) ([]int64, error) {
+	t.db.mu.Lock()
+	defer t.db.mu.Unlock()
+	table, ok := t.db.tables[t.Family]
+	if !ok {
+		return nil, errors.Wrapf(logs.ErrFamilyNotFound, "inserting into %s table", t.Family)
+	}
+
+	// convert the logs before adding any, so that a bad log adds none
+	rows := make([]row, 0, len(records))
+	for _, record := range records {
+		r := make(row, len(record))
+		for field, value := range record {
+			fieldType, ok := table.schema[field]
+			if !ok {
+				return nil, errors.Errorf("%s table has no column %s", t.Family, field)
+			}
+			r[field] = storedValue(value, fieldType)
+		}
+		rows = append(rows, r)
+	}
+
+	ids := make([]int64, 0, len(rows))
+	for _, r := range rows {
+		ids = append(ids, table.upsert(r))
+	}
+	return ids, nil
+}
+
+// upsert replaces the row with the same values for the unique key as the
+// row, or adds the row if there isn't one, and returns the id of the row
+func (t *table) upsert(r row) int64 {
+	if len(t.unique) > 0 {
+	rows:
+		for _, existing := range t.rows {
+			for _, field := range t.unique {
+				if !reflect.DeepEqual(existing[field], r[field]) {
+					continue rows
+				}
+			}
+			for field, value := range r {
+				existing[field] = value
+			}
+			return existing[idColumn].(int64)
+		}
+	}
+	return t.add(r)
+}
+
+// add adds the row with the next id, and returns the id
+func (t *table) add(r row) int64 {
+	t.lastID++
+	r[idColumn] = t.lastID
+	t.rows = append(t.rows, r)
+	return t.lastID
+}
+
+// storedValue converts a value of a log to the value stored for its field
+// type, like a database would: ints and bigints are stored as int64, and
+// decimals as strings so that they don't lose precision
+func storedValue(value interface{}, fieldType string) interface{} {
+	f, ok := value.(float64)
+	if !ok {
+		return value
+	}
+	switch mustColumnType(fieldType) {
+	case "int", "bigint":
+		return int64(f)
+	case "decimal":
+		return strconv.FormatFloat(f, 'f', -1, 64)
+	}
+	return value
+}
+
+// columnType returns the type of the column of a field type, named like the
+// MySQL data type, ie: "varchar" for `string(32)`, and whether the field type
+// is supported
+func columnType(fieldType string) (string, bool) {
+	parsed, err := logs.ParseFieldType(fieldType)
+	if err != nil {
+		return "", false
+	}
+	switch parsed.Name {
+	case "string":
+		if parsed.Length > 0 {
+			return "varchar", true
+		}
+		return "text", true
+	case "int", "bigint", "decimal", "json":
+		return parsed.Name, true
+	case "any":
+		// like MySQL, which stores any value in a JSON column
+		return "json", true
+	}
+	return "", false
+}
+
+// mustColumnType returns the type of the column of a field type that's
+// known to be supported
+func mustColumnType(fieldType string) string {
+	name, _ := columnType(fieldType)
+	return name
+}
+
+// TableSchema returns the schema of the table of a family, or nil if the
+// family doesn't have a table
+func (db *DB) TableSchema(ctx context.Context, family logs.Family) (logs.Schema, error) {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+	t, ok := db.tables[family]
+	if !ok {
+		return nil, nil
+	}
+	schema := make(logs.Schema, len(t.schema))
+	for field, fieldType := range t.schema {
+		schema[field] = fieldType
+	}
+	return schema, nil
+}
+
+// DescribeDatabase returns the tables with their columns and types, in the
+// same format as the MySQL client
+func (db *DB) DescribeDatabase(ctx context.Context) (logs.JSON, error) {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+	tables := logs.JSON{}
+	for _, family := range db.families() {
+		tables = append(tables, db.tables[family].describe(family))
+	}
+	return tables, nil
+}
+
+// DescribeTable returns the table of a family with its columns and types, or
+// no tables if the family doesn't have one
+func (db *DB) DescribeTable(ctx context.Context, family logs.Family) (logs.JSON, error) {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+	t, ok := db.tables[family]
+	if !ok {
+		return logs.JSON{}, nil
+	}
+	return logs.JSON{t.describe(family)}, nil
+}
+
+// describe describes the table of a family, with the id column followed by
+// the other columns in order
+func (t *table) describe(family logs.Family) map[string]interface{} {
+	columns := []map[string]interface{}{
+		{"name": idColumn, "nullable": false, "type": "int"},
+	}
+	for _, field := range t.fields() {
+		parsed, _ := logs.ParseFieldType(t.schema[field])
+		column := map[string]interface{}{
+			"name":     field,
+			"nullable": !parsed.Required,
+			"type":     mustColumnType(t.schema[field]),
+		}
+		// like the comment of the JSON column of an any field in MySQL
+		if parsed.Name == "any" {
+			column["schema_type"] = "any"
+		}
+		columns = append(columns, column)
+	}
+	return map[string]interface{}{"name": family.String(), "columns": columns}
+}
+
+// fields returns the columns of the table other than the id, in order
+func (t *table) fields() []string {
+	fields := make([]string, 0, len(t.schema))
+	for field := range t.schema {
+		fields = append(fields, field)
+	}
+	sort.Strings(fields)
+	return fields
+}
+
+// ListTables returns the names of the tables, in order
+func (db *DB) ListTables(ctx context.Context) ([]string, error) {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+	names := []string{}
+	for _, family := range db.families() {
+		names = append(names, family.String())
+	}
+	return names, nil
+}
+
+// families returns the families with a table, in order
+func (db *DB) families() []logs.Family {
+	families := make([]logs.Family, 0, len(db.tables))
+	for family := range db.tables {
+		families = append(families, family)
+	}
+	sort.Slice(families, func(i, j int) bool { return families[i] < families[j] })
+	return families
+}
+
+// DropTable drops the table of a family, returning `logs.ErrFamilyNotFound`
+// if it doesn't exist
+func (db *DB) DropTable(ctx context.Context, family logs.Family) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	if _, ok := db.tables[family]; !ok {
+		return logs.ErrFamilyNotFound
+	}
+	delete(db.tables, family)
+	return nil
+}
+
+// TruncateTable deletes the rows of the table of a family, keeping its
+// schema, and restarts its ids, returning `logs.ErrFamilyNotFound` if it
+// doesn't exist
+func (db *DB) TruncateTable(ctx context.Context, family logs.Family) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	t, ok := db.tables[family]
+	if !ok {
+		return logs.ErrFamilyNotFound
+	}
+	t.rows, t.lastID = nil, 0
+	return nil
+}
+
+// CountRows returns the number of rows of the table of a family, returning
+// `logs.ErrFamilyNotFound` if it doesn't exist
+func (db *DB) CountRows(ctx context.Context, family logs.Family) (int64, error) {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+	t, ok := db.tables[family]
+	if !ok {
+		return 0, logs.ErrFamilyNotFound
+	}
+	return int64(len(t.rows)), nil
+}
+
+// MergeTables merges the tables of the source families into the table of
+// the target family like the MySQL client: the target is created if needed,
+// and gets the union of their columns along with a column of the family of
+// each row, replacing the rows merged from a source previously. The columns
+// of all the tables are checked for conflicting types before anything is
+// written.
+func (db *DB) MergeTables(ctx context.Context, target logs.Family, sources []logs.Family, sourceColumn string) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	// the columns of the merged table, starting with those of the target
+	merged := logs.Schema{}
+	owners := make(map[string]logs.Family) // column -> family it was first found in
+	if t, ok := db.tables[target]; ok {
+		for field, fieldType := range t.schema {
+			merged[field], owners[field] = fieldType, target
+		}
+	}
+	for _, source := range sources {
+		t, ok := db.tables[source]
+		if !ok {
+			return errors.Wrapf(logs.ErrFamilyNotFound, "finding %s table", source)
+		}
+		for _, field := range t.fields() {
+			fieldType := t.schema[field]
+			if field == sourceColumn {
+				return errors.Wrapf(logs.ErrIncompatibleSchemas,
+					"%s table has a %s column, which is the source column", source, field)
+			}
+			mergedType, ok := merged[field]
+			if ok && mustColumnType(mergedType) != mustColumnType(fieldType) {
+				return errors.Wrapf(logs.ErrIncompatibleSchemas, "column %s is %s in %s table but %s in %s table",
+					field, mustColumnType(fieldType), source, mustColumnType(mergedType), owners[field])
+			}
+			if !ok {
+				merged[field], owners[field] = fieldType, source
+			}
+		}
+	}
+	if _, ok := merged[sourceColumn]; !ok {
+		merged[sourceColumn] = sourceFieldType
+	}
+
+	t, ok := db.tables[target]
+	if !ok {
+		t = &table{}
+		db.tables[target] = t
+	}
+	t.schema = merged
+	for _, source := range sources {
+		// replace the rows merged from the source previously
+		kept := t.rows[:0]
+		for _, r := range t.rows {
+			if r[sourceColumn] != source.String() {
+				kept = append(kept, r)
+			}
+		}
+		t.rows = kept
+		for _, r := range db.tables[source].rows {
+			copied := make(row, len(r)+1)
+			for field, value := range r {
+				if field != idColumn {
+					copied[field] = value
+				}
+			}
+			copied[sourceColumn] = source.String()
+			t.add(copied)
+		}
+	}
+	return nil
+}
+
+// PingContext returns the error of the context, since there is no server to
+// reach
+func (db *DB) PingContext(ctx context.Context) error {
+	return ctx.Err()
+}
+
+// Close does nothing, since there is no connection to close. The tables are
+// kept.
+func (db *DB) Close() error {
+	return nil
+}
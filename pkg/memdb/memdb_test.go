@@ -0,0 +1,308 @@
+package memdb_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/kolide/databalancer-logan/pkg/logs"
+	"github.com/kolide/databalancer-logan/pkg/memdb"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+)
+
+// dogs are the logs ingested by the tests
+var dogs = logs.JSON{
+	{"name": "rex", "breed": "boxer", "weight": float64(30), "price": "120.50"},
+	{"name": "max", "breed": "pug", "weight": float64(8)},
+	{"name": "bella", "breed": "boxer", "weight": float64(25), "price": float64(99)},
+}
+
+// dogSchema is the schema of the dogs
+var dogSchema = logs.Schema{"name": "string!", "breed": "string(32)", "weight": "int", "price": "decimal(10,2)"}
+
+// ingestDogs returns a service backed by an in-memory database with the dogs
+// ingested
+func ingestDogs(t *testing.T) *logs.Service {
+	svc := logs.CreateService(memdb.New(), logs.WithLogger(nil))
+	_, err := svc.Ingest(context.Background(), "dog_registry", dogSchema, nil, dogs)
+	assert.NoError(t, err)
+	return svc
+}
+
+func TestIngestThenQuery(t *testing.T) {
+	// GIVEN a service with dogs ingested
+	svc := ingestDogs(t)
+
+	// WHEN every dog is queried
+	results, columns, err := svc.Query(context.Background(), "SELECT * FROM dog_registry")
+
+	// THEN the dogs are returned as ingested, without their generated id
+	assert.NoError(t, err)
+	assert.Equal(t, logs.JSON{
+		{"name": "rex", "breed": "boxer", "weight": int64(30), "price": "120.50"},
+		{"name": "max", "breed": "pug", "weight": int64(8), "price": nil},
+		{"name": "bella", "breed": "boxer", "weight": int64(25), "price": "99"},
+	}, results)
+	assert.Equal(t, []logs.Column{
+		{Name: "breed", Type: "VARCHAR"},
+		{Name: "name", Type: "TEXT"},
+		{Name: "price", Type: "DECIMAL"},
+		{Name: "weight", Type: "INT"},
+	}, columns)
+}
+
+func TestIngestAnyThenQuery(t *testing.T) {
+	// GIVEN a service with logs of mixed values ingested into an any field
+	svc := logs.CreateService(memdb.New(), logs.WithLogger(nil))
+	records := logs.JSON{
+		{"path": "/", "meta": "Boston"},
+		{"path": "/about", "meta": float64(1.5)},
+		{"path": "/contact", "meta": false},
+		{"path": "/help", "meta": nil},
+		{"path": "/map", "meta": map[string]interface{}{"lat": 1.0, "lng": 2.0}},
+		{"path": "/tags", "meta": []interface{}{"a", 1.0}},
+	}
+	_, err := svc.Ingest(context.Background(), "request_log", logs.Schema{"path": "string", "meta": "any"}, nil, records)
+	assert.NoError(t, err)
+
+	// WHEN they're queried
+	results, columns, err := svc.Query(context.Background(), "SELECT path, meta FROM request_log")
+
+	// THEN the values are returned as ingested, from a json column
+	assert.NoError(t, err)
+	assert.Equal(t, records, results)
+	assert.Equal(t, []logs.Column{{Name: "path", Type: "TEXT"}, {Name: "meta", Type: "JSON"}}, columns)
+}
+
+func TestIngestAnyRoundTrip(t *testing.T) {
+	// GIVEN a family created with an any field
+	svc := logs.CreateService(memdb.New(), logs.WithLogger(nil))
+	_, err := svc.Ingest(context.Background(), "request_log", logs.Schema{"path": "string", "meta": "any"}, nil, logs.JSON{{"path": "/", "meta": map[string]interface{}{"lat": 1.0}}})
+	assert.NoError(t, err)
+
+	// WHEN it's described
+	tables, err := svc.DescribeFamily(context.Background(), "request_log")
+
+	// THEN the field keeps its any type, in a json column
+	assert.NoError(t, err)
+	columns := tables[0]["columns"].([]map[string]interface{})
+	assert.Equal(t, map[string]interface{}{"name": "meta", "nullable": true, "type": "json", "schema_type": "any"}, columns[1])
+
+	// AND logs with scalars and nulls are ingested again without a schema
+	_, err = svc.Ingest(context.Background(), "request_log", nil, nil, logs.JSON{{"path": "/about", "meta": "Boston"}, {"path": "/help", "meta": nil}})
+	assert.NoError(t, err)
+}
+
+func TestQuery(t *testing.T) {
+	cases := []struct {
+		name    string
+		query   string
+		args    []interface{}
+		results logs.JSON
+		err     error
+	}{
+		{
+			name:    "a column is filtered with a placeholder",
+			query:   "SELECT name FROM dog_registry WHERE breed = ?",
+			args:    []interface{}{"boxer"},
+			results: logs.JSON{{"name": "rex"}, {"name": "bella"}},
+		},
+		{
+			name:    "conditions are combined and sorted",
+			query:   "SELECT name, weight AS kg FROM dog_registry WHERE weight > 10 AND (breed = 'boxer' OR breed = 'pug') ORDER BY weight ASC",
+			results: logs.JSON{{"name": "bella", "kg": int64(25)}, {"name": "rex", "kg": int64(30)}},
+		},
+		{
+			name:    "the results are sorted in descending order and limited",
+			query:   "SELECT name FROM dog_registry ORDER BY name DESC LIMIT 1, 2",
+			results: logs.JSON{{"name": "max"}, {"name": "bella"}},
+		},
+		{
+			name:    "IN, LIKE and IS NULL are evaluated",
+			query:   "SELECT name FROM dog_registry WHERE name IN ('rex', 'max') AND name LIKE 'm%' AND price IS NULL",
+			results: logs.JSON{{"name": "max"}},
+		},
+		{
+			name:    "a decimal is compared as a number",
+			query:   "SELECT name FROM dog_registry WHERE price >= 100",
+			results: logs.JSON{{"name": "rex"}},
+		},
+		{
+			name:    "the id is returned when selected by name",
+			query:   "SELECT id, name FROM dog_registry WHERE id = 2",
+			results: logs.JSON{{"id": int64(2), "name": "max"}},
+		},
+		{
+			name:    "no matching rows are empty results",
+			query:   "SELECT name FROM dog_registry WHERE breed = 'poodle'",
+			results: logs.JSON{},
+		},
+		{
+			name:  "a missing table isn't found",
+			query: "SELECT * FROM cat_registry",
+			err:   logs.ErrFamilyNotFound,
+		},
+		{
+			name:  "a join is unsupported",
+			query: "SELECT * FROM dog_registry JOIN cat_registry ON dog_registry.id = cat_registry.id",
+			err:   memdb.ErrUnsupportedQuery,
+		},
+		{
+			name:  "an aggregate is unsupported",
+			query: "SELECT COUNT(*) FROM dog_registry",
+			err:   memdb.ErrUnsupportedQuery,
+		},
+	}
+	svc := ingestDogs(t)
+	for _, tt := range cases {
+		t.Run(tt.name, func(t *testing.T) {
+			// WHEN the dogs are queried
+			results, _, err := svc.Query(context.Background(), tt.query, tt.args...)
+
+			// THEN the results are the matching dogs, or the error
+			if tt.err != nil {
+				assert.Equal(t, tt.err, errors.Cause(err))
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tt.results, results)
+		})
+	}
+}
+
+func TestIngestUniqueThenQuery(t *testing.T) {
+	// GIVEN a family with a unique name
+	svc := logs.CreateService(memdb.New(), logs.WithLogger(nil))
+	schema := logs.Schema{"name": "string", "weight": "int"}
+	_, err := svc.Ingest(context.Background(), "dog_registry", schema, []string{"name"}, logs.JSON{
+		{"name": "rex", "weight": float64(30)},
+	})
+	assert.NoError(t, err)
+
+	// WHEN a log with the same name is ingested again
+	_, err = svc.Ingest(context.Background(), "dog_registry", schema, []string{"name"}, logs.JSON{
+		{"name": "rex", "weight": float64(32)},
+	})
+
+	// THEN it replaces the stored log
+	assert.NoError(t, err)
+	results, _, err := svc.Query(context.Background(), "SELECT name, weight FROM dog_registry")
+	assert.NoError(t, err)
+	assert.Equal(t, logs.JSON{{"name": "rex", "weight": int64(32)}}, results)
+}
+
+func TestIngestWithoutSchemaThenQuery(t *testing.T) {
+	// GIVEN a service with dogs ingested
+	svc := ingestDogs(t)
+
+	// WHEN more dogs are ingested without their schema
+	_, err := svc.Ingest(context.Background(), "dog_registry", nil, nil, logs.JSON{{"name": "fido"}})
+
+	// THEN the schema is read from the table
+	assert.NoError(t, err)
+	count, err := svc.CountFamily(context.Background(), "dog_registry")
+	assert.NoError(t, err)
+	assert.Equal(t, int64(4), count)
+}
+
+func TestDescribeLogs(t *testing.T) {
+	// GIVEN a service with dogs ingested
+	svc := ingestDogs(t)
+
+	// WHEN the logs are described
+	tables, err := svc.DescribeLogs(context.Background())
+
+	// THEN the table of the dogs is described from its schema
+	assert.NoError(t, err)
+	assert.Equal(t, logs.JSON{{
+		"name": "dog_registry",
+		"columns": []map[string]interface{}{
+			{"name": "id", "nullable": false, "type": "int", "schema_type": "int"},
+			{"name": "breed", "nullable": true, "type": "varchar", "schema_type": "string"},
+			{"name": "name", "nullable": false, "type": "text", "schema_type": "string"},
+			{"name": "price", "nullable": true, "type": "decimal", "schema_type": "decimal"},
+			{"name": "weight", "nullable": true, "type": "int", "schema_type": "int"},
+		},
+	}}, tables)
+}
+
+func TestConflictingSchema(t *testing.T) {
+	// GIVEN a service with dogs ingested
+	svc := ingestDogs(t)
+
+	// WHEN dogs are ingested with a different type for a field
+	_, err := svc.Ingest(context.Background(), "dog_registry", logs.Schema{"weight": "string"}, nil,
+		logs.JSON{{"weight": "heavy"}})
+
+	// THEN the ingest fails
+	assert.Error(t, err)
+}
+
+func TestMergeThenQuery(t *testing.T) {
+	// GIVEN dogs and cats
+	svc := ingestDogs(t)
+	_, err := svc.Ingest(context.Background(), "cat_registry", logs.Schema{"name": "string", "lives": "int"}, nil,
+		logs.JSON{{"name": "tom", "lives": float64(9)}})
+	assert.NoError(t, err)
+
+	// WHEN they're merged into pets, twice
+	req := logs.MergeRequest{Target: "pets", Sources: []logs.Family{"cat_registry", "dog_registry"}}
+	_, err = svc.MergeFamilies(context.Background(), req)
+	assert.NoError(t, err)
+	_, err = svc.MergeFamilies(context.Background(), req)
+
+	// THEN pets has the logs of both once, with the family they came from
+	assert.NoError(t, err)
+	results, _, err := svc.Query(context.Background(),
+		"SELECT name, source_family FROM pets WHERE source_family = 'cat_registry' OR weight < 10 ORDER BY name")
+	assert.NoError(t, err)
+	assert.Equal(t, logs.JSON{
+		{"name": "max", "source_family": "dog_registry"},
+		{"name": "tom", "source_family": "cat_registry"},
+	}, results)
+	count, err := svc.CountFamily(context.Background(), "pets")
+	assert.NoError(t, err)
+	assert.Equal(t, int64(4), count)
+}
+
+func TestTruncateThenIngest(t *testing.T) {
+	// GIVEN a service with dogs ingested
+	svc := ingestDogs(t)
+
+	// WHEN the dogs are truncated
+	err := svc.TruncateFamily(context.Background(), "dog_registry")
+
+	// THEN the family is kept without its logs
+	assert.NoError(t, err)
+	count, err := svc.CountFamily(context.Background(), "dog_registry")
+	assert.NoError(t, err)
+	assert.Equal(t, int64(0), count)
+	families, err := svc.ListFamilies(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"dog_registry"}, families)
+
+	// AND logs are ingested again without a schema, with their ids restarted
+	_, err = svc.Ingest(context.Background(), "dog_registry", nil, nil, logs.JSON{{"name": "spot"}})
+	assert.NoError(t, err)
+	results, _, err := svc.Query(context.Background(), "SELECT id, name FROM dog_registry")
+	assert.NoError(t, err)
+	assert.Equal(t, logs.JSON{{"id": int64(1), "name": "spot"}}, results)
+
+	// AND a missing family is not found
+	assert.Equal(t, logs.ErrFamilyNotFound, errors.Cause(svc.TruncateFamily(context.Background(), "cat_registry")))
+}
+
+func TestDropThenList(t *testing.T) {
+	// GIVEN a service with dogs ingested
+	svc := ingestDogs(t)
+
+	// WHEN the dogs are dropped
+	err := svc.DropFamily(context.Background(), "dog_registry")
+
+	// THEN the family is gone
+	assert.NoError(t, err)
+	families, err := svc.ListFamilies(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, []string{}, families)
+	assert.Equal(t, logs.ErrFamilyNotFound, errors.Cause(svc.DropFamily(context.Background(), "dog_registry")))
+}
@@ -0,0 +1,511 @@
+package memdb
+
+import (
+	"context"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/kolide/databalancer-logan/pkg/logs"
+	"github.com/pkg/errors"
+	"github.com/xwb1989/sqlparser"
+)
+
+// ErrUnsupportedQuery is returned for a query using SQL the in-memory
+// database doesn't answer, ie: a join or a GROUP BY
+var ErrUnsupportedQuery = errors.New("query isn't supported by the in-memory database")
+
+// QueryJSON returns the rows of a query, along with the columns of the rows
+// in the order they were selected. The args are bound to the `?` placeholders
+// of the query. Without any rows, the results are empty rather than nil, so
+// they marshal to `[]`.
+//
+// Only a subset of SELECT is answered: the columns or `*` of a single table,
+// filtered by a WHERE of comparisons (=, !=, <, <=, >, >=, IN, LIKE, IS NULL)
+// combined with AND, OR and NOT, sorted by ORDER BY columns, and cut by a
+// LIMIT. Anything else returns an error wrapping `ErrUnsupportedQuery`.
+// Strings are compared case-sensitively, unlike with MySQL's default
+// collation.
+func (db *DB) QueryJSON(ctx context.Context, query string, args ...interface{}) (logs.JSON, []logs.Column, error) {
+	results := logs.JSON{}
+	columns, err := db.query(ctx, query, args, func(row map[string]interface{}) error {
+		results = append(results, row)
+		return nil
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	return results, columns, nil
+}
+
+// QueryRows runs the query like `QueryJSON`, and calls fn with each row of
+// its results. It stops at the first error returned by fn.
+func (db *DB) QueryRows(ctx context.Context, query string, args []interface{}, fn func(row map[string]interface{}) error) error {
+	_, err := db.query(ctx, query, args, fn)
+	return err
+}
+
+// query runs the query like `QueryRows`, and returns the columns of its rows
+func (db *DB) query(ctx context.Context, query string, args []interface{}, fn func(row map[string]interface{}) error) ([]logs.Column, error) {
+	stmt, err := sqlparser.Parse(query)
+	if err != nil {
+		return nil, errors.Wrapf(err, "parsing query '%s'", query)
+	}
+	sel, ok := stmt.(*sqlparser.Select)
+	if !ok {
+		return nil, errors.Wrapf(ErrUnsupportedQuery, "query '%s' isn't a SELECT", query)
+	}
+
+	// select the rows while holding the lock, and then hand them over
+	// without it, so that fn can't block the other queries and inserts
+	db.mu.RLock()
+	rows, columns, err := db.selectRows(sel, args)
+	db.mu.RUnlock()
+	if err != nil {
+		return nil, errors.Wrapf(err, "running query '%s'", query)
+	}
+	for _, r := range rows {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		if err := fn(r); err != nil {
+			return nil, err
+		}
+	}
+	return columns, nil
+}
+
+// selectRows returns the rows selected by the statement, with the columns of
+// the rows. The rows are copies of those of the table.
+func (db *DB) selectRows(sel *sqlparser.Select, args []interface{}) ([]map[string]interface{}, []logs.Column, error) {
+	if sel.Distinct != "" || len(sel.GroupBy) > 0 || sel.Having != nil {
+		return nil, nil, errors.Wrap(ErrUnsupportedQuery, "DISTINCT, GROUP BY and HAVING aren't supported")
+	}
+	if len(sel.From) != 1 {
+		return nil, nil, errors.Wrap(ErrUnsupportedQuery, "only a single table can be selected from")
+	}
+	from, ok := sel.From[0].(*sqlparser.AliasedTableExpr)
+	if !ok {
+		return nil, nil, errors.Wrap(ErrUnsupportedQuery, "joins aren't supported")
+	}
+	name, ok := from.Expr.(sqlparser.TableName)
+	if !ok {
+		return nil, nil, errors.Wrap(ErrUnsupportedQuery, "subqueries aren't supported")
+	}
+	family := logs.Family(name.Name.String())
+	t, ok := db.tables[family]
+	if !ok {
+		return nil, nil, errors.Wrapf(logs.ErrFamilyNotFound, "table %s doesn't exist", family)
+	}
+	e := evaluator{table: t, args: args}
+
+	// filter the rows
+	var rows []row
+	for _, r := range t.rows {
+		if sel.Where != nil {
+			matched, err := e.match(sel.Where.Expr, r)
+			if err != nil {
+				return nil, nil, err
+			}
+			if !matched {
+				continue
+			}
+		}
+		rows = append(rows, r)
+	}
+
+	if err := e.sort(rows, sel.OrderBy); err != nil {
+		return nil, nil, err
+	}
+	rows, err := e.limit(rows, sel.Limit)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// select the columns of the rows
+	selected, err := e.columns(sel.SelectExprs)
+	if err != nil {
+		return nil, nil, err
+	}
+	results := make([]map[string]interface{}, 0, len(rows))
+	for _, r := range rows {
+		result := make(map[string]interface{}, len(selected))
+		for _, column := range selected {
+			result[column.name] = r[column.field]
+		}
+		results = append(results, result)
+	}
+	columns := make([]logs.Column, 0, len(selected))
+	for _, column := range selected {
+		columns = append(columns, logs.Column{Name: column.name, Type: t.typeName(column.field)})
+	}
+	return results, columns, nil
+}
+
+// selectedColumn is a column of the results of a query
+type selectedColumn struct {
+	name  string // column name, or alias if it was selected with one
+	field string // column of the table
+}
+
+// typeName returns the database type name of a column of the table, ie:
+// TEXT or INT
+func (t *table) typeName(field string) string {
+	if field == idColumn {
+		return "INT"
+	}
+	return strings.ToUpper(mustColumnType(t.schema[field]))
+}
+
+// evaluator evaluates the expressions of a query against the rows of a table
+type evaluator struct {
+	table *table
+	args  []interface{} // args of the `?` placeholders, in order
+}
+
+// columns returns the columns selected by the expressions, expanding `*` to
+// the id followed by the other columns in order
+func (e evaluator) columns(exprs sqlparser.SelectExprs) ([]selectedColumn, error) {
+	var selected []selectedColumn
+	for _, expr := range exprs {
+		switch expr := expr.(type) {
+		case *sqlparser.StarExpr:
+			selected = append(selected, selectedColumn{name: idColumn, field: idColumn})
+			for _, field := range e.table.fields() {
+				selected = append(selected, selectedColumn{name: field, field: field})
+			}
+		case *sqlparser.AliasedExpr:
+			col, ok := expr.Expr.(*sqlparser.ColName)
+			if !ok {
+				return nil, errors.Wrapf(ErrUnsupportedQuery, "only columns can be selected, not %s", sqlparser.String(expr.Expr))
+			}
+			field, err := e.field(col)
+			if err != nil {
+				return nil, err
+			}
+			name := field
+			if !expr.As.IsEmpty() {
+				name = expr.As.String()
+			}
+			selected = append(selected, selectedColumn{name: name, field: field})
+		default:
+			return nil, errors.Wrapf(ErrUnsupportedQuery, "can't select %s", sqlparser.String(expr))
+		}
+	}
+	return selected, nil
+}
+
+// field returns the column of the table of a column name, or an error if the
+// table doesn't have it
+func (e evaluator) field(col *sqlparser.ColName) (string, error) {
+	field := col.Name.String()
+	if _, ok := e.table.schema[field]; !ok && field != idColumn {
+		return "", errors.Errorf("unknown column %s", field)
+	}
+	return field, nil
+}
+
+// match reports whether a row matches a condition
+func (e evaluator) match(expr sqlparser.Expr, r row) (bool, error) {
+	switch expr := expr.(type) {
+	case *sqlparser.AndExpr:
+		left, err := e.match(expr.Left, r)
+		if err != nil || !left {
+			return false, err
+		}
+		return e.match(expr.Right, r)
+	case *sqlparser.OrExpr:
+		left, err := e.match(expr.Left, r)
+		if err != nil || left {
+			return left, err
+		}
+		return e.match(expr.Right, r)
+	case *sqlparser.NotExpr:
+		matched, err := e.match(expr.Expr, r)
+		return !matched, err
+	case *sqlparser.ParenExpr:
+		return e.match(expr.Expr, r)
+	case *sqlparser.IsExpr:
+		value, err := e.value(expr.Expr, r)
+		if err != nil {
+			return false, err
+		}
+		switch expr.Operator {
+		case sqlparser.IsNullStr:
+			return value == nil, nil
+		case sqlparser.IsNotNullStr:
+			return value != nil, nil
+		}
+	case *sqlparser.ComparisonExpr:
+		return e.compare(expr, r)
+	case sqlparser.BoolVal:
+		return bool(expr), nil
+	}
+	return false, errors.Wrapf(ErrUnsupportedQuery, "can't evaluate condition %s", sqlparser.String(expr))
+}
+
+// compare evaluates a comparison against a row. Like in SQL, a comparison
+// with NULL doesn't match.
+func (e evaluator) compare(expr *sqlparser.ComparisonExpr, r row) (bool, error) {
+	left, err := e.value(expr.Left, r)
+	if err != nil {
+		return false, err
+	}
+
+	switch expr.Operator {
+	case sqlparser.InStr, sqlparser.NotInStr:
+		tuple, ok := expr.Right.(sqlparser.ValTuple)
+		if !ok {
+			return false, errors.Wrapf(ErrUnsupportedQuery, "can't evaluate %s", sqlparser.String(expr))
+		}
+		found := false
+		for _, item := range tuple {
+			right, err := e.value(item, r)
+			if err != nil {
+				return false, err
+			}
+			if c, ok := compareValues(left, right); ok && c == 0 {
+				found = true
+			}
+		}
+		if left == nil {
+			return false, nil
+		}
+		return found == (expr.Operator == sqlparser.InStr), nil
+	}
+
+	right, err := e.value(expr.Right, r)
+	if err != nil {
+		return false, err
+	}
+	switch expr.Operator {
+	case sqlparser.LikeStr, sqlparser.NotLikeStr:
+		value, ok := left.(string)
+		pattern, patternOK := right.(string)
+		if !ok || !patternOK {
+			return false, nil
+		}
+		return like(value, pattern) == (expr.Operator == sqlparser.LikeStr), nil
+	}
+
+	c, ok := compareValues(left, right)
+	if !ok {
+		return false, nil
+	}
+	switch expr.Operator {
+	case sqlparser.EqualStr:
+		return c == 0, nil
+	case sqlparser.NotEqualStr:
+		return c != 0, nil
+	case sqlparser.LessThanStr:
+		return c < 0, nil
+	case sqlparser.LessEqualStr:
+		return c <= 0, nil
+	case sqlparser.GreaterThanStr:
+		return c > 0, nil
+	case sqlparser.GreaterEqualStr:
+		return c >= 0, nil
+	}
+	return false, errors.Wrapf(ErrUnsupportedQuery, "can't evaluate operator %s", expr.Operator)
+}
+
+// value evaluates an expression against a row: a column, a literal or a
+// placeholder
+func (e evaluator) value(expr sqlparser.Expr, r row) (interface{}, error) {
+	switch expr := expr.(type) {
+	case *sqlparser.ColName:
+		field, err := e.field(expr)
+		if err != nil {
+			return nil, err
+		}
+		return r[field], nil
+	case *sqlparser.SQLVal:
+		switch expr.Type {
+		case sqlparser.StrVal:
+			return string(expr.Val), nil
+		case sqlparser.IntVal:
+			return strconv.ParseInt(string(expr.Val), 10, 64)
+		case sqlparser.FloatVal:
+			return strconv.ParseFloat(string(expr.Val), 64)
+		case sqlparser.ValArg:
+			return e.arg(string(expr.Val))
+		}
+	case *sqlparser.NullVal:
+		return nil, nil
+	case sqlparser.BoolVal:
+		return bool(expr), nil
+	case *sqlparser.ParenExpr:
+		return e.value(expr.Expr, r)
+	}
+	return nil, errors.Wrapf(ErrUnsupportedQuery, "can't evaluate %s", sqlparser.String(expr))
+}
+
+// arg returns the arg of a placeholder, which the parser names `:v1`, `:v2`,
+// etc. in the order of the `?` of the query
+func (e evaluator) arg(name string) (interface{}, error) {
+	n, err := strconv.Atoi(strings.TrimPrefix(name, ":v"))
+	if err != nil || n < 1 || n > len(e.args) {
+		return nil, errors.Wrapf(logs.ErrQueryArgs, "no arg for placeholder %s", name)
+	}
+	return e.args[n-1], nil
+}
+
+// sort sorts the rows by the ORDER BY of a query. Like in MySQL, NULL sorts
+// before any value.
+func (e evaluator) sort(rows []row, orderBy sqlparser.OrderBy) error {
+	if len(orderBy) == 0 {
+		return nil
+	}
+	fields := make([]string, 0, len(orderBy))
+	for _, order := range orderBy {
+		col, ok := order.Expr.(*sqlparser.ColName)
+		if !ok {
+			return errors.Wrapf(ErrUnsupportedQuery, "can only order by columns, not %s", sqlparser.String(order.Expr))
+		}
+		field, err := e.field(col)
+		if err != nil {
+			return err
+		}
+		fields = append(fields, field)
+	}
+	sort.SliceStable(rows, func(i, j int) bool {
+		for k, field := range fields {
+			c := compareNullable(rows[i][field], rows[j][field])
+			if c == 0 {
+				continue
+			}
+			if orderBy[k].Direction == sqlparser.DescScr {
+				return c > 0
+			}
+			return c < 0
+		}
+		return false
+	})
+	return nil
+}
+
+// limit returns the rows within the LIMIT of a query
+func (e evaluator) limit(rows []row, limit *sqlparser.Limit) ([]row, error) {
+	if limit == nil {
+		return rows, nil
+	}
+	if limit.Offset != nil {
+		offset, err := e.count(limit.Offset)
+		if err != nil {
+			return nil, err
+		}
+		if offset >= len(rows) {
+			return nil, nil
+		}
+		rows = rows[offset:]
+	}
+	if limit.Rowcount != nil {
+		rowcount, err := e.count(limit.Rowcount)
+		if err != nil {
+			return nil, err
+		}
+		if rowcount < len(rows) {
+			rows = rows[:rowcount]
+		}
+	}
+	return rows, nil
+}
+
+// count evaluates the offset or row count of a LIMIT
+func (e evaluator) count(expr sqlparser.Expr) (int, error) {
+	value, err := e.value(expr, nil)
+	if err != nil {
+		return 0, err
+	}
+	n, ok := number(value)
+	if !ok || n < 0 {
+		return 0, errors.Errorf("invalid limit %s", sqlparser.String(expr))
+	}
+	return int(n), nil
+}
+
+// compareValues compares two values, returning whether they're comparable.
+// Strings are compared as strings, and a string compared with a number is
+// converted to a number, like in MySQL. NULL isn't comparable.
+func compareValues(a, b interface{}) (int, bool) {
+	if a == nil || b == nil {
+		return 0, false
+	}
+	if s, ok := a.(string); ok {
+		if t, ok := b.(string); ok {
+			return strings.Compare(s, t), true
+		}
+	}
+	x, ok := number(a)
+	if !ok {
+		return 0, false
+	}
+	y, ok := number(b)
+	if !ok {
+		return 0, false
+	}
+	switch {
+	case x < y:
+		return -1, true
+	case x > y:
+		return 1, true
+	}
+	return 0, true
+}
+
+// compareNullable compares two values for sorting, where NULL is before any
+// value
+func compareNullable(a, b interface{}) int {
+	switch {
+	case a == nil && b == nil:
+		return 0
+	case a == nil:
+		return -1
+	case b == nil:
+		return 1
+	}
+	c, _ := compareValues(a, b)
+	return c
+}
+
+// number converts a value to a float, if it's a number, a numeric string or
+// a bool
+func number(value interface{}) (float64, bool) {
+	switch v := value.(type) {
+	case int64:
+		return float64(v), true
+	case int:
+		return float64(v), true
+	case float64:
+		return v, true
+	case bool:
+		if v {
+			return 1, true
+		}
+		return 0, true
+	case string:
+		f, err := strconv.ParseFloat(strings.TrimSpace(v), 64)
+		return f, err == nil
+	}
+	return 0, false
+}
+
+// like reports whether a value matches a LIKE pattern, where `%` matches any
+// characters and `_` a single character
+func like(value, pattern string) bool {
+	var expr strings.Builder
+	expr.WriteString("^")
+	for _, r := range pattern {
+		switch r {
+		case '%':
+			expr.WriteString(".*")
+		case '_':
+			expr.WriteString(".")
+		default:
+			expr.WriteString(regexp.QuoteMeta(string(r)))
+		}
+	}
+	expr.WriteString("$")
+	return regexp.MustCompile("(?s)" + expr.String()).MatchString(value)
+}
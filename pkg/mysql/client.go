@@ -1,10 +1,17 @@
 package mysql
 
 import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
 	"fmt"
-	"log"
+	"io/ioutil"
+	"log/slog"
+	"net/url"
+	"strings"
+	"time"
 
-	_ "github.com/go-sql-driver/mysql" //mysql driver
+	gomysql "github.com/go-sql-driver/mysql" //mysql driver
 	"github.com/jmoiron/sqlx"
 	"github.com/kolide/databalancer-logan/pkg/logs"
 	"github.com/pkg/errors"
@@ -12,88 +19,801 @@ import (
 
 // Client is a connection to a MySQL database
 type Client struct {
-	*sqlx.DB // underlying database
+	*sqlx.DB                      // underlying database
+	replica         *sqlx.DB      // read replica queries are sent to, if set
+	database        string        // name of the database connected to
+	sharedTable     string        // table shared by all families, if set
+	tablePrefix     string        // prefix of the table of each family, if set
+	schemaTable     string        // table the schema of each table is recorded in, if set
+	schemas         schemaTable   // whether the schema table was created
+	batchSize       int           // maximum number of records per insert statement
+	maxPlaceholders int           // maximum number of placeholders per insert statement
+	locks           *familyLocks  // drains the inserts into tables being migrated
+	logger          *slog.Logger  // logger of the client
+	pool            *ConnPool     // settings of the connection pool, if set
+	connectTimeout  time.Duration // maximum time to wait for MySQL to answer on connect
+	stmtCacheSize   int           // maximum number of prepared statements kept
+	stmts           *stmtCache    // prepared statements of repeated queries and inserts, if enabled
+	readStmts       *stmtCache    // prepared statements of the reader, which are those of the primary without a replica
+}
+
+// Option configures optional behavior of a `Client`
+type Option func(*Client)
+
+// WithDatabase sets the name of the database the client is connected to,
+// which scopes the tables it reads from information_schema, ie: the tables
+// described by `DescribeDatabase`. Without it, the default database of the
+// connection is read.
+func WithDatabase(name string) Option {
+	return func(c *Client) {
+		c.database = name
+	}
+}
+
+// WithLogger sets the logger of the client, which is `slog.Default()` by
+// default
+func WithLogger(logger *slog.Logger) Option {
+	return func(c *Client) {
+		c.logger = logger
+	}
+}
+
+// WithSharedTable stores the logs of all families in a single table with the
+// given name, rather than in a table per family. See `SharedTable`.
+func WithSharedTable(name string) Option {
+	return func(c *Client) {
+		c.sharedTable = name
+	}
+}
+
+// ConnPool configures the pool of connections of the client to MySQL
+type ConnPool struct {
+	MaxOpen     int           // maximum number of open connections, 0 for no limit
+	MaxIdle     int           // maximum number of idle connections kept open
+	MaxLifetime time.Duration // maximum time a connection is reused, 0 for no limit
+}
+
+// DefaultConnPool are the settings of the connection pool of the clients
+// made by `CreateClient`, unless configured otherwise with `WithConnPool`.
+// MySQL closes connections idle for longer than its `wait_timeout` (8 hours
+// by default), and a connection it closed only fails once it's used, so
+// `MaxLifetime` should stay well under the `wait_timeout` of the server.
+var DefaultConnPool = ConnPool{
+	MaxOpen:     25,
+	MaxIdle:     25,
+	MaxLifetime: 5 * time.Minute,
+}
+
+// WithConnPool sets the settings of the connection pool of the client, so
+// that it neither opens an unbounded number of connections under load nor
+// reuses connections closed by MySQL
+func WithConnPool(pool ConnPool) Option {
+	return func(c *Client) {
+		c.pool = &pool
+	}
+}
+
+// WithBatchSize sets the maximum number of records inserted per statement
+func WithBatchSize(n int) Option {
+	return func(c *Client) {
+		c.batchSize = n
+	}
+}
+
+// WithMaxPlaceholders sets the maximum number of placeholders per insert
+// statement, which lowers the number of records per statement of wide tables
+func WithMaxPlaceholders(n int) Option {
+	return func(c *Client) {
+		c.maxPlaceholders = n
+	}
 }
 
 // Table defines methods for inserting and querying logs for that table
 type Table struct {
-	*sqlx.DB                   // database for table
-	Name     string            // table name
-	Schema   map[string]string // schema of the table from request
-}
-
-// CreateClient makes a new MySQL database client and ensures that it's connected
-func CreateClient(username, password, address, name string) (*Client, error) {
-	connectionString := fmt.Sprintf(
-		"%s:%s@(%s)/%s?charset=utf8&parseTime=True&loc=Local",
-		username,
-		password,
-		address,
-		name,
-	)
+	*sqlx.DB                          // database for table
+	Name            string            // table name
+	Schema          map[string]string // schema of the table from request
+	BatchSize       int               // maximum number of records per insert statement, defaults to DefaultBatchSize
+	MaxPlaceholders int               // maximum number of placeholders per insert statement, defaults to DefaultMaxPlaceholders
+	Unique          []string          // fields of the unique key that logs are upserted on, if set
+	locks           *familyLocks      // drains the inserts while the table is migrated, if set
+	stmts           *stmtCache        // prepared insert statements, if set
+	client          *Client           // reconnects after a broken connection, if set
+}
+
+// DefaultBatchSize is the maximum number of records inserted per statement,
+// unless configured otherwise with `WithBatchSize`. Large inserts are split
+// into batches so that statements stay under MySQL's `max_allowed_packet`
+// and the limit on the number of bindvars.
+const DefaultBatchSize = 1000
+
+// DefaultMaxPlaceholders is the maximum number of placeholders per insert
+// statement, unless configured otherwise with `WithMaxPlaceholders`. MySQL
+// rejects statements with more than 65535 placeholders, so the records of a
+// wide table are inserted in smaller batches than those of a narrow one.
+const DefaultMaxPlaceholders = 60000
+
+// Config describes how a client connects to MySQL, for `NewClient`
+type Config struct {
+	Username string            // user account to connect as
+	Password string            // password of the user account
+	Address  string            // address of the server, ie: `localhost:3306`
+	Database string            // name of the database to use
+	Params   map[string]string // params of the connection, which override the defaults, ie: `{"timeout": "5s"}`
+
+	// TLS is whether the connection uses TLS: `true` to verify the
+	// certificate of the server, `skip-verify` to trust any certificate, or
+	// empty (or `false`) for a connection without TLS
+	TLS string
+	// TLSCAFile is the PEM file of the CA certificates trusted to sign the
+	// certificate of the server, ie: the CA bundle of RDS or Cloud SQL. It
+	// enables TLS, whatever `TLS` is set to.
+	TLSCAFile string
+
+	// ReplicaAddress is the address of a read replica of the server, which
+	// is connected to with the rest of the config. See `WithReadReplica`.
+	ReplicaAddress string
+}
+
+// tlsConfigName is the name of the TLS config registered with the MySQL
+// driver for the CA file of a config
+const tlsConfigName = "databalancer"
+
+// tlsParam returns the value of the `tls` param of the connection, or an
+// empty string for a connection without TLS
+func (cfg Config) tlsParam() string {
+	if cfg.TLSCAFile != "" {
+		return tlsConfigName
+	}
+	return cfg.TLS
+}
+
+// registerTLSConfig registers the TLS config trusting the CA certificates of
+// the config with the MySQL driver, if the config has a CA file
+func (cfg Config) registerTLSConfig() error {
+	switch cfg.TLS {
+	case "", "true", "false", "skip-verify":
+	default:
+		return errors.Errorf("unknown TLS mode %q, expected true, skip-verify or false", cfg.TLS)
+	}
+	if cfg.TLSCAFile == "" {
+		return nil
+	}
+
+	pem, err := ioutil.ReadFile(cfg.TLSCAFile)
+	if err != nil {
+		return errors.Wrap(err, "reading CA file")
+	}
+	roots := x509.NewCertPool()
+	if !roots.AppendCertsFromPEM(pem) {
+		return errors.Errorf("no PEM certificates in CA file %s", cfg.TLSCAFile)
+	}
+	// the driver verifies the certificate against the host of the address
+	return gomysql.RegisterTLSConfig(tlsConfigName, &tls.Config{RootCAs: roots})
+}
+
+// defaultParams are the params of every connection, unless overridden by
+// the params of the config
+var defaultParams = map[string]string{
+	"charset":   "utf8",
+	"parseTime": "True",
+	"loc":       "Local",
+}
+
+// DSN returns the data source name of the config, as expected by the MySQL
+// driver
+func (cfg Config) DSN() string {
+	params := url.Values{}
+	for name, value := range defaultParams {
+		params.Set(name, value)
+	}
+	if tlsParam := cfg.tlsParam(); tlsParam != "" {
+		params.Set("tls", tlsParam)
+	}
+	for name, value := range cfg.Params {
+		params.Set(name, value)
+	}
+	return fmt.Sprintf("%s:%s@(%s)/%s?%s", cfg.Username, cfg.Password, cfg.Address, cfg.Database, params.Encode())
+}
+
+// NewClient makes a new MySQL database client with the config, and ensures
+// that it's connected
+func NewClient(cfg Config, opts ...Option) (*Client, error) {
+	if err := cfg.registerTLSConfig(); err != nil {
+		return nil, errors.Wrap(err, "configuring TLS")
+	}
+
 	// Using our connection string, we attempt to open a MySQL connection
-	db, err := sqlx.Open("mysql", connectionString)
+	db, err := sqlx.Open("mysql", cfg.DSN())
 	if err != nil {
 		return nil, errors.Wrap(err, "opening database")
 	}
 
+	defaults := []Option{
+		WithDatabase(cfg.Database),
+		WithConnPool(DefaultConnPool),
+		WithConnectTimeout(DefaultConnectTimeout),
+	}
+	if cfg.ReplicaAddress != "" {
+		replicaCfg := cfg
+		replicaCfg.Address = cfg.ReplicaAddress
+		replica, err := sqlx.Open("mysql", replicaCfg.DSN())
+		if err != nil {
+			db.Close()
+			return nil, errors.Wrap(err, "opening read replica")
+		}
+		defaults = append(defaults, WithReadReplica(replica))
+	}
+	c := ClientFromDB(db, append(defaults, opts...)...)
+
 	// Now, we ensure that can communicate with the database
-	if err = db.Ping(); err != nil {
-		return nil, errors.Wrap(err, "pinging database")
+	if err := c.Connect(context.Background()); err != nil {
+		c.Close()
+		return nil, err
+	}
+
+	c.logger.Info("connected to MySQL", "username", cfg.Username, "address", cfg.Address, "replica", cfg.ReplicaAddress, "tls", cfg.tlsParam())
+	return c, nil
+}
+
+// CreateClient makes a new MySQL database client and ensures that it's
+// connected. See `NewClient` to configure the connection further.
+func CreateClient(username, password, address, name string, opts ...Option) (*Client, error) {
+	return NewClient(Config{
+		Username: username,
+		Password: password,
+		Address:  address,
+		Database: name,
+	}, opts...)
+}
+
+// ClientFromDB makes a new MySQL database client from an open database. The
+// connection pool of the database is left as is, unless configured with
+// `WithConnPool`.
+func ClientFromDB(db *sqlx.DB, opts ...Option) *Client {
+	c := &Client{DB: db, locks: newFamilyLocks(), logger: slog.Default(), stmtCacheSize: DefaultStatementCacheSize}
+	for _, opt := range opts {
+		opt(c)
 	}
+	c.stmts = newStmtCache(c.stmtCacheSize)
+	c.readStmts = c.stmts
+	if c.replica != nil {
+		// a statement is prepared on the database it's run on
+		c.readStmts = newStmtCache(c.stmtCacheSize)
+	}
+	if c.pool != nil {
+		for _, db := range []*sqlx.DB{db, c.replica} {
+			if db == nil {
+				continue
+			}
+			db.SetMaxOpenConns(c.pool.MaxOpen)
+			db.SetMaxIdleConns(c.pool.MaxIdle)
+			db.SetConnMaxLifetime(c.pool.MaxLifetime)
+		}
+	}
+	return c
+}
 
-	log.Printf("Connected to MySQL as %s at %s\n", username, address)
-	return &Client{DB: db}, nil
+// Close closes the prepared statements of the client, and then the database
+// and its read replica
+func (c *Client) Close() error {
+	stmtErr := c.stmts.close()
+	if c.replica != nil {
+		if err := c.readStmts.close(); err != nil && stmtErr == nil {
+			stmtErr = err
+		}
+		if err := c.replica.Close(); err != nil {
+			return errors.Wrap(err, "closing read replica")
+		}
+	}
+	if err := c.DB.Close(); err != nil {
+		return errors.Wrap(err, "closing database")
+	}
+	return errors.Wrap(stmtErr, "closing prepared statements")
 }
 
 // CreateTable creates the the table (if it doesn't exist) based on the given
-// attributes with the client and creates an Insert method.
-func (c *Client) CreateTable(name logs.Family, schema logs.Schema) (logs.Table, error) {
+// attributes with the client and creates an Insert method. With unique
+// fields, the table gets a unique key on them and logs are upserted on it.
+func (c *Client) CreateTable(ctx context.Context, name logs.Family, schema logs.Schema, unique []string) (logs.Table, error) {
+	table, err := c.createTable(ctx, name, schema, unique)
+	return table, c.reconnect(ctx, err)
+}
+
+// createTable creates the table of a family like `CreateTable`
+func (c *Client) createTable(ctx context.Context, name logs.Family, schema logs.Schema, unique []string) (logs.Table, error) {
+	// make sure the names can be used as is, rather than being altered
+	table := c.tableName(name)
+	if err := CheckIdentifier(table); err != nil {
+		return nil, errors.Wrap(err, "checking table name")
+	}
+	if c.isSchemaTable(table) {
+		return nil, errors.Wrapf(logs.ErrInvalidFamily, "table %s records the schemas of the families", table)
+	}
+	for fieldName := range schema {
+		if err := CheckIdentifier(fieldName); err != nil {
+			return nil, errors.Wrapf(err, "checking column name of %s table", name)
+		}
+	}
+
+	if err := CheckUniqueKey(schema, unique); err != nil {
+		return nil, errors.Wrapf(err, "checking unique key of %s table", name)
+	}
+
+	if c.sharedTable != "" {
+		if len(unique) > 0 {
+			return nil, errors.Errorf("unique keys aren't supported in shared table %s", c.sharedTable)
+		}
+		if _, err := c.ExecContext(ctx, CreateSharedTableStatement(c.sharedTable)); err != nil {
+			return nil, errors.Wrapf(err, "creating shared table %s", c.sharedTable)
+		}
+		return &SharedTable{DB: c.DB, Name: c.sharedTable, Family: name, BatchSize: c.batchSize, MaxPlaceholders: c.maxPlaceholders, stmts: c.stmts, client: c}, nil
+	}
+
 	// construct create table statement
-	create := CreateTableStatement(name.String(), schema)
+	create := CreateTableStatement(table, schema, unique)
 
 	// create the table
-	_, err := c.Exec(create)
+	_, err := c.ExecContext(ctx, create)
 	if err != nil {
 		return nil, errors.Wrapf(err, "creating %s table", name)
 	}
 
-	return &Table{DB: c.DB, Name: name.String(), Schema: schema}, nil
+	// a table last created with the same schema already has its columns
+	var hash string
+	if c.schemaTable != "" {
+		hash = SchemaHash(schema, unique)
+		recorded, err := c.recordedSchemaHash(ctx, table)
+		if err != nil {
+			return nil, errors.Wrapf(err, "finding schema of %s table", name)
+		}
+		if recorded == hash {
+			return c.newTable(table, schema, unique), nil
+		}
+	}
+
+	// the table may have existed with fewer columns, so add any new fields
+	columns, err := c.tableColumns(ctx, table)
+	if err != nil {
+		return nil, errors.Wrapf(err, "finding columns of %s table", name)
+	}
+	alter, err := AlterTableStatement(table, columns, schema)
+	if err != nil {
+		return nil, errors.Wrapf(err, "altering %s table", name)
+	}
+	if alter != "" {
+		if err := c.migrate(ctx, table, alter); err != nil {
+			return nil, errors.Wrapf(err, "altering %s table", name)
+		}
+	}
+
+	// the table may also have existed without the unique key
+	if len(unique) > 0 {
+		if err := c.ensureUniqueKey(ctx, table, unique); err != nil {
+			return nil, errors.Wrapf(err, "adding unique key to %s table", name)
+		}
+	}
+
+	if c.schemaTable != "" {
+		if err := c.recordSchema(ctx, table, schema, hash); err != nil {
+			return nil, err
+		}
+	}
+	return c.newTable(table, schema, unique), nil
+}
+
+// newTable returns the table with the name, which inserts logs of the schema
+func (c *Client) newTable(name string, schema logs.Schema, unique []string) *Table {
+	return &Table{
+		DB:              c.DB,
+		Name:            name,
+		Schema:          schema,
+		BatchSize:       c.batchSize,
+		MaxPlaceholders: c.maxPlaceholders,
+		Unique:          unique,
+		locks:           c.locks,
+		stmts:           c.stmts,
+		client:          c,
+	}
+}
+
+// CreateTableDDL returns the statement `CreateTable` runs to create the table
+// of a family, or the shared table if it's set, without running it
+func (c *Client) CreateTableDDL(name logs.Family, schema logs.Schema, unique []string) (string, error) {
+	if err := CheckIdentifier(c.tableName(name)); err != nil {
+		return "", errors.Wrap(err, "checking table name")
+	}
+	for fieldName := range schema {
+		if err := CheckIdentifier(fieldName); err != nil {
+			return "", errors.Wrapf(err, "checking column name of %s table", name)
+		}
+	}
+	if c.sharedTable != "" {
+		return CreateSharedTableStatement(c.sharedTable), nil
+	}
+	return CreateTableStatement(c.tableName(name), schema, unique), nil
+}
+
+// ensureUniqueKey adds the unique key on the fields to a table that doesn't
+// have one, and returns an error if the table has a unique key on other fields
+func (c *Client) ensureUniqueKey(ctx context.Context, name string, unique []string) error {
+	var existing []string
+	filter, args := c.schemaFilter()
+	err := c.SelectContext(ctx, &existing,
+		"SELECT `COLUMN_NAME` FROM information_schema.statistics "+
+			"WHERE "+filter+"AND `TABLE_NAME` = ? AND `INDEX_NAME` = ? "+
+			"ORDER BY `SEQ_IN_INDEX` ASC",
+		append(args, name, uniqueKeyName)...)
+	if err != nil {
+		return errors.Wrapf(err, "describing unique key of table %s", name)
+	}
+	if len(existing) == 0 {
+		return c.migrate(ctx, name, AddUniqueKeyStatement(name, unique))
+	}
+	if strings.Join(existing, ",") != strings.Join(unique, ",") {
+		return errors.Errorf("unique fields %s conflict with existing unique key on %s",
+			strings.Join(unique, ", "), strings.Join(existing, ", "))
+	}
+	return nil
+}
+
+// TableSchema returns the schema of the table of a family, read from the
+// types of its columns, or nil if the family doesn't have a table. The
+// generated `id` column is left out, as are columns of types that logs can't
+// be ingested with. The logs of a shared table don't have columns of their
+// own, so there's no schema to read.
+func (c *Client) TableSchema(ctx context.Context, name logs.Family) (logs.Schema, error) {
+	if err := CheckIdentifier(c.tableName(name)); err != nil || c.sharedTable != "" {
+		return nil, nil
+	}
+
+	var columnDescriptions []struct {
+		Column     string // column name
+		ColumnType string // column type, with its length or precision
+		Comment    string // column comment, which tells `any` fields apart
+		Nullable   string // YES/NO if column nullable
+	}
+	filter, args := c.schemaFilter()
+	err := c.SelectContext(ctx, &columnDescriptions,
+		"SELECT `COLUMN_NAME` as `column`, "+
+			"`COLUMN_TYPE` as `columntype`, "+
+			"`COLUMN_COMMENT` as `comment`, "+
+			"`IS_NULLABLE` as `nullable` "+
+			"FROM information_schema.columns "+
+			"WHERE "+filter+"AND `TABLE_NAME` = ?",
+		append(args, c.tableName(name))...)
+	if err != nil {
+		return nil, errors.Wrapf(err, "describing table %s", name)
+	}
+	if len(columnDescriptions) == 0 {
+		return nil, nil
+	}
+
+	schema := logs.Schema{}
+	for _, columnDescription := range columnDescriptions {
+		if columnDescription.Column == "id" {
+			continue
+		}
+		if fieldType, ok := FieldTypeOf(columnDescription.ColumnType, columnDescription.Comment, columnDescription.Nullable == "YES"); ok {
+			schema[columnDescription.Column] = fieldType
+		}
+	}
+	return schema, nil
 }
 
-// Insert creates new logs in the supplied table
-func (t *Table) Insert(logs logs.JSON) error {
-	// construct insert statement
-	insert, args := InsertTableStatement(t.Name, t.Schema, logs)
+// DropTable drops the table of a family, returning `logs.ErrFamilyNotFound`
+// if it doesn't exist
+func (c *Client) DropTable(ctx context.Context, name logs.Family) error {
+	table := c.tableName(name)
+	if err := CheckIdentifier(table); err != nil || c.isSchemaTable(table) {
+		return logs.ErrFamilyNotFound
+	}
+	if c.sharedTable != "" {
+		return c.dropSharedFamily(ctx, name)
+	}
 
-	// insert the data
-	_, err := t.Exec(insert, args...)
+	columns, err := c.tableColumns(ctx, table)
 	if err != nil {
-		return errors.Wrapf(err, "inserting records for %s table", t.Name)
+		return errors.Wrapf(err, "finding %s table", name)
+	}
+	if len(columns) == 0 {
+		return logs.ErrFamilyNotFound
+	}
+
+	if err := c.migrate(ctx, table, DropTableStatement(table)); err != nil {
+		return errors.Wrapf(err, "dropping %s table", name)
+	}
+	if c.schemaTable != "" {
+		return c.forgetSchema(ctx, table)
 	}
 	return nil
 }
 
-// QueryJSON returns rows as a representation that can be marshalled to JSON
-func (c *Client) QueryJSON(query string) (logs.JSON, error) {
+// TruncateTable deletes the rows of the table of a family, keeping the table,
+// returning `logs.ErrFamilyNotFound` if it doesn't exist. With a shared
+// table, the logs of the family are deleted from it, which leaves no trace
+// of the family, like `DropTable`.
+func (c *Client) TruncateTable(ctx context.Context, name logs.Family) error {
+	table := c.tableName(name)
+	if err := CheckIdentifier(table); err != nil || c.isSchemaTable(table) {
+		return logs.ErrFamilyNotFound
+	}
+	if c.sharedTable != "" {
+		return c.dropSharedFamily(ctx, name)
+	}
+
+	columns, err := c.tableColumns(ctx, table)
+	if err != nil {
+		return errors.Wrapf(err, "finding %s table", name)
+	}
+	if len(columns) == 0 {
+		return logs.ErrFamilyNotFound
+	}
+
+	if err := c.migrate(ctx, table, TruncateTableStatement(table)); err != nil {
+		return errors.Wrapf(err, "truncating %s table", name)
+	}
+	return nil
+}
+
+// CountRows returns the number of rows of the table of a family, returning
+// `logs.ErrFamilyNotFound` if it doesn't exist
+func (c *Client) CountRows(ctx context.Context, name logs.Family) (int64, error) {
+	table := c.tableName(name)
+	if err := CheckIdentifier(table); err != nil {
+		return 0, logs.ErrFamilyNotFound
+	}
+	if c.sharedTable != "" {
+		return c.countSharedFamily(ctx, name)
+	}
+
+	columns, err := c.tableColumns(ctx, table)
+	if err != nil {
+		return 0, errors.Wrapf(err, "finding %s table", name)
+	}
+	if len(columns) == 0 {
+		return 0, logs.ErrFamilyNotFound
+	}
+
+	var count int64
+	if err := c.GetContext(ctx, &count, CountStatement(table)); err != nil {
+		return 0, errors.Wrapf(err, "counting %s table", name)
+	}
+	return count, nil
+}
+
+// migrate executes a statement migrating a table, once the inserts into the
+// table in progress are done. Inserts into the table wait for the statement
+// to finish, while inserts into other tables proceed.
+func (c *Client) migrate(ctx context.Context, name string, stmt string) error {
+	unlock := c.locks.migrating(name)
+	defer unlock()
+	_, err := c.ExecContext(ctx, stmt)
+	return err
+}
+
+// schemaFilter returns the condition on `TABLE_SCHEMA` of information_schema
+// that matches the database of the client, or the default database of the
+// connection if the client wasn't given one
+func (c *Client) schemaFilter() (string, []interface{}) {
+	if c.database != "" {
+		return "`TABLE_SCHEMA` = ? ", []interface{}{c.database}
+	}
+	return "`TABLE_SCHEMA` = DATABASE() ", nil
+}
+
+// tableColumns returns the existing columns of a table, mapped to their type
+func (c *Client) tableColumns(ctx context.Context, name string) (map[string]string, error) {
+	var columnDescriptions []struct {
+		Column   string // column name
+		Datatype string // column data type
+	}
+	filter, args := c.schemaFilter()
+	err := c.SelectContext(ctx, &columnDescriptions,
+		"SELECT `COLUMN_NAME` as `column`, "+
+			"`DATA_TYPE` as `datatype` "+
+			"FROM information_schema.columns "+
+			"WHERE "+filter+"AND `TABLE_NAME` = ?",
+		append(args, name)...)
+	if err != nil {
+		return nil, errors.Wrapf(err, "describing table %s", name)
+	}
+
+	columns := make(map[string]string)
+	for _, columnDescription := range columnDescriptions {
+		columns[columnDescription.Column] = columnDescription.Datatype
+	}
+	return columns, nil
+}
+
+// Insert creates new logs in the supplied table, in batches of at most
+// `BatchSize` records and `MaxPlaceholders` placeholders per statement. The
+// batches are inserted in a single transaction, so either all of the logs are
+// inserted or none are. It returns the number of rows affected, where MySQL
+// counts a log updating the row of its unique key as two rows.
+func (t *Table) Insert(ctx context.Context, logs logs.JSON) (int64, error) {
+	unlock := t.locks.writing(t.Name)
+	defer unlock()
+	batches := batch(logs, batchSize(len(t.Schema), t.BatchSize, t.MaxPlaceholders))
+	var inserted int64
+	err := inTransaction(ctx, t.DB, func(tx *sqlx.Tx) error {
+		for i, records := range batches {
+			// construct insert statement
+			insert, args := t.insertStatement(records)
+
+			// insert the data
+			result, err := t.stmts.exec(ctx, t.DB, tx, insert, args...)
+			if err != nil {
+				return errors.Wrapf(err, "inserting batch %d of %d for %s table", i+1, len(batches), t.Name)
+			}
+			rows, err := result.RowsAffected()
+			if err != nil {
+				return errors.Wrapf(err, "counting rows of batch %d of %d for %s table", i+1, len(batches), t.Name)
+			}
+			inserted += rows
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, t.client.reconnect(ctx, err)
+	}
+	return inserted, nil
+}
+
+// insertStatement builds the statement inserting the records into the table,
+// or upserting them if the table has a unique key
+func (t *Table) insertStatement(records []map[string]interface{}) (string, []interface{}) {
+	if len(t.Unique) > 0 {
+		return UpsertTableStatement(t.Name, t.Schema, t.Unique, records)
+	}
+	return InsertTableStatement(t.Name, t.Schema, records)
+}
+
+// inTransaction runs fn in a transaction, which is committed if fn succeeds
+// and rolled back otherwise. Note that statements which cause an implicit
+// commit in MySQL, like CREATE TABLE, can't be rolled back.
+func inTransaction(ctx context.Context, db *sqlx.DB, fn func(tx *sqlx.Tx) error) error {
+	tx, err := db.BeginTxx(ctx, nil)
+	if err != nil {
+		return errors.Wrap(err, "beginning transaction")
+	}
+	if err := fn(tx); err != nil {
+		// the error of fn is more useful than a failed rollback
+		tx.Rollback()
+		return err
+	}
+	return errors.Wrap(tx.Commit(), "committing transaction")
+}
+
+// batchSize returns the number of records per insert statement with a
+// placeholder for each of the columns, which is at most size records (or
+// DefaultBatchSize if size isn't positive) and keeps the placeholders of the
+// statement under maxPlaceholders (or DefaultMaxPlaceholders if it isn't
+// positive). At least one record is inserted per statement.
+func batchSize(columns, size, maxPlaceholders int) int {
+	if size <= 0 {
+		size = DefaultBatchSize
+	}
+	if maxPlaceholders <= 0 {
+		maxPlaceholders = DefaultMaxPlaceholders
+	}
+	if columns > 0 && size*columns > maxPlaceholders {
+		size = maxPlaceholders / columns
+	}
+	if size < 1 {
+		size = 1
+	}
+	return size
+}
+
+// batch splits the records into batches of at most size records, or of
+// DefaultBatchSize records if size isn't positive
+func batch(records []map[string]interface{}, size int) [][]map[string]interface{} {
+	if size <= 0 {
+		size = DefaultBatchSize
+	}
+	var batches [][]map[string]interface{}
+	for len(records) > size {
+		batches = append(batches, records[:size])
+		records = records[size:]
+	}
+	if len(records) > 0 {
+		batches = append(batches, records)
+	}
+	return batches
+}
+
+// InsertReturningIDs creates new logs in the supplied table one at a time,
+// and returns the generated ids of the logs in the order they were given.
+// The logs are inserted in a single transaction.
+func (t *Table) InsertReturningIDs(ctx context.Context, logs logs.JSON) ([]int64, error) {
+	unlock := t.locks.writing(t.Name)
+	defer unlock()
+	ids := make([]int64, 0, len(logs))
+	err := inTransaction(ctx, t.DB, func(tx *sqlx.Tx) error {
+		for _, record := range logs {
+			// construct a single row insert statement, since mysql only returns
+			// the id of the first row of a multi-row insert
+			insert, args := t.insertStatement([]map[string]interface{}{record})
+
+			// insert the record
+			result, err := t.stmts.exec(ctx, t.DB, tx, insert, args...)
+			if err != nil {
+				return errors.Wrapf(err, "inserting record for %s table", t.Name)
+			}
+			id, err := result.LastInsertId()
+			if err != nil {
+				return errors.Wrapf(err, "retrieving id of record for %s table", t.Name)
+			}
+			ids = append(ids, id)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, t.client.reconnect(ctx, err)
+	}
+	return ids, nil
+}
+
+// QueryJSON returns rows as a representation that can be marshalled to JSON,
+// along with the columns of the rows in the order they were selected.
+// The args are bound to the `?` placeholders of the query. Without any rows,
+// the results are empty rather than nil, so they marshal to `[]`.
+func (c *Client) QueryJSON(ctx context.Context, query string, args ...interface{}) (logs.JSON, []logs.Column, error) {
+	results := logs.JSON{}
+	columns, err := c.queryRows(ctx, query, args, func(row map[string]interface{}) error {
+		results = append(results, row)
+		return nil
+	})
+	if err != nil {
+		return nil, nil, c.reconnect(ctx, err)
+	}
+	return results, columns, nil
+}
+
+// QueryRows runs the query and calls fn with each row as it's scanned from
+// the cursor, so that the rows don't have to be held in memory at once. It
+// stops at the first error returned by fn.
+func (c *Client) QueryRows(ctx context.Context, query string, args []interface{}, fn func(row map[string]interface{}) error) error {
+	_, err := c.queryRows(ctx, query, args, fn)
+	return c.reconnect(ctx, err)
+}
+
+// queryRows runs the query like `QueryRows`, and returns the columns of its
+// rows. Note that with a shared table, the fields of the logs are returned in
+// the `log` column rather than as columns of their own.
+func (c *Client) queryRows(ctx context.Context, query string, args []interface{}, fn func(row map[string]interface{}) error) ([]logs.Column, error) {
+	query, args, err := c.rewriteQuery(query, args)
+	if err != nil {
+		return nil, err
+	}
+
 	// make the query. we use a prepared statement here because mysql
 	// only returns column type info if the statement is prepared,
 	// otherwise everything will be typed as []byte
-	stmt, err := c.Preparex(query)
+	stmt, release, err := c.readStmts.prepare(ctx, c.reader(), query)
 	if err != nil {
 		return nil, errors.Wrapf(err, "querying database with query '%s'", query)
 	}
-	defer stmt.Close()
+	defer release()
 
 	// execute the query
-	rows, err := stmt.Queryx()
+	rows, err := stmt.QueryxContext(ctx, args...)
 	if err != nil {
 		return nil, errors.Wrapf(err, "retrieving rows of query '%s'", query)
 	}
 	defer rows.Close()
 
+	columns, err := logs.ColumnsOf(rows.Rows)
+	if err != nil {
+		return nil, errors.Wrapf(err, "reading columns of query '%s'", query)
+	}
+	types := make(map[string]string, len(columns))
+	for _, column := range columns {
+		types[column.Name] = column.Type
+	}
+
 	// scan the rows into a JSON representation
-	var results []map[string]interface{}
 	for rows.Next() {
 		// create a row
 		row := make(map[string]interface{})
@@ -101,77 +821,143 @@ func (c *Client) QueryJSON(query string) (logs.JSON, error) {
 		if err := rows.MapScan(row); err != nil {
 			return nil, errors.Wrapf(err, "scanning row of query '%s'", query)
 		}
-		// the mysql driver returns text fields as []byte,
-		// so cast to string if any fields have that type
+		// the mysql driver returns text fields as []byte, so convert
+		// them based on the type of their column
 		for k, v := range row {
-			if b, ok := v.([]byte); ok {
-				row[k] = string(b)
+			row[k] = jsonValue(v, types[k])
+		}
+		if c.sharedTable != "" {
+			if err := expandLog(row); err != nil {
+				return nil, errors.Wrapf(err, "scanning row of query '%s'", query)
 			}
 		}
-		results = append(results, row)
+		if err := fn(row); err != nil {
+			return nil, err
+		}
 	}
-	return results, nil
+	if err := rows.Err(); err != nil {
+		return nil, errors.Wrapf(err, "reading rows of query '%s'", query)
+	}
+	return columns, nil
 }
 
 // DescribeDatabase returns the table names, columns, and types
-func (c *Client) DescribeDatabase() (logs.JSON, error) {
+func (c *Client) DescribeDatabase(ctx context.Context) (logs.JSON, error) {
+	tables, err := c.describeTables(ctx, "")
+	return tables, c.reconnect(ctx, err)
+}
+
+// DescribeTable returns the table of a family with its columns and types, or
+// no tables if the family doesn't have one
+func (c *Client) DescribeTable(ctx context.Context, family logs.Family) (logs.JSON, error) {
+	tables, err := c.describeTables(ctx, family.String())
+	return tables, c.reconnect(ctx, err)
+}
+
+// ListTables returns the names of the tables of the database, in order, or
+// the families of the shared table if it's set
+func (c *Client) ListTables(ctx context.Context) ([]string, error) {
+	if c.sharedTable != "" {
+		return c.listSharedFamilies(ctx)
+	}
+
+	// only list the tables of the database connected to, like
+	// `DescribeDatabase`
+	filter, args := c.schemaFilter()
+	prefixFilter, prefixArgs := c.prefixFilter()
+	filter, args = filter+prefixFilter, append(args, prefixArgs...)
+	var tables []string
+	err := c.reader().SelectContext(ctx, &tables,
+		"SELECT `TABLE_NAME` FROM information_schema.tables "+
+			"WHERE "+filter+
+			"ORDER BY `TABLE_NAME` ASC",
+		args...)
+	if err != nil {
+		return nil, errors.Wrap(err, "listing tables")
+	}
+
+	// the families of the tables, without the prefix
+	names := make([]string, 0, len(tables))
+	for _, table := range tables {
+		if name, ok := c.familyName(table); ok && !c.isSchemaTable(table) {
+			names = append(names, name)
+		}
+	}
+	return names, nil
+}
+
+// describeTables describes the table of the family with the name, or every
+// table if the name is empty. The tables are named after their families.
+func (c *Client) describeTables(ctx context.Context, name string) (logs.JSON, error) {
+	if c.sharedTable != "" {
+		return c.describeSharedTable(ctx, name)
+	}
+
 	var tableDescriptions []struct {
 		Schema   string // not used yet, but could be
 		Name     string // table name
 		Column   string // column name
 		Nullable string // YES/NO if column nullable
 		Datatype string // column data type
+		Comment  string // column comment, which tells `any` fields apart
+	}
+	// only describe the tables of the database connected to, rather than
+	// every database on the server the user can see
+	filter, args := c.schemaFilter()
+	if name != "" {
+		filter, args = filter+"AND `TABLE_NAME` = ? ", append(args, c.tableName(logs.Family(name)))
+	} else {
+		prefixFilter, prefixArgs := c.prefixFilter()
+		filter, args = filter+prefixFilter, append(args, prefixArgs...)
 	}
 	// query the table descriptions
-	err := c.Select(&tableDescriptions,
+	err := c.reader().SelectContext(ctx, &tableDescriptions,
 		"SELECT `TABLE_SCHEMA` as `schema`, "+
 			"`TABLE_NAME` as `name`, "+
 			"`COLUMN_NAME` as `column`, "+
 			"`IS_NULLABLE` as `nullable`, "+
-			"`DATA_TYPE` as `datatype` "+
+			"`DATA_TYPE` as `datatype`, "+
+			"`COLUMN_COMMENT` as `comment` "+
 			"FROM information_schema.columns "+
-			"WHERE table_schema <> 'information_schema' "+
-			"ORDER BY `name` ASC")
+			"WHERE "+filter+
+			"ORDER BY `name` ASC",
+		args...)
 	if err != nil {
 		return nil, errors.Wrap(err, "describing databse")
 	}
 
-	// list of tables with name and column
-	var tables logs.JSON
-	// the current table being iterated
-	var currentTable map[string]interface{}
+	// group the columns by table, keeping the order of the tables
+	var names []string
+	columns := make(map[string][]map[string]interface{})
 	for _, tableDescription := range tableDescriptions {
-		// set whether column is nullable
-		nullable := false
-		if tableDescription.Nullable == "YES" {
-			nullable = true
+		// describe the table by its family, without the prefix
+		family, ok := c.familyName(tableDescription.Name)
+		if !ok || c.isSchemaTable(tableDescription.Name) {
+			continue
+		}
+		tableDescription.Name = family
+		if _, ok := columns[tableDescription.Name]; !ok {
+			names = append(names, tableDescription.Name)
 		}
 		// create the column
 		column := map[string]interface{}{
 			"name":     tableDescription.Column,
-			"nullable": nullable,
+			"nullable": tableDescription.Nullable == "YES",
 			"type":     tableDescription.Datatype,
 		}
-		// if the current table is this table
-		if tableDescription.Name == currentTable["name"] {
-			// append this column to the current table
-			currentTable["columns"] = append(currentTable["columns"].([]map[string]interface{}), column)
-			continue
+		if tableDescription.Comment == logs.AnyColumnComment {
+			column["schema_type"] = "any"
 		}
+		columns[tableDescription.Name] = append(columns[tableDescription.Name], column)
+	}
 
-		// create a list of columns
-		var columns []map[string]interface{}
-		// add the column to it
-		columns = append(columns, column)
-		// create the table
-		table := map[string]interface{}{
-			"name":    tableDescription.Name,
-			"columns": columns,
-		}
-		// change the current table
-		currentTable = table
-		// add it to the list of tables
-		tables = append(tables, table)
+	// list of tables with name and columns
+	tables := logs.JSON{}
+	for _, name := range names {
+		tables = append(tables, map[string]interface{}{
+			"name":    name,
+			"columns": columns[name],
+		})
 	}
 
 	return tables, nil
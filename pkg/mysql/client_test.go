@@ -0,0 +1,958 @@
+package mysql_test
+
+import (
+	"context"
+	"database/sql/driver"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/kolide/databalancer-logan/pkg/logs"
+	"github.com/kolide/databalancer-logan/pkg/mysql"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+)
+
+// the client is the logs.DBClient wired into cmd/databalancer, and its
+// tables are the logs.Table the service inserts into
+var (
+	_ logs.DBClient = (*mysql.Client)(nil)
+	_ logs.Table    = (*mysql.Table)(nil)
+)
+
+func TestInsertReturningIDs(t *testing.T) {
+	// GIVEN
+	db := &fakeDB{}
+	table := &mysql.Table{
+		DB:     db.open(),
+		Name:   "dog_registry",
+		Schema: schema{"name": "string", "weight": "int"},
+	}
+
+	// WHEN
+	ids, err := table.InsertReturningIDs(context.Background(), logs.JSON{
+		record{"name": "max", "weight": float64(3)},
+		record{"name": "spot", "weight": float64(130)},
+		record{"name": "spike", "weight": float64(80)},
+	})
+
+	// THEN
+	assert.NoError(t, err)
+	assert.Equal(t, []int64{1, 2, 3}, ids)
+	// each record is inserted with its own statement, so the ids line up
+	if assert.Len(t, db.execs, 3) {
+		assert.Equal(t, []interface{}{"max", float64(3)}, db.execs[0].args)
+		assert.Equal(t, []interface{}{"spot", float64(130)}, db.execs[1].args)
+		assert.Equal(t, []interface{}{"spike", float64(80)}, db.execs[2].args)
+	}
+}
+
+func TestInsertBatches(t *testing.T) {
+	// GIVEN a table inserting two records per statement
+	db := &fakeDB{
+		exec: func(ctx context.Context, query string, args []interface{}) (driver.Result, error) {
+			return fakeResult{rows: int64(len(args))}, nil
+		},
+	}
+	table := &mysql.Table{
+		DB:        db.open(),
+		Name:      "dog_registry",
+		Schema:    schema{"name": "string"},
+		BatchSize: 2,
+	}
+
+	// WHEN more records than the batch size are inserted
+	inserted, err := table.Insert(context.Background(), logs.JSON{
+		record{"name": "max"},
+		record{"name": "spot"},
+		record{"name": "spike"},
+		record{"name": "rex"},
+		record{"name": "fido"},
+	})
+
+	// THEN a statement is issued per batch
+	assert.NoError(t, err)
+	assert.Equal(t, int64(5), inserted)
+	if assert.Len(t, db.execs, 3) {
+		assert.Equal(t, "INSERT INTO `dog_registry`(`name`) VALUES (?), (?);", db.execs[0].query)
+		assert.Equal(t, []interface{}{"max", "spot"}, db.execs[0].args)
+		assert.Equal(t, []interface{}{"spike", "rex"}, db.execs[1].args)
+		assert.Equal(t, "INSERT INTO `dog_registry`(`name`) VALUES (?);", db.execs[2].query)
+		assert.Equal(t, []interface{}{"fido"}, db.execs[2].args)
+	}
+}
+
+func TestInsertBatchesWideSchema(t *testing.T) {
+	// GIVEN a table with a thousand columns
+	columns := schema{}
+	row := record{}
+	for i := 0; i < 1000; i++ {
+		field := fmt.Sprintf("field_%d", i)
+		columns[field] = "int"
+		row[field] = i
+	}
+	db := &fakeDB{}
+	table := &mysql.Table{DB: db.open(), Name: "wide_registry", Schema: columns}
+
+	// WHEN more records than fit under the placeholder limit are inserted
+	var records logs.JSON
+	for i := 0; i < 150; i++ {
+		records = append(records, row)
+	}
+	_, err := table.Insert(context.Background(), records)
+
+	// THEN the records are split so that each statement stays under the limit
+	assert.NoError(t, err)
+	if assert.Len(t, db.execs, 3) {
+		for _, exec := range db.execs {
+			assert.True(t, len(exec.args) <= mysql.DefaultMaxPlaceholders)
+		}
+		assert.Len(t, db.execs[0].args, 60*1000)
+		assert.Len(t, db.execs[2].args, 30*1000)
+	}
+}
+
+func TestInsertBatchFailure(t *testing.T) {
+	// GIVEN a database that fails the second statement
+	db := &fakeDB{}
+	db.exec = func(ctx context.Context, query string, args []interface{}) (driver.Result, error) {
+		if len(db.execs) == 2 {
+			return nil, errors.New("packet too large")
+		}
+		return fakeResult{rows: 1}, nil
+	}
+	table := &mysql.Table{DB: db.open(), Name: "dog_registry", Schema: schema{"name": "string"}, BatchSize: 1}
+
+	// WHEN
+	_, err := table.Insert(context.Background(), logs.JSON{record{"name": "max"}, record{"name": "spot"}, record{"name": "spike"}})
+
+	// THEN the error identifies the batch
+	assert.EqualError(t, err, "inserting batch 2 of 3 for dog_registry table: packet too large")
+	assert.Len(t, db.execs, 2)
+
+	// AND nothing is committed
+	assert.Equal(t, 0, db.commits)
+	assert.Equal(t, 1, db.rollbacks)
+}
+
+func TestInsertCommits(t *testing.T) {
+	// GIVEN
+	db := &fakeDB{}
+	table := &mysql.Table{DB: db.open(), Name: "dog_registry", Schema: schema{"name": "string"}, BatchSize: 1}
+
+	// WHEN
+	_, err := table.Insert(context.Background(), logs.JSON{record{"name": "max"}, record{"name": "spot"}})
+
+	// THEN both batches are committed together
+	assert.NoError(t, err)
+	assert.Len(t, db.execs, 2)
+	assert.Equal(t, 1, db.commits)
+	assert.Equal(t, 0, db.rollbacks)
+}
+
+func TestInsertReturningIDsFailure(t *testing.T) {
+	// GIVEN a database that fails the second record
+	db := &fakeDB{}
+	db.exec = func(ctx context.Context, query string, args []interface{}) (driver.Result, error) {
+		if len(db.execs) == 2 {
+			return nil, errors.New("connection lost")
+		}
+		return fakeResult{lastID: 1, rows: 1}, nil
+	}
+	table := &mysql.Table{DB: db.open(), Name: "dog_registry", Schema: schema{"name": "string"}}
+
+	// WHEN
+	ids, err := table.InsertReturningIDs(context.Background(), logs.JSON{record{"name": "max"}, record{"name": "spot"}})
+
+	// THEN no ids are returned and nothing is committed
+	assert.Error(t, err)
+	assert.Nil(t, ids)
+	assert.Equal(t, 0, db.commits)
+	assert.Equal(t, 1, db.rollbacks)
+}
+
+func TestQueryJSONCancelled(t *testing.T) {
+	// GIVEN a database where queries never finish on their own
+	db := &fakeDB{
+		query: func(ctx context.Context, query string, args []interface{}) (driver.Rows, error) {
+			<-ctx.Done()
+			return nil, ctx.Err()
+		},
+	}
+	client := &mysql.Client{DB: db.open()}
+	ctx, cancel := context.WithCancel(context.Background())
+
+	// WHEN the request is cancelled mid-query
+	time.AfterFunc(10*time.Millisecond, cancel)
+	start := time.Now()
+	_, _, err := client.QueryJSON(ctx, "SELECT * FROM `dog_registry`")
+
+	// THEN the query returns promptly with the cancellation error
+	assert.Equal(t, context.Canceled, errors.Cause(err))
+	assert.True(t, time.Since(start) < time.Second, "query was not cancelled promptly")
+}
+
+// columnRows returns the rows of an information_schema query for the
+// columns of a table
+func columnRows(columns ...string) *fakeRows {
+	rows := &fakeRows{columns: []string{"column", "datatype"}}
+	for i := 0; i < len(columns); i += 2 {
+		rows.rows = append(rows.rows, []driver.Value{columns[i], columns[i+1]})
+	}
+	return rows
+}
+
+func TestQueryRows(t *testing.T) {
+	// GIVEN a database returning two rows with text columns
+	db := &fakeDB{
+		query: func(ctx context.Context, query string, args []interface{}) (driver.Rows, error) {
+			return &fakeRows{
+				columns: []string{"name", "weight"},
+				rows: [][]driver.Value{
+					{[]byte("max"), int64(3)},
+					{[]byte("spot"), int64(100)},
+				},
+			}, nil
+		},
+	}
+	client := &mysql.Client{DB: db.open()}
+
+	t.Run("each row is passed to the callback as it's scanned", func(t *testing.T) {
+		// WHEN
+		var rows logs.JSON
+		err := client.QueryRows(context.Background(), "SELECT * FROM `dog_registry`", nil, func(row map[string]interface{}) error {
+			rows = append(rows, row)
+			return nil
+		})
+
+		// THEN
+		assert.NoError(t, err)
+		assert.Equal(t, logs.JSON{
+			record{"name": "max", "weight": int64(3)},
+			record{"name": "spot", "weight": int64(100)},
+		}, rows)
+	})
+
+	t.Run("an error of the callback stops the query", func(t *testing.T) {
+		// WHEN
+		calls := 0
+		err := client.QueryRows(context.Background(), "SELECT * FROM `dog_registry`", nil, func(row map[string]interface{}) error {
+			calls++
+			return errors.New("connection reset")
+		})
+
+		// THEN
+		assert.EqualError(t, err, "connection reset")
+		assert.Equal(t, 1, calls)
+	})
+}
+
+func TestQueryJSONColumns(t *testing.T) {
+	// GIVEN a database returning columns in a different order than their names sort
+	db := &fakeDB{
+		query: func(ctx context.Context, query string, args []interface{}) (driver.Rows, error) {
+			return &fakeRows{
+				columns: []string{"weight", "name", "id"},
+				types:   []string{"DOUBLE", "VARCHAR", "INT"},
+				rows:    [][]driver.Value{{float64(3), []byte("max"), int64(1)}},
+			}, nil
+		},
+	}
+	client := &mysql.Client{DB: db.open()}
+
+	// WHEN
+	results, columns, err := client.QueryJSON(context.Background(), "SELECT weight, name, id FROM `dog_registry`")
+
+	// THEN the columns are in SELECT order with their types
+	assert.NoError(t, err)
+	assert.Equal(t, logs.JSON{record{"weight": float64(3), "name": "max", "id": int64(1)}}, results)
+	assert.Equal(t, []logs.Column{
+		{Name: "weight", Type: "DOUBLE"},
+		{Name: "name", Type: "VARCHAR"},
+		{Name: "id", Type: "INT"},
+	}, columns)
+}
+
+func TestQueryJSONEmpty(t *testing.T) {
+	// GIVEN a database where queries return no rows
+	db := &fakeDB{}
+	client := &mysql.Client{DB: db.open()}
+
+	t.Run("a query without rows returns an empty list", func(t *testing.T) {
+		// WHEN
+		results, _, err := client.QueryJSON(context.Background(), "SELECT * FROM `dog_registry`")
+
+		// THEN
+		assert.NoError(t, err)
+		encoded, err := json.Marshal(results)
+		assert.NoError(t, err)
+		assert.Equal(t, "[]", string(encoded))
+	})
+
+	t.Run("a database without tables is described as an empty list", func(t *testing.T) {
+		// WHEN
+		tables, err := client.DescribeDatabase(context.Background())
+
+		// THEN
+		assert.NoError(t, err)
+		encoded, err := json.Marshal(tables)
+		assert.NoError(t, err)
+		assert.Equal(t, "[]", string(encoded))
+	})
+}
+
+func TestQueryJSONTypes(t *testing.T) {
+	// GIVEN a database returning numeric-looking text, and numbers as text
+	db := &fakeDB{
+		query: func(ctx context.Context, query string, args []interface{}) (driver.Rows, error) {
+			return &fakeRows{
+				columns: []string{"zip", "phone", "age", "weight", "id", "views"},
+				types:   []string{"VARCHAR", "TEXT", "INT", "DECIMAL", "BIGINT", "UNSIGNED BIGINT"},
+				rows: [][]driver.Value{
+					{[]byte("02134"), []byte("5551234"), []byte("3"), []byte("12.50"), int64(7), []byte("18446744073709551615")},
+				},
+			}, nil
+		},
+	}
+	client := &mysql.Client{DB: db.open()}
+
+	// WHEN
+	results, _, err := client.QueryJSON(context.Background(), "SELECT * FROM `dog_registry`")
+
+	// THEN text columns stay strings and numeric columns are numbers
+	assert.NoError(t, err)
+	assert.Equal(t, logs.JSON{record{
+		"zip":    "02134",
+		"phone":  "5551234",
+		"age":    int64(3),
+		"weight": float64(12.5),
+		"id":     int64(7),
+		"views":  uint64(18446744073709551615),
+	}}, results)
+}
+
+func TestJSONRoundTrip(t *testing.T) {
+	// GIVEN a database storing the inserted JSON text, and returning it from
+	// a JSON column
+	var stored driver.Value
+	db := &fakeDB{
+		exec: func(ctx context.Context, query string, args []interface{}) (driver.Result, error) {
+			stored = args[0]
+			return fakeResult{rows: 1}, nil
+		},
+		query: func(ctx context.Context, query string, args []interface{}) (driver.Rows, error) {
+			return &fakeRows{
+				columns: []string{"geo"},
+				types:   []string{"JSON"},
+				rows:    [][]driver.Value{{[]byte(stored.(string))}},
+			}, nil
+		},
+	}
+	client := &mysql.Client{DB: db.open()}
+	table := &mysql.Table{DB: client.DB, Name: "request_log", Schema: schema{"geo": "json"}}
+	geo := map[string]interface{}{"lat": 1.5, "lng": -2.0, "city": map[string]interface{}{"name": "Boston"}}
+
+	// WHEN a nested object is inserted and queried back
+	_, err := table.Insert(context.Background(), logs.JSON{record{"geo": geo}})
+	assert.NoError(t, err)
+	results, _, err := client.QueryJSON(context.Background(), "SELECT geo FROM `request_log`")
+
+	// THEN it's returned as an object
+	assert.NoError(t, err)
+	assert.Equal(t, logs.JSON{record{"geo": geo}}, results)
+}
+
+// describeRows returns the rows of an information_schema query describing
+// the columns of tables, filtered on the schema bound to the query like MySQL
+func describeRows(args []interface{}, columns ...string) *fakeRows {
+	rows := &fakeRows{columns: []string{"schema", "name", "column", "nullable", "datatype"}}
+	for i := 0; i < len(columns); i += 4 {
+		if len(args) > 0 && args[0] != columns[i] {
+			continue
+		}
+		if len(args) > 1 && args[1] != columns[i+1] {
+			continue
+		}
+		rows.rows = append(rows.rows, []driver.Value{columns[i], columns[i+1], columns[i+2], "YES", columns[i+3]})
+	}
+	return rows
+}
+
+func TestConnPool(t *testing.T) {
+	t.Run("the pool of the database is left as is by default", func(t *testing.T) {
+		client := mysql.ClientFromDB((&fakeDB{}).open())
+		assert.Equal(t, 0, client.Stats().MaxOpenConnections)
+	})
+
+	t.Run("the pool of the database is configured when set", func(t *testing.T) {
+		client := mysql.ClientFromDB((&fakeDB{}).open(), mysql.WithConnPool(mysql.ConnPool{
+			MaxOpen:     3,
+			MaxIdle:     2,
+			MaxLifetime: time.Minute,
+		}))
+		assert.Equal(t, 3, client.Stats().MaxOpenConnections)
+	})
+}
+
+func TestDescribeDatabaseScopedToDatabase(t *testing.T) {
+	// GIVEN a server with the tables of another database
+	var described []interface{}
+	db := &fakeDB{
+		query: func(ctx context.Context, query string, args []interface{}) (driver.Rows, error) {
+			described = args
+			return describeRows(args,
+				"databalancer", "dog_registry", "name", "text",
+				"billing", "invoices", "amount", "int",
+			), nil
+		},
+	}
+	client := mysql.ClientFromDB(db.open(), mysql.WithDatabase("databalancer"))
+
+	// WHEN the database is described
+	tables, err := client.DescribeDatabase(context.Background())
+
+	// THEN only the tables of the configured database are described
+	assert.NoError(t, err)
+	assert.Equal(t, []interface{}{"databalancer"}, described)
+	if assert.Len(t, tables, 1) {
+		assert.Equal(t, "dog_registry", tables[0]["name"])
+	}
+}
+
+func TestListTables(t *testing.T) {
+	// GIVEN a database with several tables
+	var listed []interface{}
+	db := &fakeDB{
+		query: func(ctx context.Context, query string, args []interface{}) (driver.Rows, error) {
+			listed = args
+			return &fakeRows{
+				columns: []string{"TABLE_NAME"},
+				rows:    [][]driver.Value{{[]byte("cat_registry")}, {[]byte("dog_registry")}, {[]byte("request_log")}},
+			}, nil
+		},
+	}
+	client := mysql.ClientFromDB(db.open(), mysql.WithDatabase("databalancer"))
+
+	// WHEN
+	names, err := client.ListTables(context.Background())
+
+	// THEN the tables of the configured database are listed
+	assert.NoError(t, err)
+	assert.Equal(t, []interface{}{"databalancer"}, listed)
+	assert.Equal(t, []string{"cat_registry", "dog_registry", "request_log"}, names)
+}
+
+func TestDescribeDatabaseGroupsColumns(t *testing.T) {
+	// GIVEN several tables with several columns each
+	db := &fakeDB{
+		query: func(ctx context.Context, query string, args []interface{}) (driver.Rows, error) {
+			return describeRows(args,
+				"databalancer", "cat_registry", "id", "int",
+				"databalancer", "cat_registry", "name", "text",
+				"databalancer", "cat_registry", "lives", "int",
+				"databalancer", "dog_registry", "id", "int",
+				"databalancer", "dog_registry", "name", "text",
+				"databalancer", "dog_registry", "breed", "text",
+				"databalancer", "dog_registry", "weight", "int",
+			), nil
+		},
+	}
+	client := mysql.ClientFromDB(db.open(), mysql.WithDatabase("databalancer"))
+
+	// WHEN the database is described
+	tables, err := client.DescribeDatabase(context.Background())
+
+	// THEN every column of every table is described
+	assert.NoError(t, err)
+	column := func(name, datatype string) map[string]interface{} {
+		return map[string]interface{}{"name": name, "nullable": true, "type": datatype}
+	}
+	assert.Equal(t, logs.JSON{
+		{
+			"name":    "cat_registry",
+			"columns": []map[string]interface{}{column("id", "int"), column("name", "text"), column("lives", "int")},
+		},
+		{
+			"name":    "dog_registry",
+			"columns": []map[string]interface{}{column("id", "int"), column("name", "text"), column("breed", "text"), column("weight", "int")},
+		},
+	}, tables)
+}
+
+func TestDescribeTable(t *testing.T) {
+	// GIVEN a database with several tables
+	var described []interface{}
+	db := &fakeDB{
+		query: func(ctx context.Context, query string, args []interface{}) (driver.Rows, error) {
+			described = args
+			return describeRows(args,
+				"databalancer", "cat_registry", "name", "text",
+				"databalancer", "dog_registry", "name", "text",
+				"databalancer", "dog_registry", "weight", "int",
+			), nil
+		},
+	}
+	client := mysql.ClientFromDB(db.open(), mysql.WithDatabase("databalancer"))
+
+	t.Run("a known family is described with its columns", func(t *testing.T) {
+		// WHEN
+		tables, err := client.DescribeTable(context.Background(), "dog_registry")
+
+		// THEN the table is filtered by the query
+		assert.NoError(t, err)
+		assert.Equal(t, []interface{}{"databalancer", "dog_registry"}, described)
+		if assert.Len(t, tables, 1) {
+			assert.Equal(t, "dog_registry", tables[0]["name"])
+			assert.Len(t, tables[0]["columns"], 2)
+		}
+	})
+
+	t.Run("an unknown family has no tables", func(t *testing.T) {
+		// WHEN
+		tables, err := client.DescribeTable(context.Background(), "bird_registry")
+
+		// THEN
+		assert.NoError(t, err)
+		assert.Empty(t, tables)
+	})
+}
+
+func TestCreateTableAddsColumns(t *testing.T) {
+	// GIVEN an existing table without an age column
+	db := &fakeDB{
+		query: func(ctx context.Context, query string, args []interface{}) (driver.Rows, error) {
+			return columnRows("id", "int", "name", "text", "breed", "text"), nil
+		},
+	}
+	client := &mysql.Client{DB: db.open()}
+
+	// WHEN
+	_, err := client.CreateTable(context.Background(), "dog_registry",
+		logs.Schema{"name": "string", "breed": "string", "age": "int"}, nil)
+
+	// THEN the age column is added
+	assert.NoError(t, err)
+	if assert.Len(t, db.execs, 2) {
+		assert.Equal(t, "ALTER TABLE `dog_registry` ADD COLUMN `age` INT;", db.execs[1].query)
+	}
+}
+
+func TestMigrationDrainsFamilyInserts(t *testing.T) {
+	// GIVEN a database where altering a table blocks until released
+	altering := make(chan struct{})
+	release := make(chan struct{})
+	db := &fakeDB{
+		query: func(ctx context.Context, query string, args []interface{}) (driver.Rows, error) {
+			return columnRows("id", "int", "name", "text"), nil
+		},
+	}
+	client := mysql.ClientFromDB(db.open())
+	dogs, err := client.CreateTable(context.Background(), "dog_registry", logs.Schema{"name": "string"}, nil)
+	assert.NoError(t, err)
+	cats, err := client.CreateTable(context.Background(), "cat_registry", logs.Schema{"name": "string"}, nil)
+	assert.NoError(t, err)
+	db.exec = func(ctx context.Context, query string, args []interface{}) (driver.Result, error) {
+		if strings.HasPrefix(query, "ALTER TABLE") {
+			close(altering)
+			<-release
+		}
+		return fakeResult{rows: 1}, nil
+	}
+
+	// WHEN the dog table is migrated to add a column
+	migrated := make(chan error)
+	go func() {
+		_, err := client.CreateTable(context.Background(), "dog_registry", logs.Schema{"name": "string", "age": "int"}, nil)
+		migrated <- err
+	}()
+	<-altering
+
+	// THEN inserts into other families proceed
+	_, err = cats.Insert(context.Background(), logs.JSON{record{"name": "tom"}})
+	assert.NoError(t, err)
+
+	// AND inserts into the migrating family wait for the migration
+	inserted := make(chan error)
+	go func() {
+		_, err := dogs.Insert(context.Background(), logs.JSON{record{"name": "max"}})
+		inserted <- err
+	}()
+	select {
+	case <-inserted:
+		t.Fatal("insert into a migrating family was not blocked")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	close(release)
+	assert.NoError(t, <-migrated)
+	select {
+	case err := <-inserted:
+		assert.NoError(t, err)
+	case <-time.After(time.Second):
+		t.Fatal("insert was not resumed after the migration")
+	}
+}
+
+func TestCreateTableConflictingColumn(t *testing.T) {
+	// GIVEN an existing table where weight is text
+	db := &fakeDB{
+		query: func(ctx context.Context, query string, args []interface{}) (driver.Rows, error) {
+			return columnRows("id", "int", "name", "text", "weight", "text"), nil
+		},
+	}
+	client := &mysql.Client{DB: db.open()}
+
+	// WHEN
+	_, err := client.CreateTable(context.Background(), "dog_registry",
+		logs.Schema{"name": "string", "weight": "int"}, nil)
+
+	// THEN nothing is altered
+	assert.Error(t, err)
+	assert.Len(t, db.execs, 1)
+}
+
+func TestCreateTableUniqueKey(t *testing.T) {
+	schema := logs.Schema{"request_id": "string(36)", "status": "int"}
+
+	// uniqueKeyDB returns a database with an existing request_log table,
+	// which has a unique key on the given columns
+	uniqueKeyDB := func(key ...string) *fakeDB {
+		return &fakeDB{
+			query: func(ctx context.Context, query string, args []interface{}) (driver.Rows, error) {
+				if strings.Contains(query, "information_schema.statistics") {
+					rows := &fakeRows{columns: []string{"COLUMN_NAME"}}
+					for _, column := range key {
+						rows.rows = append(rows.rows, []driver.Value{column})
+					}
+					return rows, nil
+				}
+				return columnRows("id", "int", "request_id", "varchar", "status", "int"), nil
+			},
+		}
+	}
+
+	t.Run("logs are upserted on the unique key", func(t *testing.T) {
+		// GIVEN
+		db := uniqueKeyDB("request_id")
+		client := mysql.ClientFromDB(db.open())
+
+		// WHEN
+		table, err := client.CreateTable(context.Background(), "request_log", schema, []string{"request_id"})
+		assert.NoError(t, err)
+		_, err = table.Insert(context.Background(), logs.JSON{record{"request_id": "a1", "status": float64(200)}})
+
+		// THEN
+		assert.NoError(t, err)
+		if assert.Len(t, db.execs, 2) {
+			assert.Equal(t, mysql.CreateTableStatement("request_log", schema, []string{"request_id"}), db.execs[0].query)
+			assert.Equal(t, "INSERT INTO `request_log`(`request_id`, `status`) VALUES (?, ?) ON DUPLICATE KEY UPDATE `id` = LAST_INSERT_ID(`id`), `status` = VALUES(`status`);", db.execs[1].query)
+		}
+	})
+
+	t.Run("the unique key is added to an existing table without one", func(t *testing.T) {
+		// GIVEN
+		db := uniqueKeyDB()
+		client := mysql.ClientFromDB(db.open())
+
+		// WHEN
+		_, err := client.CreateTable(context.Background(), "request_log", schema, []string{"request_id"})
+
+		// THEN
+		assert.NoError(t, err)
+		if assert.Len(t, db.execs, 2) {
+			assert.Equal(t, "ALTER TABLE `request_log` ADD UNIQUE KEY `unique_key`(`request_id`);", db.execs[1].query)
+		}
+	})
+
+	t.Run("a unique key on other fields is a conflict", func(t *testing.T) {
+		// GIVEN
+		db := uniqueKeyDB("request_id", "status")
+		client := mysql.ClientFromDB(db.open())
+
+		// WHEN
+		_, err := client.CreateTable(context.Background(), "request_log", schema, []string{"request_id"})
+
+		// THEN
+		assert.Error(t, err)
+		assert.Len(t, db.execs, 1)
+	})
+
+	t.Run("a unique text field is rejected before touching the database", func(t *testing.T) {
+		// GIVEN
+		db := uniqueKeyDB()
+		client := mysql.ClientFromDB(db.open())
+
+		// WHEN
+		_, err := client.CreateTable(context.Background(), "request_log", logs.Schema{"path": "string"}, []string{"path"})
+
+		// THEN
+		assert.Error(t, err)
+		assert.Empty(t, db.execs)
+	})
+
+	t.Run("a unique key is rejected with a shared table", func(t *testing.T) {
+		// GIVEN
+		db := uniqueKeyDB()
+		client := mysql.ClientFromDB(db.open(), mysql.WithSharedTable("raw_logs"))
+
+		// WHEN
+		_, err := client.CreateTable(context.Background(), "request_log", schema, []string{"request_id"})
+
+		// THEN
+		assert.Error(t, err)
+		assert.Empty(t, db.execs)
+	})
+}
+
+func TestCreateTableRejectsInvalidIdentifiers(t *testing.T) {
+	cases := map[string]logs.Schema{
+		"dog_registry":    {"🐶": "string"},
+		"dog\x00registry": {"name": "string"},
+	}
+
+	for family, schema := range cases {
+		// GIVEN
+		db := &fakeDB{}
+		client := &mysql.Client{DB: db.open()}
+
+		// WHEN
+		_, err := client.CreateTable(context.Background(), logs.Family(family), schema, nil)
+
+		// THEN nothing is sent to the database
+		assert.Error(t, err)
+		assert.Empty(t, db.execs)
+	}
+}
+
+func TestTableSchema(t *testing.T) {
+	// GIVEN an existing request_log table
+	db := &fakeDB{
+		query: func(ctx context.Context, query string, args []interface{}) (driver.Rows, error) {
+			rows := &fakeRows{columns: []string{"column", "columntype", "nullable"}}
+			if args[0] == "request_log" {
+				rows.rows = [][]driver.Value{
+					{"id", "int(11)", "NO"},
+					{"path", "varchar(255)", "NO"},
+					{"status", "int(11)", "YES"},
+					{"price", "decimal(10,2)", "YES"},
+					{"received", "datetime", "YES"},
+				}
+			}
+			return rows, nil
+		},
+	}
+	client := &mysql.Client{DB: db.open()}
+
+	// THEN the schema is read from its columns, without the id and the
+	// columns logs can't be ingested with
+	schema, err := client.TableSchema(context.Background(), "request_log")
+	assert.NoError(t, err)
+	assert.Equal(t, logs.Schema{"path": "string(255)!", "status": "int", "price": "decimal(10,2)"}, schema)
+
+	// AND a missing table has no schema
+	schema, err = client.TableSchema(context.Background(), "cat_registry")
+	assert.NoError(t, err)
+	assert.Nil(t, schema)
+}
+
+func TestAnyRoundTrip(t *testing.T) {
+	// GIVEN a database whose request_log table has the commented JSON column
+	// of an any field once it's created
+	db := &fakeDB{
+		query: func(ctx context.Context, query string, args []interface{}) (driver.Rows, error) {
+			if strings.Contains(query, "`DATA_TYPE` as `datatype`, `COLUMN_COMMENT`") {
+				return &fakeRows{
+					columns: []string{"schema", "name", "column", "nullable", "datatype", "comment"},
+					rows: [][]driver.Value{
+						{"logs", "request_log", "id", "NO", "int", ""},
+						{"logs", "request_log", "meta", "YES", "json", "databalancer:any"},
+					},
+				}, nil
+			}
+			if strings.Contains(query, "`COLUMN_COMMENT`") {
+				return &fakeRows{
+					columns: []string{"column", "columntype", "comment", "nullable"},
+					rows: [][]driver.Value{
+						{"id", "int(11)", "", "NO"},
+						{"meta", "json", "databalancer:any", "YES"},
+					},
+				}, nil
+			}
+			return columnRows("id", "int", "meta", "json"), nil
+		},
+	}
+	svc := logs.CreateService(&mysql.Client{DB: db.open()}, logs.WithLogger(nil))
+
+	// WHEN the family is created with an any field
+	_, err := svc.Ingest(context.Background(), "request_log", logs.Schema{"meta": "any"}, nil, logs.JSON{{"meta": map[string]interface{}{"lat": 1.0}}})
+
+	// THEN its column is commented
+	assert.NoError(t, err)
+	if assert.NotEmpty(t, db.execs) {
+		assert.Contains(t, db.execs[0].query, "`meta` JSON COMMENT 'databalancer:any'")
+	}
+
+	// AND the schema read from the table has the any field
+	schema, err := (&mysql.Client{DB: db.open()}).TableSchema(context.Background(), "request_log")
+	assert.NoError(t, err)
+	assert.Equal(t, logs.Schema{"meta": "any"}, schema)
+
+	// AND it's described with its any type
+	tables, err := svc.DescribeFamily(context.Background(), "request_log")
+	assert.NoError(t, err)
+	if assert.Len(t, tables, 1) {
+		columns := tables[0]["columns"].([]map[string]interface{})
+		assert.Equal(t, map[string]interface{}{"name": "meta", "nullable": true, "type": "json", "schema_type": "any"}, columns[1])
+	}
+
+	// AND logs with scalars and nulls are ingested again without a schema
+	execs := len(db.execs)
+	_, err = svc.Ingest(context.Background(), "request_log", nil, nil, logs.JSON{{"meta": "Boston"}, {"meta": nil}})
+	assert.NoError(t, err)
+	assert.True(t, len(db.execs) > execs, "expected the logs to be inserted")
+}
+
+func TestCountRows(t *testing.T) {
+	// GIVEN an existing dog_registry table with 3 rows
+	db := &fakeDB{
+		query: func(ctx context.Context, query string, args []interface{}) (driver.Rows, error) {
+			if strings.HasPrefix(query, "SELECT COUNT(*)") {
+				return &fakeRows{columns: []string{"COUNT(*)"}, rows: [][]driver.Value{{int64(3)}}}, nil
+			}
+			if args[0] == "dog_registry" {
+				return columnRows("id", "int", "name", "text"), nil
+			}
+			return columnRows(), nil
+		},
+	}
+	client := &mysql.Client{DB: db.open()}
+
+	// THEN the rows of an existing table are counted
+	count, err := client.CountRows(context.Background(), "dog_registry")
+	assert.NoError(t, err)
+	assert.Equal(t, int64(3), count)
+
+	// AND a missing table is not found
+	_, err = client.CountRows(context.Background(), "cat_registry")
+	assert.Equal(t, logs.ErrFamilyNotFound, err)
+
+	// AND a name breaking out of its identifier is not found
+	_, err = client.CountRows(context.Background(), "dog_registry` WHERE 1=1; --")
+	assert.Equal(t, logs.ErrFamilyNotFound, err)
+}
+
+func TestCreateTableDDL(t *testing.T) {
+	// GIVEN a client, and a client with a shared table
+	db := &fakeDB{}
+	client := mysql.ClientFromDB(db.open())
+	shared := mysql.ClientFromDB(db.open(), mysql.WithSharedTable("raw_logs"))
+	schema := logs.Schema{"name": "string", "weight": "int"}
+
+	// THEN the DDL is the statement creating the table of the family
+	ddl, err := client.CreateTableDDL("dog_registry", schema, []string{"name"})
+	assert.NoError(t, err)
+	assert.Equal(t, mysql.CreateTableStatement("dog_registry", schema, []string{"name"}), ddl)
+
+	// AND the statement creating the shared table, with a shared table
+	ddl, err = shared.CreateTableDDL("dog_registry", schema, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, mysql.CreateSharedTableStatement("raw_logs"), ddl)
+
+	// AND nothing is run
+	assert.Empty(t, db.execs)
+
+	// AND a name MySQL can't store as is is rejected
+	_, err = client.CreateTableDDL(logs.Family(strings.Repeat("a", 65)), schema, nil)
+	assert.Error(t, err)
+}
+
+func TestCountStatement(t *testing.T) {
+	assert.Equal(t, "SELECT COUNT(*) FROM `dog_registry`;", mysql.CountStatement("dog_registry"))
+	assert.Equal(t, "SELECT COUNT(*) FROM `dog``registry`;", mysql.CountStatement("dog`registry"))
+}
+
+func TestDropTable(t *testing.T) {
+	// GIVEN an existing dog_registry table
+	db := &fakeDB{
+		query: func(ctx context.Context, query string, args []interface{}) (driver.Rows, error) {
+			if args[0] == "dog_registry" {
+				return columnRows("id", "int", "name", "text"), nil
+			}
+			return columnRows(), nil
+		},
+	}
+	client := &mysql.Client{DB: db.open()}
+
+	// THEN an existing table is dropped
+	assert.NoError(t, client.DropTable(context.Background(), "dog_registry"))
+	if assert.Len(t, db.execs, 1) {
+		assert.Equal(t, "DROP TABLE IF EXISTS `dog_registry`;", db.execs[0].query)
+	}
+
+	// AND a missing table is not found
+	assert.Equal(t, logs.ErrFamilyNotFound, client.DropTable(context.Background(), "cat_registry"))
+	assert.Len(t, db.execs, 1)
+}
+
+func TestTruncateTable(t *testing.T) {
+	// GIVEN an existing dog_registry table
+	db := &fakeDB{
+		query: func(ctx context.Context, query string, args []interface{}) (driver.Rows, error) {
+			if args[0] == "dog_registry" {
+				return columnRows("id", "int", "name", "text"), nil
+			}
+			return columnRows(), nil
+		},
+	}
+	client := &mysql.Client{DB: db.open()}
+
+	// THEN an existing table is truncated
+	assert.NoError(t, client.TruncateTable(context.Background(), "dog_registry"))
+	if assert.Len(t, db.execs, 1) {
+		assert.Equal(t, "TRUNCATE TABLE `dog_registry`;", db.execs[0].query)
+	}
+
+	// AND a missing table is not found
+	assert.Equal(t, logs.ErrFamilyNotFound, client.TruncateTable(context.Background(), "cat_registry"))
+	assert.Len(t, db.execs, 1)
+}
+
+func TestConcurrentCreateTable(t *testing.T) {
+	// GIVEN a client whose families already have tables
+	db := &fakeDB{
+		query: func(ctx context.Context, query string, args []interface{}) (driver.Rows, error) {
+			return columnRows("id", "int", "name", "text"), nil
+		},
+	}
+	client := mysql.ClientFromDB(db.open())
+	families := []logs.Family{"dog_registry", "cat_registry", "bird_registry"}
+
+	// WHEN many ingests create and insert into the same and different
+	// families at once
+	var wg sync.WaitGroup
+	errs := make(chan error, 50)
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			family := families[i%len(families)]
+			table, err := client.CreateTable(context.Background(), family, logs.Schema{"name": "string"}, nil)
+			if err != nil {
+				errs <- err
+				return
+			}
+			_, err = table.Insert(context.Background(), logs.JSON{record{"name": fmt.Sprintf("pet %d", i)}})
+			errs <- err
+		}(i)
+	}
+	wg.Wait()
+	close(errs)
+
+	// THEN every call succeeds, without racing when run with -race
+	for err := range errs {
+		assert.NoError(t, err)
+	}
+}
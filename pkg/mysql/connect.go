@@ -0,0 +1,63 @@
+package mysql
+
+import (
+	"context"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// DefaultConnectTimeout is the maximum time `CreateClient` waits for MySQL to
+// answer, unless configured otherwise with `WithConnectTimeout`
+const DefaultConnectTimeout = 30 * time.Second
+
+const (
+	// connectMinBackoff is the delay before the first retry of a ping
+	connectMinBackoff = 100 * time.Millisecond
+	// connectMaxBackoff is the maximum delay between the retries of a ping
+	connectMaxBackoff = 5 * time.Second
+)
+
+// WithConnectTimeout sets the maximum time `Connect` waits for MySQL to
+// answer. A timeout of 0 or less pings MySQL only once.
+func WithConnectTimeout(timeout time.Duration) Option {
+	return func(c *Client) {
+		c.connectTimeout = timeout
+	}
+}
+
+// Connect pings MySQL until it answers, doubling the delay between the pings,
+// so that the service can start before the database is ready. It returns the
+// error of the last ping once the connect timeout of the client has passed.
+func (c *Client) Connect(ctx context.Context) error {
+	if c.connectTimeout <= 0 {
+		return errors.Wrap(c.PingContext(ctx), "pinging database")
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, c.connectTimeout)
+	defer cancel()
+
+	backoff := connectMinBackoff
+	var lastErr error
+	for {
+		err := c.PingContext(ctx)
+		if err == nil {
+			return nil
+		}
+		// a ping cut short by the deadline doesn't say why MySQL can't be
+		// reached, unlike the error of the ping before it
+		if ctx.Err() == nil || lastErr == nil {
+			lastErr = err
+		}
+
+		c.logger.Warn("database not ready, retrying", "delay", backoff, "err", err)
+		select {
+		case <-ctx.Done():
+			return errors.Wrap(lastErr, "pinging database")
+		case <-time.After(backoff):
+		}
+		if backoff *= 2; backoff > connectMaxBackoff {
+			backoff = connectMaxBackoff
+		}
+	}
+}
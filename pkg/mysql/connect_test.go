@@ -0,0 +1,156 @@
+package mysql_test
+
+import (
+	"context"
+	"io/ioutil"
+	"log/slog"
+	"net"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/kolide/databalancer-logan/pkg/mysql"
+	"github.com/stretchr/testify/assert"
+)
+
+// discardLogger drops the log lines of the clients under test
+var discardLogger = slog.New(slog.NewTextHandler(ioutil.Discard, nil))
+
+func TestConnect(t *testing.T) {
+	t.Run("connecting retries until the database answers", func(t *testing.T) {
+		// GIVEN a database refusing the first connections
+		db := &fakeDB{connectErrs: 2}
+		client := mysql.ClientFromDB(db.open(), mysql.WithConnectTimeout(5*time.Second), mysql.WithLogger(discardLogger))
+
+		// WHEN the client connects
+		err := client.Connect(context.Background())
+
+		// THEN it connects once the database answers
+		assert.NoError(t, err)
+		assert.Equal(t, 3, db.connects)
+	})
+
+	t.Run("connecting returns the last error after the timeout", func(t *testing.T) {
+		// GIVEN a database refusing every connection
+		db := &fakeDB{connectErrs: 1000}
+		client := mysql.ClientFromDB(db.open(), mysql.WithConnectTimeout(250*time.Millisecond), mysql.WithLogger(discardLogger))
+
+		// WHEN the client connects
+		start := time.Now()
+		err := client.Connect(context.Background())
+
+		// THEN it gives up with the error of the database
+		assert.Error(t, err)
+		assert.Contains(t, err.Error(), "connection refused")
+		assert.True(t, time.Since(start) < 2*time.Second)
+		assert.True(t, db.connects > 1)
+	})
+
+	t.Run("connecting without a timeout pings once", func(t *testing.T) {
+		db := &fakeDB{connectErrs: 1}
+		client := mysql.ClientFromDB(db.open())
+
+		err := client.Connect(context.Background())
+
+		assert.Error(t, err)
+		assert.Equal(t, 1, db.connects)
+	})
+}
+
+func TestClose(t *testing.T) {
+	// GIVEN a client with an open connection
+	db := &fakeDB{}
+	client := mysql.ClientFromDB(db.open())
+	assert.NoError(t, client.PingContext(context.Background()))
+
+	// WHEN
+	err := client.Close()
+
+	// THEN the connection is closed, and the database can't be used anymore
+	assert.NoError(t, err)
+	assert.Equal(t, 1, db.connCloses)
+	assert.Error(t, client.PingContext(context.Background()))
+}
+
+func TestConfigDSN(t *testing.T) {
+	cases := []struct {
+		name   string
+		config mysql.Config
+		dsn    string
+	}{
+		{
+			name:   "a config has the default params",
+			config: mysql.Config{Username: "root", Password: "secret", Address: "localhost:3306", Database: "databalancer"},
+			dsn:    "root:secret@(localhost:3306)/databalancer?charset=utf8&loc=Local&parseTime=True",
+		},
+		{
+			name: "the params of a config are added to the defaults, or override them",
+			config: mysql.Config{
+				Username: "root",
+				Address:  "localhost:3306",
+				Database: "databalancer",
+				Params:   map[string]string{"timeout": "5s", "loc": "UTC"},
+			},
+			dsn: "root:@(localhost:3306)/databalancer?charset=utf8&loc=UTC&parseTime=True&timeout=5s",
+		},
+		{
+			name:   "a config with TLS enabled has the tls param",
+			config: mysql.Config{Username: "root", Address: "db.example.com:3306", Database: "databalancer", TLS: "true"},
+			dsn:    "root:@(db.example.com:3306)/databalancer?charset=utf8&loc=Local&parseTime=True&tls=true",
+		},
+		{
+			name:   "a config with a CA file uses the registered TLS config",
+			config: mysql.Config{Username: "root", Address: "db.example.com:3306", Database: "databalancer", TLSCAFile: "/etc/ssl/rds-ca.pem"},
+			dsn:    "root:@(db.example.com:3306)/databalancer?charset=utf8&loc=Local&parseTime=True&tls=databalancer",
+		},
+	}
+
+	for _, tt := range cases {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.dsn, tt.config.DSN())
+		})
+	}
+}
+
+func TestNewClientTLS(t *testing.T) {
+	t.Run("an unknown TLS mode is rejected", func(t *testing.T) {
+		_, err := mysql.NewClient(mysql.Config{Address: "localhost:3306", TLS: "always"})
+		assert.EqualError(t, err, `configuring TLS: unknown TLS mode "always", expected true, skip-verify or false`)
+	})
+
+	t.Run("a CA file without certificates is rejected", func(t *testing.T) {
+		file, err := ioutil.TempFile("", "ca")
+		if !assert.NoError(t, err) {
+			return
+		}
+		defer os.Remove(file.Name())
+		file.WriteString("not a certificate")
+		file.Close()
+
+		_, err = mysql.NewClient(mysql.Config{Address: "localhost:3306", TLSCAFile: file.Name()})
+		assert.EqualError(t, err, "configuring TLS: no PEM certificates in CA file "+file.Name())
+	})
+}
+
+func TestNewClient(t *testing.T) {
+	// GIVEN the address of a server that isn't listening anymore
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if !assert.NoError(t, err) {
+		return
+	}
+	address := listener.Addr().String()
+	listener.Close()
+
+	// WHEN a client is made from a config with the address
+	client, err := mysql.NewClient(mysql.Config{
+		Username: "root",
+		Address:  address,
+		Database: "databalancer",
+	}, mysql.WithConnectTimeout(0), mysql.WithLogger(discardLogger))
+
+	// THEN it connects to the address of the config
+	assert.Nil(t, client)
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), address)
+	}
+}
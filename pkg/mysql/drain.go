@@ -0,0 +1,53 @@
+package mysql
+
+import "sync"
+
+// familyLocks drains the inserts into the table of a family while the table
+// is migrated (ie: altered to add columns, or dropped), so that inserts don't
+// hit the table mid-migration. Inserts wait for the migration to finish, and
+// only the inserts into the migrated table are held.
+type familyLocks struct {
+	mu    sync.Mutex
+	locks map[string]*sync.RWMutex // table name -> lock of its table
+}
+
+// newFamilyLocks creates the locks of the tables of a client
+func newFamilyLocks() *familyLocks {
+	return &familyLocks{locks: make(map[string]*sync.RWMutex)}
+}
+
+// get returns the lock of a table, creating it if needed
+func (l *familyLocks) get(name string) *sync.RWMutex {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	lock, ok := l.locks[name]
+	if !ok {
+		lock = &sync.RWMutex{}
+		l.locks[name] = lock
+	}
+	return lock
+}
+
+// writing locks a table for an insert, waiting for any migration of the table
+// to finish first, and returns the function unlocking it. Inserts into the
+// same table don't wait for each other. A nil `familyLocks` doesn't lock.
+func (l *familyLocks) writing(name string) func() {
+	if l == nil {
+		return func() {}
+	}
+	lock := l.get(name)
+	lock.RLock()
+	return lock.RUnlock
+}
+
+// migrating locks a table for a migration, waiting for the inserts in
+// progress to finish and holding new ones until the migration is done, and
+// returns the function unlocking it. A nil `familyLocks` doesn't lock.
+func (l *familyLocks) migrating(name string) func() {
+	if l == nil {
+		return func() {}
+	}
+	lock := l.get(name)
+	lock.Lock()
+	return lock.Unlock
+}
@@ -0,0 +1,235 @@
+package mysql_test
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"io"
+	"sync"
+
+	gomysql "github.com/go-sql-driver/mysql"
+	"github.com/jmoiron/sqlx"
+)
+
+// fakeDB is a database/sql driver that records the statements it receives,
+// so that the client can be tested without a running MySQL server
+type fakeDB struct {
+	mu    sync.Mutex
+	execs []fakeCall // statements executed
+	// exec handles an executed statement, returning a result with an
+	// incrementing insert id when nil
+	exec func(ctx context.Context, query string, args []interface{}) (driver.Result, error)
+	// query handles a query, returning no rows when nil
+	query     func(ctx context.Context, query string, args []interface{}) (driver.Rows, error)
+	lastID    int64
+	commits   int // transactions committed
+	rollbacks int // transactions rolled back
+	// connectErrs is the number of connections that fail before the
+	// connections succeed, like a server that isn't ready yet
+	connectErrs int
+	connects    int // connections attempted
+	prepares    int // statements prepared
+	stmtCloses  int // prepared statements closed
+	connCloses  int // connections closed
+	restarts    int // times the server restarted, breaking the connections opened before
+}
+
+// fakeCall is a statement received by the fake driver
+type fakeCall struct {
+	query string
+	args  []interface{}
+}
+
+// open returns a sqlx database backed by the fake driver
+func (f *fakeDB) open() *sqlx.DB {
+	return sqlx.NewDb(sql.OpenDB(f), "mysql")
+}
+
+// Connect implements driver.Connector
+func (f *fakeDB) Connect(ctx context.Context) (driver.Conn, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.connects++
+	if f.connects <= f.connectErrs {
+		return nil, errors.New("connection refused")
+	}
+	return &fakeConn{db: f, restarts: f.restarts}, nil
+}
+
+// restart restarts the server, so that the connections opened before fail
+// once they're used, like MySQL's
+func (f *fakeDB) restart() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.restarts++
+}
+
+// Driver implements driver.Connector
+func (f *fakeDB) Driver() driver.Driver {
+	return nil
+}
+
+func (f *fakeDB) handleExec(ctx context.Context, query string, args []interface{}) (driver.Result, error) {
+	f.mu.Lock()
+	f.execs = append(f.execs, fakeCall{query: query, args: args})
+	exec := f.exec
+	f.lastID++
+	id := f.lastID
+	f.mu.Unlock()
+	if exec != nil {
+		return exec(ctx, query, args)
+	}
+	return fakeResult{lastID: id, rows: 1}, nil
+}
+
+func (f *fakeDB) handleQuery(ctx context.Context, query string, args []interface{}) (driver.Rows, error) {
+	if f.query != nil {
+		return f.query(ctx, query, args)
+	}
+	return &fakeRows{}, nil
+}
+
+// fakeConn is a connection to the fake driver
+type fakeConn struct {
+	db       *fakeDB
+	restarts int // restarts of the server when the connection was opened
+}
+
+// broken reports whether the server restarted since the connection was opened
+func (c *fakeConn) broken() bool {
+	c.db.mu.Lock()
+	defer c.db.mu.Unlock()
+	return c.restarts != c.db.restarts
+}
+
+func (c *fakeConn) Prepare(query string) (driver.Stmt, error) {
+	c.db.mu.Lock()
+	defer c.db.mu.Unlock()
+	c.db.prepares++
+	return &fakeStmt{conn: c, query: query}, nil
+}
+
+func (c *fakeConn) Close() error {
+	c.db.mu.Lock()
+	defer c.db.mu.Unlock()
+	c.db.connCloses++
+	return nil
+}
+
+func (c *fakeConn) Begin() (driver.Tx, error) {
+	return fakeTx{db: c.db}, nil
+}
+
+// fakeTx is a transaction of the fake driver
+type fakeTx struct {
+	db *fakeDB
+}
+
+func (tx fakeTx) Commit() error {
+	tx.db.mu.Lock()
+	defer tx.db.mu.Unlock()
+	tx.db.commits++
+	return nil
+}
+
+func (tx fakeTx) Rollback() error {
+	tx.db.mu.Lock()
+	defer tx.db.mu.Unlock()
+	tx.db.rollbacks++
+	return nil
+}
+
+// fakeStmt is a prepared statement of the fake driver
+type fakeStmt struct {
+	conn  *fakeConn
+	query string
+}
+
+func (s *fakeStmt) Close() error {
+	s.conn.db.mu.Lock()
+	defer s.conn.db.mu.Unlock()
+	s.conn.db.stmtCloses++
+	return nil
+}
+
+func (s *fakeStmt) NumInput() int {
+	return -1
+}
+
+func (s *fakeStmt) Exec(args []driver.Value) (driver.Result, error) {
+	panic("ExecContext is used instead")
+}
+
+func (s *fakeStmt) Query(args []driver.Value) (driver.Rows, error) {
+	panic("QueryContext is used instead")
+}
+
+func (s *fakeStmt) ExecContext(ctx context.Context, args []driver.NamedValue) (driver.Result, error) {
+	if s.conn.broken() {
+		return nil, gomysql.ErrInvalidConn
+	}
+	return s.conn.db.handleExec(ctx, s.query, values(args))
+}
+
+func (s *fakeStmt) QueryContext(ctx context.Context, args []driver.NamedValue) (driver.Rows, error) {
+	if s.conn.broken() {
+		return nil, gomysql.ErrInvalidConn
+	}
+	return s.conn.db.handleQuery(ctx, s.query, values(args))
+}
+
+// values converts driver values to a plain slice for assertions
+func values(args []driver.NamedValue) []interface{} {
+	var vals []interface{}
+	for _, arg := range args {
+		vals = append(vals, arg.Value)
+	}
+	return vals
+}
+
+// fakeResult is the result of an executed statement
+type fakeResult struct {
+	lastID int64
+	rows   int64
+}
+
+func (r fakeResult) LastInsertId() (int64, error) {
+	return r.lastID, nil
+}
+
+func (r fakeResult) RowsAffected() (int64, error) {
+	return r.rows, nil
+}
+
+// fakeRows are the rows returned by a query of the fake driver
+type fakeRows struct {
+	columns []string
+	types   []string // database type names of the columns, if set
+	rows    [][]driver.Value
+}
+
+func (r *fakeRows) Columns() []string {
+	return r.columns
+}
+
+// ColumnTypeDatabaseTypeName implements driver.RowsColumnTypeDatabaseTypeName
+func (r *fakeRows) ColumnTypeDatabaseTypeName(index int) string {
+	if index < len(r.types) {
+		return r.types[index]
+	}
+	return ""
+}
+
+func (r *fakeRows) Close() error {
+	return nil
+}
+
+func (r *fakeRows) Next(dest []driver.Value) error {
+	if len(r.rows) == 0 {
+		return io.EOF
+	}
+	copy(dest, r.rows[0])
+	r.rows = r.rows[1:]
+	return nil
+}
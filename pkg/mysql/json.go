@@ -0,0 +1,53 @@
+package mysql
+
+import (
+	"encoding/json"
+	"strconv"
+	"strings"
+)
+
+// jsonValue converts a value scanned from a column with the database type to
+// a value that marshals to the matching JSON type. The driver returns text,
+// and numbers without a Go type like DECIMAL, as []byte, so only the values
+// of numeric columns are parsed as numbers. A text column holding a zip code
+// like "02134" stays a string. The text of a JSON column is decoded, so
+// that an object stored by a json field is returned as an object.
+func jsonValue(v interface{}, dbType string) interface{} {
+	b, ok := v.([]byte)
+	if !ok {
+		return v
+	}
+	if strings.EqualFold(dbType, "JSON") {
+		var decoded interface{}
+		if err := json.Unmarshal(b, &decoded); err == nil {
+			return decoded
+		}
+	}
+	s := string(b)
+	switch numericKind(dbType) {
+	case "int":
+		if n, err := strconv.ParseInt(s, 10, 64); err == nil {
+			return n
+		}
+		if n, err := strconv.ParseUint(s, 10, 64); err == nil {
+			return n
+		}
+	case "float":
+		if f, err := strconv.ParseFloat(s, 64); err == nil {
+			return f
+		}
+	}
+	return s
+}
+
+// numericKind returns "int" or "float" for the numeric database types, and
+// "" for any other type. Unsigned types are named like "UNSIGNED BIGINT".
+func numericKind(dbType string) string {
+	switch strings.TrimPrefix(strings.ToUpper(dbType), "UNSIGNED ") {
+	case "TINYINT", "SMALLINT", "MEDIUMINT", "INT", "BIGINT", "YEAR":
+		return "int"
+	case "DECIMAL", "FLOAT", "DOUBLE":
+		return "float"
+	}
+	return ""
+}
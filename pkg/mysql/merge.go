@@ -0,0 +1,195 @@
+package mysql
+
+import (
+	"context"
+	"sort"
+	"strings"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/kolide/databalancer-logan/pkg/logs"
+	"github.com/pkg/errors"
+)
+
+// sourceColumnType is the column type of the family of each log of a merged table
+const sourceColumnType = "VARCHAR(255)"
+
+// MergeTables merges the tables of the source families into the table of
+// the target family, which is created if needed and gets the union of their
+// columns along with a column of the family of each row. The columns of all
+// the tables are checked for conflicting types before anything is written.
+// Each source is copied in its own transaction, replacing the rows copied
+// from it by a previous merge, so a failed merge can be resumed.
+func (c *Client) MergeTables(ctx context.Context, target logs.Family, sources []logs.Family, sourceColumn string) error {
+	if c.sharedTable != "" {
+		return errors.New("merging families isn't supported in shared table mode")
+	}
+	targetTable := c.tableName(target)
+	if err := CheckIdentifier(targetTable); err != nil {
+		return errors.Wrap(err, "checking target table name")
+	}
+	if c.isSchemaTable(targetTable) {
+		return errors.Wrapf(logs.ErrInvalidMerge, "table %s records the schemas of the families", targetTable)
+	}
+	if err := CheckIdentifier(sourceColumn); err != nil {
+		return errors.Wrap(err, "checking source column name")
+	}
+
+	// the columns of the merged table, starting with those of the target
+	targetColumns, err := c.columnTypes(ctx, targetTable)
+	if err != nil {
+		return errors.Wrapf(err, "finding columns of %s table", target)
+	}
+	columns := make(map[string]string)
+	owners := make(map[string]logs.Family) // column -> family it was first found in
+	for column, columnType := range targetColumns {
+		columns[column], owners[column] = columnType, target
+	}
+
+	// check that the columns of the sources don't conflict before writing
+	sourceColumns := make(map[logs.Family][]string)
+	for _, source := range sources {
+		if err := CheckIdentifier(c.tableName(source)); err != nil {
+			return logs.ErrFamilyNotFound
+		}
+		existing, err := c.columnTypes(ctx, c.tableName(source))
+		if err != nil {
+			return errors.Wrapf(err, "finding columns of %s table", source)
+		}
+		if len(existing) == 0 {
+			return errors.Wrapf(logs.ErrFamilyNotFound, "finding %s table", source)
+		}
+		for column, columnType := range existing {
+			if column == "id" {
+				continue
+			}
+			if column == sourceColumn {
+				return errors.Wrapf(logs.ErrIncompatibleSchemas,
+					"%s table has a %s column, which is the source column", source, column)
+			}
+			mergedType, ok := columns[column]
+			if ok && !strings.EqualFold(mergedType, columnType) {
+				return errors.Wrapf(logs.ErrIncompatibleSchemas, "column %s is %s in %s table but %s in %s table",
+					column, columnType, source, mergedType, owners[column])
+			}
+			if !ok {
+				columns[column], owners[column] = columnType, source
+			}
+			sourceColumns[source] = append(sourceColumns[source], column)
+		}
+		sort.Strings(sourceColumns[source])
+	}
+
+	// create the target table with the merged columns
+	if _, err := c.ExecContext(ctx, CreateMergeTableStatement(targetTable, sourceColumn)); err != nil {
+		return errors.Wrapf(err, "creating %s table", target)
+	}
+	if len(targetColumns) == 0 {
+		targetColumns = map[string]string{"id": "int", sourceColumn: sourceColumnType}
+	}
+	columns[sourceColumn] = sourceColumnType
+	if alter := AddColumnsStatement(targetTable, targetColumns, columns); alter != "" {
+		if err := c.migrate(ctx, targetTable, alter); err != nil {
+			return errors.Wrapf(err, "altering %s table", target)
+		}
+	}
+
+	// copy the rows of each source
+	unlock := c.locks.writing(targetTable)
+	defer unlock()
+	for _, source := range sources {
+		clearRows, copyRows := MergeTableStatements(targetTable, c.tableName(source), sourceColumns[source], sourceColumn)
+		err := inTransaction(ctx, c.DB, func(tx *sqlx.Tx) error {
+			if _, err := tx.ExecContext(ctx, clearRows, source.String()); err != nil {
+				return errors.Wrapf(err, "clearing rows previously merged from %s", source)
+			}
+			if _, err := tx.ExecContext(ctx, copyRows, source.String()); err != nil {
+				return errors.Wrapf(err, "copying rows of %s", source)
+			}
+			return nil
+		})
+		if err != nil {
+			return errors.Wrapf(err, "merging %s table into %s table", source, target)
+		}
+	}
+	return nil
+}
+
+// columnTypes returns the existing columns of a table, mapped to their full
+// column type, ie: `varchar(255)` rather than `varchar`
+func (c *Client) columnTypes(ctx context.Context, name string) (map[string]string, error) {
+	var columnDescriptions []struct {
+		Column   string // column name
+		Datatype string // column type
+	}
+	filter, args := c.schemaFilter()
+	err := c.SelectContext(ctx, &columnDescriptions,
+		"SELECT `COLUMN_NAME` as `column`, "+
+			"`COLUMN_TYPE` as `datatype` "+
+			"FROM information_schema.columns "+
+			"WHERE "+filter+"AND `TABLE_NAME` = ?",
+		append(args, name)...)
+	if err != nil {
+		return nil, errors.Wrapf(err, "describing table %s", name)
+	}
+
+	columns := make(map[string]string)
+	for _, columnDescription := range columnDescriptions {
+		columns[columnDescription.Column] = columnDescription.Datatype
+	}
+	return columns, nil
+}
+
+// CreateMergeTableStatement builds a statement creating the table families
+// are merged into, with an indexed column of the family of each row
+func CreateMergeTableStatement(name string, sourceColumn string) string {
+	return "CREATE TABLE IF NOT EXISTS `" +
+		escapeIdentifier(name) +
+		"`(`id` INT NOT NULL AUTO_INCREMENT, `" +
+		escapeIdentifier(sourceColumn) + "` " + sourceColumnType + ", " +
+		"PRIMARY KEY(`id`), " +
+		"INDEX(`" + escapeIdentifier(sourceColumn) + "`)" +
+		");"
+}
+
+// AddColumnsStatement builds a statement that adds the columns missing from
+// the existing columns of a table, both given as maps of column name to MySQL
+// column type. It returns an empty statement if no columns need to be added.
+func AddColumnsStatement(name string, existing map[string]string, columns map[string]string) string {
+	var addColumns []string
+	for column, columnType := range columns {
+		if _, ok := existing[column]; !ok {
+			addColumns = append(addColumns, "ADD COLUMN `"+escapeIdentifier(column)+"` "+columnType)
+		}
+	}
+	if len(addColumns) == 0 {
+		return ""
+	}
+	sort.Strings(addColumns)
+
+	return "ALTER TABLE `" + escapeIdentifier(name) + "` " + strings.Join(addColumns, ", ") + ";"
+}
+
+// MergeTableStatements builds the statements merging the columns of a source
+// table into a target table: one deleting the rows previously merged from the
+// source, and one copying the rows of the source. Both statements take the
+// name of the source as their only argument.
+func MergeTableStatements(target, source string, columns []string, sourceColumn string) (string, string) {
+	safeSourceColumn := "`" + escapeIdentifier(sourceColumn) + "`"
+	// the columns inserted, and the values selected for them
+	var insertColumns, selectValues []string
+	for _, column := range columns {
+		safeColumn := "`" + escapeIdentifier(column) + "`"
+		insertColumns = append(insertColumns, safeColumn)
+		selectValues = append(selectValues, safeColumn)
+	}
+	insertColumns = append(insertColumns, safeSourceColumn)
+	selectValues = append(selectValues, "?")
+
+	clearRows := "DELETE FROM `" + escapeIdentifier(target) + "` WHERE " + safeSourceColumn + " = ?;"
+	copyRows := "INSERT INTO `" +
+		escapeIdentifier(target) +
+		"`(" + strings.Join(insertColumns, ", ") + ") " +
+		"SELECT " + strings.Join(selectValues, ", ") +
+		" FROM `" + escapeIdentifier(source) + "`;"
+	return clearRows, copyRows
+}
@@ -0,0 +1,89 @@
+package mysql_test
+
+import (
+	"context"
+	"database/sql/driver"
+	"testing"
+
+	"github.com/kolide/databalancer-logan/pkg/logs"
+	"github.com/kolide/databalancer-logan/pkg/mysql"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+)
+
+// tablesDB returns a fake database with tables of the given columns, mapped
+// to their column type
+func tablesDB(tables map[string][]string) *fakeDB {
+	return &fakeDB{
+		query: func(ctx context.Context, query string, args []interface{}) (driver.Rows, error) {
+			if len(args) == 0 {
+				return columnRows(), nil
+			}
+			return columnRows(tables[args[0].(string)]...), nil
+		},
+	}
+}
+
+func TestMergeTables(t *testing.T) {
+	// GIVEN two compatible families
+	db := tablesDB(map[string][]string{
+		"dog_registry": {"id", "int(11)", "name", "text", "weight", "int(11)"},
+		"cat_registry": {"id", "int(11)", "name", "text", "lives", "int(11)"},
+	})
+	client := mysql.ClientFromDB(db.open())
+
+	// WHEN they're merged into a new family
+	err := client.MergeTables(context.Background(), "pets", []logs.Family{"cat_registry", "dog_registry"}, "source_family")
+
+	// THEN the merged table has the union of their columns, and the rows of
+	// each family are copied along with the family they came from
+	assert.NoError(t, err)
+	if assert.Len(t, db.execs, 6) {
+		assert.Equal(t, "CREATE TABLE IF NOT EXISTS `pets`(`id` INT NOT NULL AUTO_INCREMENT, `source_family` VARCHAR(255), PRIMARY KEY(`id`), INDEX(`source_family`));", db.execs[0].query)
+		assert.Equal(t, "ALTER TABLE `pets` ADD COLUMN `lives` int(11), ADD COLUMN `name` text, ADD COLUMN `weight` int(11);", db.execs[1].query)
+		assert.Equal(t, "DELETE FROM `pets` WHERE `source_family` = ?;", db.execs[2].query)
+		assert.Equal(t, []interface{}{"cat_registry"}, db.execs[2].args)
+		assert.Equal(t, "INSERT INTO `pets`(`lives`, `name`, `source_family`) SELECT `lives`, `name`, ? FROM `cat_registry`;", db.execs[3].query)
+		assert.Equal(t, []interface{}{"cat_registry"}, db.execs[3].args)
+		assert.Equal(t, "DELETE FROM `pets` WHERE `source_family` = ?;", db.execs[4].query)
+		assert.Equal(t, []interface{}{"dog_registry"}, db.execs[4].args)
+		assert.Equal(t, "INSERT INTO `pets`(`name`, `weight`, `source_family`) SELECT `name`, `weight`, ? FROM `dog_registry`;", db.execs[5].query)
+		assert.Equal(t, []interface{}{"dog_registry"}, db.execs[5].args)
+	}
+	assert.Equal(t, 2, db.commits)
+}
+
+func TestMergeTablesIncompatible(t *testing.T) {
+	// GIVEN two families with a column of conflicting types
+	db := tablesDB(map[string][]string{
+		"dog_registry": {"id", "int(11)", "name", "text", "weight", "int(11)"},
+		"cat_registry": {"id", "int(11)", "name", "text", "weight", "text"},
+	})
+	client := mysql.ClientFromDB(db.open())
+
+	// WHEN they're merged
+	err := client.MergeTables(context.Background(), "pets", []logs.Family{"cat_registry", "dog_registry"}, "source_family")
+
+	// THEN nothing is written
+	assert.Equal(t, logs.ErrIncompatibleSchemas, errors.Cause(err))
+	assert.Empty(t, db.execs)
+}
+
+func TestMergeTablesResumed(t *testing.T) {
+	// GIVEN a merge target which already has the rows of a family
+	db := tablesDB(map[string][]string{
+		"pets":         {"id", "int(11)", "name", "text", "source_family", "varchar(255)"},
+		"dog_registry": {"id", "int(11)", "name", "text"},
+	})
+	client := mysql.ClientFromDB(db.open())
+
+	// WHEN the family is merged again
+	err := client.MergeTables(context.Background(), "pets", []logs.Family{"dog_registry"}, "source_family")
+
+	// THEN the table isn't altered, and the rows merged before are replaced
+	assert.NoError(t, err)
+	if assert.Len(t, db.execs, 3) {
+		assert.Equal(t, "DELETE FROM `pets` WHERE `source_family` = ?;", db.execs[1].query)
+		assert.Equal(t, "INSERT INTO `pets`(`name`, `source_family`) SELECT `name`, ? FROM `dog_registry`;", db.execs[2].query)
+	}
+}
@@ -0,0 +1,111 @@
+package mysql
+
+import (
+	"strings"
+
+	"github.com/kolide/databalancer-logan/pkg/logs"
+	"github.com/pkg/errors"
+	"github.com/xwb1989/sqlparser"
+)
+
+// WithTablePrefix prefixes the table of each family with the prefix, ie:
+// `prod_` stores the logs of `dog_registry` in the `prod_dog_registry` table,
+// so that several environments can share a database. Clients still see the
+// plain family names: queries on families are rewritten to query their
+// prefixed tables, and the prefix is stripped from the described tables.
+// Tables without the prefix are left out, as if they didn't exist.
+func WithTablePrefix(prefix string) Option {
+	return func(c *Client) {
+		c.tablePrefix = prefix
+	}
+}
+
+// tableName returns the name of the table of a family
+func (c *Client) tableName(family logs.Family) string {
+	return c.tablePrefix + family.String()
+}
+
+// familyName returns the family of a table, and whether the table has the
+// prefix of the client
+func (c *Client) familyName(table string) (string, bool) {
+	if !strings.HasPrefix(table, c.tablePrefix) {
+		return "", false
+	}
+	return strings.TrimPrefix(table, c.tablePrefix), true
+}
+
+// prefixFilter returns the condition on `TABLE_NAME` of information_schema
+// that matches the tables with the prefix of the client, or no condition
+// without a prefix
+func (c *Client) prefixFilter() (string, []interface{}) {
+	if c.tablePrefix == "" {
+		return "", nil
+	}
+	escaped := strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(c.tablePrefix)
+	return "AND `TABLE_NAME` LIKE ? ", []interface{}{escaped + "%"}
+}
+
+// PrefixedTableQuery rewrites a query on families into a query on their
+// prefixed tables, by prefixing every table in the query. A table without an
+// alias is aliased with its family, so that columns qualified with the family
+// still resolve, ie: `SELECT dog_registry.name FROM dog_registry` becomes
+// `select dog_registry.name from prod_dog_registry as dog_registry`. The args
+// of the `?` placeholders of the query are returned in the order of the
+// placeholders of the rewritten query.
+func PrefixedTableQuery(query string, prefix string, args []interface{}) (string, []interface{}, error) {
+	stmt, err := sqlparser.Parse(query)
+	if err != nil {
+		return "", nil, errors.Wrapf(err, "parsing query '%s'", query)
+	}
+
+	err = sqlparser.Walk(func(node sqlparser.SQLNode) (bool, error) {
+		tableExpr, ok := node.(*sqlparser.AliasedTableExpr)
+		if !ok {
+			return true, nil
+		}
+		table, ok := tableExpr.Expr.(sqlparser.TableName)
+		if !ok {
+			return true, nil
+		}
+		family := table.Name.String()
+		table.Name = sqlparser.NewTableIdent(prefix + family)
+		tableExpr.Expr = table
+		if tableExpr.As.IsEmpty() {
+			tableExpr.As = sqlparser.NewTableIdent(family)
+		}
+		return true, nil
+	}, stmt)
+	if err != nil {
+		return "", nil, errors.Wrapf(err, "rewriting query '%s'", query)
+	}
+
+	return logs.FormatQuery(stmt, args)
+}
+
+// explainPrefix starts the queries explained by `logs.Service.Explain`
+const explainPrefix = "EXPLAIN "
+
+// rewriteQuery rewrites a query on families into a query on the shared table
+// or on the prefixed tables of the families, if the client has either. The
+// parser doesn't parse the statement an `EXPLAIN` explains, so the statement
+// is rewritten on its own and explained.
+func (c *Client) rewriteQuery(query string, args []interface{}) (string, []interface{}, error) {
+	if c.sharedTable == "" && c.tablePrefix == "" {
+		return query, args, nil
+	}
+	explain := ""
+	if len(query) > len(explainPrefix) && strings.EqualFold(query[:len(explainPrefix)], explainPrefix) {
+		explain, query = query[:len(explainPrefix)], query[len(explainPrefix):]
+	}
+	var rewritten string
+	var err error
+	if c.sharedTable != "" {
+		rewritten, args, err = SharedTableQuery(query, c.sharedTable, args)
+	} else {
+		rewritten, args, err = PrefixedTableQuery(query, c.tablePrefix, args)
+	}
+	if err != nil {
+		return "", nil, err
+	}
+	return explain + rewritten, args, nil
+}
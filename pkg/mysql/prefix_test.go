@@ -0,0 +1,197 @@
+package mysql_test
+
+import (
+	"context"
+	"database/sql/driver"
+	"strings"
+	"testing"
+
+	"github.com/kolide/databalancer-logan/pkg/logs"
+	"github.com/kolide/databalancer-logan/pkg/mysql"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPrefixedTableQuery(t *testing.T) {
+	cases := []struct {
+		name   string
+		query  string
+		result string
+	}{
+		{
+			name:   "a family is replaced with its prefixed table, aliased with the family",
+			query:  "SELECT * FROM `dog_registry` WHERE id > 2",
+			result: "select * from prod_dog_registry as dog_registry where id > 2",
+		},
+		{
+			name:   "columns qualified with the family still resolve",
+			query:  "SELECT dog_registry.name FROM dog_registry",
+			result: "select dog_registry.name from prod_dog_registry as dog_registry",
+		},
+		{
+			name:   "an aliased family keeps its alias",
+			query:  "SELECT d.id FROM dog_registry AS d",
+			result: "select d.id from prod_dog_registry as d",
+		},
+		{
+			name:   "families in joins and subqueries are each prefixed",
+			query:  "SELECT * FROM dog_registry JOIN cat_registry ON dog_registry.id = cat_registry.id WHERE dog_registry.id IN (SELECT id FROM bird_registry)",
+			result: "select * from prod_dog_registry as dog_registry join prod_cat_registry as cat_registry on dog_registry.id = cat_registry.id where dog_registry.id in (select id from prod_bird_registry as bird_registry)",
+		},
+	}
+
+	for _, tt := range cases {
+		t.Run(tt.name, func(t *testing.T) {
+			query, _, err := mysql.PrefixedTableQuery(tt.query, "prod_", nil)
+			assert.NoError(t, err)
+			assert.Equal(t, tt.result, query)
+		})
+	}
+}
+
+func TestTablePrefixIngestAndQuery(t *testing.T) {
+	// GIVEN a client with a table prefix, whose table has the columns of the
+	// logs
+	var queried string
+	var queriedArgs []interface{}
+	db := &fakeDB{
+		query: func(ctx context.Context, query string, args []interface{}) (driver.Rows, error) {
+			if strings.Contains(query, "information_schema") {
+				return columnRows("id", "int", "name", "text"), nil
+			}
+			queried, queriedArgs = query, args
+			return &fakeRows{}, nil
+		},
+	}
+	client := mysql.ClientFromDB(db.open(), mysql.WithTablePrefix("prod_"))
+
+	// WHEN logs are ingested
+	table, err := client.CreateTable(context.Background(), "dog_registry", logs.Schema{"name": "string"}, nil)
+	assert.NoError(t, err)
+	_, err = table.Insert(context.Background(), logs.JSON{record{"name": "max"}})
+	assert.NoError(t, err)
+
+	// THEN they're inserted into the prefixed table
+	if assert.Len(t, db.execs, 2) {
+		assert.Equal(t, mysql.CreateTableStatement("prod_dog_registry", logs.Schema{"name": "string"}, nil), db.execs[0].query)
+		assert.Equal(t, "INSERT INTO `prod_dog_registry`(`name`) VALUES (?);", db.execs[1].query)
+	}
+
+	// WHEN the family is queried with args
+	_, _, err = client.QueryJSON(context.Background(), "SELECT name FROM dog_registry WHERE name = ?", "max")
+
+	// THEN the prefixed table is queried
+	assert.NoError(t, err)
+	assert.Equal(t, "select name from prod_dog_registry as dog_registry where name = ?", queried)
+	assert.Equal(t, []interface{}{"max"}, queriedArgs)
+
+	// WHEN the family is explained
+	_, _, err = client.QueryJSON(context.Background(), "EXPLAIN select name from dog_registry limit 10")
+
+	// THEN the prefixed table is explained
+	assert.NoError(t, err)
+	assert.Equal(t, "EXPLAIN select name from prod_dog_registry as dog_registry limit 10", queried)
+
+	// AND the DDL is of the prefixed table
+	ddl, err := client.CreateTableDDL("dog_registry", logs.Schema{"name": "string"}, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, db.execs[0].query, ddl)
+}
+
+func TestTablePrefixTooLong(t *testing.T) {
+	// GIVEN a client with a prefix that makes the table name too long
+	db := &fakeDB{}
+	client := mysql.ClientFromDB(db.open(), mysql.WithTablePrefix("production_environment_"))
+
+	// WHEN a family that fits on its own is created
+	_, err := client.CreateTable(context.Background(), "dog_registry_of_the_county_of_los_angeles_2024", logs.Schema{"name": "string"}, nil)
+
+	// THEN the prefixed name is rejected
+	assert.Error(t, err)
+	assert.Empty(t, db.execs)
+}
+
+func TestTablePrefixDescribe(t *testing.T) {
+	// GIVEN a database with the tables of two environments
+	var described []interface{}
+	db := &fakeDB{
+		query: func(ctx context.Context, query string, args []interface{}) (driver.Rows, error) {
+			described = args
+			rows := &fakeRows{columns: []string{"schema", "name", "column", "nullable", "datatype"}}
+			for _, name := range []string{"prod_cat_registry", "prod_dog_registry"} {
+				if len(args) > 1 && args[1] != name && args[1] != `prod\_%` {
+					continue
+				}
+				rows.rows = append(rows.rows, []driver.Value{"databalancer", name, "name", "YES", "text"})
+			}
+			return rows, nil
+		},
+	}
+	client := mysql.ClientFromDB(db.open(), mysql.WithDatabase("databalancer"), mysql.WithTablePrefix("prod_"))
+
+	t.Run("only the prefixed tables are described, by their family", func(t *testing.T) {
+		// WHEN
+		tables, err := client.DescribeDatabase(context.Background())
+
+		// THEN
+		assert.NoError(t, err)
+		assert.Equal(t, []interface{}{"databalancer", `prod\_%`}, described)
+		if assert.Len(t, tables, 2) {
+			assert.Equal(t, "cat_registry", tables[0]["name"])
+			assert.Equal(t, "dog_registry", tables[1]["name"])
+		}
+	})
+
+	t.Run("a family is described from its prefixed table", func(t *testing.T) {
+		// WHEN
+		tables, err := client.DescribeTable(context.Background(), "dog_registry")
+
+		// THEN
+		assert.NoError(t, err)
+		assert.Equal(t, []interface{}{"databalancer", "prod_dog_registry"}, described)
+		if assert.Len(t, tables, 1) {
+			assert.Equal(t, "dog_registry", tables[0]["name"])
+		}
+	})
+}
+
+func TestTablePrefixListTables(t *testing.T) {
+	// GIVEN a database with the tables of two environments
+	var listed []interface{}
+	db := &fakeDB{
+		query: func(ctx context.Context, query string, args []interface{}) (driver.Rows, error) {
+			listed = args
+			return &fakeRows{
+				columns: []string{"TABLE_NAME"},
+				rows:    [][]driver.Value{{[]byte("prod_cat_registry")}, {[]byte("prod_dog_registry")}},
+			}, nil
+		},
+	}
+	client := mysql.ClientFromDB(db.open(), mysql.WithDatabase("databalancer"), mysql.WithTablePrefix("prod_"))
+
+	// WHEN
+	names, err := client.ListTables(context.Background())
+
+	// THEN the families of the prefixed tables are listed
+	assert.NoError(t, err)
+	assert.Equal(t, []interface{}{"databalancer", `prod\_%`}, listed)
+	assert.Equal(t, []string{"cat_registry", "dog_registry"}, names)
+}
+
+func TestSharedTableExplain(t *testing.T) {
+	// GIVEN a client in shared table mode
+	var queried string
+	db := &fakeDB{
+		query: func(ctx context.Context, query string, args []interface{}) (driver.Rows, error) {
+			queried = query
+			return &fakeRows{}, nil
+		},
+	}
+	client := mysql.ClientFromDB(db.open(), mysql.WithSharedTable("raw_logs"))
+
+	// WHEN a family is explained
+	_, _, err := client.QueryJSON(context.Background(), "EXPLAIN select * from dog_registry")
+
+	// THEN the query on the shared table is explained
+	assert.NoError(t, err)
+	assert.Equal(t, "EXPLAIN select * from (select id, log from raw_logs where family = 'dog_registry') as dog_registry", queried)
+}
@@ -0,0 +1,59 @@
+package mysql
+
+import (
+	"context"
+	"database/sql/driver"
+	"net"
+
+	gomysql "github.com/go-sql-driver/mysql"
+	"github.com/jmoiron/sqlx"
+	"github.com/pkg/errors"
+)
+
+// defaultMaxIdleConns is the number of idle connections `database/sql` keeps
+// when the pool of a client isn't configured
+const defaultMaxIdleConns = 2
+
+// isConnError reports whether an error is of a connection to MySQL that
+// broke, rather than of the statement sent on it, ie: a connection MySQL
+// closed when it restarted, which fails once it's used
+func isConnError(err error) bool {
+	switch cause := errors.Cause(err).(type) {
+	case *net.OpError:
+		return true
+	default:
+		return cause == driver.ErrBadConn || cause == gomysql.ErrInvalidConn
+	}
+}
+
+// reconnect recovers the connections of the client from an error of a broken
+// connection, and returns the error marked as `logs.ErrUnavailable`, so that
+// the request can be retried. Once MySQL restarts, every idle connection of
+// the pool is broken, and `database/sql` only discards a broken connection
+// once it's used, so the requests would keep failing until the pool is
+// recycled. Instead, the idle connections are closed, and MySQL is pinged on
+// a new connection, which the next requests reuse. Any other error, or any
+// error of a table without a client, is returned like `unavailable` does.
+func (c *Client) reconnect(ctx context.Context, err error) error {
+	if c == nil || err == nil || ctx.Err() != nil || !isConnError(err) {
+		return unavailable(err)
+	}
+	maxIdle := defaultMaxIdleConns
+	if c.pool != nil {
+		maxIdle = c.pool.MaxIdle
+	}
+	for _, db := range []*sqlx.DB{c.DB, c.replica} {
+		if db == nil {
+			continue
+		}
+		// lowering the maximum number of idle connections closes them
+		db.SetMaxIdleConns(0)
+		db.SetMaxIdleConns(maxIdle)
+	}
+	if pingErr := c.PingContext(ctx); pingErr != nil {
+		c.logger.Warn("database connection lost, reconnecting failed", "err", err, "ping_err", pingErr)
+	} else {
+		c.logger.Info("database connection lost, reconnected", "err", err)
+	}
+	return &unavailableError{err}
+}
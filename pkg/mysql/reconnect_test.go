@@ -0,0 +1,87 @@
+package mysql_test
+
+import (
+	"context"
+	"database/sql"
+	"testing"
+
+	"github.com/kolide/databalancer-logan/pkg/logs"
+	"github.com/kolide/databalancer-logan/pkg/mysql"
+	"github.com/stretchr/testify/assert"
+)
+
+// openConns opens n connections of the client and returns them to its pool,
+// where they're kept idle
+func openConns(t *testing.T, client *mysql.Client, n int) {
+	var conns []*sql.Conn
+	for i := 0; i < n; i++ {
+		conn, err := client.Conn(context.Background())
+		assert.NoError(t, err)
+		conns = append(conns, conn)
+	}
+	for _, conn := range conns {
+		conn.Close()
+	}
+}
+
+func TestReconnect(t *testing.T) {
+	t.Run("a query on a broken connection recovers the pool", func(t *testing.T) {
+		// GIVEN a client with idle connections to a server which restarts
+		db := &fakeDB{}
+		client := mysql.ClientFromDB(db.open(), mysql.WithConnPool(mysql.ConnPool{MaxOpen: 5, MaxIdle: 5}), mysql.WithLogger(discardLogger))
+		openConns(t, client, 3)
+		db.restart()
+
+		// WHEN
+		_, _, err := client.QueryJSON(context.Background(), "SELECT * FROM dog_registry")
+
+		// THEN the query can be retried, and the broken connections are closed
+		assert.Equal(t, logs.ErrUnavailable, logs.Kind(err))
+		assert.Contains(t, err.Error(), "invalid connection")
+		assert.Equal(t, 3, db.connCloses)
+
+		// WHEN the query is retried
+		_, _, err = client.QueryJSON(context.Background(), "SELECT * FROM dog_registry")
+
+		// THEN it runs on a new connection
+		assert.NoError(t, err)
+		assert.Equal(t, 4, db.connects)
+	})
+
+	t.Run("an insert on a broken connection recovers the pool", func(t *testing.T) {
+		// GIVEN
+		db := &fakeDB{}
+		client := mysql.ClientFromDB(db.open(), mysql.WithConnPool(mysql.ConnPool{MaxOpen: 5, MaxIdle: 5}), mysql.WithLogger(discardLogger))
+		table, err := client.CreateTable(context.Background(), "dog_registry", logs.Schema{"name": "string"}, nil)
+		assert.NoError(t, err)
+		openConns(t, client, 3)
+		db.restart()
+
+		// WHEN
+		_, err = table.Insert(context.Background(), logs.JSON{record{"name": "max"}})
+
+		// THEN
+		assert.Equal(t, logs.ErrUnavailable, logs.Kind(err))
+
+		// WHEN the insert is retried
+		_, err = table.Insert(context.Background(), logs.JSON{record{"name": "max"}})
+
+		// THEN
+		assert.NoError(t, err)
+	})
+
+	t.Run("a broken connection is unavailable while the server is down", func(t *testing.T) {
+		// GIVEN a server refusing the connections after it restarts
+		db := &fakeDB{}
+		client := mysql.ClientFromDB(db.open(), mysql.WithLogger(discardLogger))
+		openConns(t, client, 1)
+		db.restart()
+		db.connectErrs = 1000
+
+		// WHEN
+		_, _, err := client.QueryJSON(context.Background(), "SELECT * FROM dog_registry")
+
+		// THEN
+		assert.Equal(t, logs.ErrUnavailable, logs.Kind(err))
+	})
+}
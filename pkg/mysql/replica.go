@@ -0,0 +1,40 @@
+package mysql
+
+import (
+	"context"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/pkg/errors"
+)
+
+// WithReadReplica sends the queries of the client, and the descriptions and
+// lists of its tables, to a read replica of the database, while the tables
+// are created, altered and inserted into on the primary. Since a replica can
+// lag behind the primary, logs that were just ingested may not be queried
+// yet. The replica is closed with the client.
+func WithReadReplica(replica *sqlx.DB) Option {
+	return func(c *Client) {
+		c.replica = replica
+	}
+}
+
+// reader returns the database the client reads from: the read replica if it
+// has one, or the primary
+func (c *Client) reader() *sqlx.DB {
+	if c.replica != nil {
+		return c.replica
+	}
+	return c.DB
+}
+
+// PingContext pings the primary, and then the read replica if the client has
+// one, since queries fail without it
+func (c *Client) PingContext(ctx context.Context) error {
+	if err := c.DB.PingContext(ctx); err != nil {
+		return err
+	}
+	if c.replica != nil {
+		return errors.Wrap(c.replica.PingContext(ctx), "pinging read replica")
+	}
+	return nil
+}
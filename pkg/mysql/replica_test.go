@@ -0,0 +1,80 @@
+package mysql_test
+
+import (
+	"context"
+	"database/sql/driver"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/kolide/databalancer-logan/pkg/logs"
+	"github.com/kolide/databalancer-logan/pkg/mysql"
+	"github.com/stretchr/testify/assert"
+)
+
+// recordingDB is a fake database that records the queries it runs, whose
+// tables have the columns of dog_registry
+func recordingDB() (*fakeDB, *[]string) {
+	var mu sync.Mutex
+	var queries []string
+	db := &fakeDB{
+		query: func(ctx context.Context, query string, args []interface{}) (driver.Rows, error) {
+			mu.Lock()
+			queries = append(queries, query)
+			mu.Unlock()
+			if strings.Contains(query, "information_schema.columns") {
+				return columnRows("id", "int", "name", "text"), nil
+			}
+			return &fakeRows{}, nil
+		},
+	}
+	return db, &queries
+}
+
+func TestReadReplica(t *testing.T) {
+	// GIVEN a client with a read replica
+	primary, primaryQueries := recordingDB()
+	replica, replicaQueries := recordingDB()
+	client := mysql.ClientFromDB(primary.open(), mysql.WithReadReplica(replica.open()))
+
+	// WHEN logs are ingested
+	table, err := client.CreateTable(context.Background(), "dog_registry", logs.Schema{"name": "string"}, nil)
+	assert.NoError(t, err)
+	_, err = table.Insert(context.Background(), logs.JSON{record{"name": "max"}})
+	assert.NoError(t, err)
+
+	// THEN they're written to the primary
+	assert.Len(t, primary.execs, 2)
+	assert.Empty(t, replica.execs)
+
+	// WHEN the logs are queried, and the database described
+	_, _, err = client.QueryJSON(context.Background(), "SELECT name FROM dog_registry")
+	assert.NoError(t, err)
+	_, err = client.DescribeDatabase(context.Background())
+	assert.NoError(t, err)
+
+	// THEN both are read from the replica
+	if assert.Len(t, *replicaQueries, 2) {
+		assert.Equal(t, "SELECT name FROM dog_registry", (*replicaQueries)[0])
+		assert.Contains(t, (*replicaQueries)[1], "information_schema.columns")
+	}
+	for _, query := range *primaryQueries {
+		assert.NotEqual(t, "SELECT name FROM dog_registry", query)
+	}
+
+	// AND both are pinged
+	assert.NoError(t, client.PingContext(context.Background()))
+}
+
+func TestReadReplicaNotConfigured(t *testing.T) {
+	// GIVEN a client without a read replica
+	db, queries := recordingDB()
+	client := mysql.ClientFromDB(db.open())
+
+	// WHEN the logs are queried
+	_, _, err := client.QueryJSON(context.Background(), "SELECT name FROM dog_registry")
+
+	// THEN they're read from the primary
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"SELECT name FROM dog_registry"}, *queries)
+}
@@ -0,0 +1,118 @@
+package mysql
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"sync"
+
+	"github.com/pkg/errors"
+)
+
+// WithSchemaTable records the schema of the table of each family in the table
+// with the given name, along with a hash of the schema and its unique fields.
+// A family ingested again with the schema it was last created with skips
+// reading and altering the columns of its table. Without it, the columns of
+// the table are checked on every ingest. The schema table isn't a family, so
+// it's left out of the described and listed tables.
+func WithSchemaTable(name string) Option {
+	return func(c *Client) {
+		c.schemaTable = name
+	}
+}
+
+// schemaTable creates the table the schemas are recorded in once, retrying
+// on the next ingest if creating it failed
+type schemaTable struct {
+	mu      sync.Mutex
+	created bool
+}
+
+// CreateSchemaTableStatement builds a create table statement string for the
+// table the schema of each table is recorded in
+func CreateSchemaTableStatement(name string) string {
+	return "CREATE TABLE IF NOT EXISTS `" +
+		escapeIdentifier(name) +
+		"`(`table_name` VARCHAR(64) NOT NULL, " +
+		"`schema_hash` CHAR(64) NOT NULL, " +
+		"`schema` JSON NOT NULL, " +
+		"`updated_at` TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP, " +
+		"PRIMARY KEY(`table_name`)" +
+		");"
+}
+
+// SchemaHash returns the hash of a schema and its unique fields, which is
+// the same for the same fields and types in any order
+func SchemaHash(schema map[string]string, unique []string) string {
+	// the keys of a map are encoded in order
+	encoded, _ := json.Marshal(struct {
+		Schema map[string]string `json:"schema"`
+		Unique []string          `json:"unique"`
+	}{schema, unique})
+	sum := sha256.Sum256(encoded)
+	return hex.EncodeToString(sum[:])
+}
+
+// isSchemaTable reports whether the table is the schema table of the client
+func (c *Client) isSchemaTable(table string) bool {
+	return c.schemaTable != "" && table == c.schemaTable
+}
+
+// ensureSchemaTable creates the schema table, unless it was already created
+// by the client
+func (c *Client) ensureSchemaTable(ctx context.Context) error {
+	c.schemas.mu.Lock()
+	defer c.schemas.mu.Unlock()
+	if c.schemas.created {
+		return nil
+	}
+	if _, err := c.ExecContext(ctx, CreateSchemaTableStatement(c.schemaTable)); err != nil {
+		return errors.Wrapf(err, "creating schema table %s", c.schemaTable)
+	}
+	c.schemas.created = true
+	return nil
+}
+
+// recordedSchemaHash returns the hash of the schema recorded for a table, or
+// an empty hash if it has none
+func (c *Client) recordedSchemaHash(ctx context.Context, table string) (string, error) {
+	if err := c.ensureSchemaTable(ctx); err != nil {
+		return "", err
+	}
+	var hash string
+	err := c.GetContext(ctx, &hash,
+		"SELECT `schema_hash` FROM `"+escapeIdentifier(c.schemaTable)+"` WHERE `table_name` = ?", table)
+	if err == sql.ErrNoRows {
+		return "", nil
+	}
+	if err != nil {
+		return "", errors.Wrapf(err, "reading schema of table %s", table)
+	}
+	return hash, nil
+}
+
+// recordSchema records the schema a table was created or altered with
+func (c *Client) recordSchema(ctx context.Context, table string, schema map[string]string, hash string) error {
+	encoded, err := json.Marshal(schema)
+	if err != nil {
+		return errors.Wrapf(err, "encoding schema of table %s", table)
+	}
+	_, err = c.ExecContext(ctx,
+		"INSERT INTO `"+escapeIdentifier(c.schemaTable)+"`(`table_name`, `schema_hash`, `schema`) VALUES (?, ?, ?) "+
+			"ON DUPLICATE KEY UPDATE `schema_hash` = VALUES(`schema_hash`), `schema` = VALUES(`schema`);",
+		table, hash, string(encoded))
+	return errors.Wrapf(err, "recording schema of table %s", table)
+}
+
+// forgetSchema removes the schema recorded for a dropped table, so that the
+// table is created again when its family is ingested again
+func (c *Client) forgetSchema(ctx context.Context, table string) error {
+	if err := c.ensureSchemaTable(ctx); err != nil {
+		return err
+	}
+	_, err := c.ExecContext(ctx,
+		"DELETE FROM `"+escapeIdentifier(c.schemaTable)+"` WHERE `table_name` = ?", table)
+	return errors.Wrapf(err, "forgetting schema of table %s", table)
+}
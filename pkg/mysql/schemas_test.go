@@ -0,0 +1,146 @@
+package mysql_test
+
+import (
+	"context"
+	"database/sql/driver"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/kolide/databalancer-logan/pkg/logs"
+	"github.com/kolide/databalancer-logan/pkg/mysql"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+)
+
+// schemaDB is a fake database with a dog_registry table of an id and a name,
+// whose recorded schema hash is the given hash, if any
+func schemaDB(hash string) (*fakeDB, *[]string) {
+	var mu sync.Mutex
+	var queries []string
+	db := &fakeDB{
+		query: func(ctx context.Context, query string, args []interface{}) (driver.Rows, error) {
+			mu.Lock()
+			queries = append(queries, query)
+			mu.Unlock()
+			switch {
+			case strings.Contains(query, "`schema_hash`") && hash != "":
+				return &fakeRows{columns: []string{"schema_hash"}, rows: [][]driver.Value{{[]byte(hash)}}}, nil
+			case strings.Contains(query, "information_schema"):
+				return columnRows("id", "int", "name", "text"), nil
+			}
+			return &fakeRows{}, nil
+		},
+	}
+	return db, &queries
+}
+
+func TestSchemaTableMatchingSchema(t *testing.T) {
+	// GIVEN a table last created with the same schema
+	schema := logs.Schema{"name": "string"}
+	db, queries := schemaDB(mysql.SchemaHash(schema, nil))
+	client := mysql.ClientFromDB(db.open(), mysql.WithSchemaTable("databalancer_schemas"))
+
+	// WHEN the family is ingested again, twice
+	for i := 0; i < 2; i++ {
+		_, err := client.CreateTable(context.Background(), "dog_registry", schema, nil)
+		assert.NoError(t, err)
+	}
+
+	// THEN the schema table is created once, and the columns of the table
+	// are neither read nor altered
+	var execs []string
+	for _, call := range db.execs {
+		execs = append(execs, call.query)
+	}
+	assert.Equal(t, []string{
+		mysql.CreateTableStatement("dog_registry", schema, nil),
+		mysql.CreateSchemaTableStatement("databalancer_schemas"),
+		mysql.CreateTableStatement("dog_registry", schema, nil),
+	}, execs)
+	for _, query := range *queries {
+		assert.NotContains(t, query, "information_schema")
+	}
+}
+
+func TestSchemaTableChangedSchema(t *testing.T) {
+	t.Run("a schema with a new field alters the table and is recorded", func(t *testing.T) {
+		// GIVEN a table created with another schema
+		db, _ := schemaDB(mysql.SchemaHash(logs.Schema{"name": "string"}, nil))
+		client := mysql.ClientFromDB(db.open(), mysql.WithSchemaTable("databalancer_schemas"))
+
+		// WHEN the family is ingested with a new field
+		schema := logs.Schema{"name": "string", "weight": "int"}
+		_, err := client.CreateTable(context.Background(), "dog_registry", schema, nil)
+
+		// THEN the field is added, and the new schema is recorded
+		assert.NoError(t, err)
+		if assert.Len(t, db.execs, 4) {
+			assert.Equal(t, "ALTER TABLE `dog_registry` ADD COLUMN `weight` INT;", db.execs[2].query)
+			assert.Contains(t, db.execs[3].query, "INSERT INTO `databalancer_schemas`")
+			assert.Equal(t, []interface{}{"dog_registry", mysql.SchemaHash(schema, nil), `{"name":"string","weight":"int"}`}, db.execs[3].args)
+		}
+	})
+
+	t.Run("a schema conflicting with the table is refused", func(t *testing.T) {
+		// GIVEN a table created with another schema
+		db, _ := schemaDB(mysql.SchemaHash(logs.Schema{"name": "string"}, nil))
+		client := mysql.ClientFromDB(db.open(), mysql.WithSchemaTable("databalancer_schemas"))
+
+		// WHEN the family is ingested with another type for a field
+		_, err := client.CreateTable(context.Background(), "dog_registry", logs.Schema{"name": "int"}, nil)
+
+		// THEN the schemas are incompatible, and nothing is altered or recorded
+		assert.Equal(t, logs.ErrIncompatibleSchemas, errors.Cause(err))
+		assert.Equal(t, logs.ErrValidation, logs.Kind(err))
+		assert.Len(t, db.execs, 2)
+	})
+}
+
+func TestSchemaTableDrop(t *testing.T) {
+	// GIVEN a table with a recorded schema
+	db, _ := schemaDB(mysql.SchemaHash(logs.Schema{"name": "string"}, nil))
+	client := mysql.ClientFromDB(db.open(), mysql.WithSchemaTable("databalancer_schemas"))
+
+	// WHEN the family is dropped
+	err := client.DropTable(context.Background(), "dog_registry")
+
+	// THEN its schema is forgotten, so that it's created again when ingested
+	assert.NoError(t, err)
+	if assert.Len(t, db.execs, 3) {
+		assert.Equal(t, "DELETE FROM `databalancer_schemas` WHERE `table_name` = ?", db.execs[2].query)
+		assert.Equal(t, []interface{}{"dog_registry"}, db.execs[2].args)
+	}
+}
+
+func TestSchemaTableIsNotAFamily(t *testing.T) {
+	// GIVEN a database with the schema table
+	db := &fakeDB{
+		query: func(ctx context.Context, query string, args []interface{}) (driver.Rows, error) {
+			return &fakeRows{
+				columns: []string{"TABLE_NAME"},
+				rows:    [][]driver.Value{{[]byte("databalancer_schemas")}, {[]byte("dog_registry")}},
+			}, nil
+		},
+	}
+	client := mysql.ClientFromDB(db.open(), mysql.WithSchemaTable("databalancer_schemas"))
+
+	// WHEN the tables are listed
+	names, err := client.ListTables(context.Background())
+
+	// THEN the schema table isn't listed
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"dog_registry"}, names)
+
+	// AND it can't be ingested into or dropped
+	_, err = client.CreateTable(context.Background(), "databalancer_schemas", logs.Schema{"name": "string"}, nil)
+	assert.Equal(t, logs.ErrInvalidFamily, errors.Cause(err))
+	assert.Equal(t, logs.ErrFamilyNotFound, client.DropTable(context.Background(), "databalancer_schemas"))
+}
+
+func TestSchemaHash(t *testing.T) {
+	schema := logs.Schema{"name": "string", "weight": "int"}
+	assert.Equal(t, mysql.SchemaHash(schema, nil), mysql.SchemaHash(logs.Schema{"weight": "int", "name": "string"}, nil))
+	assert.NotEqual(t, mysql.SchemaHash(schema, nil), mysql.SchemaHash(schema, []string{"name"}))
+	assert.NotEqual(t, mysql.SchemaHash(schema, nil), mysql.SchemaHash(logs.Schema{"name": "string", "weight": "bigint"}, nil))
+}
@@ -0,0 +1,283 @@
+package mysql
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/kolide/databalancer-logan/pkg/logs"
+	"github.com/pkg/errors"
+	"github.com/xwb1989/sqlparser"
+)
+
+// SharedTable inserts the logs of a family into a table shared by all
+// families, where each log is stored as a JSON document next to its family.
+// This trades the strongly typed columns of a table per family for having a
+// single table to operate.
+type SharedTable struct {
+	*sqlx.DB                    // database for table
+	Name            string      // shared table name
+	Family          logs.Family // family of the logs inserted
+	BatchSize       int         // maximum number of records per insert statement, defaults to DefaultBatchSize
+	MaxPlaceholders int         // maximum number of placeholders per insert statement, defaults to DefaultMaxPlaceholders
+	stmts           *stmtCache  // prepared insert statements, if set
+	client          *Client     // reconnects after a broken connection, if set
+}
+
+// CreateSharedTableStatement builds a create table statement string for a
+// table shared by all families
+func CreateSharedTableStatement(name string) string {
+	return "CREATE TABLE IF NOT EXISTS `" +
+		escapeIdentifier(name) +
+		"`(`id` INT NOT NULL AUTO_INCREMENT, " +
+		"`family` VARCHAR(255) NOT NULL, " +
+		"`log` JSON, " +
+		"PRIMARY KEY(`id`), " +
+		"INDEX(`family`)" +
+		");"
+}
+
+// InsertSharedTableStatement builds a statement to insert records of a family
+// into a shared table, and returns the arguments to be passed to the statement
+func InsertSharedTableStatement(name string, family string, records []map[string]interface{}) (string, []interface{}, error) {
+	// the list of bindvars for all records
+	var valueBindvars []string
+	// the list of args to pass into the statement
+	var args []interface{}
+	for _, record := range records {
+		log, err := json.Marshal(record)
+		if err != nil {
+			return "", nil, errors.Wrapf(err, "encoding %s record", family)
+		}
+		valueBindvars = append(valueBindvars, "(?, ?)")
+		args = append(args, family, string(log))
+	}
+
+	stmt := "INSERT INTO `" +
+		escapeIdentifier(name) +
+		"`(`family`, `log`) VALUES " +
+		strings.Join(valueBindvars, ", ") +
+		";"
+
+	return stmt, args, nil
+}
+
+// SharedTableQuery rewrites a query on families into a query on the shared
+// table, by replacing every table in the query with a subquery selecting the
+// `id` and `log` columns of that family from the shared table. Note that this
+// means the fields of a log can only be referenced through the `log` column,
+// ie: `WHERE log->"$.breed" = "husky"`. The args of the `?` placeholders of
+// the query are returned in the order of the placeholders of the rewritten query.
+func SharedTableQuery(query string, name string, args []interface{}) (string, []interface{}, error) {
+	stmt, err := sqlparser.Parse(query)
+	if err != nil {
+		return "", nil, errors.Wrapf(err, "parsing query '%s'", query)
+	}
+
+	err = sqlparser.Walk(func(node sqlparser.SQLNode) (bool, error) {
+		tableExpr, ok := node.(*sqlparser.AliasedTableExpr)
+		if !ok {
+			return true, nil
+		}
+		table, ok := tableExpr.Expr.(sqlparser.TableName)
+		if !ok {
+			return true, nil
+		}
+		family := table.Name.String()
+		tableExpr.Expr = &sqlparser.Subquery{Select: familySelect(name, family)}
+		if tableExpr.As.IsEmpty() {
+			tableExpr.As = sqlparser.NewTableIdent(family)
+		}
+		// don't walk into the subquery, it's already on the shared table
+		return false, nil
+	}, stmt)
+	if err != nil {
+		return "", nil, errors.Wrapf(err, "rewriting query '%s'", query)
+	}
+
+	return logs.FormatQuery(stmt, args)
+}
+
+// familySelect builds `SELECT id, log FROM name WHERE family = 'family'`
+func familySelect(name, family string) *sqlparser.Select {
+	return &sqlparser.Select{
+		SelectExprs: sqlparser.SelectExprs{
+			&sqlparser.AliasedExpr{Expr: &sqlparser.ColName{Name: sqlparser.NewColIdent("id")}},
+			&sqlparser.AliasedExpr{Expr: &sqlparser.ColName{Name: sqlparser.NewColIdent("log")}},
+		},
+		From: sqlparser.TableExprs{
+			&sqlparser.AliasedTableExpr{
+				Expr: sqlparser.TableName{Name: sqlparser.NewTableIdent(name)},
+			},
+		},
+		Where: sqlparser.NewWhere(sqlparser.WhereStr, &sqlparser.ComparisonExpr{
+			Operator: sqlparser.EqualStr,
+			Left:     &sqlparser.ColName{Name: sqlparser.NewColIdent("family")},
+			Right:    sqlparser.NewStrVal([]byte(family)),
+		}),
+	}
+}
+
+// expandLog replaces the `log` column of a row from a shared table with the
+// fields of the log, so that results have the same shape as a table per
+// family. The column is text, unless its type is JSON, whose values are
+// already decoded (see `jsonValue`).
+func expandLog(row map[string]interface{}) error {
+	var fields map[string]interface{}
+	switch log := row["log"].(type) {
+	case string:
+		if err := json.Unmarshal([]byte(log), &fields); err != nil {
+			return errors.Wrap(err, "decoding log column")
+		}
+	case map[string]interface{}:
+		fields = log
+	default:
+		return nil
+	}
+	delete(row, "log")
+	for k, v := range fields {
+		row[k] = v
+	}
+	return nil
+}
+
+// Insert creates new logs in the shared table, in batches of at most
+// `BatchSize` records and `MaxPlaceholders` placeholders per statement, in a
+// single transaction. It returns the number of rows inserted.
+func (t *SharedTable) Insert(ctx context.Context, logs logs.JSON) (int64, error) {
+	// each record has a placeholder for its family and its log
+	batches := batch(logs, batchSize(2, t.BatchSize, t.MaxPlaceholders))
+	var inserted int64
+	err := inTransaction(ctx, t.DB, func(tx *sqlx.Tx) error {
+		for i, records := range batches {
+			// construct insert statement
+			insert, args, err := InsertSharedTableStatement(t.Name, t.Family.String(), records)
+			if err != nil {
+				return err
+			}
+
+			// insert the data
+			result, err := t.stmts.exec(ctx, t.DB, tx, insert, args...)
+			if err != nil {
+				return errors.Wrapf(err, "inserting batch %d of %d of %s records for %s table", i+1, len(batches), t.Family, t.Name)
+			}
+			rows, err := result.RowsAffected()
+			if err != nil {
+				return errors.Wrapf(err, "counting rows of batch %d of %d of %s records for %s table", i+1, len(batches), t.Family, t.Name)
+			}
+			inserted += rows
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, t.client.reconnect(ctx, err)
+	}
+	return inserted, nil
+}
+
+// InsertReturningIDs creates new logs in the shared table one at a time,
+// and returns the generated ids of the logs in the order they were given.
+// The logs are inserted in a single transaction.
+func (t *SharedTable) InsertReturningIDs(ctx context.Context, logs logs.JSON) ([]int64, error) {
+	ids := make([]int64, 0, len(logs))
+	err := inTransaction(ctx, t.DB, func(tx *sqlx.Tx) error {
+		for _, record := range logs {
+			// construct a single row insert statement
+			insert, args, err := InsertSharedTableStatement(t.Name, t.Family.String(), []map[string]interface{}{record})
+			if err != nil {
+				return err
+			}
+
+			// insert the record
+			result, err := t.stmts.exec(ctx, t.DB, tx, insert, args...)
+			if err != nil {
+				return errors.Wrapf(err, "inserting %s record for %s table", t.Family, t.Name)
+			}
+			id, err := result.LastInsertId()
+			if err != nil {
+				return errors.Wrapf(err, "retrieving id of %s record for %s table", t.Family, t.Name)
+			}
+			ids = append(ids, id)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, t.client.reconnect(ctx, err)
+	}
+	return ids, nil
+}
+
+// describeSharedTable returns the families of the shared table, with the
+// columns that queries on a family can use. Only the family with the name is
+// described, unless the name is empty.
+func (c *Client) describeSharedTable(ctx context.Context, name string) (logs.JSON, error) {
+	filter, args := "", []interface{}{}
+	if name != "" {
+		filter, args = "WHERE `family` = ? ", []interface{}{name}
+	}
+	var families []string
+	err := c.reader().SelectContext(ctx, &families,
+		"SELECT DISTINCT `family` FROM `"+escapeIdentifier(c.sharedTable)+"` "+filter+"ORDER BY `family` ASC",
+		args...)
+	if err != nil {
+		return nil, errors.Wrapf(err, "describing shared table %s", c.sharedTable)
+	}
+
+	tables := logs.JSON{}
+	for _, family := range families {
+		tables = append(tables, map[string]interface{}{
+			"name": family,
+			"columns": []map[string]interface{}{
+				{"name": "id", "nullable": false, "type": "int"},
+				{"name": "log", "nullable": true, "type": "json"},
+			},
+		})
+	}
+	return tables, nil
+}
+
+// dropSharedFamily deletes the logs of a family from the shared table,
+// returning `logs.ErrFamilyNotFound` if there weren't any
+func (c *Client) dropSharedFamily(ctx context.Context, family logs.Family) error {
+	result, err := c.ExecContext(ctx,
+		"DELETE FROM `"+escapeIdentifier(c.sharedTable)+"` WHERE `family` = ?", family.String())
+	if err != nil {
+		return errors.Wrapf(err, "deleting %s logs from shared table %s", family, c.sharedTable)
+	}
+	deleted, err := result.RowsAffected()
+	if err != nil {
+		return errors.Wrapf(err, "deleting %s logs from shared table %s", family, c.sharedTable)
+	}
+	if deleted == 0 {
+		return logs.ErrFamilyNotFound
+	}
+	return nil
+}
+
+// listSharedFamilies returns the families with logs in the shared table, in
+// order
+func (c *Client) listSharedFamilies(ctx context.Context) ([]string, error) {
+	var families []string
+	err := c.reader().SelectContext(ctx, &families,
+		"SELECT DISTINCT `family` FROM `"+escapeIdentifier(c.sharedTable)+"` ORDER BY `family` ASC")
+	if err != nil {
+		return nil, errors.Wrapf(err, "listing families of shared table %s", c.sharedTable)
+	}
+	return families, nil
+}
+
+// countSharedFamily counts the logs of a family in the shared table. A family
+// without any logs doesn't exist, since the shared table is its only trace.
+func (c *Client) countSharedFamily(ctx context.Context, family logs.Family) (int64, error) {
+	var count int64
+	err := c.GetContext(ctx, &count,
+		"SELECT COUNT(*) FROM `"+escapeIdentifier(c.sharedTable)+"` WHERE `family` = ?", family.String())
+	if err != nil {
+		return 0, errors.Wrapf(err, "counting %s logs in shared table %s", family, c.sharedTable)
+	}
+	if count == 0 {
+		return 0, logs.ErrFamilyNotFound
+	}
+	return count, nil
+}
@@ -0,0 +1,148 @@
+package mysql_test
+
+import (
+	"context"
+	"database/sql/driver"
+	"testing"
+
+	"github.com/kolide/databalancer-logan/pkg/logs"
+	"github.com/kolide/databalancer-logan/pkg/mysql"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCreateSharedTableStatement(t *testing.T) {
+	assert.Equal(t,
+		"CREATE TABLE IF NOT EXISTS `raw_logs`(`id` INT NOT NULL AUTO_INCREMENT, `family` VARCHAR(255) NOT NULL, `log` JSON, PRIMARY KEY(`id`), INDEX(`family`));",
+		mysql.CreateSharedTableStatement("raw_logs"))
+}
+
+func TestInsertSharedTableStatement(t *testing.T) {
+	stmt, args, err := mysql.InsertSharedTableStatement("raw_logs", "dog_registry", records{
+		record{"name": "max", "weight": float64(3)},
+		record{"name": "spot", "weight": float64(130)},
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, "INSERT INTO `raw_logs`(`family`, `log`) VALUES (?, ?), (?, ?);", stmt)
+	assert.Equal(t, []interface{}{
+		"dog_registry", `{"name":"max","weight":3}`,
+		"dog_registry", `{"name":"spot","weight":130}`,
+	}, args)
+}
+
+// describes a test case for SharedTableQuery
+type sharedQueryCase struct {
+	name   string
+	query  string
+	result string
+}
+
+func TestSharedTableQuery(t *testing.T) {
+	cases := []sharedQueryCase{
+		{
+			name:   "a family is replaced with a subquery on the shared table",
+			query:  "SELECT * FROM `dog_registry` WHERE id > 2",
+			result: "select * from (select id, log from raw_logs where family = 'dog_registry') as dog_registry where id > 2",
+		},
+		{
+			name:   "an aliased family keeps its alias",
+			query:  "SELECT d.id FROM dog_registry AS d",
+			result: "select d.id from (select id, log from raw_logs where family = 'dog_registry') as d",
+		},
+		{
+			name:   "families in a join are each replaced",
+			query:  "SELECT * FROM dog_registry JOIN cat_registry ON dog_registry.id = cat_registry.id",
+			result: "select * from (select id, log from raw_logs where family = 'dog_registry') as dog_registry join (select id, log from raw_logs where family = 'cat_registry') as cat_registry on dog_registry.id = cat_registry.id",
+		},
+		{
+			name:   "quotes in family names can't escape the family literal",
+			query:  "SELECT * FROM `dog'registry`",
+			result: "select * from (select id, log from raw_logs where family = 'dog\\'registry') as `dog'registry`",
+		},
+	}
+
+	for _, tt := range cases {
+		t.Run(tt.name, func(t *testing.T) {
+			query, _, err := mysql.SharedTableQuery(tt.query, "raw_logs", nil)
+			assert.NoError(t, err)
+			assert.Equal(t, tt.result, query)
+		})
+	}
+}
+
+func TestSharedTableIngestAndQuery(t *testing.T) {
+	// GIVEN a client in shared table mode
+	db := &fakeDB{
+		query: func(ctx context.Context, query string, args []interface{}) (driver.Rows, error) {
+			return &fakeRows{
+				columns: []string{"id", "log"},
+				rows: [][]driver.Value{
+					{int64(1), []byte(`{"name":"max","weight":3}`)},
+				},
+			}, nil
+		},
+	}
+	client := mysql.ClientFromDB(db.open(), mysql.WithSharedTable("raw_logs"))
+
+	// WHEN logs are ingested
+	table, err := client.CreateTable(context.Background(), "dog_registry", logs.Schema{"name": "string", "weight": "int"}, nil)
+	assert.NoError(t, err)
+	_, err = table.Insert(context.Background(), logs.JSON{record{"name": "max", "weight": float64(3)}})
+	assert.NoError(t, err)
+
+	// THEN they're inserted into the shared table
+	if assert.Len(t, db.execs, 2) {
+		assert.Equal(t, mysql.CreateSharedTableStatement("raw_logs"), db.execs[0].query)
+		assert.Equal(t, []interface{}{"dog_registry", `{"name":"max","weight":3}`}, db.execs[1].args)
+	}
+
+	// WHEN the family is queried
+	results, _, err := client.QueryJSON(context.Background(), "SELECT * FROM dog_registry")
+
+	// THEN the logs have the fields of the family
+	assert.NoError(t, err)
+	assert.Equal(t, logs.JSON{{"id": int64(1), "name": "max", "weight": float64(3)}}, results)
+}
+
+func TestSharedTableQueryJSONColumn(t *testing.T) {
+	// GIVEN a client in shared table mode, whose log column is of type JSON
+	db := &fakeDB{
+		query: func(ctx context.Context, query string, args []interface{}) (driver.Rows, error) {
+			return &fakeRows{
+				columns: []string{"id", "log"},
+				types:   []string{"INT", "JSON"},
+				rows: [][]driver.Value{
+					{int64(1), []byte(`{"name":"max","weight":3}`)},
+				},
+			}, nil
+		},
+	}
+	client := mysql.ClientFromDB(db.open(), mysql.WithSharedTable("raw_logs"))
+
+	// WHEN the family is queried
+	results, _, err := client.QueryJSON(context.Background(), "SELECT * FROM dog_registry")
+
+	// THEN the decoded log is expanded into the fields of the family
+	assert.NoError(t, err)
+	assert.Equal(t, logs.JSON{{"id": int64(1), "name": "max", "weight": float64(3)}}, results)
+}
+
+func TestSharedTableQueryArgs(t *testing.T) {
+	// GIVEN a client in shared table mode
+	var queried string
+	var queriedArgs []interface{}
+	db := &fakeDB{
+		query: func(ctx context.Context, query string, args []interface{}) (driver.Rows, error) {
+			queried, queriedArgs = query, args
+			return &fakeRows{}, nil
+		},
+	}
+	client := mysql.ClientFromDB(db.open(), mysql.WithSharedTable("raw_logs"))
+
+	// WHEN a family is queried with args
+	_, _, err := client.QueryJSON(context.Background(), `SELECT * FROM dog_registry WHERE log->"$.breed" = ?`, "husky")
+
+	// THEN the placeholders are kept in the rewritten query
+	assert.NoError(t, err)
+	assert.Equal(t, "select * from (select id, log from raw_logs where family = 'dog_registry') as dog_registry where log -> '$.breed' = ?", queried)
+	assert.Equal(t, []interface{}{"husky"}, queriedArgs)
+}
@@ -1,24 +1,26 @@
 package mysql
 
 import (
+	"encoding/json"
 	"sort"
+	"strconv"
 	"strings"
+	"unicode/utf8"
+
+	"github.com/kolide/databalancer-logan/pkg/logs"
+	"github.com/pkg/errors"
 )
 
 // CreateTableStatement builds a create table statement string from a
 // table name and a schema. Note that the table will have an INT typed `id`
-// primary key
-func CreateTableStatement(name string, schema map[string]string) string {
+// primary key, and a unique key on the unique fields if there are any.
+func CreateTableStatement(name string, schema map[string]string, unique []string) string {
 	// list of fields in the schema
 	var tableFields []string
 	for fieldName, fieldType := range schema {
 		// append field field name and appropriate field type to field list
-		switch fieldType {
-		case "string":
-			field := "`" + Escape(fieldName) + "` TEXT, "
-			tableFields = append(tableFields, field)
-		case "int":
-			field := "`" + Escape(fieldName) + "` INT, "
+		if columnType, ok := columnDefinition(fieldType); ok {
+			field := "`" + escapeIdentifier(fieldName) + "` " + columnType + ", "
 			tableFields = append(tableFields, field)
 		}
 	}
@@ -29,15 +31,212 @@ func CreateTableStatement(name string, schema map[string]string) string {
 	safeTableFields := strings.Join(tableFields, "")
 
 	stmt := "CREATE TABLE IF NOT EXISTS `" +
-		Escape(name) +
+		escapeIdentifier(name) +
 		"`(`id` INT NOT NULL AUTO_INCREMENT, " +
 		safeTableFields +
 		"PRIMARY KEY(`id`)" +
+		uniqueKeyDefinition(unique) +
 		");"
 
 	return stmt
 }
 
+// uniqueKeyName is the name of the unique key of a table, which logs with the
+// same values for its fields are upserted on
+const uniqueKeyName = "unique_key"
+
+// uniqueKeyDefinition returns the definition of the unique key on the fields,
+// preceded by a comma, or an empty string if there are no fields
+func uniqueKeyDefinition(unique []string) string {
+	if len(unique) == 0 {
+		return ""
+	}
+	var safeFieldNames []string
+	for _, fieldName := range unique {
+		safeFieldNames = append(safeFieldNames, escapeIdentifier(fieldName))
+	}
+	return ", UNIQUE KEY `" + uniqueKeyName + "`(`" + strings.Join(safeFieldNames, "`, `") + "`)"
+}
+
+// AddUniqueKeyStatement builds a statement that adds a unique key on the
+// fields to an existing table. It fails if the table already has logs with
+// the same values for the fields.
+func AddUniqueKeyStatement(name string, unique []string) string {
+	return "ALTER TABLE `" + escapeIdentifier(name) + "` ADD" + strings.TrimPrefix(uniqueKeyDefinition(unique), ",") + ";"
+}
+
+// CheckUniqueKey returns an error if the fields can't be the unique key of a
+// table with the schema. MySQL can only index a prefix of a TEXT column, so
+// string fields have to be declared with a length, ie: `string(64)`.
+func CheckUniqueKey(schema map[string]string, unique []string) error {
+	for _, fieldName := range unique {
+		fieldType, ok := schema[fieldName]
+		if !ok {
+			return errors.Errorf("unique field %s is not in the schema", fieldName)
+		}
+		if columnType, _ := ColumnType(fieldType); columnType == "TEXT" {
+			return errors.Errorf("unique field %s must be a string with a length, ie: string(255)", fieldName)
+		}
+	}
+	return nil
+}
+
+// AlterTableStatement builds a statement that adds the fields of the schema
+// missing from the existing columns of a table, given as a map of column name
+// to MySQL data type. It returns an empty statement if no columns need to be
+// added, and an error if a field conflicts with the type of an existing column.
+// Only the data types are compared, so a string field of a different length
+// than its VARCHAR column isn't a conflict. The columns added are nullable even
+// for required fields, since the logs already in the table don't have them.
+func AlterTableStatement(name string, columns map[string]string, schema map[string]string) (string, error) {
+	// list of columns to add
+	var addColumns []string
+	for fieldName, fieldType := range schema {
+		columnType, ok := ColumnType(fieldType)
+		if !ok {
+			continue
+		}
+		existingType, exists := columns[fieldName]
+		if !exists {
+			addColumn := "ADD COLUMN `" + escapeIdentifier(fieldName) + "` " + columnType + columnComment(fieldType)
+			addColumns = append(addColumns, addColumn)
+			continue
+		}
+		if !strings.EqualFold(existingType, dataType(columnType)) {
+			return "", errors.Wrapf(logs.ErrIncompatibleSchemas, "field %s of type %s conflicts with existing column of type %s",
+				fieldName, fieldType, existingType)
+		}
+	}
+	if len(addColumns) == 0 {
+		return "", nil
+	}
+	// sort the columns
+	sort.Strings(addColumns)
+
+	stmt := "ALTER TABLE `" +
+		escapeIdentifier(name) +
+		"` " +
+		strings.Join(addColumns, ", ") +
+		";"
+
+	return stmt, nil
+}
+
+// DropTableStatement builds a statement that drops a table
+func DropTableStatement(name string) string {
+	return "DROP TABLE IF EXISTS `" + escapeIdentifier(name) + "`;"
+}
+
+// TruncateTableStatement builds a statement that deletes all of the rows of
+// a table, which also resets its AUTO_INCREMENT ids
+func TruncateTableStatement(name string) string {
+	return "TRUNCATE TABLE `" + escapeIdentifier(name) + "`;"
+}
+
+// CountStatement builds a statement that counts the rows of a table
+func CountStatement(name string) string {
+	return "SELECT COUNT(*) FROM `" + escapeIdentifier(name) + "`;"
+}
+
+// ColumnType returns the MySQL column type for a schema field type, and
+// whether the field type is supported
+func ColumnType(fieldType string) (string, bool) {
+	parsed, err := logs.ParseFieldType(fieldType)
+	if err != nil {
+		return "", false
+	}
+	switch parsed.Name {
+	case "string":
+		// TEXT columns are stored off-page and can only be indexed with a
+		// prefix, so strings with a length get a VARCHAR column
+		if parsed.Length > 0 {
+			return "VARCHAR(" + strconv.Itoa(parsed.Length) + ")", true
+		}
+		return "TEXT", true
+	case "int":
+		return "INT", true
+	case "bigint":
+		return "BIGINT", true
+	case "decimal":
+		return "DECIMAL(" + strconv.Itoa(parsed.Precision) + "," + strconv.Itoa(parsed.Scale) + ")", true
+	case "json", "any":
+		return "JSON", true
+	}
+	return "", false
+}
+
+// FieldTypeOf returns the schema field type of a MySQL column type, ie:
+// `string(32)` for `varchar(32)`, and whether logs can be ingested with it. A
+// column which isn't nullable is a required field, ie: `int!`, and a JSON
+// column with the comment of an `any` field is an `any` field.
+func FieldTypeOf(columnType, comment string, nullable bool) (string, bool) {
+	columnType = strings.ToLower(strings.TrimSpace(columnType))
+	args := ""
+	if i := strings.Index(columnType, "("); i >= 0 && strings.HasSuffix(columnType, ")") {
+		args = columnType[i+1 : len(columnType)-1]
+	}
+	var fieldType string
+	switch dataType(columnType) {
+	case "text":
+		fieldType = "string"
+	case "varchar":
+		fieldType = "string(" + args + ")"
+	case "int":
+		// older versions of MySQL have a display width, ie: `int(11)`
+		fieldType = "int"
+	case "bigint":
+		fieldType = "bigint"
+	case "decimal":
+		fieldType = "decimal(" + args + ")"
+	case "json":
+		fieldType = "json"
+		if comment == logs.AnyColumnComment {
+			fieldType = "any"
+		}
+	default:
+		return "", false
+	}
+	if _, err := logs.ParseFieldType(fieldType); err != nil {
+		return "", false
+	}
+	if !nullable {
+		fieldType += "!"
+	}
+	return fieldType, true
+}
+
+// columnDefinition returns the MySQL column type for a schema field type
+// followed by `NOT NULL` if the field is required, and whether the field
+// type is supported
+func columnDefinition(fieldType string) (string, bool) {
+	columnType, ok := ColumnType(fieldType)
+	if !ok {
+		return "", false
+	}
+	if parsed, _ := logs.ParseFieldType(fieldType); parsed.Required {
+		columnType += " NOT NULL"
+	}
+	return columnType + columnComment(fieldType), true
+}
+
+// columnComment returns the comment of the column of an `any` field,
+// preceded by a space, or an empty string for any other field type
+func columnComment(fieldType string) string {
+	if parsed, _ := logs.ParseFieldType(fieldType); parsed.Name == "any" {
+		return " COMMENT '" + logs.AnyColumnComment + "'"
+	}
+	return ""
+}
+
+// dataType returns the data type of a column type, without its length, ie:
+// `VARCHAR` for `VARCHAR(32)`
+func dataType(columnType string) string {
+	if i := strings.Index(columnType, "("); i >= 0 {
+		return columnType[:i]
+	}
+	return columnType
+}
+
 // InsertTableStatement builds a statement to insert records into a table,
 // given a table name, schema, and some records, and returns the arguments to be passed
 // to the statement
@@ -51,14 +250,20 @@ func InsertTableStatement(name string, schema map[string]string, records []map[s
 	for fieldName := range schema {
 		// append a bindvar for the field
 		bindvars = append(bindvars, "?")
-		// append safe field name and to list of fields
-		fieldNames = append(fieldNames, Escape(fieldName))
+		// append field name to list of fields
+		fieldNames = append(fieldNames, fieldName)
 	}
 	// sort the fields
 	sort.Strings(fieldNames)
 
-	// concatenate the field names and wrap in backticks
-	var safeTableFields = "`" + strings.Join(fieldNames, "`, `") + "`"
+	// list of safe field names
+	var safeFieldNames []string
+	for _, fieldName := range fieldNames {
+		safeFieldNames = append(safeFieldNames, escapeIdentifier(fieldName))
+	}
+
+	// concatenate the safe field names and wrap in backticks
+	var safeTableFields = "`" + strings.Join(safeFieldNames, "`, `") + "`"
 
 	// concatenate the bindvars and wrap in parens
 	var bindvarString = "(" + strings.Join(bindvars, ", ") + ")"
@@ -67,23 +272,22 @@ func InsertTableStatement(name string, schema map[string]string, records []map[s
 	var valueBindvars []string
 	// the list of args to pass into the statement
 	var args []interface{}
+	isJSON := jsonFields(schema)
 	for _, record := range records {
 		// append a bindvarstring for each record
 		valueBindvars = append(valueBindvars, bindvarString)
 
 		for _, fieldName := range fieldNames {
-			// append field values as arguments
-			fieldValue := record[fieldName]
-			if fieldValue != nil {
-				args = append(args, fieldValue)
-			}
+			// append field values as arguments, where a missing field is
+			// inserted as NULL so the arguments line up with the bindvars
+			args = append(args, insertValue(record[fieldName], isJSON[fieldName]))
 		}
 	}
 	// join the value bind vars
 	valuePlaceholders := strings.Join(valueBindvars, ", ")
 
 	stmt := "INSERT INTO `" +
-		Escape(name) +
+		escapeIdentifier(name) +
 		"`(" +
 		safeTableFields +
 		") VALUES " +
@@ -93,10 +297,122 @@ func InsertTableStatement(name string, schema map[string]string, records []map[s
 	return stmt, args
 }
 
-// Escape prepares strings to be safely used in MySQL statements
-// I found this from a quick google search. For the sake of time,
-// I'm just going to trust this. Ideally, it would have lots of tests
-//  to protect from injection attacks.
+// jsonFields returns the fields of the schema stored as JSON, which are the
+// fields with the json or any type
+func jsonFields(schema map[string]string) map[string]bool {
+	fields := make(map[string]bool)
+	for fieldName, fieldType := range schema {
+		if parsed, err := logs.ParseFieldType(fieldType); err == nil && (parsed.Name == "json" || parsed.Name == "any") {
+			fields[fieldName] = true
+		}
+	}
+	return fields
+}
+
+// insertValue returns the value of a field of a record as the argument of an
+// insert. The values of json and any fields are bound as JSON text, which
+// the driver can't do itself, so that a string is stored as a JSON string.
+// They were decoded from JSON, so they can always be encoded again.
+func insertValue(value interface{}, isJSON bool) interface{} {
+	if !isJSON || value == nil {
+		return value
+	}
+	encoded, err := json.Marshal(value)
+	if err != nil {
+		return value
+	}
+	return string(encoded)
+}
+
+// UpsertTableStatement builds a statement like `InsertTableStatement`, which
+// updates the logs with the same values for the unique fields as a record
+// rather than inserting the record again. The `id` of an updated log is kept,
+// and returned as the last insert id of the statement.
+func UpsertTableStatement(name string, schema map[string]string, unique []string, records []map[string]interface{}) (string, []interface{}) {
+	insert, args := InsertTableStatement(name, schema, records)
+
+	// the fields which aren't part of the unique key are updated
+	var fieldNames []string
+	for fieldName := range schema {
+		if !containsField(unique, fieldName) {
+			fieldNames = append(fieldNames, fieldName)
+		}
+	}
+	sort.Strings(fieldNames)
+
+	updates := []string{"`id` = LAST_INSERT_ID(`id`)"}
+	for _, fieldName := range fieldNames {
+		safeFieldName := "`" + escapeIdentifier(fieldName) + "`"
+		updates = append(updates, safeFieldName+" = VALUES("+safeFieldName+")")
+	}
+
+	stmt := strings.TrimSuffix(insert, ";") +
+		" ON DUPLICATE KEY UPDATE " +
+		strings.Join(updates, ", ") +
+		";"
+
+	return stmt, args
+}
+
+// containsField reports whether the field names contain the field name
+func containsField(fieldNames []string, fieldName string) bool {
+	for _, name := range fieldNames {
+		if name == fieldName {
+			return true
+		}
+	}
+	return false
+}
+
+// escapeIdentifier prepares a table or column name to be safely used between
+// backticks in MySQL statements. Inside backticks, a backtick is the only
+// character with a special meaning, and it's escaped by doubling it. Other
+// characters, including backslashes and quotes, are taken literally, which is
+// why `Escape` can't be used for identifiers. Names that MySQL would reject or
+// alter should be checked with `CheckIdentifier` first.
+func escapeIdentifier(name string) string {
+	return strings.Replace(name, "`", "``", -1)
+}
+
+// maxIdentifierLength is the maximum number of characters in a MySQL table or
+// column name
+const maxIdentifierLength = 64
+
+// CheckIdentifier returns an error if the name can't be used as a MySQL table
+// or column name as is. MySQL identifiers can contain any character of the
+// Unicode Basic Multilingual Plane except NUL, can't end with a space and are
+// at most 64 characters long. Rather than letting MySQL fail, or silently
+// storing something other than what was asked for, these names are rejected.
+func CheckIdentifier(name string) error {
+	if name == "" {
+		return errors.New("identifier is empty")
+	}
+	if !utf8.ValidString(name) {
+		return errors.Errorf("identifier %q is not valid UTF-8", name)
+	}
+	if utf8.RuneCountInString(name) > maxIdentifierLength {
+		return errors.Errorf("identifier %q is longer than %d characters", name, maxIdentifierLength)
+	}
+	for _, r := range name {
+		if r == 0 {
+			return errors.Errorf("identifier %q contains a NUL character", name)
+		}
+		if r > 0xFFFF {
+			return errors.Errorf("identifier %q contains the character %q, which MySQL doesn't support in identifiers", name, r)
+		}
+	}
+	if strings.HasSuffix(name, " ") {
+		return errors.Errorf("identifier %q ends with a space", name)
+	}
+	return nil
+}
+
+// Escape prepares a string to be used between quotes as a MySQL string
+// literal, escaping the same characters as `mysql_real_escape_string`. It's
+// meant for values, and isn't safe for identifiers, where backticks are the
+// only special character (see `escapeIdentifier`). Note that it relies on
+// backslash escapes, so it isn't safe with the `NO_BACKSLASH_ESCAPES` SQL
+// mode. Values should be passed as bindvars wherever possible.
 func Escape(sql string) string {
 	dest := make([]byte, 0, 2*len(sql))
 	var escape byte
@@ -1,6 +1,7 @@
 package mysql_test
 
 import (
+	"strings"
 	"testing"
 
 	"github.com/kolide/databalancer-logan/pkg/mysql"
@@ -17,6 +18,7 @@ type createCase struct {
 	name      string
 	tableName string
 	schema    schema
+	unique    []string
 	statement string
 }
 
@@ -28,6 +30,61 @@ func TestCreateTableStatement(t *testing.T) {
 			schema:    schema{"name": "string", "breed": "string", "weight": "int"},
 			statement: "CREATE TABLE IF NOT EXISTS `dog_registry`(`id` INT NOT NULL AUTO_INCREMENT, `breed` TEXT, `name` TEXT, `weight` INT, PRIMARY KEY(`id`));",
 		},
+		{
+			name:      "string normalizations are stored as text",
+			tableName: "dog_registry",
+			schema:    schema{"name": "string:trim,lower"},
+			statement: "CREATE TABLE IF NOT EXISTS `dog_registry`(`id` INT NOT NULL AUTO_INCREMENT, `name` TEXT, PRIMARY KEY(`id`));",
+		},
+		{
+			name:      "strings with a length are stored as varchar",
+			tableName: "request_log",
+			schema:    schema{"status": "string(32)", "body": "string", "path": "string(255):trim"},
+			statement: "CREATE TABLE IF NOT EXISTS `request_log`(`id` INT NOT NULL AUTO_INCREMENT, `body` TEXT, `path` VARCHAR(255), `status` VARCHAR(32), PRIMARY KEY(`id`));",
+		},
+		{
+			name:      "decimals are stored with their precision and scale",
+			tableName: "orders",
+			schema:    schema{"price": "decimal(10,2)", "quantity": "decimal"},
+			statement: "CREATE TABLE IF NOT EXISTS `orders`(`id` INT NOT NULL AUTO_INCREMENT, `price` DECIMAL(10,2), `quantity` DECIMAL(10,0), PRIMARY KEY(`id`));",
+		},
+		{
+			name:      "json fields are stored as json",
+			tableName: "request_log",
+			schema:    schema{"geo": "json", "path": "string"},
+			statement: "CREATE TABLE IF NOT EXISTS `request_log`(`id` INT NOT NULL AUTO_INCREMENT, `geo` JSON, `path` TEXT, PRIMARY KEY(`id`));",
+		},
+		{
+			name:      "any fields are stored as commented json",
+			tableName: "request_log",
+			schema:    schema{"meta": "any", "tags": "any!"},
+			statement: "CREATE TABLE IF NOT EXISTS `request_log`(`id` INT NOT NULL AUTO_INCREMENT, `meta` JSON COMMENT 'databalancer:any', `tags` JSON NOT NULL COMMENT 'databalancer:any', PRIMARY KEY(`id`));",
+		},
+		{
+			name:      "required fields are not null",
+			tableName: "dog_registry",
+			schema:    schema{"name": "string!", "breed": "string(32)!:trim", "weight": "int"},
+			statement: "CREATE TABLE IF NOT EXISTS `dog_registry`(`id` INT NOT NULL AUTO_INCREMENT, `breed` VARCHAR(32) NOT NULL, `name` TEXT NOT NULL, `weight` INT, PRIMARY KEY(`id`));",
+		},
+		{
+			name:      "types are case-insensitive and trimmed",
+			tableName: "dog_registry",
+			schema:    schema{"name": "String", "weight": " int ", "age": "INT", "breed": " STRING(32)! "},
+			statement: "CREATE TABLE IF NOT EXISTS `dog_registry`(`id` INT NOT NULL AUTO_INCREMENT, `age` INT, `breed` VARCHAR(32) NOT NULL, `name` TEXT, `weight` INT, PRIMARY KEY(`id`));",
+		},
+		{
+			name:      "strings with an invalid length are left out",
+			tableName: "request_log",
+			schema:    schema{"status": "string(0)", "body": "string"},
+			statement: "CREATE TABLE IF NOT EXISTS `request_log`(`id` INT NOT NULL AUTO_INCREMENT, `body` TEXT, PRIMARY KEY(`id`));",
+		},
+		{
+			name:      "unique fields get a unique key in the order they're given",
+			tableName: "request_log",
+			schema:    schema{"request_id": "string(36)", "host": "string(255)", "status": "int"},
+			unique:    []string{"request_id", "host"},
+			statement: "CREATE TABLE IF NOT EXISTS `request_log`(`id` INT NOT NULL AUTO_INCREMENT, `host` VARCHAR(255), `request_id` VARCHAR(36), `status` INT, PRIMARY KEY(`id`), UNIQUE KEY `unique_key`(`request_id`, `host`));",
+		},
 		// NOTE: not sure if this is even desirable
 		{
 			name:      "can construct a create statement from an empty schema",
@@ -35,6 +92,18 @@ func TestCreateTableStatement(t *testing.T) {
 			schema:    schema{},
 			statement: "CREATE TABLE IF NOT EXISTS `cat_registry`(`id` INT NOT NULL AUTO_INCREMENT, PRIMARY KEY(`id`));",
 		},
+		{
+			name:      "keeps quotes, backslashes and multibyte characters in names as is",
+			tableName: "chien_registre",
+			schema:    schema{"naïve": "string", "it's": "int", `back\slash`: "string", "名前": "string"},
+			statement: "CREATE TABLE IF NOT EXISTS `chien_registre`(`id` INT NOT NULL AUTO_INCREMENT, `back\\slash` TEXT, `it's` INT, `naïve` TEXT, `名前` TEXT, PRIMARY KEY(`id`));",
+		},
+		{
+			name:      "doubles backticks in names rather than ending the identifier",
+			tableName: "dog`registry",
+			schema:    schema{"name` TEXT, `evil": "string", "`": "int"},
+			statement: "CREATE TABLE IF NOT EXISTS `dog``registry`(`id` INT NOT NULL AUTO_INCREMENT, ```` INT, `name`` TEXT, ``evil` TEXT, PRIMARY KEY(`id`));",
+		},
 		{
 			name:      "escapes attempts to inject sql",
 			tableName: "criminal_registry",
@@ -45,9 +114,157 @@ func TestCreateTableStatement(t *testing.T) {
 
 	for _, tt := range cases {
 		t.Run(tt.name, func(t *testing.T) {
-			assert.Equal(t, tt.statement, mysql.CreateTableStatement(tt.tableName, tt.schema))
+			assert.Equal(t, tt.statement, mysql.CreateTableStatement(tt.tableName, tt.schema, tt.unique))
+		})
+	}
+}
+
+// describes a test case for AlterTableStatement
+type alterCase struct {
+	name      string
+	tableName string
+	columns   map[string]string
+	schema    schema
+	statement string
+	err       bool
+}
+
+func TestAlterTableStatement(t *testing.T) {
+	cases := []alterCase{
+		{
+			name:      "adds the schema fields missing from the table",
+			tableName: "dog_registry",
+			columns:   map[string]string{"id": "int", "name": "text", "breed": "text"},
+			schema:    schema{"name": "string", "breed": "string", "age": "int", "owner": "string"},
+			statement: "ALTER TABLE `dog_registry` ADD COLUMN `age` INT, ADD COLUMN `owner` TEXT;",
+		},
+		{
+			name:      "does nothing when the table has every field",
+			tableName: "dog_registry",
+			columns:   map[string]string{"id": "int", "name": "text", "weight": "int"},
+			schema:    schema{"name": "string", "weight": "int"},
+			statement: "",
+		},
+		{
+			name:      "adds required fields as nullable columns",
+			tableName: "dog_registry",
+			columns:   map[string]string{"id": "int", "name": "text"},
+			schema:    schema{"name": "string!", "owner": "string!"},
+			statement: "ALTER TABLE `dog_registry` ADD COLUMN `owner` TEXT;",
+		},
+		{
+			name:      "adds strings with a length as varchar columns",
+			tableName: "request_log",
+			columns:   map[string]string{"id": "int", "status": "varchar"},
+			schema:    schema{"status": "string(64)", "method": "string(8)"},
+			statement: "ALTER TABLE `request_log` ADD COLUMN `method` VARCHAR(8);",
+		},
+		{
+			name:      "adds any fields as commented json columns",
+			tableName: "request_log",
+			columns:   map[string]string{"id": "int"},
+			schema:    schema{"meta": "any"},
+			statement: "ALTER TABLE `request_log` ADD COLUMN `meta` JSON COMMENT 'databalancer:any';",
+		},
+		{
+			name:      "returns an error when a string with a length conflicts with a text column",
+			tableName: "request_log",
+			columns:   map[string]string{"id": "int", "status": "text"},
+			schema:    schema{"status": "string(32)"},
+			err:       true,
+		},
+		{
+			name:      "doubles backticks in the names of the columns added",
+			tableName: "dog`registry",
+			columns:   map[string]string{"id": "int"},
+			schema:    schema{"owner`; DROP TABLE users; --": "string"},
+			statement: "ALTER TABLE `dog``registry` ADD COLUMN `owner``; DROP TABLE users; --` TEXT;",
+		},
+		{
+			name:      "returns an error when a field conflicts with the type of a column",
+			tableName: "dog_registry",
+			columns:   map[string]string{"id": "int", "name": "text", "weight": "text"},
+			schema:    schema{"name": "string", "weight": "int"},
+			err:       true,
+		},
+	}
+
+	for _, tt := range cases {
+		t.Run(tt.name, func(t *testing.T) {
+			stmt, err := mysql.AlterTableStatement(tt.tableName, tt.columns, tt.schema)
+			if tt.err {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tt.statement, stmt)
+		})
+	}
+}
+
+func TestFieldTypeOf(t *testing.T) {
+	cases := []struct {
+		columnType string
+		comment    string
+		nullable   bool
+		fieldType  string
+		ok         bool
+	}{
+		{columnType: "text", nullable: true, fieldType: "string", ok: true},
+		{columnType: "varchar(32)", nullable: true, fieldType: "string(32)", ok: true},
+		{columnType: "int", nullable: true, fieldType: "int", ok: true},
+		{columnType: "int(11)", nullable: true, fieldType: "int", ok: true},
+		{columnType: "bigint", nullable: true, fieldType: "bigint", ok: true},
+		{columnType: "bigint(20)", nullable: false, fieldType: "bigint!", ok: true},
+		{columnType: "decimal(10,2)", nullable: true, fieldType: "decimal(10,2)", ok: true},
+		{columnType: "json", nullable: true, fieldType: "json", ok: true},
+		{columnType: "json", comment: "databalancer:any", nullable: true, fieldType: "any", ok: true},
+		{columnType: "json", comment: "databalancer:any", nullable: false, fieldType: "any!", ok: true},
+		{columnType: "text", comment: "databalancer:any", nullable: true, fieldType: "string", ok: true},
+		{columnType: "VARCHAR(255)", nullable: false, fieldType: "string(255)!", ok: true},
+		{columnType: "datetime", nullable: true},
+		{columnType: "double", nullable: true},
+	}
+
+	for _, tt := range cases {
+		t.Run(tt.columnType+" "+tt.comment, func(t *testing.T) {
+			fieldType, ok := mysql.FieldTypeOf(tt.columnType, tt.comment, tt.nullable)
+			assert.Equal(t, tt.ok, ok)
+			assert.Equal(t, tt.fieldType, fieldType)
 		})
 	}
+
+	// AND a field type round trips through its column type
+	for _, fieldType := range []string{"string", "string(64)", "int", "bigint", "decimal(10,2)", "json"} {
+		columnType, ok := mysql.ColumnType(fieldType)
+		assert.True(t, ok)
+		roundTripped, ok := mysql.FieldTypeOf(columnType, "", true)
+		assert.True(t, ok)
+		assert.Equal(t, fieldType, roundTripped)
+	}
+}
+
+func TestDropTableStatement(t *testing.T) {
+	assert.Equal(t, "DROP TABLE IF EXISTS `dog_registry`;", mysql.DropTableStatement("dog_registry"))
+	assert.Equal(t, "DROP TABLE IF EXISTS `dog``; DROP TABLE users; --`;", mysql.DropTableStatement("dog`; DROP TABLE users; --"))
+}
+
+func TestTruncateTableStatement(t *testing.T) {
+	assert.Equal(t, "TRUNCATE TABLE `dog_registry`;", mysql.TruncateTableStatement("dog_registry"))
+	assert.Equal(t, "TRUNCATE TABLE `dog``; DROP TABLE users; --`;", mysql.TruncateTableStatement("dog`; DROP TABLE users; --"))
+}
+
+func TestAddUniqueKeyStatement(t *testing.T) {
+	assert.Equal(t, "ALTER TABLE `request_log` ADD UNIQUE KEY `unique_key`(`request_id`);", mysql.AddUniqueKeyStatement("request_log", []string{"request_id"}))
+	assert.Equal(t, "ALTER TABLE `dog``registry` ADD UNIQUE KEY `unique_key`(`name``)`, `weight`);", mysql.AddUniqueKeyStatement("dog`registry", []string{"name`)", "weight"}))
+}
+
+func TestCheckUniqueKey(t *testing.T) {
+	schema := schema{"request_id": "string(36)!", "status": "int", "body": "string"}
+	assert.NoError(t, mysql.CheckUniqueKey(schema, nil))
+	assert.NoError(t, mysql.CheckUniqueKey(schema, []string{"request_id", "status"}))
+	assert.EqualError(t, mysql.CheckUniqueKey(schema, []string{"body"}), "unique field body must be a string with a length, ie: string(255)")
+	assert.EqualError(t, mysql.CheckUniqueKey(schema, []string{"path"}), "unique field path is not in the schema")
 }
 
 // describes a test case for InsertTableStatement
@@ -78,6 +295,74 @@ func TestInsertTableStatement(t *testing.T) {
 				"bulldog", "spike", float64(80),
 			},
 		},
+
+		{
+			name:      "values of fields with quotes and multibyte characters in their names line up",
+			tableName: "dog_registry",
+			schema:    schema{"it's": "string", "名前": "string"},
+			records: records{
+				record{"it's": "a dog", "名前": "ポチ"},
+			},
+			statement: "INSERT INTO `dog_registry`(`it's`, `名前`) VALUES (?, ?);",
+			args:      []interface{}{"a dog", "ポチ"},
+		},
+		{
+			name:      "missing optional fields are inserted as null",
+			tableName: "dog_registry",
+			schema:    schema{"name": "string", "weight": "int"},
+			records: records{
+				record{"name": "max"},
+				record{"name": "spot", "weight": float64(130)},
+			},
+			statement: "INSERT INTO `dog_registry`(`name`, `weight`) VALUES (?, ?), (?, ?);",
+			args:      []interface{}{"max", nil, "spot", float64(130)},
+		},
+		{
+			name:      "values after a missing or null field stay in their columns",
+			tableName: "dog_registry",
+			schema:    schema{"name": "string", "breed": "string", "weight": "int"},
+			records: records{
+				record{"breed": "husky", "weight": float64(130)},
+				record{"name": nil, "breed": "bulldog", "weight": float64(80)},
+			},
+			statement: "INSERT INTO `dog_registry`(`breed`, `name`, `weight`) VALUES (?, ?, ?), (?, ?, ?);",
+			args:      []interface{}{"husky", nil, float64(130), "bulldog", nil, float64(80)},
+		},
+		{
+			name:      "doubles backticks in names rather than ending the identifier",
+			tableName: "dog`registry",
+			schema:    schema{"name`) VALUES (1); --": "string", "weight": "int"},
+			records: records{
+				record{"name`) VALUES (1); --": "max", "weight": float64(3)},
+			},
+			statement: "INSERT INTO `dog``registry`(`name``) VALUES (1); --`, `weight`) VALUES (?, ?);",
+			args:      []interface{}{"max", float64(3)},
+		},
+		{
+			name:      "objects and arrays of json fields are bound as json text",
+			tableName: "request_log",
+			schema:    schema{"geo": "json", "tags": "json", "path": "string"},
+			records: records{
+				record{"geo": map[string]interface{}{"lat": 1.0, "lng": 2.0}, "tags": []interface{}{"a", "b"}, "path": "/"},
+				record{"path": "/about"},
+			},
+			statement: "INSERT INTO `request_log`(`geo`, `path`, `tags`) VALUES (?, ?, ?), (?, ?, ?);",
+			args:      []interface{}{`{"lat":1,"lng":2}`, "/", `["a","b"]`, nil, "/about", nil},
+		},
+		{
+			name:      "values of any fields are bound as json text",
+			tableName: "request_log",
+			schema:    schema{"meta": "any"},
+			records: records{
+				record{"meta": "Boston"},
+				record{"meta": float64(3)},
+				record{"meta": true},
+				record{"meta": nil},
+				record{"meta": map[string]interface{}{"lat": 1.0}},
+			},
+			statement: "INSERT INTO `request_log`(`meta`) VALUES (?), (?), (?), (?), (?);",
+			args:      []interface{}{`"Boston"`, "3", "true", nil, `{"lat":1}`},
+		},
 	}
 
 	for _, tt := range cases {
@@ -88,3 +373,120 @@ func TestInsertTableStatement(t *testing.T) {
 		})
 	}
 }
+
+// describes a test case for Escape
+type escapeCase struct {
+	name    string
+	value   string
+	escaped string
+}
+
+func TestEscape(t *testing.T) {
+	cases := []escapeCase{
+		{name: "a plain value is unchanged", value: "dog_registry", escaped: "dog_registry"},
+		{name: "an empty value is unchanged", value: "", escaped: ""},
+		{name: "multibyte characters are unchanged", value: "名前", escaped: "名前"},
+		{name: "backticks are unchanged", value: "dog`registry", escaped: "dog`registry"},
+		{name: "NUL bytes are escaped", value: "dog\x00registry", escaped: `dog\0registry`},
+		{name: "newlines are escaped", value: "dog\nregistry", escaped: `dog\nregistry`},
+		{name: "carriage returns are escaped", value: "dog\rregistry", escaped: `dog\rregistry`},
+		{name: "backslashes are escaped", value: `back\slash`, escaped: `back\\slash`},
+		{name: "single quotes are escaped", value: "it's", escaped: `it\'s`},
+		{name: "double quotes are escaped", value: `"quoted"`, escaped: `\"quoted\"`},
+		{name: "Ctrl-Z is escaped", value: "dog\x1aregistry", escaped: `dog\Zregistry`},
+		{name: "a quote can't end the literal", value: "'; DROP TABLE users; --", escaped: `\'; DROP TABLE users; --`},
+		{name: "a backslash can't escape the escaped quote", value: `\'; DROP TABLE users; --`, escaped: `\\\'; DROP TABLE users; --`},
+		{name: "a double quoted literal can't be ended", value: `" OR "1"="1`, escaped: `\" OR \"1\"=\"1`},
+		{name: "combined control characters are all escaped", value: "'\x00\n\r\\\"\x1a'", escaped: `\'\0\n\r\\\"\Z\'`},
+	}
+
+	for _, tt := range cases {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.escaped, mysql.Escape(tt.value))
+		})
+	}
+}
+
+// describes a test case for CheckIdentifier
+type identifierCase struct {
+	name       string
+	identifier string
+	valid      bool
+}
+
+func TestCheckIdentifier(t *testing.T) {
+	cases := []identifierCase{
+		{name: "a plain name is valid", identifier: "dog_registry", valid: true},
+		{name: "accented characters are valid", identifier: "naïve", valid: true},
+		{name: "multibyte characters are valid", identifier: "名前", valid: true},
+		{name: "quotes are valid", identifier: `it's "quoted"`, valid: true},
+		{name: "backslashes are valid", identifier: `back\slash`, valid: true},
+		{name: "64 characters are valid", identifier: strings.Repeat("a", 64), valid: true},
+		{name: "an empty name is rejected", identifier: ""},
+		{name: "emoji are rejected", identifier: "dog_🐶"},
+		{name: "NUL bytes are rejected", identifier: "dog\x00registry"},
+		{name: "invalid UTF-8 is rejected", identifier: "dog\xffregistry"},
+		{name: "a truncated multibyte sequence is rejected", identifier: "名前"[:4]},
+		{name: "a trailing space is rejected", identifier: "dog_registry "},
+		{name: "65 characters are rejected", identifier: strings.Repeat("a", 65)},
+	}
+
+	for _, tt := range cases {
+		t.Run(tt.name, func(t *testing.T) {
+			err := mysql.CheckIdentifier(tt.identifier)
+			if tt.valid {
+				assert.NoError(t, err)
+			} else {
+				assert.Error(t, err)
+			}
+		})
+	}
+}
+
+func TestUpsertTableStatement(t *testing.T) {
+	cases := []struct {
+		name      string
+		schema    schema
+		unique    []string
+		records   records
+		statement string
+		args      []interface{}
+	}{
+		{
+			name:   "the fields outside of the unique key are updated",
+			schema: schema{"request_id": "string(36)", "status": "int", "path": "string"},
+			unique: []string{"request_id"},
+			records: records{
+				record{"request_id": "a1", "status": float64(200), "path": "/"},
+				record{"request_id": "b2", "path": "/dogs"},
+			},
+			statement: "INSERT INTO `request_log`(`path`, `request_id`, `status`) VALUES (?, ?, ?), (?, ?, ?) " +
+				"ON DUPLICATE KEY UPDATE `id` = LAST_INSERT_ID(`id`), `path` = VALUES(`path`), `status` = VALUES(`status`);",
+			args: []interface{}{"/", "a1", float64(200), "/dogs", "b2", nil},
+		},
+		{
+			name:      "only the id is kept when every field is in the unique key",
+			schema:    schema{"request_id": "string(36)", "status": "int"},
+			unique:    []string{"status", "request_id"},
+			records:   records{record{"request_id": "a1", "status": float64(200)}},
+			statement: "INSERT INTO `request_log`(`request_id`, `status`) VALUES (?, ?) ON DUPLICATE KEY UPDATE `id` = LAST_INSERT_ID(`id`);",
+			args:      []interface{}{"a1", float64(200)},
+		},
+		{
+			name:      "doubles backticks in the names of updated fields",
+			schema:    schema{"request_id": "string(36)", "st`atus": "int"},
+			unique:    []string{"request_id"},
+			records:   records{record{"request_id": "a1", "st`atus": float64(200)}},
+			statement: "INSERT INTO `request_log`(`request_id`, `st``atus`) VALUES (?, ?) ON DUPLICATE KEY UPDATE `id` = LAST_INSERT_ID(`id`), `st``atus` = VALUES(`st``atus`);",
+			args:      []interface{}{"a1", float64(200)},
+		},
+	}
+
+	for _, tt := range cases {
+		t.Run(tt.name, func(t *testing.T) {
+			stmt, args := mysql.UpsertTableStatement("request_log", tt.schema, tt.unique, tt.records)
+			assert.Equal(t, tt.statement, stmt)
+			assert.Equal(t, tt.args, args)
+		})
+	}
+}
@@ -0,0 +1,135 @@
+package mysql
+
+import (
+	"container/list"
+	"context"
+	"database/sql"
+	"sync"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/pkg/errors"
+)
+
+// DefaultStatementCacheSize is the maximum number of prepared statements
+// kept by a client, unless configured otherwise with `WithStatementCacheSize`.
+// A statement is prepared on each connection it's used on, so MySQL holds up
+// to this many statements per open connection, which counts towards its
+// `max_prepared_stmt_count`.
+const DefaultStatementCacheSize = 100
+
+// WithStatementCacheSize sets the maximum number of prepared statements kept
+// by the client, so that repeated queries and inserts skip preparing their
+// statement. The least recently used statement is closed once the cache is
+// full. A size of 0 or less disables the cache.
+func WithStatementCacheSize(n int) Option {
+	return func(c *Client) {
+		c.stmtCacheSize = n
+	}
+}
+
+// stmtCache is a bounded cache of prepared statements keyed by their SQL,
+// which closes the least recently used statement when it's full. A nil cache
+// prepares a new statement each time.
+type stmtCache struct {
+	mu    sync.Mutex
+	size  int                      // maximum number of statements
+	stmts map[string]*list.Element // SQL -> element of order holding the statement
+	order *list.List               // cached statements, most recently used first
+}
+
+// cachedStmt is a statement held by a `stmtCache`
+type cachedStmt struct {
+	query string
+	stmt  *sqlx.Stmt
+}
+
+// newStmtCache returns a cache of at most size statements, or nil if size
+// isn't positive
+func newStmtCache(size int) *stmtCache {
+	if size <= 0 {
+		return nil
+	}
+	return &stmtCache{
+		size:  size,
+		stmts: make(map[string]*list.Element),
+		order: list.New(),
+	}
+}
+
+// prepare returns a prepared statement for the query, and a func to call once
+// done with it. A cached statement is reused rather than prepared again, and
+// is only closed once it's evicted, after the queries in progress are done.
+func (c *stmtCache) prepare(ctx context.Context, db *sqlx.DB, query string) (*sqlx.Stmt, func(), error) {
+	if c == nil {
+		stmt, err := db.PreparexContext(ctx, query)
+		if err != nil {
+			return nil, nil, err
+		}
+		return stmt, func() { stmt.Close() }, nil
+	}
+
+	c.mu.Lock()
+	if elem, ok := c.stmts[query]; ok {
+		c.order.MoveToFront(elem)
+		c.mu.Unlock()
+		return elem.Value.(*cachedStmt).stmt, func() {}, nil
+	}
+	c.mu.Unlock()
+
+	// prepare the statement without holding the lock, since it's a round
+	// trip to MySQL
+	stmt, err := db.PreparexContext(ctx, query)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	// the statement may have been cached while it was prepared
+	if elem, ok := c.stmts[query]; ok {
+		stmt.Close()
+		c.order.MoveToFront(elem)
+		return elem.Value.(*cachedStmt).stmt, func() {}, nil
+	}
+	c.stmts[query] = c.order.PushFront(&cachedStmt{query: query, stmt: stmt})
+	for c.order.Len() > c.size {
+		oldest := c.order.Remove(c.order.Back()).(*cachedStmt)
+		delete(c.stmts, oldest.query)
+		oldest.stmt.Close()
+	}
+	return stmt, func() {}, nil
+}
+
+// exec executes the query with the args in the transaction, with a statement
+// from the cache
+func (c *stmtCache) exec(ctx context.Context, db *sqlx.DB, tx *sqlx.Tx, query string, args ...interface{}) (sql.Result, error) {
+	if c == nil {
+		return tx.ExecContext(ctx, query, args...)
+	}
+	stmt, release, err := c.prepare(ctx, db, query)
+	if err != nil {
+		return nil, errors.Wrap(err, "preparing statement")
+	}
+	defer release()
+	// the statement is prepared again on the connection of the transaction,
+	// unless it was already prepared on it
+	return tx.StmtxContext(ctx, stmt).ExecContext(ctx, args...)
+}
+
+// close closes and removes all of the statements of the cache
+func (c *stmtCache) close() error {
+	if c == nil {
+		return nil
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	var firstErr error
+	for elem := c.order.Front(); elem != nil; elem = elem.Next() {
+		if err := elem.Value.(*cachedStmt).stmt.Close(); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	c.stmts = make(map[string]*list.Element)
+	c.order.Init()
+	return firstErr
+}
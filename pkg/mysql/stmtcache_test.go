@@ -0,0 +1,102 @@
+package mysql_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/kolide/databalancer-logan/pkg/mysql"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestStatementCache(t *testing.T) {
+	t.Run("a repeated query is prepared once", func(t *testing.T) {
+		// GIVEN
+		db := &fakeDB{}
+		client := mysql.ClientFromDB(db.open())
+
+		// WHEN
+		for i := 0; i < 3; i++ {
+			_, _, err := client.QueryJSON(context.Background(), "SELECT * FROM `dog_registry`")
+			assert.NoError(t, err)
+		}
+
+		// THEN
+		assert.Equal(t, 1, db.prepares)
+		assert.Equal(t, 0, db.stmtCloses)
+	})
+
+	t.Run("a repeated query is prepared each time without a cache", func(t *testing.T) {
+		// GIVEN
+		db := &fakeDB{}
+		client := mysql.ClientFromDB(db.open(), mysql.WithStatementCacheSize(0))
+
+		// WHEN
+		for i := 0; i < 3; i++ {
+			_, _, err := client.QueryJSON(context.Background(), "SELECT * FROM `dog_registry`")
+			assert.NoError(t, err)
+		}
+
+		// THEN
+		assert.Equal(t, 3, db.prepares)
+		assert.Equal(t, 3, db.stmtCloses)
+	})
+
+	t.Run("the least recently used statement is closed once the cache is full", func(t *testing.T) {
+		// GIVEN
+		db := &fakeDB{}
+		client := mysql.ClientFromDB(db.open(), mysql.WithStatementCacheSize(2))
+
+		// WHEN
+		for _, query := range []string{
+			"SELECT * FROM `dog_registry`",
+			"SELECT * FROM `cat_registry`",
+			"SELECT * FROM `dog_registry`",
+			"SELECT * FROM `bird_registry`",
+			"SELECT * FROM `dog_registry`",
+		} {
+			_, _, err := client.QueryJSON(context.Background(), query)
+			assert.NoError(t, err)
+		}
+
+		// THEN only the cat query was evicted
+		assert.Equal(t, 3, db.prepares)
+		assert.Equal(t, 1, db.stmtCloses)
+	})
+
+	t.Run("the statements are closed with the client", func(t *testing.T) {
+		// GIVEN
+		db := &fakeDB{}
+		client := mysql.ClientFromDB(db.open())
+		_, _, err := client.QueryJSON(context.Background(), "SELECT * FROM `dog_registry`")
+		assert.NoError(t, err)
+
+		// WHEN
+		err = client.Close()
+
+		// THEN
+		assert.NoError(t, err)
+		assert.Equal(t, 1, db.stmtCloses)
+	})
+}
+
+func BenchmarkRepeatedQuery(b *testing.B) {
+	for _, bm := range []struct {
+		name string
+		size int
+	}{
+		{name: "uncached", size: 0},
+		{name: "cached", size: mysql.DefaultStatementCacheSize},
+	} {
+		b.Run(bm.name, func(b *testing.B) {
+			db := &fakeDB{}
+			client := mysql.ClientFromDB(db.open(), mysql.WithStatementCacheSize(bm.size))
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				if _, _, err := client.QueryJSON(context.Background(), "SELECT * FROM `dog_registry`"); err != nil {
+					b.Fatal(err)
+				}
+			}
+			b.ReportMetric(float64(db.prepares)/float64(b.N), "prepares/op")
+		})
+	}
+}
@@ -0,0 +1,36 @@
+package mysql
+
+import (
+	gomysql "github.com/go-sql-driver/mysql"
+	"github.com/kolide/databalancer-logan/pkg/logs"
+	"github.com/pkg/errors"
+)
+
+// the numbers of the errors MySQL refuses a connection with when it has too
+// many of them
+const (
+	errConCount               = 1040 // ER_CON_COUNT_ERROR, past max_connections
+	errTooManyUserConnections = 1203 // ER_TOO_MANY_USER_CONNECTIONS, past max_user_connections
+)
+
+// unavailable marks an error as `logs.ErrUnavailable`, keeping its message,
+// if MySQL refused the connection it was returned for because it has too many
+// connections, since the request can be retried once they're released. Any
+// other error is returned as is.
+func unavailable(err error) error {
+	mysqlErr, ok := errors.Cause(err).(*gomysql.MySQLError)
+	if !ok || (mysqlErr.Number != errConCount && mysqlErr.Number != errTooManyUserConnections) {
+		return err
+	}
+	return &unavailableError{err}
+}
+
+// unavailableError is an error of MySQL having too many connections
+type unavailableError struct {
+	error
+}
+
+// Cause implements the causer interface of `github.com/pkg/errors`
+func (e *unavailableError) Cause() error {
+	return logs.ErrUnavailable
+}
@@ -0,0 +1,67 @@
+package mysql_test
+
+import (
+	"context"
+	"database/sql/driver"
+	"testing"
+
+	gomysql "github.com/go-sql-driver/mysql"
+	"github.com/kolide/databalancer-logan/pkg/logs"
+	"github.com/kolide/databalancer-logan/pkg/mysql"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTooManyConnections(t *testing.T) {
+	// GIVEN a MySQL server out of connections
+	tooMany := &gomysql.MySQLError{Number: 1040, Message: "Too many connections"}
+	db := &fakeDB{
+		exec: func(ctx context.Context, query string, args []interface{}) (driver.Result, error) {
+			return nil, tooMany
+		},
+		query: func(ctx context.Context, query string, args []interface{}) (driver.Rows, error) {
+			return nil, tooMany
+		},
+	}
+	client := mysql.ClientFromDB(db.open())
+
+	t.Run("a query is unavailable", func(t *testing.T) {
+		// WHEN
+		_, _, err := client.QueryJSON(context.Background(), "SELECT * FROM dog_registry")
+
+		// THEN the error can be retried, and keeps the message of MySQL
+		assert.Equal(t, logs.ErrUnavailable, logs.Kind(err))
+		assert.Contains(t, err.Error(), "Error 1040: Too many connections")
+	})
+
+	t.Run("creating a table is unavailable", func(t *testing.T) {
+		// WHEN
+		_, err := client.CreateTable(context.Background(), "dog_registry", logs.Schema{"name": "string"}, nil)
+
+		// THEN
+		assert.Equal(t, logs.ErrUnavailable, logs.Kind(err))
+	})
+
+	t.Run("an insert is unavailable", func(t *testing.T) {
+		// WHEN
+		table := &mysql.Table{DB: db.open(), Name: "dog_registry", Schema: schema{"name": "string"}}
+		_, err := table.Insert(context.Background(), logs.JSON{record{"name": "max"}})
+
+		// THEN
+		assert.Equal(t, logs.ErrUnavailable, logs.Kind(err))
+	})
+
+	t.Run("other errors of MySQL are database errors", func(t *testing.T) {
+		// GIVEN
+		db := &fakeDB{
+			query: func(ctx context.Context, query string, args []interface{}) (driver.Rows, error) {
+				return nil, &gomysql.MySQLError{Number: 1146, Message: "Table 'databalancer.dog_registry' doesn't exist"}
+			},
+		}
+
+		// WHEN
+		_, _, err := mysql.ClientFromDB(db.open()).QueryJSON(context.Background(), "SELECT * FROM dog_registry")
+
+		// THEN
+		assert.Equal(t, logs.ErrDatabase, logs.Kind(err))
+	})
+}
@@ -0,0 +1,554 @@
+// Package postgres stores logs in a PostgreSQL database, with a table per
+// log family.
+//
+// NOTE: the package uses the "postgres" database/sql driver, which isn't
+// vendored yet. A driver registering that name, like github.com/lib/pq,
+// has to be imported by the binary for `CreateClient` to connect.
+package postgres
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"log/slog"
+	"net/url"
+	"strings"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/kolide/databalancer-logan/pkg/logs"
+	"github.com/pkg/errors"
+)
+
+// Client is a connection to a Postgres database
+type Client struct {
+	*sqlx.DB               // underlying database
+	batchSize int          // maximum number of records per insert statement
+	logger    *slog.Logger // logger of the client
+}
+
+// Option configures optional behavior of a `Client`
+type Option func(*Client)
+
+// WithBatchSize sets the maximum number of records inserted per statement
+func WithBatchSize(n int) Option {
+	return func(c *Client) {
+		c.batchSize = n
+	}
+}
+
+// WithLogger sets the logger of the client, which is `slog.Default()` by
+// default
+func WithLogger(logger *slog.Logger) Option {
+	return func(c *Client) {
+		c.logger = logger
+	}
+}
+
+// Table defines methods for inserting logs into that table
+type Table struct {
+	*sqlx.DB                    // database for table
+	Name      string            // table name
+	Schema    map[string]string // schema of the table from request
+	BatchSize int               // maximum number of records per insert statement, defaults to DefaultBatchSize
+}
+
+// DefaultBatchSize is the maximum number of records inserted per statement,
+// unless configured otherwise with `WithBatchSize`
+const DefaultBatchSize = 1000
+
+// maxPlaceholders is the maximum number of placeholders per insert
+// statement. Postgres rejects statements with more than 65535 parameters.
+const maxPlaceholders = 60000
+
+// CreateClient makes a new Postgres database client and ensures that it's connected
+func CreateClient(username, password, address, name string, opts ...Option) (*Client, error) {
+	connectionURL := url.URL{
+		Scheme:   "postgres",
+		User:     url.UserPassword(username, password),
+		Host:     address,
+		Path:     "/" + name,
+		RawQuery: "sslmode=disable",
+	}
+	db, err := sqlx.Open("postgres", connectionURL.String())
+	if err != nil {
+		return nil, errors.Wrap(err, "opening database")
+	}
+
+	// Now, we ensure that can communicate with the database
+	if err = db.Ping(); err != nil {
+		return nil, errors.Wrap(err, "pinging database")
+	}
+
+	c := ClientFromDB(db, opts...)
+	c.logger.Info("connected to Postgres", "username", username, "address", address)
+	return c, nil
+}
+
+// ClientFromDB makes a new Postgres database client from an open database
+func ClientFromDB(db *sqlx.DB, opts ...Option) *Client {
+	c := &Client{DB: db, logger: slog.Default()}
+	for _, opt := range opts {
+		opt(c)
+	}
+	return c
+}
+
+// CreateTable creates the table (if it doesn't exist) based on the given
+// attributes with the client, adding any columns it's missing. Unique keys
+// aren't supported yet, so unique fields are rejected.
+func (c *Client) CreateTable(ctx context.Context, name logs.Family, schema logs.Schema, unique []string) (logs.Table, error) {
+	if len(unique) > 0 {
+		return nil, errors.Errorf("can't create %s table with a unique key, which isn't supported by Postgres", name)
+	}
+	// make sure the names can be used as is, rather than being truncated
+	if err := CheckIdentifier(name.String()); err != nil {
+		return nil, errors.Wrap(err, "checking table name")
+	}
+	for fieldName := range schema {
+		if err := CheckIdentifier(fieldName); err != nil {
+			return nil, errors.Wrapf(err, "checking column name of %s table", name)
+		}
+	}
+
+	// create the table
+	if _, err := c.ExecContext(ctx, CreateTableStatement(name.String(), schema)); err != nil {
+		return nil, errors.Wrapf(err, "creating %s table", name)
+	}
+
+	// the table may have existed with fewer columns, so add any new fields
+	columns, err := c.tableColumns(ctx, name.String())
+	if err != nil {
+		return nil, errors.Wrapf(err, "finding columns of %s table", name)
+	}
+	alter, err := AlterTableStatement(name.String(), columns, schema)
+	if err != nil {
+		return nil, errors.Wrapf(err, "altering %s table", name)
+	}
+	if alter != "" {
+		if _, err := c.ExecContext(ctx, alter); err != nil {
+			return nil, errors.Wrapf(err, "altering %s table", name)
+		}
+	}
+
+	return &Table{DB: c.DB, Name: name.String(), Schema: schema, BatchSize: c.batchSize}, nil
+}
+
+// CreateTableDDL returns the statement `CreateTable` runs to create the table
+// of a family, without running it
+func (c *Client) CreateTableDDL(name logs.Family, schema logs.Schema, unique []string) (string, error) {
+	if len(unique) > 0 {
+		return "", errors.Errorf("can't create %s table with a unique key, which isn't supported by Postgres", name)
+	}
+	if err := CheckIdentifier(name.String()); err != nil {
+		return "", errors.Wrap(err, "checking table name")
+	}
+	for fieldName := range schema {
+		if err := CheckIdentifier(fieldName); err != nil {
+			return "", errors.Wrapf(err, "checking column name of %s table", name)
+		}
+	}
+	return CreateTableStatement(name.String(), schema), nil
+}
+
+// DropTable drops the table of a family, returning `logs.ErrFamilyNotFound`
+// if it doesn't exist
+func (c *Client) DropTable(ctx context.Context, name logs.Family) error {
+	if err := CheckIdentifier(name.String()); err != nil {
+		return logs.ErrFamilyNotFound
+	}
+
+	columns, err := c.tableColumns(ctx, name.String())
+	if err != nil {
+		return errors.Wrapf(err, "finding %s table", name)
+	}
+	if len(columns) == 0 {
+		return logs.ErrFamilyNotFound
+	}
+
+	if _, err := c.ExecContext(ctx, DropTableStatement(name.String())); err != nil {
+		return errors.Wrapf(err, "dropping %s table", name)
+	}
+	return nil
+}
+
+// TruncateTable deletes the rows of the table of a family, keeping the table,
+// returning `logs.ErrFamilyNotFound` if it doesn't exist
+func (c *Client) TruncateTable(ctx context.Context, name logs.Family) error {
+	if err := CheckIdentifier(name.String()); err != nil {
+		return logs.ErrFamilyNotFound
+	}
+
+	columns, err := c.tableColumns(ctx, name.String())
+	if err != nil {
+		return errors.Wrapf(err, "finding %s table", name)
+	}
+	if len(columns) == 0 {
+		return logs.ErrFamilyNotFound
+	}
+
+	if _, err := c.ExecContext(ctx, TruncateTableStatement(name.String())); err != nil {
+		return errors.Wrapf(err, "truncating %s table", name)
+	}
+	return nil
+}
+
+// CountRows returns the number of rows of the table of a family, returning
+// `logs.ErrFamilyNotFound` if it doesn't exist
+func (c *Client) CountRows(ctx context.Context, name logs.Family) (int64, error) {
+	if err := CheckIdentifier(name.String()); err != nil {
+		return 0, logs.ErrFamilyNotFound
+	}
+
+	columns, err := c.tableColumns(ctx, name.String())
+	if err != nil {
+		return 0, errors.Wrapf(err, "finding %s table", name)
+	}
+	if len(columns) == 0 {
+		return 0, logs.ErrFamilyNotFound
+	}
+
+	var count int64
+	if err := c.GetContext(ctx, &count, CountStatement(name.String())); err != nil {
+		return 0, errors.Wrapf(err, "counting %s table", name)
+	}
+	return count, nil
+}
+
+// TableSchema returns the schema of the table of a family, read from the
+// types of its columns, or nil if the family doesn't have a table. The
+// generated `id` column is left out, as are columns of types that logs can't
+// be ingested with.
+func (c *Client) TableSchema(ctx context.Context, name logs.Family) (logs.Schema, error) {
+	if err := CheckIdentifier(name.String()); err != nil {
+		return nil, nil
+	}
+
+	var columnDescriptions []struct {
+		Column    string        // column name
+		Datatype  string        // column data type
+		Comment   string        // column comment, which tells `any` fields apart
+		Precision sql.NullInt64 // precision of numeric columns
+		Scale     sql.NullInt64 // scale of numeric columns
+		Nullable  string        // YES/NO if column nullable
+	}
+	err := c.SelectContext(ctx, &columnDescriptions,
+		`SELECT column_name AS "column", `+
+			`data_type AS "datatype", `+
+			columnCommentSelect+`AS "comment", `+
+			`numeric_precision AS "precision", `+
+			`numeric_scale AS "scale", `+
+			`is_nullable AS "nullable" `+
+			`FROM information_schema.columns `+
+			`WHERE table_schema = current_schema() AND table_name = $1`,
+		name.String())
+	if err != nil {
+		return nil, errors.Wrapf(err, "describing table %s", name)
+	}
+	if len(columnDescriptions) == 0 {
+		return nil, nil
+	}
+
+	schema := logs.Schema{}
+	for _, columnDescription := range columnDescriptions {
+		if columnDescription.Column == "id" {
+			continue
+		}
+		fieldType, ok := FieldTypeOf(columnDescription.Datatype, columnDescription.Comment,
+			int(columnDescription.Precision.Int64), int(columnDescription.Scale.Int64),
+			columnDescription.Nullable == "YES")
+		if ok {
+			schema[columnDescription.Column] = fieldType
+		}
+	}
+	return schema, nil
+}
+
+// columnCommentSelect selects the comment of a column of
+// information_schema.columns, which doesn't have it, or an empty string if
+// the column doesn't have one
+const columnCommentSelect = `COALESCE(col_description(format('%I.%I', table_schema, table_name)::regclass, ordinal_position), '') `
+
+// tableColumns returns the existing columns of a table in the current schema,
+// mapped to their type
+func (c *Client) tableColumns(ctx context.Context, name string) (map[string]string, error) {
+	var columnDescriptions []struct {
+		Column   string // column name
+		Datatype string // column data type
+	}
+	err := c.SelectContext(ctx, &columnDescriptions,
+		`SELECT column_name AS "column", `+
+			`data_type AS "datatype" `+
+			`FROM information_schema.columns `+
+			`WHERE table_schema = current_schema() AND table_name = $1`,
+		name)
+	if err != nil {
+		return nil, errors.Wrapf(err, "describing table %s", name)
+	}
+
+	columns := make(map[string]string)
+	for _, columnDescription := range columnDescriptions {
+		columns[columnDescription.Column] = columnDescription.Datatype
+	}
+	return columns, nil
+}
+
+// Insert creates new logs in the supplied table, in batches of at most
+// `BatchSize` records per statement, in a single transaction. It returns the
+// number of rows inserted.
+func (t *Table) Insert(ctx context.Context, logs logs.JSON) (int64, error) {
+	batches := batch(logs, batchSize(len(t.Schema), t.BatchSize))
+	var inserted int64
+	err := inTransaction(ctx, t.DB, func(tx *sqlx.Tx) error {
+		for i, records := range batches {
+			// construct insert statement
+			insert, args := InsertTableStatement(t.Name, t.Schema, records)
+
+			// insert the data
+			result, err := tx.ExecContext(ctx, insert, args...)
+			if err != nil {
+				return errors.Wrapf(err, "inserting batch %d of %d for %s table", i+1, len(batches), t.Name)
+			}
+			rows, err := result.RowsAffected()
+			if err != nil {
+				return errors.Wrapf(err, "counting rows of batch %d of %d for %s table", i+1, len(batches), t.Name)
+			}
+			inserted += rows
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	return inserted, nil
+}
+
+// InsertReturningIDs creates new logs in the supplied table one at a time,
+// and returns the generated ids of the logs in the order they were given.
+// The logs are inserted in a single transaction.
+func (t *Table) InsertReturningIDs(ctx context.Context, logs logs.JSON) ([]int64, error) {
+	ids := make([]int64, 0, len(logs))
+	err := inTransaction(ctx, t.DB, func(tx *sqlx.Tx) error {
+		for _, record := range logs {
+			// construct a single row insert statement, since postgres doesn't
+			// guarantee the order of the ids returned by a multi-row insert
+			insert, args := InsertTableStatement(t.Name, t.Schema, []map[string]interface{}{record})
+			insert = insert[:len(insert)-1] + ` RETURNING "id";`
+
+			// insert the record
+			var id int64
+			if err := tx.QueryRowxContext(ctx, insert, args...).Scan(&id); err != nil {
+				return errors.Wrapf(err, "inserting record for %s table", t.Name)
+			}
+			ids = append(ids, id)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return ids, nil
+}
+
+// inTransaction runs fn in a transaction, which is committed if fn succeeds
+// and rolled back otherwise
+func inTransaction(ctx context.Context, db *sqlx.DB, fn func(tx *sqlx.Tx) error) error {
+	tx, err := db.BeginTxx(ctx, nil)
+	if err != nil {
+		return errors.Wrap(err, "beginning transaction")
+	}
+	if err := fn(tx); err != nil {
+		// the error of fn is more useful than a failed rollback
+		tx.Rollback()
+		return err
+	}
+	return errors.Wrap(tx.Commit(), "committing transaction")
+}
+
+// batchSize returns the number of records per insert statement with a
+// placeholder for each of the columns, which is at most size records (or
+// DefaultBatchSize if size isn't positive) and keeps the placeholders of the
+// statement under the limit of Postgres
+func batchSize(columns, size int) int {
+	if size <= 0 {
+		size = DefaultBatchSize
+	}
+	if columns > 0 && size*columns > maxPlaceholders {
+		size = maxPlaceholders / columns
+	}
+	if size < 1 {
+		size = 1
+	}
+	return size
+}
+
+// batch splits the records into batches of at most size records
+func batch(records []map[string]interface{}, size int) [][]map[string]interface{} {
+	var batches [][]map[string]interface{}
+	for len(records) > size {
+		batches = append(batches, records[:size])
+		records = records[size:]
+	}
+	if len(records) > 0 {
+		batches = append(batches, records)
+	}
+	return batches
+}
+
+// textValue converts the text of a value scanned from a column with the
+// database type, decoding the text of JSON and JSONB columns
+func textValue(b []byte, dbType string) interface{} {
+	if strings.EqualFold(dbType, "JSON") || strings.EqualFold(dbType, "JSONB") {
+		var decoded interface{}
+		if err := json.Unmarshal(b, &decoded); err == nil {
+			return decoded
+		}
+	}
+	return string(b)
+}
+
+// QueryJSON returns rows as a representation that can be marshalled to JSON,
+// along with the columns of the rows in the order they were selected.
+// The args are bound to the `?` placeholders of the query. Without any rows,
+// the results are empty rather than nil, so they marshal to `[]`.
+func (c *Client) QueryJSON(ctx context.Context, query string, args ...interface{}) (logs.JSON, []logs.Column, error) {
+	results := logs.JSON{}
+	columns, err := c.queryRows(ctx, query, args, func(row map[string]interface{}) error {
+		results = append(results, row)
+		return nil
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	return results, columns, nil
+}
+
+// QueryRows runs the query and calls fn with each row as it's scanned from
+// the cursor. It stops at the first error returned by fn.
+func (c *Client) QueryRows(ctx context.Context, query string, args []interface{}, fn func(row map[string]interface{}) error) error {
+	_, err := c.queryRows(ctx, query, args, fn)
+	return err
+}
+
+// queryRows runs the query like `QueryRows`, and returns the columns of its rows
+func (c *Client) queryRows(ctx context.Context, query string, args []interface{}, fn func(row map[string]interface{}) error) ([]logs.Column, error) {
+	// Postgres placeholders are numbered, ie: `$1` rather than `?`
+	query = c.Rebind(query)
+	rows, err := c.QueryxContext(ctx, query, args...)
+	if err != nil {
+		return nil, errors.Wrapf(err, "retrieving rows of query '%s'", query)
+	}
+	defer rows.Close()
+
+	columns, err := logs.ColumnsOf(rows.Rows)
+	if err != nil {
+		return nil, errors.Wrapf(err, "reading columns of query '%s'", query)
+	}
+	types := make(map[string]string, len(columns))
+	for _, column := range columns {
+		types[column.Name] = column.Type
+	}
+
+	for rows.Next() {
+		row := make(map[string]interface{})
+		if err := rows.MapScan(row); err != nil {
+			return nil, errors.Wrapf(err, "scanning row of query '%s'", query)
+		}
+		// text fields may be returned as []byte, so cast them to string,
+		// and decode the text of JSON columns
+		for k, v := range row {
+			if b, ok := v.([]byte); ok {
+				row[k] = textValue(b, types[k])
+			}
+		}
+		if err := fn(row); err != nil {
+			return nil, err
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, errors.Wrapf(err, "reading rows of query '%s'", query)
+	}
+	return columns, nil
+}
+
+// DescribeDatabase returns the tables of the current schema with their columns
+func (c *Client) DescribeDatabase(ctx context.Context) (logs.JSON, error) {
+	return c.describeTables(ctx, "")
+}
+
+// DescribeTable returns the table of a family with its columns and types, or
+// no tables if the family doesn't have one
+func (c *Client) DescribeTable(ctx context.Context, family logs.Family) (logs.JSON, error) {
+	return c.describeTables(ctx, family.String())
+}
+
+// ListTables returns the names of the tables of the current schema, in order
+func (c *Client) ListTables(ctx context.Context) ([]string, error) {
+	var names []string
+	err := c.SelectContext(ctx, &names,
+		`SELECT table_name FROM information_schema.tables `+
+			`WHERE table_schema = current_schema() `+
+			`ORDER BY table_name ASC`)
+	if err != nil {
+		return nil, errors.Wrap(err, "listing tables")
+	}
+	return names, nil
+}
+
+// describeTables describes the table with the name, or every table of the
+// current schema if the name is empty
+func (c *Client) describeTables(ctx context.Context, name string) (logs.JSON, error) {
+	filter, args := "", []interface{}{}
+	if name != "" {
+		filter, args = `AND table_name = $1 `, []interface{}{name}
+	}
+	var tableDescriptions []struct {
+		Name     string // table name
+		Column   string // column name
+		Nullable string // YES/NO if column nullable
+		Datatype string // column data type
+		Comment  string // column comment, which tells `any` fields apart
+	}
+	err := c.SelectContext(ctx, &tableDescriptions,
+		`SELECT table_name AS "name", `+
+			`column_name AS "column", `+
+			`is_nullable AS "nullable", `+
+			`data_type AS "datatype", `+
+			columnCommentSelect+`AS "comment" `+
+			`FROM information_schema.columns `+
+			`WHERE table_schema = current_schema() `+filter+
+			`ORDER BY table_name ASC, ordinal_position ASC`,
+		args...)
+	if err != nil {
+		return nil, errors.Wrap(err, "describing database")
+	}
+
+	// group the columns by table, keeping the order of the tables
+	var names []string
+	columns := make(map[string][]map[string]interface{})
+	for _, tableDescription := range tableDescriptions {
+		if _, ok := columns[tableDescription.Name]; !ok {
+			names = append(names, tableDescription.Name)
+		}
+		// create the column
+		column := map[string]interface{}{
+			"name":     tableDescription.Column,
+			"nullable": tableDescription.Nullable == "YES",
+			"type":     tableDescription.Datatype,
+		}
+		if tableDescription.Comment == logs.AnyColumnComment {
+			column["schema_type"] = "any"
+		}
+		columns[tableDescription.Name] = append(columns[tableDescription.Name], column)
+	}
+
+	// list of tables with name and columns
+	tables := logs.JSON{}
+	for _, name := range names {
+		tables = append(tables, map[string]interface{}{
+			"name":    name,
+			"columns": columns[name],
+		})
+	}
+
+	return tables, nil
+}
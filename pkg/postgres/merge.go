@@ -0,0 +1,185 @@
+package postgres
+
+import (
+	"context"
+	"sort"
+	"strings"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/kolide/databalancer-logan/pkg/logs"
+	"github.com/pkg/errors"
+)
+
+// sourceColumnType is the column type of the family of each log of a merged table
+const sourceColumnType = "VARCHAR(255)"
+
+// MergeTables merges the tables of the source families into the table of
+// the target family, which is created if needed and gets the union of their
+// columns along with a column of the family of each row. The columns of all
+// the tables are checked for conflicting types before anything is written.
+// Each source is copied in its own transaction, replacing the rows copied
+// from it by a previous merge, so a failed merge can be resumed.
+func (c *Client) MergeTables(ctx context.Context, target logs.Family, sources []logs.Family, sourceColumn string) error {
+	if err := CheckIdentifier(target.String()); err != nil {
+		return errors.Wrap(err, "checking target table name")
+	}
+	if err := CheckIdentifier(sourceColumn); err != nil {
+		return errors.Wrap(err, "checking source column name")
+	}
+
+	// the columns of the merged table, starting with those of the target
+	targetColumns, err := c.columnTypes(ctx, target.String())
+	if err != nil {
+		return errors.Wrapf(err, "finding columns of %s table", target)
+	}
+	columns := make(map[string]string)
+	owners := make(map[string]logs.Family) // column -> family it was first found in
+	for column, columnType := range targetColumns {
+		columns[column], owners[column] = columnType, target
+	}
+
+	// check that the columns of the sources don't conflict before writing
+	sourceColumns := make(map[logs.Family][]string)
+	for _, source := range sources {
+		if err := CheckIdentifier(source.String()); err != nil {
+			return logs.ErrFamilyNotFound
+		}
+		existing, err := c.columnTypes(ctx, source.String())
+		if err != nil {
+			return errors.Wrapf(err, "finding columns of %s table", source)
+		}
+		if len(existing) == 0 {
+			return errors.Wrapf(logs.ErrFamilyNotFound, "finding %s table", source)
+		}
+		for column, columnType := range existing {
+			if column == "id" {
+				continue
+			}
+			if column == sourceColumn {
+				return errors.Wrapf(logs.ErrIncompatibleSchemas,
+					"%s table has a %s column, which is the source column", source, column)
+			}
+			mergedType, ok := columns[column]
+			if ok && !strings.EqualFold(mergedType, columnType) {
+				return errors.Wrapf(logs.ErrIncompatibleSchemas, "column %s is %s in %s table but %s in %s table",
+					column, columnType, source, mergedType, owners[column])
+			}
+			if !ok {
+				columns[column], owners[column] = columnType, source
+			}
+			sourceColumns[source] = append(sourceColumns[source], column)
+		}
+		sort.Strings(sourceColumns[source])
+	}
+
+	// create the target table with the merged columns
+	if _, err := c.ExecContext(ctx, CreateMergeTableStatement(target.String(), sourceColumn)); err != nil {
+		return errors.Wrapf(err, "creating %s table", target)
+	}
+	if len(targetColumns) == 0 {
+		targetColumns = map[string]string{"id": "integer", sourceColumn: sourceColumnType}
+	}
+	columns[sourceColumn] = sourceColumnType
+	if alter := AddColumnsStatement(target.String(), targetColumns, columns); alter != "" {
+		if _, err := c.ExecContext(ctx, alter); err != nil {
+			return errors.Wrapf(err, "altering %s table", target)
+		}
+	}
+
+	// copy the rows of each source
+	for _, source := range sources {
+		clearRows, copyRows := MergeTableStatements(target.String(), source.String(), sourceColumns[source], sourceColumn)
+		err := inTransaction(ctx, c.DB, func(tx *sqlx.Tx) error {
+			if _, err := tx.ExecContext(ctx, clearRows, source.String()); err != nil {
+				return errors.Wrapf(err, "clearing rows previously merged from %s", source)
+			}
+			if _, err := tx.ExecContext(ctx, copyRows, source.String()); err != nil {
+				return errors.Wrapf(err, "copying rows of %s", source)
+			}
+			return nil
+		})
+		if err != nil {
+			return errors.Wrapf(err, "merging %s table into %s table", source, target)
+		}
+	}
+	return nil
+}
+
+// columnTypes returns the existing columns of a table in the current schema,
+// mapped to their full column type, ie: `character varying(255)`
+func (c *Client) columnTypes(ctx context.Context, name string) (map[string]string, error) {
+	var columnDescriptions []struct {
+		Column   string // column name
+		Datatype string // column type
+	}
+	err := c.SelectContext(ctx, &columnDescriptions,
+		`SELECT a.attname AS "column", `+
+			`format_type(a.atttypid, a.atttypmod) AS "datatype" `+
+			`FROM pg_catalog.pg_attribute a `+
+			`JOIN pg_catalog.pg_class t ON t.oid = a.attrelid `+
+			`WHERE t.relname = $1 AND t.relnamespace = current_schema()::regnamespace `+
+			`AND a.attnum > 0 AND NOT a.attisdropped`,
+		name)
+	if err != nil {
+		return nil, errors.Wrapf(err, "describing table %s", name)
+	}
+
+	columns := make(map[string]string)
+	for _, columnDescription := range columnDescriptions {
+		columns[columnDescription.Column] = columnDescription.Datatype
+	}
+	return columns, nil
+}
+
+// CreateMergeTableStatement builds a statement creating the table families
+// are merged into, with a column of the family of each row
+func CreateMergeTableStatement(name string, sourceColumn string) string {
+	return "CREATE TABLE IF NOT EXISTS " +
+		quoteIdentifier(name) +
+		`("id" SERIAL, ` +
+		quoteIdentifier(sourceColumn) + " " + sourceColumnType + ", " +
+		`PRIMARY KEY("id")` +
+		");"
+}
+
+// AddColumnsStatement builds a statement that adds the columns missing from
+// the existing columns of a table, both given as maps of column name to
+// Postgres column type. It returns an empty statement if no columns need to
+// be added.
+func AddColumnsStatement(name string, existing map[string]string, columns map[string]string) string {
+	var addColumns []string
+	for column, columnType := range columns {
+		if _, ok := existing[column]; !ok {
+			addColumns = append(addColumns, "ADD COLUMN "+quoteIdentifier(column)+" "+columnType)
+		}
+	}
+	if len(addColumns) == 0 {
+		return ""
+	}
+	sort.Strings(addColumns)
+
+	return "ALTER TABLE " + quoteIdentifier(name) + " " + strings.Join(addColumns, ", ") + ";"
+}
+
+// MergeTableStatements builds the statements merging the columns of a source
+// table into a target table: one deleting the rows previously merged from the
+// source, and one copying the rows of the source. Both statements take the
+// name of the source as their only argument.
+func MergeTableStatements(target, source string, columns []string, sourceColumn string) (string, string) {
+	// the columns inserted, and the values selected for them
+	var insertColumns, selectValues []string
+	for _, column := range columns {
+		insertColumns = append(insertColumns, quoteIdentifier(column))
+		selectValues = append(selectValues, quoteIdentifier(column))
+	}
+	insertColumns = append(insertColumns, quoteIdentifier(sourceColumn))
+	selectValues = append(selectValues, "$1")
+
+	clearRows := "DELETE FROM " + quoteIdentifier(target) + " WHERE " + quoteIdentifier(sourceColumn) + " = $1;"
+	copyRows := "INSERT INTO " +
+		quoteIdentifier(target) +
+		"(" + strings.Join(insertColumns, ", ") + ") " +
+		"SELECT " + strings.Join(selectValues, ", ") +
+		" FROM " + quoteIdentifier(source) + ";"
+	return clearRows, copyRows
+}
@@ -0,0 +1,281 @@
+package postgres
+
+import (
+	"encoding/json"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/kolide/databalancer-logan/pkg/logs"
+	"github.com/pkg/errors"
+)
+
+// CreateTableStatement builds a create table statement string from a
+// table name and a schema. Note that the table will have a SERIAL typed `id`
+// primary key. Postgres doesn't have column comments in a CREATE TABLE, so
+// the comments of the columns of `any` fields follow it.
+func CreateTableStatement(name string, schema map[string]string) string {
+	// list of fields in the schema
+	var tableFields []string
+	for fieldName, fieldType := range schema {
+		// append field name and appropriate field type to field list
+		if columnType, ok := columnDefinition(fieldType); ok {
+			field := quoteIdentifier(fieldName) + " " + columnType + ", "
+			tableFields = append(tableFields, field)
+		}
+	}
+	// sort the fields
+	sort.Strings(tableFields)
+
+	stmt := "CREATE TABLE IF NOT EXISTS " +
+		quoteIdentifier(name) +
+		`("id" SERIAL, ` +
+		strings.Join(tableFields, "") +
+		`PRIMARY KEY("id")` +
+		");"
+
+	return stmt + columnComments(name, schema)
+}
+
+// columnComments builds the statements commenting the columns of the `any`
+// fields of the schema, each preceded by a space, so that they're told apart
+// from the JSONB columns of `json` fields
+func columnComments(name string, schema map[string]string) string {
+	var comments []string
+	for fieldName, fieldType := range schema {
+		if parsed, err := logs.ParseFieldType(fieldType); err == nil && parsed.Name == "any" {
+			comments = append(comments, " COMMENT ON COLUMN "+quoteIdentifier(name)+"."+quoteIdentifier(fieldName)+" IS '"+logs.AnyColumnComment+"';")
+		}
+	}
+	sort.Strings(comments)
+	return strings.Join(comments, "")
+}
+
+// AlterTableStatement builds a statement that adds the fields of the schema
+// missing from the existing columns of a table, given as a map of column name
+// to Postgres data type. It returns an empty statement if no columns need to be
+// added, and an error if a field conflicts with the type of an existing column.
+// The columns added are nullable even for required fields, since the logs
+// already in the table don't have them.
+func AlterTableStatement(name string, columns map[string]string, schema map[string]string) (string, error) {
+	// list of columns to add
+	var addColumns []string
+	added := make(map[string]string)
+	for fieldName, fieldType := range schema {
+		columnType, ok := ColumnType(fieldType)
+		if !ok {
+			continue
+		}
+		existingType, exists := columns[fieldName]
+		if !exists {
+			addColumns = append(addColumns, "ADD COLUMN "+quoteIdentifier(fieldName)+" "+columnType)
+			added[fieldName] = fieldType
+			continue
+		}
+		if !strings.EqualFold(existingType, columnType) {
+			return "", errors.Wrapf(logs.ErrIncompatibleSchemas, "field %s of type %s conflicts with existing column of type %s",
+				fieldName, fieldType, existingType)
+		}
+	}
+	if len(addColumns) == 0 {
+		return "", nil
+	}
+	// sort the columns
+	sort.Strings(addColumns)
+
+	stmt := "ALTER TABLE " +
+		quoteIdentifier(name) +
+		" " +
+		strings.Join(addColumns, ", ") +
+		";"
+
+	return stmt + columnComments(name, added), nil
+}
+
+// DropTableStatement builds a statement that drops a table
+func DropTableStatement(name string) string {
+	return "DROP TABLE IF EXISTS " + quoteIdentifier(name) + ";"
+}
+
+// TruncateTableStatement builds a statement that deletes all of the rows of
+// a table, and restarts its ids like MySQL does
+func TruncateTableStatement(name string) string {
+	return "TRUNCATE TABLE " + quoteIdentifier(name) + " RESTART IDENTITY;"
+}
+
+// CountStatement builds a statement that counts the rows of a table
+func CountStatement(name string) string {
+	return "SELECT COUNT(*) FROM " + quoteIdentifier(name) + ";"
+}
+
+// ColumnType returns the Postgres column type for a schema field type, and
+// whether the field type is supported
+func ColumnType(fieldType string) (string, bool) {
+	parsed, err := logs.ParseFieldType(fieldType)
+	if err != nil {
+		return "", false
+	}
+	switch parsed.Name {
+	case "string":
+		// unlike MySQL, Postgres stores TEXT and VARCHAR the same way, so the
+		// length of a string is only checked on ingest
+		return "TEXT", true
+	case "int":
+		return "INTEGER", true
+	case "bigint":
+		return "BIGINT", true
+	case "decimal":
+		return "NUMERIC(" + strconv.Itoa(parsed.Precision) + "," + strconv.Itoa(parsed.Scale) + ")", true
+	case "json", "any":
+		// JSONB is parsed once on insert rather than on each query
+		return "JSONB", true
+	}
+	return "", false
+}
+
+// FieldTypeOf returns the schema field type of a Postgres data type, along
+// with the precision and scale of numeric columns, and whether logs can be
+// ingested with it. Strings are stored as TEXT, so their length is lost. A
+// column which isn't nullable is a required field, ie: `int!`, and a JSONB
+// column with the comment of an `any` field is an `any` field.
+func FieldTypeOf(dataType, comment string, precision, scale int, nullable bool) (string, bool) {
+	var fieldType string
+	switch strings.ToLower(strings.TrimSpace(dataType)) {
+	case "text":
+		fieldType = "string"
+	case "integer":
+		fieldType = "int"
+	case "bigint":
+		fieldType = "bigint"
+	case "numeric":
+		fieldType = "decimal(" + strconv.Itoa(precision) + "," + strconv.Itoa(scale) + ")"
+	case "jsonb":
+		fieldType = "json"
+		if comment == logs.AnyColumnComment {
+			fieldType = "any"
+		}
+	default:
+		return "", false
+	}
+	if _, err := logs.ParseFieldType(fieldType); err != nil {
+		return "", false
+	}
+	if !nullable {
+		fieldType += "!"
+	}
+	return fieldType, true
+}
+
+// columnDefinition returns the Postgres column type for a schema field type
+// followed by `NOT NULL` if the field is required, and whether the field
+// type is supported
+func columnDefinition(fieldType string) (string, bool) {
+	columnType, ok := ColumnType(fieldType)
+	if !ok {
+		return "", false
+	}
+	if parsed, _ := logs.ParseFieldType(fieldType); parsed.Required {
+		columnType += " NOT NULL"
+	}
+	return columnType, true
+}
+
+// InsertTableStatement builds a statement to insert records into a table,
+// given a table name, schema, and some records, and returns the arguments to
+// be passed to the statement. Unlike MySQL, Postgres placeholders are
+// numbered, ie: `($1, $2), ($3, $4)`.
+func InsertTableStatement(name string, schema map[string]string, records []map[string]interface{}) (string, []interface{}) {
+	// list of field names (to preserve order between field names and arguments)
+	var fieldNames []string
+	for fieldName := range schema {
+		fieldNames = append(fieldNames, fieldName)
+	}
+	// sort the fields
+	sort.Strings(fieldNames)
+
+	// list of safe field names
+	var safeFieldNames []string
+	for _, fieldName := range fieldNames {
+		safeFieldNames = append(safeFieldNames, quoteIdentifier(fieldName))
+	}
+
+	// the list of bindvars for all records
+	var valueBindvars []string
+	// the list of args to pass into the statement
+	var args []interface{}
+	isJSON := jsonFields(schema)
+	for _, record := range records {
+		// will represent placeholders for the fields of the record
+		var bindvars []string
+		for _, fieldName := range fieldNames {
+			// append field values as arguments, numbering their placeholders
+			args = append(args, insertValue(record[fieldName], isJSON[fieldName]))
+			bindvars = append(bindvars, "$"+strconv.Itoa(len(args)))
+		}
+		valueBindvars = append(valueBindvars, "("+strings.Join(bindvars, ", ")+")")
+	}
+
+	stmt := "INSERT INTO " +
+		quoteIdentifier(name) +
+		"(" +
+		strings.Join(safeFieldNames, ", ") +
+		") VALUES " +
+		strings.Join(valueBindvars, ", ") +
+		";"
+
+	return stmt, args
+}
+
+// jsonFields returns the fields of the schema stored as JSON, which are the
+// fields with the json or any type
+func jsonFields(schema map[string]string) map[string]bool {
+	fields := make(map[string]bool)
+	for fieldName, fieldType := range schema {
+		if parsed, err := logs.ParseFieldType(fieldType); err == nil && (parsed.Name == "json" || parsed.Name == "any") {
+			fields[fieldName] = true
+		}
+	}
+	return fields
+}
+
+// insertValue returns the value of a field of a record as the argument of an
+// insert, where the values of json and any fields are bound as JSON text
+func insertValue(value interface{}, isJSON bool) interface{} {
+	if !isJSON || value == nil {
+		return value
+	}
+	encoded, err := json.Marshal(value)
+	if err != nil {
+		return value
+	}
+	return string(encoded)
+}
+
+// quoteIdentifier quotes an identifier for use in a statement, doubling any
+// double quotes in it so that it can't end the quoted identifier early
+func quoteIdentifier(name string) string {
+	return `"` + strings.Replace(name, `"`, `""`, -1) + `"`
+}
+
+// maxIdentifierLength is the maximum length of a Postgres identifier in
+// bytes. Longer identifiers are silently truncated by Postgres.
+const maxIdentifierLength = 63
+
+// CheckIdentifier returns an error if the name can't be used as is as a
+// Postgres table or column name, rather than letting Postgres truncate it.
+func CheckIdentifier(name string) error {
+	if name == "" {
+		return errors.New("identifier is empty")
+	}
+	if !utf8.ValidString(name) {
+		return errors.Errorf("identifier %q is not valid UTF-8", name)
+	}
+	if len(name) > maxIdentifierLength {
+		return errors.Errorf("identifier %q is longer than %d bytes", name, maxIdentifierLength)
+	}
+	if strings.ContainsRune(name, 0) {
+		return errors.Errorf("identifier %q contains a NUL character", name)
+	}
+	return nil
+}
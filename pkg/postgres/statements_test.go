@@ -0,0 +1,271 @@
+package postgres_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/kolide/databalancer-logan/pkg/postgres"
+	"github.com/stretchr/testify/assert"
+)
+
+// utility types to clean up tests
+type schema map[string]string
+type record map[string]interface{}
+type records []map[string]interface{}
+
+// describes a test case for CreateTableStatement
+type createCase struct {
+	name      string
+	tableName string
+	schema    schema
+	statement string
+}
+
+func TestCreateTableStatement(t *testing.T) {
+	cases := []createCase{
+		{
+			name:      "can construct a create statement from a schema",
+			tableName: "dog_registry",
+			schema:    schema{"name": "string", "breed": "string", "weight": "int"},
+			statement: `CREATE TABLE IF NOT EXISTS "dog_registry"("id" SERIAL, "breed" TEXT, "name" TEXT, "weight" INTEGER, PRIMARY KEY("id"));`,
+		},
+		{
+			name:      "string normalizations are stored as text",
+			tableName: "dog_registry",
+			schema:    schema{"name": "string:trim,lower"},
+			statement: `CREATE TABLE IF NOT EXISTS "dog_registry"("id" SERIAL, "name" TEXT, PRIMARY KEY("id"));`,
+		},
+		{
+			name:      "required fields are not null",
+			tableName: "dog_registry",
+			schema:    schema{"name": "string!", "weight": "int"},
+			statement: `CREATE TABLE IF NOT EXISTS "dog_registry"("id" SERIAL, "name" TEXT NOT NULL, "weight" INTEGER, PRIMARY KEY("id"));`,
+		},
+		{
+			name:      "strings with a length are stored as text",
+			tableName: "request_log",
+			schema:    schema{"status": "string(32)"},
+			statement: `CREATE TABLE IF NOT EXISTS "request_log"("id" SERIAL, "status" TEXT, PRIMARY KEY("id"));`,
+		},
+		{
+			name:      "json fields are stored as jsonb",
+			tableName: "request_log",
+			schema:    schema{"geo": "json"},
+			statement: `CREATE TABLE IF NOT EXISTS "request_log"("id" SERIAL, "geo" JSONB, PRIMARY KEY("id"));`,
+		},
+		{
+			name:      "any fields are stored as commented jsonb",
+			tableName: "request_log",
+			schema:    schema{"meta": "any", "geo": "json"},
+			statement: `CREATE TABLE IF NOT EXISTS "request_log"("id" SERIAL, "geo" JSONB, "meta" JSONB, PRIMARY KEY("id")); COMMENT ON COLUMN "request_log"."meta" IS 'databalancer:any';`,
+		},
+		{
+			name:      "can construct a create statement from an empty schema",
+			tableName: "cat_registry",
+			schema:    schema{},
+			statement: `CREATE TABLE IF NOT EXISTS "cat_registry"("id" SERIAL, PRIMARY KEY("id"));`,
+		},
+		{
+			name:      "doubles double quotes and keeps multibyte characters in names as is",
+			tableName: "chien_registre",
+			schema:    schema{"naïve": "string", `say "woof"`: "int", "名前": "string"},
+			statement: `CREATE TABLE IF NOT EXISTS "chien_registre"("id" SERIAL, "naïve" TEXT, "say ""woof""" INTEGER, "名前" TEXT, PRIMARY KEY("id"));`,
+		},
+		{
+			name:      "escapes attempts to inject sql",
+			tableName: "criminal_registry",
+			schema:    schema{"name": "string", "test; DROP TABLE users": "test; DROP TABLE users"},
+			statement: `CREATE TABLE IF NOT EXISTS "criminal_registry"("id" SERIAL, "name" TEXT, PRIMARY KEY("id"));`,
+		},
+	}
+
+	for _, tt := range cases {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.statement, postgres.CreateTableStatement(tt.tableName, tt.schema))
+		})
+	}
+}
+
+// describes a test case for AlterTableStatement
+type alterCase struct {
+	name      string
+	tableName string
+	columns   map[string]string
+	schema    schema
+	statement string
+	err       bool
+}
+
+func TestAlterTableStatement(t *testing.T) {
+	cases := []alterCase{
+		{
+			name:      "adds the schema fields missing from the table",
+			tableName: "dog_registry",
+			columns:   map[string]string{"id": "integer", "name": "text", "breed": "text"},
+			schema:    schema{"name": "string", "breed": "string", "age": "int", "owner": "string"},
+			statement: `ALTER TABLE "dog_registry" ADD COLUMN "age" INTEGER, ADD COLUMN "owner" TEXT;`,
+		},
+		{
+			name:      "adds any fields as commented jsonb columns",
+			tableName: "request_log",
+			columns:   map[string]string{"id": "integer", "meta": "jsonb"},
+			schema:    schema{"meta": "any", "tags": "any", "geo": "json"},
+			statement: `ALTER TABLE "request_log" ADD COLUMN "geo" JSONB, ADD COLUMN "tags" JSONB; COMMENT ON COLUMN "request_log"."tags" IS 'databalancer:any';`,
+		},
+		{
+			name:      "does nothing when the table has every field",
+			tableName: "dog_registry",
+			columns:   map[string]string{"id": "integer", "name": "text", "weight": "integer"},
+			schema:    schema{"name": "string", "weight": "int"},
+			statement: "",
+		},
+		{
+			name:      "returns an error when a field conflicts with the type of a column",
+			tableName: "dog_registry",
+			columns:   map[string]string{"id": "integer", "name": "text", "weight": "text"},
+			schema:    schema{"name": "string", "weight": "int"},
+			err:       true,
+		},
+	}
+
+	for _, tt := range cases {
+		t.Run(tt.name, func(t *testing.T) {
+			stmt, err := postgres.AlterTableStatement(tt.tableName, tt.columns, tt.schema)
+			if tt.err {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tt.statement, stmt)
+		})
+	}
+}
+
+// describes a test case for InsertTableStatement
+type insertCase struct {
+	name      string
+	tableName string
+	schema    schema
+	records   records
+	statement string
+	args      []interface{}
+}
+
+func TestInsertTableStatement(t *testing.T) {
+	cases := []insertCase{
+		{
+			name:      "can construct an insert statement from a schema and logs",
+			tableName: "dog_registry",
+			schema:    schema{"name": "string", "breed": "string", "weight": "int"},
+			records: records{
+				record{"name": "max", "breed": "chihuahua", "weight": float64(3)},
+				record{"name": "spot", "breed": "husky", "weight": float64(130)},
+				record{"name": "spike", "breed": "bulldog", "weight": float64(80)},
+			},
+			statement: `INSERT INTO "dog_registry"("breed", "name", "weight") VALUES ($1, $2, $3), ($4, $5, $6), ($7, $8, $9);`,
+			args: []interface{}{
+				"chihuahua", "max", float64(3),
+				"husky", "spot", float64(130),
+				"bulldog", "spike", float64(80),
+			},
+		},
+		{
+			name:      "absent fields are inserted as NULL",
+			tableName: "dog_registry",
+			schema:    schema{"name": "string", "weight": "int"},
+			records: records{
+				record{"name": "max"},
+				record{"name": "spot", "weight": float64(130)},
+			},
+			statement: `INSERT INTO "dog_registry"("name", "weight") VALUES ($1, $2), ($3, $4);`,
+			args:      []interface{}{"max", nil, "spot", float64(130)},
+		},
+		{
+			name:      "values of fields with quotes and multibyte characters in their names line up",
+			tableName: "dog_registry",
+			schema:    schema{`say "woof"`: "string", "名前": "string"},
+			records: records{
+				record{`say "woof"`: "a dog", "名前": "ポチ"},
+			},
+			statement: `INSERT INTO "dog_registry"("say ""woof""", "名前") VALUES ($1, $2);`,
+			args:      []interface{}{"a dog", "ポチ"},
+		},
+	}
+
+	for _, tt := range cases {
+		t.Run(tt.name, func(t *testing.T) {
+			stmt, args := postgres.InsertTableStatement(tt.tableName, tt.schema, tt.records)
+			assert.Equal(t, tt.statement, stmt)
+			assert.Equal(t, tt.args, args)
+		})
+	}
+}
+
+// describes a test case for CheckIdentifier
+type identifierCase struct {
+	name       string
+	identifier string
+	valid      bool
+}
+
+func TestTruncateTableStatement(t *testing.T) {
+	assert.Equal(t, `TRUNCATE TABLE "dog_registry" RESTART IDENTITY;`, postgres.TruncateTableStatement("dog_registry"))
+	assert.Equal(t, `TRUNCATE TABLE "dog""; DROP TABLE users; --" RESTART IDENTITY;`, postgres.TruncateTableStatement(`dog"; DROP TABLE users; --`))
+}
+
+func TestFieldTypeOf(t *testing.T) {
+	cases := []struct {
+		dataType  string
+		comment   string
+		precision int
+		scale     int
+		nullable  bool
+		fieldType string
+		ok        bool
+	}{
+		{dataType: "text", nullable: true, fieldType: "string", ok: true},
+		{dataType: "integer", nullable: true, fieldType: "int", ok: true},
+		{dataType: "integer", nullable: false, fieldType: "int!", ok: true},
+		{dataType: "bigint", nullable: true, fieldType: "bigint", ok: true},
+		{dataType: "numeric", precision: 10, scale: 2, nullable: true, fieldType: "decimal(10,2)", ok: true},
+		{dataType: "jsonb", nullable: true, fieldType: "json", ok: true},
+		{dataType: "jsonb", comment: "databalancer:any", nullable: true, fieldType: "any", ok: true},
+		{dataType: "jsonb", comment: "databalancer:any", nullable: false, fieldType: "any!", ok: true},
+		{dataType: "integer", comment: "databalancer:any", nullable: true, fieldType: "int", ok: true},
+		{dataType: "timestamp without time zone", nullable: true},
+	}
+
+	for _, tt := range cases {
+		t.Run(tt.dataType+" "+tt.comment, func(t *testing.T) {
+			fieldType, ok := postgres.FieldTypeOf(tt.dataType, tt.comment, tt.precision, tt.scale, tt.nullable)
+			assert.Equal(t, tt.ok, ok)
+			assert.Equal(t, tt.fieldType, fieldType)
+		})
+	}
+}
+
+func TestCheckIdentifier(t *testing.T) {
+	cases := []identifierCase{
+		{name: "a plain name is valid", identifier: "dog_registry", valid: true},
+		{name: "multibyte characters are valid", identifier: "名前", valid: true},
+		{name: "quotes are valid", identifier: `it's "quoted"`, valid: true},
+		{name: "emoji are valid", identifier: "dog_🐶", valid: true},
+		{name: "63 bytes are valid", identifier: strings.Repeat("a", 63), valid: true},
+		{name: "an empty name is rejected", identifier: ""},
+		{name: "NUL bytes are rejected", identifier: "dog\x00registry"},
+		{name: "invalid UTF-8 is rejected", identifier: "dog\xffregistry"},
+		{name: "64 bytes are rejected", identifier: strings.Repeat("a", 64)},
+		{name: "22 three byte characters are rejected", identifier: strings.Repeat("名", 22)},
+	}
+
+	for _, tt := range cases {
+		t.Run(tt.name, func(t *testing.T) {
+			err := postgres.CheckIdentifier(tt.identifier)
+			if tt.valid {
+				assert.NoError(t, err)
+			} else {
+				assert.Error(t, err)
+			}
+		})
+	}
+}
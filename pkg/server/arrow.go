@@ -0,0 +1,116 @@
+package server
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/apache/arrow/go/arrow"
+	"github.com/apache/arrow/go/arrow/array"
+	"github.com/apache/arrow/go/arrow/ipc"
+	"github.com/apache/arrow/go/arrow/memory"
+	"github.com/kolide/databalancer-logan/pkg/logs"
+	"github.com/pkg/errors"
+)
+
+// arrowContentType is the media type of an Apache Arrow IPC stream, where
+// the schema of the columns is followed by a record batch of the results
+const arrowContentType = "application/vnd.apache.arrow.stream"
+
+// acceptsArrow reports whether the request accepts an Arrow stream
+func acceptsArrow(r *http.Request) bool {
+	return accepts(r, arrowContentType)
+}
+
+// arrowRecord builds a record of the results of a query, with a field per
+// column in the order they were selected, typed after the database type of
+// the column. A null is a null of the field, whatever its type.
+func arrowRecord(results logs.JSON, columns []logs.Column) (array.Record, error) {
+	fields := make([]arrow.Field, len(columns))
+	for i, column := range columns {
+		fields[i] = arrow.Field{Name: column.Name, Type: arrowType(column.Type), Nullable: true}
+	}
+	builder := array.NewRecordBuilder(memory.DefaultAllocator, arrow.NewSchema(fields, nil))
+	defer builder.Release()
+
+	for _, row := range results {
+		for i, column := range columns {
+			if err := appendArrow(builder.Field(i), row[column.Name]); err != nil {
+				return nil, errors.Wrapf(err, "converting value of column %s", column.Name)
+			}
+		}
+	}
+	return builder.NewRecord(), nil
+}
+
+// writeArrow writes a record as an Arrow stream of its schema and a single
+// record batch
+func writeArrow(w http.ResponseWriter, record array.Record) error {
+	w.Header().Set("Content-Type", arrowContentType)
+	writer := ipc.NewWriter(w, ipc.WithSchema(record.Schema()))
+	if err := writer.Write(record); err != nil {
+		return err
+	}
+	return writer.Close()
+}
+
+// arrowType returns the Arrow type of the values of a column of a database
+// type, ie: integers are 64 bits, and text, times, JSON and DECIMAL columns
+// are strings formatted like they are in CSV, which keeps every digit of a
+// decimal that a double would round
+func arrowType(dbType string) arrow.DataType {
+	name := strings.ToUpper(dbType)
+	unsigned := strings.HasPrefix(name, "UNSIGNED ")
+	switch strings.TrimPrefix(name, "UNSIGNED ") {
+	case "TINYINT", "SMALLINT", "MEDIUMINT", "INT", "INTEGER", "BIGINT", "YEAR", "INT2", "INT4", "INT8":
+		if unsigned {
+			return arrow.PrimitiveTypes.Uint64
+		}
+		return arrow.PrimitiveTypes.Int64
+	case "FLOAT", "DOUBLE", "REAL", "FLOAT4", "FLOAT8":
+		return arrow.PrimitiveTypes.Float64
+	case "BOOL", "BOOLEAN":
+		return arrow.FixedWidthTypes.Boolean
+	}
+	return arrow.BinaryTypes.String
+}
+
+// appendArrow appends a value of a result to the builder of its field,
+// converting it to the type of the field
+func appendArrow(builder array.Builder, value interface{}) error {
+	if value == nil {
+		builder.AppendNull()
+		return nil
+	}
+	switch b := builder.(type) {
+	case *array.Int64Builder:
+		n, err := strconv.ParseInt(csvValue(value), 10, 64)
+		if err != nil {
+			return err
+		}
+		b.Append(n)
+	case *array.Uint64Builder:
+		n, err := strconv.ParseUint(csvValue(value), 10, 64)
+		if err != nil {
+			return err
+		}
+		b.Append(n)
+	case *array.Float64Builder:
+		f, err := strconv.ParseFloat(csvValue(value), 64)
+		if err != nil {
+			return err
+		}
+		b.Append(f)
+	case *array.BooleanBuilder:
+		v, err := strconv.ParseBool(csvValue(value))
+		if err != nil {
+			return err
+		}
+		b.Append(v)
+	case *array.StringBuilder:
+		b.Append(csvValue(value))
+	default:
+		return errors.Errorf("unsupported arrow builder %T", builder)
+	}
+	return nil
+}
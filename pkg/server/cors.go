@@ -0,0 +1,84 @@
+package server
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// DefaultCORSHeaders are the request headers browsers can send in a
+// cross-origin request, unless others are configured
+var DefaultCORSHeaders = []string{"Content-Type", "Content-Encoding", RequestIDHeader}
+
+// corsMaxAge is how long a browser can cache the response to a preflight
+// request
+const corsMaxAge = 10 * time.Minute
+
+// CORS is the cross-origin resource sharing policy of the handler, which
+// lets web pages served from other origins call the API
+type CORS struct {
+	// Origins are the origins allowed to call the API, ie:
+	// `https://ui.example.com`, or `*` for any origin
+	Origins []string
+	// Methods are the methods allowed in cross-origin requests, which are
+	// the methods of the requested path if empty
+	Methods []string
+	// Headers are the request headers allowed in cross-origin requests,
+	// which are `DefaultCORSHeaders` if empty
+	Headers []string
+}
+
+// WithCORS lets browsers call the API from the allowed origins of the policy,
+// by answering their preflight `OPTIONS` requests and setting the
+// `Access-Control-Allow-*` headers of the responses to their requests.
+// Without allowed origins, which is the default, no CORS headers are set, so
+// that browsers block cross-origin requests.
+func WithCORS(cors CORS) Option {
+	return func(h *handler) {
+		h.cors = cors
+	}
+}
+
+// allowsOrigin reports whether the policy allows requests from the origin
+func (c CORS) allowsOrigin(origin string) bool {
+	for _, allowed := range c.Origins {
+		if allowed == "*" || allowed == origin {
+			return true
+		}
+	}
+	return false
+}
+
+// setCORSHeaders sets the CORS headers of the response to a request from an
+// allowed origin, and reports whether the origin of the request is allowed
+func (h *handler) setCORSHeaders(w http.ResponseWriter, r *http.Request) bool {
+	if len(h.cors.Origins) == 0 {
+		return false
+	}
+	// the response depends on the origin, so it can't be cached for others
+	w.Header().Add("Vary", "Origin")
+	origin := r.Header.Get("Origin")
+	if origin == "" || !h.cors.allowsOrigin(origin) {
+		return false
+	}
+	w.Header().Set("Access-Control-Allow-Origin", origin)
+	w.Header().Set("Access-Control-Expose-Headers", RequestIDHeader)
+	return true
+}
+
+// setPreflightHeaders sets the headers of the response to a preflight
+// request, which are the methods and headers allowed in the request. The
+// methods default to those allowed on the path.
+func (h *handler) setPreflightHeaders(w http.ResponseWriter, allowed []string) {
+	methods, headers := h.cors.Methods, h.cors.Headers
+	if len(methods) == 0 {
+		methods = allowed
+	}
+	if len(headers) == 0 {
+		headers = DefaultCORSHeaders
+	}
+	w.Header().Set("Access-Control-Allow-Methods", strings.Join(methods, ", "))
+	w.Header().Set("Access-Control-Allow-Headers", strings.Join(headers, ", "))
+	w.Header().Set("Access-Control-Max-Age", strconv.Itoa(int(corsMaxAge/time.Second)))
+}
@@ -0,0 +1,74 @@
+package server
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/kolide/databalancer-logan/pkg/logs"
+)
+
+// csvContentType is the media type of comma-separated values, where the
+// first line of the response is the header of the columns, and each line
+// after it is a row of the results
+const csvContentType = "text/csv"
+
+// acceptsCSV reports whether the request accepts comma-separated values
+func acceptsCSV(r *http.Request) bool {
+	return accepts(r, csvContentType)
+}
+
+// writeCSV writes the results of a query as comma-separated values, with a
+// header row of the names of the columns in the order they were selected,
+// and then a row per result, written as it's formatted
+func writeCSV(w http.ResponseWriter, results logs.JSON, columns []logs.Column) error {
+	w.Header().Set("Content-Type", csvContentType+"; charset=UTF-8")
+	writer := csv.NewWriter(w)
+
+	record := make([]string, len(columns))
+	for i, column := range columns {
+		record[i] = column.Name
+	}
+	if err := writer.Write(record); err != nil {
+		return err
+	}
+	for _, row := range results {
+		for i, column := range columns {
+			record[i] = csvValue(row[column.Name])
+		}
+		if err := writer.Write(record); err != nil {
+			return err
+		}
+	}
+	writer.Flush()
+	return writer.Error()
+}
+
+// csvValue formats a value of a result as a field, ie: a null is an empty
+// field, numbers keep all their digits, times are in RFC 3339 like in JSON,
+// and a JSON object or array is written as JSON
+func csvValue(value interface{}) string {
+	switch v := value.(type) {
+	case nil:
+		return ""
+	case string:
+		return v
+	case []byte:
+		return string(v)
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	case time.Time:
+		return v.Format(time.RFC3339Nano)
+	case map[string]interface{}, []interface{}:
+		encoded, err := json.Marshal(v)
+		if err != nil {
+			return fmt.Sprint(v)
+		}
+		return string(encoded)
+	default:
+		return fmt.Sprint(v)
+	}
+}
@@ -0,0 +1,57 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+)
+
+// The codes of the error responses, which tell clients what kind of error a
+// response is without parsing its message
+const (
+	codeInvalidRequest   = "invalid_request"    // 400: the request is invalid
+	codeNotFound         = "not_found"          // 404: the route, family, log or saved query doesn't exist
+	codeMethodNotAllowed = "method_not_allowed" // 405: the route doesn't support the method
+	codeConflict         = "conflict"           // 409: the request conflicts with the stored logs
+	codeRequestTooLarge  = "request_too_large"  // 413: the body or its logs exceed the limits
+	codeInternal         = "internal"           // 500: the request failed on the server
+	codeUnavailable      = "unavailable"        // 503: the database is busy, and the request can be retried
+	codeTimeout          = "timeout"            // 504: the query took too long
+)
+
+// errorResponse is the JSON body of an error response
+type errorResponse struct {
+	Message string `json:"error"`           // what went wrong
+	Code    string `json:"code"`            // kind of the error, ie: `invalid_request`
+	Field   string `json:"field,omitempty"` // path of the invalid field of the request, if any
+
+	// every invalid field of the request, when there may be more than one
+	Errors []fieldErrorResponse `json:"errors,omitempty"`
+
+	// the logs of an ingest request stored before it failed, since the
+	// batches of a streamed request are inserted one at a time
+	Inserted *int64  `json:"inserted,omitempty"`
+	IDs      []int64 `json:"ids,omitempty"`
+}
+
+// fieldErrorResponse describes an invalid field in the body of an error
+// response with several of them
+type fieldErrorResponse struct {
+	Message string `json:"error"` // what's wrong with the field
+	Field   string `json:"field"` // path of the field in the request, ie: `logs[2].age`
+}
+
+// writeJSONError responds to a request with an error, like `http.Error`, but
+// with a JSON body, ie: `{"error":"Route not found: GET /api","code":"not_found"}`
+func writeJSONError(w http.ResponseWriter, status int, code, msg string) {
+	writeErrorResponse(w, status, errorResponse{Message: msg, Code: code})
+}
+
+// writeErrorResponse responds to a request with the JSON body of an error
+func writeErrorResponse(w http.ResponseWriter, status int, body errorResponse) {
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(status)
+	// like `http.Error`, a failure writing to the client is left for the
+	// client to notice
+	json.NewEncoder(w).Encode(body)
+}
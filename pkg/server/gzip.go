@@ -0,0 +1,109 @@
+package server
+
+import (
+	"compress/gzip"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// minGzipBytes is the size under which a response is sent uncompressed, since
+// compressing it would hardly save anything
+const minGzipBytes = 1024
+
+// acceptsGzip reports whether the request accepts a gzip response, ie:
+// `Accept-Encoding: gzip, deflate` but not `Accept-Encoding: gzip;q=0`
+func acceptsGzip(r *http.Request) bool {
+	for _, accept := range strings.Split(r.Header.Get("Accept-Encoding"), ",") {
+		coding, params, _ := strings.Cut(strings.TrimSpace(accept), ";")
+		if !strings.EqualFold(strings.TrimSpace(coding), "gzip") {
+			continue
+		}
+		name, value, ok := strings.Cut(strings.TrimSpace(params), "=")
+		if !ok || strings.TrimSpace(name) != "q" {
+			return true
+		}
+		q, err := strconv.ParseFloat(strings.TrimSpace(value), 64)
+		return err == nil && q > 0
+	}
+	return false
+}
+
+// compressResponse returns a response writer which compresses the response
+// with gzip if the request accepts it, and a func to call once the response is
+// written. Responses smaller than `minGzipBytes` are sent uncompressed.
+func compressResponse(w http.ResponseWriter, r *http.Request) (http.ResponseWriter, func()) {
+	w.Header().Add("Vary", "Accept-Encoding")
+	if !acceptsGzip(r) {
+		return w, func() {}
+	}
+	gw := &gzipResponseWriter{ResponseWriter: w}
+	return gw, gw.close
+}
+
+// gzipResponseWriter holds back the start of a response until it's large
+// enough to be worth compressing, and then compresses the rest of it
+type gzipResponseWriter struct {
+	http.ResponseWriter
+	status  int          // status of the response, until it's written
+	buf     []byte       // start of the response, until it's written
+	gz      *gzip.Writer // compresses the response, once it's large enough
+	written bool         // whether the status of the response was written
+}
+
+// WriteHeader holds back the status of the response until it's known whether
+// the response is compressed
+func (w *gzipResponseWriter) WriteHeader(status int) {
+	if w.written {
+		w.ResponseWriter.WriteHeader(status)
+		return
+	}
+	if w.status == 0 {
+		w.status = status
+	}
+}
+
+// Write compresses the response once it's at least `minGzipBytes` long
+func (w *gzipResponseWriter) Write(p []byte) (int, error) {
+	if w.gz != nil {
+		return w.gz.Write(p)
+	}
+	if w.written {
+		return w.ResponseWriter.Write(p)
+	}
+	w.buf = append(w.buf, p...)
+	if len(w.buf) < minGzipBytes {
+		return len(p), nil
+	}
+
+	// the response is large enough, so compress it
+	w.Header().Set("Content-Encoding", "gzip")
+	w.Header().Del("Content-Length")
+	w.writeHeader()
+	w.gz = gzip.NewWriter(w.ResponseWriter)
+	if _, err := w.gz.Write(w.buf); err != nil {
+		return 0, err
+	}
+	w.buf = nil
+	return len(p), nil
+}
+
+// writeHeader writes the status of the response held back so far
+func (w *gzipResponseWriter) writeHeader() {
+	w.written = true
+	if w.status != 0 {
+		w.ResponseWriter.WriteHeader(w.status)
+	}
+}
+
+// close writes the rest of the response, uncompressed if it was too small
+func (w *gzipResponseWriter) close() {
+	if w.gz != nil {
+		w.gz.Close()
+		return
+	}
+	if !w.written {
+		w.writeHeader()
+		w.ResponseWriter.Write(w.buf)
+	}
+}
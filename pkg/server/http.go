@@ -1,156 +1,852 @@
 package server
 
 import (
+	"context"
 	"encoding/json"
-	"log"
+	"io"
+	"log/slog"
 	"net/http"
+	"strconv"
+	"time"
 
 	"github.com/kolide/databalancer-logan/pkg/logs"
 	"github.com/pkg/errors"
 )
 
-// HTTP creates a new HTTP server to handle requests
-func HTTP(address string, logs LogService) error {
-	log.Printf("Starting HTTP server on %s\n", address)
+// RetryAfter is how long clients are asked to wait before retrying a request
+// the database was too busy for, in the `Retry-After` header of its 503
+const RetryAfter = time.Second
 
-	if err := http.ListenAndServe(address,
-		handler{
-			logSvc: logs,
-		},
-	); err != nil {
+// ShutdownTimeout is the maximum time to wait for the requests in progress
+// to finish once the server is shutting down
+const ShutdownTimeout = 10 * time.Second
+
+// HTTP creates a new HTTP server to handle requests, until the context is
+// done. The server then stops accepting requests, and returns once the
+// requests in progress are done or after `ShutdownTimeout`.
+func HTTP(ctx context.Context, address string, logs LogService, opts ...Option) error {
+	h := newHandler(logs, opts...)
+	srv := &http.Server{Addr: address, Handler: h.chain()}
+	h.logger.Info("starting HTTP server", "address", address)
+
+	served := make(chan error, 1)
+	go func() {
+		served <- srv.ListenAndServe()
+	}()
+
+	select {
+	case err := <-served:
 		return errors.Wrapf(err, "starting server at address '%s'", address)
+	case <-ctx.Done():
 	}
 
+	h.logger.Info("shutting down HTTP server", "address", address)
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), ShutdownTimeout)
+	defer cancel()
+	if err := srv.Shutdown(shutdownCtx); err != nil {
+		return errors.Wrap(err, "shutting down server")
+	}
 	return nil
 }
 
+// Handler returns the HTTP handler for the API routes, backed by the
+// given log service, and wrapped with its middlewares
+func Handler(logs LogService, opts ...Option) http.Handler {
+	return newHandler(logs, opts...).chain()
+}
+
+// newHandler creates the handler with its options applied
+func newHandler(logs LogService, opts ...Option) *handler {
+	h := &handler{
+		logSvc:          logs,
+		logger:          slog.Default(),
+		maxJSONDepth:    DefaultMaxJSONDepth,
+		maxJSONArray:    DefaultMaxJSONArray,
+		maxBodyBytes:    DefaultMaxBodyBytes,
+		maxIngestLogs:   DefaultMaxIngestLogs,
+		ingestBatchSize: DefaultIngestBatchSize,
+	}
+	for _, opt := range opts {
+		opt(h)
+	}
+	return h
+}
+
 // handler is an internal wrapper around HTTP handlers that allows us to pass
 // some services for our handlers
 type handler struct {
-	logSvc LogService
+	logSvc          LogService
+	logger          *slog.Logger
+	maxJSONDepth    int          // maximum nesting depth of a request body
+	maxJSONArray    int          // maximum number of elements in any array of a request body
+	maxBodyBytes    int64        // maximum size of a request body, 0 for no limit
+	maxIngestLogs   int          // maximum number of logs of an ingest request, 0 for no limit
+	ingestBatchSize int          // number of logs of an ingest request decoded at once, 0 to decode them all at once
+	cors            CORS         // cross-origin policy, which allows no origin by default
+	middlewares     []Middleware // middlewares around the routes, the first being the outermost
+}
+
+// Option configures optional behavior of the HTTP handler
+type Option func(*handler)
+
+const (
+	// DefaultMaxJSONDepth is the default maximum nesting depth of a request body
+	DefaultMaxJSONDepth = 32
+	// DefaultMaxJSONArray is the default maximum number of elements in any
+	// array of a request body
+	DefaultMaxJSONArray = 100000
+	// DefaultMaxBodyBytes is the default maximum size of a request body
+	DefaultMaxBodyBytes = 10 << 20
+	// DefaultMaxIngestLogs is the default maximum number of logs of an
+	// ingest request
+	DefaultMaxIngestLogs = 10000
+	// DefaultIngestBatchSize is the default number of logs of an ingest
+	// request decoded and ingested at once
+	DefaultIngestBatchSize = 1000
+)
+
+// WithJSONLimits limits the nesting depth and the number of elements in any
+// array of the JSON request bodies, which guards against payloads that are
+// expensive to decode. Requests exceeding the limits get a 400 response.
+func WithJSONLimits(maxDepth, maxArray int) Option {
+	return func(h *handler) {
+		h.maxJSONDepth = maxDepth
+		h.maxJSONArray = maxArray
+	}
+}
+
+// WithMaxBodyBytes limits the size of the request bodies, so that a huge
+// body can't exhaust the memory of the service while it's buffered. Requests
+// exceeding the limit get a 413 response. A limit of 0 or less disables it.
+func WithMaxBodyBytes(n int64) Option {
+	return func(h *handler) {
+		h.maxBodyBytes = n
+	}
+}
+
+// WithMaxIngestLogs limits the number of logs of an ingest request, so that
+// a request with millions of logs can't exhaust the memory of the service
+// while they're decoded and inserted. The logs are counted before the body
+// is decoded, or as they're decoded for a streamed request (see
+// `WithIngestBatchSize`), and requests exceeding the limit get a 413
+// response. A limit of 0 or less disables it.
+func WithMaxIngestLogs(n int) Option {
+	return func(h *handler) {
+		h.maxIngestLogs = n
+	}
+}
+
+// WithIngestBatchSize sets the number of logs of an ingest request decoded
+// and ingested at once. A request with more logs, whose family and schema
+// come before its logs, is streamed: its logs are decoded and ingested a
+// batch at a time as its body is read, rather than all at once, so they're
+// never all held in memory. Since each batch is ingested on its own, the
+// batches before an invalid log are stored. A size of 0 or less decodes the
+// logs of every request at once.
+func WithIngestBatchSize(n int) Option {
+	return func(h *handler) {
+		h.ingestBatchSize = n
+	}
+}
+
+// WithLogger sets the logger of the errors handling requests, which is
+// `slog.Default()` by default. A nil logger disables the logging of the
+// server.
+func WithLogger(logger *slog.Logger) Option {
+	return func(h *handler) {
+		if logger == nil {
+			logger = slog.New(slog.NewTextHandler(io.Discard, nil))
+		}
+		h.logger = logger
+	}
 }
 
 // LogService contains the methods for the log processing service
 type LogService interface {
-	Ingest(family logs.Family, schema logs.Schema, logs logs.JSON) error
-	Query(query string) (logs.JSON, error)
-	DescribeLogs() (logs.JSON, error)
+	Ingest(ctx context.Context, family logs.Family, schema logs.Schema, unique []string, logs logs.JSON) (logs.IngestResult, error)
+	IngestBatches(ctx context.Context, family logs.Family, schema logs.Schema, unique []string, next func() (logs.JSON, error)) (logs.IngestResult, error)
+	IngestDDL(ctx context.Context, family logs.Family, schema logs.Schema, unique []string) (string, error)
+	Query(ctx context.Context, query string, args ...interface{}) (logs.JSON, []logs.Column, error)
+	QueryPage(ctx context.Context, query string, args []interface{}, page logs.Page) (logs.JSON, []logs.Column, logs.Page, error)
+	Explain(ctx context.Context, query string, args ...interface{}) (logs.JSON, error)
+	Search(ctx context.Context, req logs.SearchRequest) (logs.JSON, []logs.Column, error)
+	GetLog(ctx context.Context, family logs.Family, id int64) (map[string]interface{}, error)
+	QueryStream(ctx context.Context, query string, args []interface{}, fn func(row map[string]interface{}) error) error
+	SaveQuery(ctx context.Context, name string, query string) error
+	QuerySaved(ctx context.Context, name string, params map[string]interface{}) (logs.JSON, []logs.Column, error)
+	DescribeLogs(ctx context.Context) (logs.JSON, error)
+	DescribeFamily(ctx context.Context, family logs.Family) (logs.JSON, error)
+	ListFamilies(ctx context.Context) ([]string, error)
+	DropFamily(ctx context.Context, family logs.Family) error
+	TruncateFamily(ctx context.Context, family logs.Family) error
+	CountFamily(ctx context.Context, family logs.Family) (int64, error)
+	MergeFamilies(ctx context.Context, req logs.MergeRequest) (logs.MergeResult, error)
+	Ping(ctx context.Context) error
+	Stats() []logs.FamilyStats
 }
 
-// ServeHTTP implements the HandlerFunc interface in the net/http package.
-func (h handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
-	// PUT /api/log
-	if r.URL.Path == "/api/log" && r.Method == "PUT" {
-		h.ingestLogHandler(w, r)
+// ingestLogHandler is an HTTP handler which ingests logs from the network
+func (h *handler) ingestLogHandler(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+	r.Body = h.limitBody(w, r.Body)
+	if err := h.decompressBody(w, r); err != nil {
+		writeJSONError(w, http.StatusBadRequest, codeInvalidRequest, "Invalid request: "+err.Error())
+		return
+	}
+
+	// stream the logs of a large request a batch at a time, rather than
+	// decoding them at once
+	if stream := h.streamIngest(r); stream != nil {
+		h.ingestLogs(w, r, stream.body, func(ctx context.Context) (logs.IngestResult, int, error) {
+			result, err := h.logSvc.IngestBatches(ctx, stream.body.Family, stream.body.Schema, stream.body.Unique, stream.next)
+			return result, stream.count, err
+		})
+		return
+	}
+
+	// decode the request
+	var body ingestRequest
+	err := h.decodeRequest(r, &body, ingestShape, h.checkIngestLogs)
+	if h.writeDecodeError(w, err) {
+		return
+	}
+	if err != nil {
+		writeJSONError(w, http.StatusInternalServerError, codeInternal, "An error occured parsing JSON: "+err.Error())
+		h.logger.Error("error parsing json of log", "err", err)
+		return
+	}
+
+	// validate the request before touching the database
+	records, invalid := body.validate()
+	if invalid != nil {
+		h.writeValidationError(w, invalid)
 		return
 	}
 
-	// POST /api/query
-	if r.URL.Path == "/api/query" && r.Method == "POST" {
-		h.queryHandler(w, r)
+	h.ingestLogs(w, r, &body, func(ctx context.Context) (logs.IngestResult, int, error) {
+		result, err := h.logSvc.Ingest(ctx, body.Family, body.Schema, body.Unique, records)
+		return result, len(records), err
+	})
+}
+
+// writeDecodeError responds to a request whose body can't be decoded, ie:
+// because it's too large or invalid, and reports whether the error is such an
+// error
+func (h *handler) writeDecodeError(w http.ResponseWriter, err error) bool {
+	status, body, ok := decodeErrorResponse(err)
+	if ok {
+		writeErrorResponse(w, status, body)
+	}
+	return ok
+}
+
+// decodeErrorResponse returns the status and the body of the response to a
+// request whose body can't be decoded, and whether the error is such an error
+func decodeErrorResponse(err error) (int, errorResponse, bool) {
+	switch {
+	case isBodyTooLargeError(err):
+		return http.StatusRequestEntityTooLarge, errorResponse{Message: "Request body too large: " + err.Error(), Code: codeRequestTooLarge}, true
+	case errors.Cause(err) == errTooManyLogs:
+		return http.StatusRequestEntityTooLarge, errorResponse{Message: "Request too large: " + err.Error(), Code: codeRequestTooLarge}, true
+	case isJSONLimitError(err) || isInvalidGzipError(err):
+		return http.StatusBadRequest, errorResponse{Message: "Invalid request: " + err.Error(), Code: codeInvalidRequest}, true
+	}
+	if invalid, ok := err.(*validationError); ok {
+		return http.StatusBadRequest, validationErrorResponse(invalid), true
+	}
+	return 0, errorResponse{}, false
+}
+
+// ingestLogs ingests the logs of a request with ingest, which returns the
+// number of logs it ingested along with its result, and responds with the
+// result. An error decoding the logs of a streamed request is reported like
+// an error decoding the request.
+func (h *handler) ingestLogs(w http.ResponseWriter, r *http.Request, body *ingestRequest, ingest func(ctx context.Context) (logs.IngestResult, int, error)) {
+	result, rows, err := ingest(r.Context())
+	if err != nil {
+		h.writeIngestError(w, err, result, "family", body.Family, "rows", rows)
 		return
 	}
 
-	// GET /api/describe
-	if r.URL.Path == "/api/describe" && r.Method == "GET" {
-		h.describeHandler(w, r)
+	// add the DDL of the table of the family, if it's asked for
+	response := struct {
+		logs.IngestResult
+		DDL string `json:"ddl,omitempty"`
+	}{IngestResult: result}
+	if wantsExplain(r) {
+		response.DDL, err = h.logSvc.IngestDDL(r.Context(), body.Family, body.Schema, body.Unique)
+		if err != nil {
+			h.writeServiceError(w, err, "generating DDL", "family", body.Family)
+			return
+		}
+	}
+
+	// set json content-type
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+
+	if err := json.NewEncoder(w).Encode(response); err != nil {
+		writeJSONError(w, http.StatusInternalServerError, codeInternal, "An error occured encoding the result: "+err.Error())
+		h.logger.Error("error encoding result", "err", err)
 		return
 	}
+}
 
-	// handle route not found
-	http.Error(w, "Route not found: "+r.Method+" "+r.URL.Path, http.StatusNotFound)
+// wantsExplain reports whether the `explain` query-string param of an ingest
+// request asks for the DDL of the table of the family, ie: `?explain=1`
+func wantsExplain(r *http.Request) bool {
+	explain, err := strconv.ParseBool(r.URL.Query().Get("explain"))
+	return err == nil && explain
 }
 
-// ingestLogHandler is an HTTP handler which ingests logs from the network
-func (h *handler) ingestLogHandler(w http.ResponseWriter, r *http.Request) {
+// dropFamilyHandler is an HTTP handler which deletes a log family
+func (h *handler) dropFamilyHandler(w http.ResponseWriter, r *http.Request) {
 	defer r.Body.Close()
 
-	// decode the request
-	var body struct {
-		Family logs.Family `json:"family"`
-		Schema logs.Schema `json:"schema"`
-		Logs   logs.JSON   `json:"logs"`
+	family := logs.Family(pathParam(r, "family"))
+
+	err := h.logSvc.DropFamily(r.Context(), family)
+	if err == logs.ErrFamilyNotFound {
+		writeJSONError(w, http.StatusNotFound, codeNotFound, "Log family not found: "+family.String())
+		return
 	}
-	err := json.NewDecoder(r.Body).Decode(&body)
-	// TODO: Add validation, responding about how the request was invalid with a 400 request
 	if err != nil {
-		http.Error(w, "An error occured parsing JSON: "+err.Error(), http.StatusInternalServerError)
-		// TODO: change to structured logger and use debug level logging, or report to error aggregation service
-		log.Printf("error parsing json of log: %+v\n", err)
+		h.writeServiceError(w, err, "deleting logs", "family", family)
 		return
 	}
 
-	// ingest the logs through the service
-	if err = h.logSvc.Ingest(body.Family, body.Schema, body.Logs); err != nil {
-		http.Error(w, "An error occured ingesting logs: "+err.Error(), http.StatusInternalServerError)
-		// TODO: change to structured logger and use debug level logging, or report to error aggregation service
-		log.Printf("error ingesting log: %+v\n", err)
+	// set json content-type
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+	w.WriteHeader(http.StatusOK)
+	w.Write([]byte("{}"))
+}
+
+// truncateFamilyHandler is an HTTP handler which deletes the logs of a log
+// family, keeping the family
+func (h *handler) truncateFamilyHandler(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+
+	family := logs.Family(pathParam(r, "family"))
+
+	err := h.logSvc.TruncateFamily(r.Context(), family)
+	if err == logs.ErrFamilyNotFound {
+		writeJSONError(w, http.StatusNotFound, codeNotFound, "Log family not found: "+family.String())
+		return
+	}
+	if err != nil {
+		h.writeServiceError(w, err, "truncating logs", "family", family)
 		return
 	}
 
+	// set json content-type
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
 	w.WriteHeader(http.StatusOK)
 	w.Write([]byte("{}"))
 }
 
+// getLogHandler is an HTTP handler which responds with the log of a family
+// with the id in its path, ie: `/api/log/dog_registry/42`
+func (h *handler) getLogHandler(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+
+	family := logs.Family(pathParam(r, "family"))
+	id, err := strconv.ParseInt(pathParam(r, "id"), 10, 64)
+	if err != nil || id < 1 {
+		writeJSONError(w, http.StatusBadRequest, codeInvalidRequest, "Invalid request: id must be a positive integer, got "+strconv.Quote(pathParam(r, "id")))
+		return
+	}
+
+	record, err := h.logSvc.GetLog(r.Context(), family, id)
+	if err == logs.ErrFamilyNotFound {
+		writeJSONError(w, http.StatusNotFound, codeNotFound, "Log family not found: "+family.String())
+		return
+	}
+	if errors.Cause(err) == logs.ErrQueryTimeout {
+		writeJSONError(w, http.StatusGatewayTimeout, codeTimeout, "Query timed out: "+err.Error())
+		return
+	}
+	if err != nil {
+		h.writeServiceError(w, err, "getting log", "family", family, "id", id)
+		return
+	}
+
+	// set json content-type
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+
+	if err := json.NewEncoder(w).Encode(record); err != nil {
+		writeJSONError(w, http.StatusInternalServerError, codeInternal, "An error occured encoding the log: "+err.Error())
+		h.logger.Error("error encoding log", "err", err)
+		return
+	}
+}
+
+// countFamilyHandler is an HTTP handler which counts the logs of a family
+func (h *handler) countFamilyHandler(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+
+	family := logs.Family(pathParam(r, "family"))
+
+	count, err := h.logSvc.CountFamily(r.Context(), family)
+	if err == logs.ErrFamilyNotFound {
+		writeJSONError(w, http.StatusNotFound, codeNotFound, "Log family not found: "+family.String())
+		return
+	}
+	if err != nil {
+		h.writeServiceError(w, err, "counting logs", "family", family)
+		return
+	}
+
+	// set json content-type
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+
+	var countResponse struct {
+		Count int64 `json:"count"`
+	}
+	countResponse.Count = count
+
+	if err := json.NewEncoder(w).Encode(countResponse); err != nil {
+		writeJSONError(w, http.StatusInternalServerError, codeInternal, "An error occured encoding the count: "+err.Error())
+		h.logger.Error("error encoding count", "err", err)
+		return
+	}
+}
+
+// writeServiceError responds with the status of the kind of an error returned
+// by the logs service (see `logs.Kind`): a 400 for an invalid request, a 404
+// for something missing, a 503 asking the client to retry later for a busy
+// database, and a 500 for a database error. Database errors are logged along
+// with the keyvals describing the request.
+func (h *handler) writeServiceError(w http.ResponseWriter, err error, action string, keyvals ...interface{}) {
+	status, body := h.serviceErrorResponse(w, err, action, keyvals...)
+	writeErrorResponse(w, status, body)
+}
+
+// serviceErrorResponse returns the status and the body of the response to an
+// error returned by the logs service, like `writeServiceError`, setting the
+// `Retry-After` header of a busy database and logging database errors
+func (h *handler) serviceErrorResponse(w http.ResponseWriter, err error, action string, keyvals ...interface{}) (int, errorResponse) {
+	switch logs.Kind(err) {
+	case logs.ErrValidation:
+		return http.StatusBadRequest, errorResponse{Message: "Invalid request: " + err.Error(), Code: codeInvalidRequest}
+	case logs.ErrNotFound:
+		return http.StatusNotFound, errorResponse{Message: "Not found: " + err.Error(), Code: codeNotFound}
+	case logs.ErrUnavailable:
+		w.Header().Set("Retry-After", strconv.Itoa(int(RetryAfter/time.Second)))
+		h.logger.Warn("database unavailable "+action, append(keyvals, "err", err)...)
+		return http.StatusServiceUnavailable, errorResponse{Message: "Service unavailable: " + err.Error(), Code: codeUnavailable}
+	default:
+		h.logger.Error("error "+action, append(keyvals, "err", err)...)
+		return http.StatusInternalServerError, errorResponse{Message: "An error occured " + action + ": " + err.Error(), Code: codeInternal}
+	}
+}
+
+// writeIngestError responds to an ingest request that failed with the
+// error, along with the number of logs inserted before it failed, and their
+// ids if they're returned. The batches of a streamed request are inserted
+// one at a time, so the batches before the one that failed are stored, and
+// a client retrying the request should only send the logs after them.
+func (h *handler) writeIngestError(w http.ResponseWriter, err error, result logs.IngestResult, keyvals ...interface{}) {
+	status, body, ok := decodeErrorResponse(err)
+	if !ok {
+		status, body = http.StatusBadRequest, errorResponse{Code: codeInvalidRequest}
+		switch cause := errors.Cause(err).(type) {
+		case *logs.FieldError:
+			body = validationErrorResponse(&validationError{Field: logFieldPath(cause), Message: cause.Message})
+		case logs.FieldErrors:
+			body = fieldErrorsResponse(cause)
+		default:
+			if cause == logs.ErrInvalidFamily {
+				body.Field, body.Message = "family", cause.Error()
+			} else {
+				status, body = h.serviceErrorResponse(w, err, "ingesting logs", keyvals...)
+			}
+		}
+	}
+	body.Inserted, body.IDs = &result.Inserted, result.IDs
+	writeErrorResponse(w, status, body)
+}
+
+// wantsMeta reports whether the `meta` query-string param of the request asks
+// for the columns of the results, ie: `?meta=1` or `?meta=true`
+func wantsMeta(r *http.Request) bool {
+	meta, err := strconv.ParseBool(r.URL.Query().Get("meta"))
+	return err == nil && meta
+}
+
 // queryHandler is an HTTP handler which ingests logs from the network
 func (h *handler) queryHandler(w http.ResponseWriter, r *http.Request) {
 	defer r.Body.Close()
+	r.Body = h.limitBody(w, r.Body)
+	w, finish := compressResponse(w, r)
+	defer finish()
 
 	// decode the request
 	var body struct {
-		Query string `json:"query"`
+		Query  string                 `json:"query"`
+		Args   []interface{}          `json:"args"`   // values of the `?` placeholders of the query
+		Saved  string                 `json:"saved"`  // name of a saved query, run instead of the query
+		Params map[string]interface{} `json:"params"` // params of the saved query
+		Limit  int                    `json:"limit"`  // maximum number of results of the page
+		Offset int                    `json:"offset"` // number of results skipped before the page
+	}
+	err := h.decodeRequest(r, &body, queryShape)
+	if h.writeDecodeError(w, err) {
+		return
 	}
-	err := json.NewDecoder(r.Body).Decode(&body)
-	// TODO: Add validation, responding about how the request was invalid with a 400 request
 	if err != nil {
-		http.Error(w, "An error occured parsing JSON: "+err.Error(), http.StatusInternalServerError)
-		// TODO: change to structured logger and use debug level logging, or report to error aggregation service
-		log.Printf("error parsing json of log: %+v\n", err)
+		writeJSONError(w, http.StatusInternalServerError, codeInternal, "An error occured parsing JSON: "+err.Error())
+		h.logger.Error("error parsing json of query", "err", err)
+		return
+	}
+
+	if body.Query == "" && body.Saved == "" {
+		h.writeValidationError(w, &validationError{Field: "query", Message: "query or saved is required"})
+		return
+	}
+
+	// only the results of a query can be paginated, since streamed results
+	// don't need to be, and a saved query can have a LIMIT of its own
+	page := logs.Page{Limit: body.Limit, Offset: body.Offset}
+	paged := page != logs.Page{}
+	if paged && (body.Saved != "" || acceptsNDJSON(r)) {
+		writeJSONError(w, http.StatusBadRequest, codeInvalidRequest, "Invalid request: limit and offset can't be used with saved or streamed queries")
+		return
+	}
+
+	// stream the results a row per line, if the client accepts it
+	if acceptsNDJSON(r) {
+		h.streamQuery(w, r, body.Query, body.Args, body.Saved, body.Params)
 		return
 	}
 
 	// query the logs service
-	results, err := h.logSvc.Query(body.Query)
+	var results logs.JSON
+	var columns []logs.Column
+	switch {
+	case body.Saved != "":
+		results, columns, err = h.logSvc.QuerySaved(r.Context(), body.Saved, body.Params)
+	case paged:
+		results, columns, page, err = h.logSvc.QueryPage(r.Context(), body.Query, body.Args, page)
+	default:
+		results, columns, err = h.logSvc.Query(r.Context(), body.Query, body.Args...)
+	}
+	if err == logs.ErrSavedQueryNotFound {
+		writeJSONError(w, http.StatusNotFound, codeNotFound, "Saved query not found: "+body.Saved)
+		return
+	}
+	if errors.Cause(err) == logs.ErrQueryArgs {
+		writeJSONError(w, http.StatusBadRequest, codeInvalidRequest, "Invalid query args: "+err.Error())
+		return
+	}
+	if errors.Cause(err) == logs.ErrInvalidPage {
+		writeJSONError(w, http.StatusBadRequest, codeInvalidRequest, "Invalid page: "+err.Error())
+		return
+	}
+	if errors.Cause(err) == logs.ErrQueryTimeout {
+		writeJSONError(w, http.StatusGatewayTimeout, codeTimeout, "Query timed out: "+err.Error())
+		return
+	}
 	if err != nil {
-		http.Error(w, "An error occured querying logs: "+err.Error(), http.StatusInternalServerError)
-		// TODO: change to structured logger and use debug level logging, or report to error aggregation service
-		log.Printf("error querying log: %+v\n", err)
+		h.writeServiceError(w, err, "querying logs", "query", body.Query, "saved", body.Saved)
+		return
+	}
+
+	// write the results as an Arrow stream, if the client accepts it
+	if acceptsArrow(r) {
+		record, err := arrowRecord(results, columns)
+		if err != nil {
+			writeJSONError(w, http.StatusInternalServerError, codeInternal, "An error occured encoding the results: "+err.Error())
+			h.logger.Error("error encoding results as Arrow", "query", body.Query, "saved", body.Saved, "err", err)
+			return
+		}
+		defer record.Release()
+		if err := writeArrow(w, record); err != nil {
+			h.logger.Error("error writing results as Arrow", "query", body.Query, "saved", body.Saved, "err", err)
+		}
+		return
+	}
+
+	// write the results as CSV, if the client accepts it
+	if acceptsCSV(r) {
+		if err := writeCSV(w, results, columns); err != nil {
+			h.logger.Error("error writing results as CSV", "query", body.Query, "saved", body.Saved, "err", err)
+		}
 		return
 	}
 
 	// set json content-type
 	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
 
-	// format the response as JSON with a results field that's a list of results
+	// format the response as JSON with a results field that's a list of
+	// results, and the columns of the results if asked for with `?meta=1`.
+	// A paginated query also gets the page that was applied to it, so that
+	// the client can ask for the next one.
 	var queryResponse struct {
-		Results logs.JSON `json:"results"`
+		Columns []logs.Column `json:"columns,omitempty"`
+		Results logs.JSON     `json:"results"`
+		Page    *logs.Page    `json:"page,omitempty"`
 	}
 	queryResponse.Results = results
+	if wantsMeta(r) {
+		queryResponse.Columns = columns
+	}
+	if paged {
+		queryResponse.Page = &page
+	}
 
 	if err := json.NewEncoder(w).Encode(queryResponse); err != nil {
-		http.Error(w, "An error occured encoding the results: "+err.Error(), http.StatusInternalServerError)
-		// TODO: change to structured logger and use debug level logging, or report to error aggregation service
-		log.Printf("error encoding results: %+v\n", err)
+		writeJSONError(w, http.StatusInternalServerError, codeInternal, "An error occured encoding the results: "+err.Error())
+		h.logger.Error("error encoding results", "err", err)
+		return
+	}
+}
+
+// explainHandler responds with the plan the database would execute a query
+// with, which is validated like the queries of the query handler
+func (h *handler) explainHandler(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+	r.Body = h.limitBody(w, r.Body)
+
+	// decode the request
+	var body struct {
+		Query string        `json:"query"`
+		Args  []interface{} `json:"args"` // values of the `?` placeholders of the query
+	}
+	err := h.decodeRequest(r, &body, explainShape)
+	if h.writeDecodeError(w, err) {
+		return
+	}
+	if err != nil {
+		writeJSONError(w, http.StatusInternalServerError, codeInternal, "An error occured parsing JSON: "+err.Error())
+		h.logger.Error("error parsing json of explain", "err", err)
+		return
+	}
+
+	// explain the query with the logs service
+	plan, err := h.logSvc.Explain(r.Context(), body.Query, body.Args...)
+	if errors.Cause(err) == logs.ErrQueryTimeout {
+		writeJSONError(w, http.StatusGatewayTimeout, codeTimeout, "Query timed out: "+err.Error())
+		return
+	}
+	if err != nil {
+		h.writeServiceError(w, err, "explaining query", "query", body.Query)
+		return
+	}
+
+	// set json content-type
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+
+	// format the response as JSON with a plan field that's a list of the
+	// rows of the plan
+	explainResponse := struct {
+		Plan logs.JSON `json:"plan"`
+	}{plan}
+	if err := json.NewEncoder(w).Encode(explainResponse); err != nil {
+		writeJSONError(w, http.StatusInternalServerError, codeInternal, "An error occured encoding the plan: "+err.Error())
+		h.logger.Error("error encoding plan", "err", err)
+		return
+	}
+}
+
+// searchHandler responds with the logs of a family matching the conditions of
+// a structured search, like the query handler responds with the results of a
+// query
+func (h *handler) searchHandler(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+	r.Body = h.limitBody(w, r.Body)
+	w, finish := compressResponse(w, r)
+	defer finish()
+
+	// decode the request
+	var body logs.SearchRequest
+	err := h.decodeRequest(r, &body, searchShape)
+	if h.writeDecodeError(w, err) {
+		return
+	}
+	if err != nil {
+		writeJSONError(w, http.StatusInternalServerError, codeInternal, "An error occured parsing JSON: "+err.Error())
+		h.logger.Error("error parsing json of search", "err", err)
+		return
+	}
+
+	// search the logs with the logs service
+	results, columns, err := h.logSvc.Search(r.Context(), body)
+	if errors.Cause(err) == logs.ErrQueryTimeout {
+		writeJSONError(w, http.StatusGatewayTimeout, codeTimeout, "Query timed out: "+err.Error())
+		return
+	}
+	if err != nil {
+		h.writeServiceError(w, err, "searching logs", "family", body.Family)
+		return
+	}
+
+	// set json content-type
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+
+	// format the response like the response of a query
+	var searchResponse struct {
+		Columns []logs.Column `json:"columns,omitempty"`
+		Results logs.JSON     `json:"results"`
+	}
+	searchResponse.Results = results
+	if wantsMeta(r) {
+		searchResponse.Columns = columns
+	}
+	if err := json.NewEncoder(w).Encode(searchResponse); err != nil {
+		writeJSONError(w, http.StatusInternalServerError, codeInternal, "An error occured encoding the results: "+err.Error())
+		h.logger.Error("error encoding results", "err", err)
+		return
+	}
+}
+
+// saveQueryHandler saves a query with a name, so that it can be run by
+// the query handler with `{"saved": name, "params": {...}}`
+func (h *handler) saveQueryHandler(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+	r.Body = h.limitBody(w, r.Body)
+
+	// decode the request
+	var body struct {
+		Name  string `json:"name"`
+		Query string `json:"query"`
+	}
+	err := h.decodeRequest(r, &body, saveQueryShape)
+	if h.writeDecodeError(w, err) {
+		return
+	}
+	if err != nil {
+		writeJSONError(w, http.StatusInternalServerError, codeInternal, "An error occured parsing JSON: "+err.Error())
+		h.logger.Error("error parsing json of saved query", "err", err)
+		return
+	}
+
+	// save the query in the logs service
+	if err := h.logSvc.SaveQuery(r.Context(), body.Name, body.Query); err != nil {
+		h.writeServiceError(w, err, "saving query", "name", body.Name)
+		return
+	}
+
+	// set json content-type
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+	w.WriteHeader(http.StatusOK)
+	w.Write([]byte("{}"))
+}
+
+// mergeFamiliesHandler merges the logs of several families into a target
+// family, optionally dropping them once merged
+func (h *handler) mergeFamiliesHandler(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+	r.Body = h.limitBody(w, r.Body)
+
+	// decode the request
+	var body logs.MergeRequest
+	err := h.decodeRequest(r, &body, mergeShape)
+	if h.writeDecodeError(w, err) {
+		return
+	}
+	if err != nil {
+		writeJSONError(w, http.StatusInternalServerError, codeInternal, "An error occured parsing JSON: "+err.Error())
+		h.logger.Error("error parsing json of merge", "err", err)
+		return
+	}
+
+	// merge the families through the service
+	result, err := h.logSvc.MergeFamilies(r.Context(), body)
+	switch errors.Cause(err) {
+	case nil:
+	case logs.ErrInvalidMerge:
+		writeJSONError(w, http.StatusBadRequest, codeInvalidRequest, "Invalid merge: "+err.Error())
+		return
+	case logs.ErrIncompatibleSchemas:
+		writeJSONError(w, http.StatusConflict, codeConflict, "Incompatible families: "+err.Error())
+		return
+	case logs.ErrFamilyNotFound:
+		writeJSONError(w, http.StatusNotFound, codeNotFound, "Log family not found: "+err.Error())
+		return
+	default:
+		h.writeServiceError(w, err, "merging logs", "family", body.Target)
+		return
+	}
+
+	// set json content-type
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+
+	if err := json.NewEncoder(w).Encode(result); err != nil {
+		writeJSONError(w, http.StatusInternalServerError, codeInternal, "An error occured encoding the result: "+err.Error())
+		h.logger.Error("error encoding result", "err", err)
 		return
 	}
 }
 
-// describeHandler is an HTTP handler which ingests logs from the network
+// describeHandler is an HTTP handler which describes the tables of every log
+// family
 func (h *handler) describeHandler(w http.ResponseWriter, r *http.Request) {
 	defer r.Body.Close()
+	w, finish := compressResponse(w, r)
+	defer finish()
 
 	// describe the logs of the log service
-	tables, err := h.logSvc.DescribeLogs()
+	tables, err := h.logSvc.DescribeLogs(r.Context())
 	if err != nil {
-		http.Error(w, "An error occured describing logs: "+err.Error(), http.StatusInternalServerError)
-		// TODO: change to structured logger and use debug level logging, or report to error aggregation service
-		log.Printf("error describing logs: %+v\n", err)
+		h.writeServiceError(w, err, "describing logs")
+		return
+	}
+
+	h.writeTables(w, tables)
+}
+
+// describeFamilyHandler is an HTTP handler which describes the table of a
+// single log family
+func (h *handler) describeFamilyHandler(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+	w, finish := compressResponse(w, r)
+	defer finish()
+
+	family := logs.Family(pathParam(r, "family"))
+
+	tables, err := h.logSvc.DescribeFamily(r.Context(), family)
+	if err == logs.ErrFamilyNotFound {
+		writeJSONError(w, http.StatusNotFound, codeNotFound, "Log family not found: "+family.String())
+		return
+	}
+	if err != nil {
+		h.writeServiceError(w, err, "describing logs", "family", family)
+		return
+	}
+
+	h.writeTables(w, tables)
+}
+
+// familiesHandler is an HTTP handler which lists the names of the log
+// families, without describing their tables
+func (h *handler) familiesHandler(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+
+	families, err := h.logSvc.ListFamilies(r.Context())
+	if err != nil {
+		h.writeServiceError(w, err, "listing families")
 		return
 	}
 
 	// set json content-type
 	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
 
+	var familiesResponse struct {
+		Families []string `json:"families"`
+	}
+	familiesResponse.Families = families
+
+	if err := json.NewEncoder(w).Encode(familiesResponse); err != nil {
+		writeJSONError(w, http.StatusInternalServerError, codeInternal, "An error occured encoding the families: "+err.Error())
+		h.logger.Error("error encoding families", "err", err)
+		return
+	}
+}
+
+// writeTables writes the described tables as the JSON response
+func (h *handler) writeTables(w http.ResponseWriter, tables logs.JSON) {
+	// set json content-type
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+
 	// format the response as JSON with a results field that's a list of results
 	var describeResponse struct {
 		Tables logs.JSON `json:"tables"`
@@ -158,9 +854,55 @@ func (h *handler) describeHandler(w http.ResponseWriter, r *http.Request) {
 	describeResponse.Tables = tables
 
 	if err := json.NewEncoder(w).Encode(describeResponse); err != nil {
-		http.Error(w, "An error occured encoding the results: "+err.Error(), http.StatusInternalServerError)
-		// TODO: change to structured logger and use debug level logging, or report to error aggregation service
-		log.Printf("error encoding results: %+v\n", err)
+		writeJSONError(w, http.StatusInternalServerError, codeInternal, "An error occured encoding the results: "+err.Error())
+		h.logger.Error("error encoding results", "err", err)
+		return
+	}
+}
+
+// statsHandler is an HTTP handler which reports the ingest rate and lag of
+// each log family
+func (h *handler) statsHandler(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+
+	// set json content-type
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+
+	var statsResponse struct {
+		Families []logs.FamilyStats `json:"families"`
+	}
+	statsResponse.Families = h.logSvc.Stats()
+
+	if err := json.NewEncoder(w).Encode(statsResponse); err != nil {
+		writeJSONError(w, http.StatusInternalServerError, codeInternal, "An error occured encoding the stats: "+err.Error())
+		h.logger.Error("error encoding stats", "err", err)
+		return
+	}
+}
+
+// healthzHandler is an HTTP handler which checks that the service can reach
+// its database, for liveness and readiness probes
+func (h *handler) healthzHandler(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+
+	// set json content-type
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+
+	var healthResponse struct {
+		Status string `json:"status"`
+		Error  string `json:"error,omitempty"`
+	}
+	healthResponse.Status = "ok"
+
+	if err := h.logSvc.Ping(r.Context()); err != nil {
+		h.logger.Error("error checking health", "err", err)
+		healthResponse.Status = "unavailable"
+		healthResponse.Error = err.Error()
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+
+	if err := json.NewEncoder(w).Encode(healthResponse); err != nil {
+		h.logger.Error("error encoding health", "err", err)
 		return
 	}
 }
@@ -0,0 +1,2469 @@
+package server_test
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/apache/arrow/go/arrow"
+	"github.com/apache/arrow/go/arrow/array"
+	"github.com/apache/arrow/go/arrow/ipc"
+	"github.com/kolide/databalancer-logan/pkg/logs"
+	"github.com/kolide/databalancer-logan/pkg/memdb"
+	"github.com/kolide/databalancer-logan/pkg/server"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+)
+
+// newHandler creates the handler of the service that doesn't log, unless the
+// options set a logger
+func newHandler(logSvc server.LogService, opts ...server.Option) http.Handler {
+	return server.Handler(logSvc, append([]server.Option{server.WithLogger(nil)}, opts...)...)
+}
+
+// MOCKS
+type mockLogService struct {
+	err      error     // error returned by the service
+	rows     logs.JSON // rows returned by queries, if set
+	ingested logs.JSON // logs of the last ingest
+	batches  []int     // sizes of the batches of the last streamed ingest
+}
+
+func (m *mockLogService) Ingest(ctx context.Context, family logs.Family, schema logs.Schema, unique []string, records logs.JSON) (logs.IngestResult, error) {
+	if m.err != nil {
+		return logs.IngestResult{}, m.err
+	}
+	m.ingested = records
+	return logs.IngestResult{Inserted: int64(len(records))}, nil
+}
+
+func (m *mockLogService) IngestBatches(ctx context.Context, family logs.Family, schema logs.Schema, unique []string, next func() (logs.JSON, error)) (logs.IngestResult, error) {
+	if m.err != nil {
+		return logs.IngestResult{}, m.err
+	}
+	m.ingested, m.batches = nil, nil
+	for {
+		batch, err := next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return logs.IngestResult{Inserted: int64(len(m.ingested))}, err
+		}
+		m.ingested = append(m.ingested, batch...)
+		m.batches = append(m.batches, len(batch))
+	}
+	return logs.IngestResult{Inserted: int64(len(m.ingested))}, nil
+}
+
+func (m *mockLogService) IngestDDL(ctx context.Context, family logs.Family, schema logs.Schema, unique []string) (string, error) {
+	if m.err != nil {
+		return "", m.err
+	}
+	return "CREATE TABLE IF NOT EXISTS `" + family.String() + "`(`id` INT NOT NULL AUTO_INCREMENT, PRIMARY KEY(`id`));", nil
+}
+
+func (m *mockLogService) Explain(ctx context.Context, query string, args ...interface{}) (logs.JSON, error) {
+	if m.err != nil {
+		return nil, m.err
+	}
+	if m.rows != nil {
+		return m.rows, nil
+	}
+	return logs.JSON{}, nil
+}
+
+func (m *mockLogService) Query(ctx context.Context, query string, args ...interface{}) (logs.JSON, []logs.Column, error) {
+	if m.err != nil {
+		return nil, nil, m.err
+	}
+	if strings.Count(query, "?") != len(args) {
+		return nil, nil, errors.Wrapf(logs.ErrQueryArgs, "query has %d placeholders for %d args", strings.Count(query, "?"), len(args))
+	}
+	if m.rows != nil {
+		return m.rows, nil, nil
+	}
+	return logs.JSON{}, nil, nil
+}
+
+func (m *mockLogService) Search(ctx context.Context, req logs.SearchRequest) (logs.JSON, []logs.Column, error) {
+	query, args, err := logs.SearchQuery(req)
+	if err != nil {
+		return nil, nil, err
+	}
+	return m.Query(ctx, query, args...)
+}
+
+func (m *mockLogService) GetLog(ctx context.Context, family logs.Family, id int64) (map[string]interface{}, error) {
+	if m.err != nil {
+		return nil, m.err
+	}
+	if family != "dog_registry" {
+		return nil, logs.ErrFamilyNotFound
+	}
+	for _, row := range m.rows {
+		if row["id"] == float64(id) {
+			return row, nil
+		}
+	}
+	return nil, errors.Wrapf(logs.ErrLogNotFound, "%s has no log with id %d", family, id)
+}
+
+func (m *mockLogService) QueryPage(ctx context.Context, query string, args []interface{}, page logs.Page) (logs.JSON, []logs.Column, logs.Page, error) {
+	results, columns, err := m.Query(ctx, query, args...)
+	if err != nil {
+		return nil, nil, logs.Page{}, err
+	}
+	return results, columns, page, nil
+}
+
+func (m *mockLogService) QueryStream(ctx context.Context, query string, args []interface{}, fn func(row map[string]interface{}) error) error {
+	if m.err != nil {
+		return m.err
+	}
+	for _, row := range m.results() {
+		if err := fn(row); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (m *mockLogService) SaveQuery(ctx context.Context, name string, query string) error {
+	return nil
+}
+
+func (m *mockLogService) QuerySaved(ctx context.Context, name string, params map[string]interface{}) (logs.JSON, []logs.Column, error) {
+	if name != "top_dogs" {
+		return nil, nil, logs.ErrSavedQueryNotFound
+	}
+	return m.results(), m.columns(), nil
+}
+
+// columns are the columns of the rows returned by the queries of the mock
+func (m *mockLogService) columns() []logs.Column {
+	return []logs.Column{{Name: "name", Type: "VARCHAR"}, {Name: "weight", Type: "DOUBLE"}}
+}
+
+// results are the rows returned by the queries of the mock
+func (m *mockLogService) results() logs.JSON {
+	return logs.JSON{
+		{"name": "max", "weight": float64(3)},
+		{"name": "spot", "weight": float64(100)},
+	}
+}
+
+func (m *mockLogService) DescribeLogs(ctx context.Context) (logs.JSON, error) {
+	return logs.JSON{}, nil
+}
+
+func (m *mockLogService) DescribeFamily(ctx context.Context, family logs.Family) (logs.JSON, error) {
+	if family != "dog_registry" {
+		return nil, logs.ErrFamilyNotFound
+	}
+	return logs.JSON{{"name": "dog_registry"}}, nil
+}
+
+func (m *mockLogService) ListFamilies(ctx context.Context) ([]string, error) {
+	if m.err != nil {
+		return nil, m.err
+	}
+	return []string{"cat_registry", "dog_registry"}, nil
+}
+
+func (m *mockLogService) CountFamily(ctx context.Context, family logs.Family) (int64, error) {
+	if family != "dog_registry" {
+		return 0, logs.ErrFamilyNotFound
+	}
+	return 2, nil
+}
+
+func (m *mockLogService) DropFamily(ctx context.Context, family logs.Family) error {
+	if family != "dog_registry" {
+		return logs.ErrFamilyNotFound
+	}
+	return nil
+}
+
+func (m *mockLogService) TruncateFamily(ctx context.Context, family logs.Family) error {
+	if family != "dog_registry" {
+		return logs.ErrFamilyNotFound
+	}
+	return nil
+}
+
+func (m *mockLogService) MergeFamilies(ctx context.Context, req logs.MergeRequest) (logs.MergeResult, error) {
+	switch {
+	case req.Target == "":
+		return logs.MergeResult{}, errors.Wrap(logs.ErrInvalidMerge, "target family is empty")
+	case req.Target == "conflicts":
+		return logs.MergeResult{}, errors.Wrap(logs.ErrIncompatibleSchemas, "column weight is int in dog_registry table but text in cat_registry table")
+	}
+	return logs.MergeResult{Merged: req.Sources}, nil
+}
+
+func (m *mockLogService) Ping(ctx context.Context) error {
+	return m.err
+}
+
+func (m *mockLogService) Stats() []logs.FamilyStats {
+	return []logs.FamilyStats{{Family: "dog_registry", Records: 3, RecordsPerSecond: 0.05}}
+}
+
+// describes a test case for a request to the handler
+type requestCase struct {
+	name   string
+	method string
+	path   string
+	body   string
+	status int
+}
+
+func TestJSONLimits(t *testing.T) {
+	// GIVEN
+	handler := newHandler(&mockLogService{}, server.WithJSONLimits(4, 3))
+
+	// THEN
+	cases := []requestCase{
+		{
+			name:   "a body within the limits is accepted",
+			method: "PUT",
+			path:   "/api/log",
+			body:   `{"family":"dog_registry","schema":{"name":"string"},"logs":[{"name":"max"},{"name":"spot"},{"name":"spike"}]}`,
+			status: http.StatusOK,
+		},
+		{
+			name:   "a body nested too deeply is rejected",
+			method: "PUT",
+			path:   "/api/log",
+			body:   `{"family":"dog_registry","schema":{"name":"string"},"logs":[{"name":{"first":{"last":"max"}}}]}`,
+			status: http.StatusBadRequest,
+		},
+		{
+			name:   "a body with an array that is too large is rejected",
+			method: "PUT",
+			path:   "/api/log",
+			body:   `{"family":"dog_registry","schema":{"name":"string"},"logs":[{"name":"max"},{"name":"spot"},{"name":"spike"},{"name":"rex"}]}`,
+			status: http.StatusBadRequest,
+		},
+		{
+			name:   "a query body nested too deeply is rejected",
+			method: "POST",
+			path:   "/api/query",
+			body:   `{"query":[[[["SELECT 1"]]]]}`,
+			status: http.StatusBadRequest,
+		},
+	}
+
+	for _, tt := range cases {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, httptest.NewRequest(tt.method, tt.path, strings.NewReader(tt.body)))
+			assert.Equal(t, tt.status, w.Code)
+		})
+	}
+}
+
+func TestMaxIngestLogs(t *testing.T) {
+	// GIVEN
+	service := &mockLogService{}
+	handler := newHandler(service, server.WithMaxIngestLogs(2))
+
+	// THEN
+	cases := []requestCase{
+		{
+			name:   "logs within the limit are ingested",
+			method: "PUT",
+			path:   "/api/log",
+			body:   `{"family":"dog_registry","schema":{"name":"string"},"logs":[{"name":"max","toys":[1,2,3]},{"name":"spot"}]}`,
+			status: http.StatusOK,
+		},
+		{
+			name:   "logs over the limit are too large",
+			method: "PUT",
+			path:   "/api/log",
+			body:   `{"family":"dog_registry","schema":{"name":"string"},"logs":[{"name":"max"},{"name":"spot"},{"name":"spike"}]}`,
+			status: http.StatusRequestEntityTooLarge,
+		},
+		{
+			name:   "logs over the limit are too large wherever they are in the body",
+			method: "PUT",
+			path:   "/api/log",
+			body:   `{"logs":[{"name":"max"},{"name":"spot"},{"name":"spike"}],"family":"dog_registry","schema":{"name":"string"}}`,
+			status: http.StatusRequestEntityTooLarge,
+		},
+		{
+			name:   "a single log object is within the limit",
+			method: "PUT",
+			path:   "/api/log",
+			body:   `{"family":"dog_registry","schema":{"name":"string"},"logs":{"name":"max"}}`,
+			status: http.StatusOK,
+		},
+	}
+
+	for _, tt := range cases {
+		t.Run(tt.name, func(t *testing.T) {
+			service.ingested = nil
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, httptest.NewRequest(tt.method, tt.path, strings.NewReader(tt.body)))
+			assert.Equal(t, tt.status, w.Code)
+			if tt.status == http.StatusRequestEntityTooLarge {
+				assert.Contains(t, w.Body.String(), "Request too large: maximum is 2: request has too many logs")
+				assert.Nil(t, service.ingested)
+			}
+		})
+	}
+}
+
+// ingestBody returns the body of an ingest request of n dogs, with the fields
+// before and after its logs
+func ingestBody(before string, n int, after string) string {
+	dogs := make([]string, n)
+	for i := range dogs {
+		dogs[i] = fmt.Sprintf(`{"name":"dog %d"}`, i)
+	}
+	return "{" + before + `"logs":[` + strings.Join(dogs, ",") + "]" + after + "}"
+}
+
+// failingDB is an in-memory database whose tables fail an insert once a
+// number of them succeeded
+type failingDB struct {
+	*memdb.DB
+	insertOK int // number of inserts that succeed
+	inserts  int // number of inserts attempted
+}
+
+func (db *failingDB) CreateTable(ctx context.Context, family logs.Family, schema logs.Schema, unique []string) (logs.Table, error) {
+	table, err := db.DB.CreateTable(ctx, family, schema, unique)
+	if err != nil {
+		return nil, err
+	}
+	return &failingTable{Table: table, db: db}, nil
+}
+
+type failingTable struct {
+	logs.Table
+	db *failingDB
+}
+
+var errLockTimeout = errors.New("Error 1205: Lock wait timeout exceeded")
+
+func (t *failingTable) Insert(ctx context.Context, records logs.JSON) (int64, error) {
+	if t.db.inserts++; t.db.inserts > t.db.insertOK {
+		return 0, errLockTimeout
+	}
+	return t.Table.Insert(ctx, records)
+}
+
+func (t *failingTable) InsertReturningIDs(ctx context.Context, records logs.JSON) ([]int64, error) {
+	if t.db.inserts++; t.db.inserts > t.db.insertOK {
+		return nil, errLockTimeout
+	}
+	return t.Table.InsertReturningIDs(ctx, records)
+}
+
+func TestIngestStreamFailure(t *testing.T) {
+	fields := `"family":"dog_registry","schema":{"name":"string"},`
+
+	t.Run("a failure in the second batch responds with the logs stored before it", func(t *testing.T) {
+		// GIVEN a database failing the insert of the second batch
+		db := &failingDB{DB: memdb.New(), insertOK: 1}
+		svc := logs.CreateService(db, logs.WithLogger(nil))
+		handler := newHandler(svc, server.WithIngestBatchSize(10))
+
+		// WHEN
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, httptest.NewRequest("PUT", "/api/log", strings.NewReader(ingestBody(fields, 25, ""))))
+
+		// THEN the error has the number of logs of the first batch, which
+		// are the only ones stored
+		assert.Equal(t, http.StatusInternalServerError, w.Code)
+		var body struct {
+			Code     string  `json:"code"`
+			Inserted *int64  `json:"inserted"`
+			IDs      []int64 `json:"ids"`
+		}
+		assert.NoError(t, json.NewDecoder(w.Body).Decode(&body))
+		assert.Equal(t, "internal", body.Code)
+		if assert.NotNil(t, body.Inserted) {
+			assert.Equal(t, int64(10), *body.Inserted)
+		}
+		assert.Empty(t, body.IDs)
+		count, err := svc.CountFamily(context.Background(), "dog_registry")
+		assert.NoError(t, err)
+		assert.Equal(t, int64(10), count)
+	})
+
+	t.Run("the ids of the logs stored before the failure are returned when enabled", func(t *testing.T) {
+		// GIVEN a service returning the ids of the logs
+		db := &failingDB{DB: memdb.New(), insertOK: 1}
+		svc := logs.CreateService(db, logs.WithLogger(nil), logs.WithReturnIDs(true))
+		handler := newHandler(svc, server.WithIngestBatchSize(10))
+
+		// WHEN
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, httptest.NewRequest("PUT", "/api/log", strings.NewReader(ingestBody(fields, 25, ""))))
+
+		// THEN
+		assert.Equal(t, http.StatusInternalServerError, w.Code)
+		var body struct {
+			Inserted int64   `json:"inserted"`
+			IDs      []int64 `json:"ids"`
+		}
+		assert.NoError(t, json.NewDecoder(w.Body).Decode(&body))
+		assert.Equal(t, int64(10), body.Inserted)
+		assert.Equal(t, []int64{1, 2, 3, 4, 5, 6, 7, 8, 9, 10}, body.IDs)
+	})
+
+	t.Run("an invalid log in the second batch responds with the logs stored before it", func(t *testing.T) {
+		// GIVEN
+		svc := logs.CreateService(memdb.New(), logs.WithLogger(nil))
+		handler := newHandler(svc, server.WithIngestBatchSize(10))
+		body := strings.Replace(ingestBody(fields, 25, ""), `{"name":"dog 12"}`, `{"name":12}`, 1)
+
+		// WHEN
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, httptest.NewRequest("PUT", "/api/log", strings.NewReader(body)))
+
+		// THEN
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+		assert.JSONEq(t, `{"field":"logs[12].name","error":"expected string, got number 12","code":"invalid_request","inserted":10}`, w.Body.String())
+		count, err := svc.CountFamily(context.Background(), "dog_registry")
+		assert.NoError(t, err)
+		assert.Equal(t, int64(10), count)
+	})
+}
+
+func TestIngestStream(t *testing.T) {
+	// GIVEN a handler decoding 1000 logs at once
+	service := &mockLogService{}
+	handler := newHandler(service, server.WithIngestBatchSize(1000), server.WithMaxIngestLogs(3000))
+	fields := `"family":"dog_registry","schema":{"name":"string"},`
+
+	ingest := func(body string) *httptest.ResponseRecorder {
+		service.ingested, service.batches = nil, nil
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, httptest.NewRequest("PUT", "/api/log", strings.NewReader(body)))
+		return w
+	}
+
+	t.Run("the logs of a large request are ingested in batches", func(t *testing.T) {
+		// WHEN
+		w := ingest(ingestBody(fields, 2500, ""))
+
+		// THEN
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.JSONEq(t, `{"inserted":2500}`, w.Body.String())
+		assert.Equal(t, []int{1000, 1000, 500}, service.batches)
+		if assert.Len(t, service.ingested, 2500) {
+			assert.Equal(t, "dog 2499", service.ingested[2499]["name"])
+		}
+	})
+
+	t.Run("the logs of a request within a batch are decoded at once", func(t *testing.T) {
+		// WHEN
+		w := ingest(ingestBody(fields, 1000, ""))
+
+		// THEN
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Nil(t, service.batches)
+		assert.Len(t, service.ingested, 1000)
+	})
+
+	t.Run("logs before the schema are decoded at once", func(t *testing.T) {
+		// WHEN
+		w := ingest(ingestBody("", 2500, `,"family":"dog_registry","schema":{"name":"string"}`))
+
+		// THEN
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Nil(t, service.batches)
+		assert.Len(t, service.ingested, 2500)
+	})
+
+	t.Run("an invalid log is reported with its index", func(t *testing.T) {
+		// WHEN
+		body := ingestBody(fields, 2500, "")
+		body = strings.Replace(body, `{"name":"dog 1500"}`, `"dog 1500"`, 1)
+		w := ingest(body)
+
+		// THEN the batches before it are ingested
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+		assert.JSONEq(t, `{"field":"logs[1500]","error":"log is not an object","code":"invalid_request","inserted":1000}`, w.Body.String())
+		assert.Equal(t, []int{1000}, service.batches)
+	})
+
+	t.Run("a field after the logs of a streamed request is rejected", func(t *testing.T) {
+		// WHEN
+		w := ingest(ingestBody(fields, 2500, `,"unique":["name"]`))
+
+		// THEN
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+		assert.Contains(t, w.Body.String(), `"field":"unique"`)
+	})
+
+	t.Run("too many logs are rejected as they're decoded", func(t *testing.T) {
+		// WHEN
+		w := ingest(ingestBody(fields, 3001, ""))
+
+		// THEN
+		assert.Equal(t, http.StatusRequestEntityTooLarge, w.Code)
+		assert.Equal(t, []int{1000, 1000, 1000}, service.batches)
+	})
+
+	t.Run("a truncated body is invalid JSON", func(t *testing.T) {
+		// WHEN
+		body := ingestBody(fields, 2500, "")
+		w := ingest(body[:len(body)-100])
+
+		// THEN
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+		assert.Contains(t, w.Body.String(), "invalid JSON")
+	})
+
+	t.Run("a streamed request is ingested by the service", func(t *testing.T) {
+		// GIVEN a service backed by an in-memory database
+		svc := logs.CreateService(memdb.New(), logs.WithLogger(nil))
+		handler := newHandler(svc, server.WithIngestBatchSize(10))
+
+		// WHEN
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, httptest.NewRequest("PUT", "/api/log", strings.NewReader(ingestBody(fields, 25, ""))))
+
+		// THEN
+		assert.Equal(t, http.StatusOK, w.Code)
+		count, err := svc.CountFamily(context.Background(), "dog_registry")
+		assert.NoError(t, err)
+		assert.Equal(t, int64(25), count)
+	})
+}
+
+func TestMaxBodyBytes(t *testing.T) {
+	// GIVEN
+	handler := newHandler(&mockLogService{}, server.WithMaxBodyBytes(64))
+
+	// THEN
+	cases := []requestCase{
+		{
+			name:   "a body within the limit is accepted",
+			method: "POST",
+			path:   "/api/query",
+			body:   `{"query":"SELECT * FROM dog_registry"}`,
+			status: http.StatusOK,
+		},
+		{
+			name:   "a query body over the limit is too large",
+			method: "POST",
+			path:   "/api/query",
+			body:   `{"query":"SELECT * FROM dog_registry WHERE name = '` + strings.Repeat("x", 64) + `'"}`,
+			status: http.StatusRequestEntityTooLarge,
+		},
+		{
+			name:   "an ingest body over the limit is too large",
+			method: "PUT",
+			path:   "/api/log",
+			body:   `{"family":"dog_registry","schema":{"name":"string"},"logs":[{"name":"max"},{"name":"spot"}]}`,
+			status: http.StatusRequestEntityTooLarge,
+		},
+		{
+			name:   "an explain body over the limit is too large",
+			method: "POST",
+			path:   "/api/explain",
+			body:   `{"query":"SELECT * FROM dog_registry WHERE name = '` + strings.Repeat("x", 64) + `'"}`,
+			status: http.StatusRequestEntityTooLarge,
+		},
+		{
+			name:   "a search body over the limit is too large",
+			method: "POST",
+			path:   "/api/search",
+			body:   `{"family":"dog_registry","where":[{"field":"name","op":"=","value":"` + strings.Repeat("x", 64) + `"}]}`,
+			status: http.StatusRequestEntityTooLarge,
+		},
+		{
+			name:   "a saved query body over the limit is too large",
+			method: "POST",
+			path:   "/api/saved-queries",
+			body:   `{"name":"top_dogs","query":"SELECT * FROM dog_registry WHERE name = '` + strings.Repeat("x", 64) + `'"}`,
+			status: http.StatusRequestEntityTooLarge,
+		},
+		{
+			name:   "a merge body over the limit is too large",
+			method: "POST",
+			path:   "/api/admin/merge",
+			body:   `{"target":"dogs","sources":["dog_registry","` + strings.Repeat("x", 64) + `"]}`,
+			status: http.StatusRequestEntityTooLarge,
+		},
+	}
+
+	for _, tt := range cases {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, httptest.NewRequest(tt.method, tt.path, strings.NewReader(tt.body)))
+			assert.Equal(t, tt.status, w.Code)
+		})
+	}
+}
+
+func TestIngestInserted(t *testing.T) {
+	// GIVEN
+	handler := newHandler(&mockLogService{})
+
+	// WHEN
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest("PUT", "/api/log", strings.NewReader(
+		`{"family":"dog_registry","schema":{"name":"string"},"logs":[{"name":"max"},{"name":"spot"}]}`,
+	)))
+
+	// THEN the response has the number of inserted logs
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.JSONEq(t, `{"inserted":2}`, w.Body.String())
+}
+
+func TestIngestExplain(t *testing.T) {
+	// GIVEN
+	handler := newHandler(&mockLogService{})
+	body := `{"family":"dog_registry","schema":{"name":"string"},"logs":[{"name":"max"}]}`
+
+	// WHEN logs are ingested with the explain param
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest("PUT", "/api/log?explain=1", strings.NewReader(body)))
+
+	// THEN the response has the DDL of the table of the family
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.JSONEq(t, `{"inserted":1,"ddl":"CREATE TABLE IF NOT EXISTS `+"`dog_registry`(`id` INT NOT NULL AUTO_INCREMENT, PRIMARY KEY(`id`));"+`"}`, w.Body.String())
+
+	// AND it doesn't without the param
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest("PUT", "/api/log?explain=0", strings.NewReader(body)))
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.JSONEq(t, `{"inserted":1}`, w.Body.String())
+}
+
+func TestIngestSingleLog(t *testing.T) {
+	ingest := func(body string) (*httptest.ResponseRecorder, logs.JSON) {
+		svc := &mockLogService{}
+		w := httptest.NewRecorder()
+		newHandler(svc).ServeHTTP(w, httptest.NewRequest("PUT", "/api/log", strings.NewReader(body)))
+		return w, svc.ingested
+	}
+
+	// WHEN a log is sent in a list, and on its own
+	listed, listedLogs := ingest(`{"family":"dog_registry","schema":{"name":"string"},"logs":[{"name":"max"}]}`)
+	single, singleLogs := ingest(`{"family":"dog_registry","schema":{"name":"string"},"logs": {"name":"max"}}`)
+
+	// THEN both are ingested the same way
+	assert.Equal(t, http.StatusOK, listed.Code)
+	assert.Equal(t, http.StatusOK, single.Code)
+	assert.Equal(t, logs.JSON{{"name": "max"}}, listedLogs)
+	assert.Equal(t, listedLogs, singleLogs)
+	assert.JSONEq(t, listed.Body.String(), single.Body.String())
+
+	// AND logs that are neither a list nor an object are invalid
+	w, _ := ingest(`{"family":"dog_registry","schema":{"name":"string"},"logs":"max"}`)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.JSONEq(t, `{"error":"invalid value of type string","field":"logs","code":"invalid_request"}`, w.Body.String())
+}
+
+// gzipped compresses the body with gzip
+func gzipped(t *testing.T, body string) *bytes.Buffer {
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	_, err := gz.Write([]byte(body))
+	assert.NoError(t, err)
+	assert.NoError(t, gz.Close())
+	return &buf
+}
+
+func TestIngestGzip(t *testing.T) {
+	// GIVEN
+	handler := newHandler(&mockLogService{}, server.WithMaxBodyBytes(1024))
+	ingest := func(body *bytes.Buffer) *httptest.ResponseRecorder {
+		r := httptest.NewRequest("PUT", "/api/log", body)
+		r.Header.Set("Content-Encoding", "gzip")
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+		return w
+	}
+
+	t.Run("a gzipped body is decompressed", func(t *testing.T) {
+		w := ingest(gzipped(t, `{"family":"dog_registry","schema":{"name":"string"},"logs":[{"name":"max"},{"name":"spot"}]}`))
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.JSONEq(t, `{"inserted":2}`, w.Body.String())
+	})
+
+	t.Run("a body that isn't gzipped is invalid", func(t *testing.T) {
+		w := ingest(bytes.NewBufferString(`{"family":"dog_registry"}`))
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+	})
+
+	t.Run("a truncated gzipped body is invalid", func(t *testing.T) {
+		body := gzipped(t, `{"family":"dog_registry","schema":{"name":"string"},"logs":[{"name":"max"}]}`)
+		body.Truncate(body.Len() - 4)
+		w := ingest(body)
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+	})
+
+	t.Run("a gzipped body expanding over the limit is too large", func(t *testing.T) {
+		body := gzipped(t, `{"family":"dog_registry","schema":{"name":"string"},"logs":[{"name":"`+strings.Repeat("x", 1<<18)+`"}]}`)
+		assert.True(t, body.Len() < 1024)
+		w := ingest(body)
+		assert.Equal(t, http.StatusRequestEntityTooLarge, w.Code)
+	})
+}
+
+func TestIngestValidation(t *testing.T) {
+	// GIVEN
+	handler := newHandler(&mockLogService{})
+
+	// THEN
+	cases := []struct {
+		name  string
+		body  string
+		field string
+	}{
+		{
+			name:  "a missing family is rejected",
+			body:  `{"schema":{"name":"string"},"logs":[{"name":"max"}]}`,
+			field: "family",
+		},
+		{
+			name:  "a family that isn't a safe table name is rejected",
+			body:  `{"family":"dog registry","schema":{"name":"string"},"logs":[{"name":"max"}]}`,
+			field: "family",
+		},
+		{
+			name:  "a schema with an unknown type is rejected",
+			body:  `{"family":"dog_registry","schema":{"name":"text"},"logs":[{"name":"max"}]}`,
+			field: "schema.name",
+		},
+		{
+			name:  "a schema with an empty type is rejected",
+			body:  `{"family":"dog_registry","schema":{"name":"string","weight":""},"logs":[{"name":"max"}]}`,
+			field: "schema.weight",
+		},
+		{
+			name:  "a schema with a misspelled type is rejected",
+			body:  `{"family":"dog_registry","schema":{"name":"strng"},"logs":[{"name":"max"}]}`,
+			field: "schema.name",
+		},
+		{
+			name:  "a schema with invalid options is rejected",
+			body:  `{"family":"dog_registry","schema":{"weight":"int:trim"},"logs":[{"weight":3}]}`,
+			field: "schema.weight",
+		},
+		{
+			name:  "a schema with an invalid string length is rejected",
+			body:  `{"family":"dog_registry","schema":{"name":"string(0)"},"logs":[{"name":"max"}]}`,
+			field: "schema.name",
+		},
+		{
+			name:  "a schema that isn't an object is rejected",
+			body:  `{"family":"dog_registry","schema":"name","logs":[{"name":"max"}]}`,
+			field: "schema",
+		},
+		{
+			name:  "a unique field outside of the schema is rejected",
+			body:  `{"family":"dog_registry","schema":{"name":"string(32)"},"unique":["name","breed"],"logs":[{"name":"max"}]}`,
+			field: "unique[1]",
+		},
+		{
+			name:  "missing logs are rejected",
+			body:  `{"family":"dog_registry","schema":{"name":"string"},"logs":[]}`,
+			field: "logs",
+		},
+		{
+			name:  "a log that isn't an object is rejected",
+			body:  `{"family":"dog_registry","schema":{"name":"string"},"logs":[{"name":"max"},"spot"]}`,
+			field: "logs[1]",
+		},
+	}
+
+	for _, tt := range cases {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, httptest.NewRequest("PUT", "/api/log", strings.NewReader(tt.body)))
+			assert.Equal(t, http.StatusBadRequest, w.Code)
+
+			var body struct {
+				Error string `json:"error"`
+				Field string `json:"field"`
+			}
+			assert.NoError(t, json.NewDecoder(w.Body).Decode(&body))
+			assert.Equal(t, tt.field, body.Field)
+			assert.NotEmpty(t, body.Error)
+		})
+	}
+}
+
+func TestMalformedBodies(t *testing.T) {
+	// GIVEN
+	handler := newHandler(&mockLogService{})
+
+	// THEN
+	cases := []struct {
+		name  string
+		path  string
+		body  string
+		error string
+		field string
+	}{
+		{
+			name:  "an ingest family that isn't a string",
+			path:  "/api/log",
+			body:  `{"family":3,"schema":{"name":"string"},"logs":[{"name":"max"}]}`,
+			error: "invalid value of type number",
+			field: "family",
+		},
+		{
+			name:  "an ingest schema type that isn't a string",
+			path:  "/api/log",
+			body:  `{"family":"dog_registry","schema":{"name":"string","weight":{"type":"int"}},"logs":[{"name":"max"}]}`,
+			error: "invalid value of type object",
+			field: "schema.weight",
+		},
+		{
+			name:  "an ingest schema that's a list",
+			path:  "/api/log",
+			body:  `{"family":"dog_registry","schema":["name"],"logs":[{"name":"max"}]}`,
+			error: "invalid value of type array",
+			field: "schema",
+		},
+		{
+			name:  "an ingest unique field that isn't a string",
+			path:  "/api/log",
+			body:  `{"family":"dog_registry","schema":{"name":"string"},"unique":["name",true],"logs":[{"name":"max"}]}`,
+			error: "invalid value of type bool",
+			field: "unique[1]",
+		},
+		{
+			name:  "an ingest body that isn't an object",
+			path:  "/api/log",
+			body:  `[{"family":"dog_registry"}]`,
+			error: "invalid value of type array",
+		},
+		{
+			name:  "an ingest body that isn't JSON",
+			path:  "/api/log",
+			body:  `{"family":"dog_registry",`,
+			error: "invalid JSON: unexpected end of JSON input",
+		},
+		{
+			name:  "an empty ingest body",
+			path:  "/api/log",
+			body:  ``,
+			error: "request body is required",
+		},
+		{
+			name:  "an ingest body with a misspelled field",
+			path:  "/api/log",
+			body:  `{"familly":"dog_registry","schema":{"name":"string"},"logs":[{"name":"max"}]}`,
+			error: "unknown field",
+			field: "familly",
+		},
+		{
+			name:  "an ingest body with data after it",
+			path:  "/api/log",
+			body:  `{"family":"dog_registry","schema":{"name":"string"},"logs":[{"name":"max"}]} {"family":"cat_registry"}`,
+			error: "invalid JSON: invalid character '{' after top-level value",
+		},
+		{
+			name:  "a query body with a misspelled field",
+			path:  "/api/query",
+			body:  `{"query":"SELECT * FROM dog_registry WHERE name = ?","arg":["max"]}`,
+			error: "unknown field",
+			field: "arg",
+		},
+		{
+			name:  "a query that isn't a string",
+			path:  "/api/query",
+			body:  `{"query":["SELECT * FROM dog_registry"]}`,
+			error: "invalid value of type array",
+			field: "query",
+		},
+		{
+			name:  "query args that aren't a list",
+			path:  "/api/query",
+			body:  `{"query":"SELECT * FROM dog_registry WHERE name = ?","args":"max"}`,
+			error: "invalid value of type string",
+			field: "args",
+		},
+		{
+			name:  "a query limit that isn't a number",
+			path:  "/api/query",
+			body:  `{"query":"SELECT * FROM dog_registry","limit":"10"}`,
+			error: "invalid value of type string",
+			field: "limit",
+		},
+		{
+			name:  "a query limit that isn't an integer",
+			path:  "/api/query",
+			body:  `{"query":"SELECT * FROM dog_registry","limit":1.5}`,
+			error: "invalid value of type number 1.5",
+			field: "limit",
+		},
+		{
+			name:  "a query without a query or a saved query",
+			path:  "/api/query",
+			body:  `{"args":[1]}`,
+			error: "query or saved is required",
+			field: "query",
+		},
+		{
+			name:  "a query body that isn't JSON",
+			path:  "/api/query",
+			body:  `{"query":"SELECT 1"]`,
+			error: "invalid JSON: invalid character ']' after object key:value pair",
+		},
+	}
+
+	for _, tt := range cases {
+		t.Run(tt.name+" is rejected", func(t *testing.T) {
+			method := "POST"
+			if tt.path == "/api/log" {
+				method = "PUT"
+			}
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, httptest.NewRequest(method, tt.path, strings.NewReader(tt.body)))
+
+			assert.Equal(t, http.StatusBadRequest, w.Code)
+			var body struct {
+				Error string `json:"error"`
+				Field string `json:"field"`
+			}
+			assert.NoError(t, json.NewDecoder(w.Body).Decode(&body))
+			assert.Equal(t, tt.error, body.Error)
+			assert.Equal(t, tt.field, body.Field)
+		})
+	}
+}
+
+func TestIngestWithoutSchema(t *testing.T) {
+	// GIVEN
+	service := &mockLogService{}
+	handler := newHandler(service)
+
+	// WHEN logs are sent without a schema, with unique fields
+	w := httptest.NewRecorder()
+	body := `{"family":"dog_registry","unique":["name"],"logs":[{"name":"max"}]}`
+	handler.ServeHTTP(w, httptest.NewRequest("PUT", "/api/log", strings.NewReader(body)))
+
+	// THEN they're passed to the service, which reads the schema of the family
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, logs.JSON{{"name": "max"}}, service.ingested)
+}
+
+func TestIngestFieldError(t *testing.T) {
+	// GIVEN
+	err := errors.Wrap(&logs.FieldError{Index: 1, Field: "weight", Message: `expected int, got string "heavy"`}, "validating dog_registry logs against schema")
+	handler := newHandler(&mockLogService{err: err})
+
+	// WHEN
+	w := httptest.NewRecorder()
+	body := `{"family":"dog_registry","schema":{"weight":"int"},"logs":[{"weight":3},{"weight":"heavy"}]}`
+	handler.ServeHTTP(w, httptest.NewRequest("PUT", "/api/log", strings.NewReader(body)))
+
+	// THEN
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	var invalid struct {
+		Error string `json:"error"`
+		Field string `json:"field"`
+	}
+	assert.NoError(t, json.NewDecoder(w.Body).Decode(&invalid))
+	assert.Equal(t, "logs[1].weight", invalid.Field)
+	assert.Equal(t, `expected int, got string "heavy"`, invalid.Error)
+}
+
+func TestIngestFieldErrors(t *testing.T) {
+	// GIVEN a service reporting every invalid field of the logs
+	err := errors.Wrap(logs.FieldErrors{
+		{Index: 0, Field: "weight", Message: `expected int, got string "heavy"`},
+		{Index: 2, Field: "age", Message: "field was not specified in the schema"},
+	}, "validating dog_registry logs against schema")
+	handler := newHandler(&mockLogService{err: err})
+
+	// WHEN
+	w := httptest.NewRecorder()
+	body := `{"family":"dog_registry","schema":{"weight":"int"},"logs":[{"weight":"heavy"},{"weight":3},{"weight":4,"age":2}]}`
+	handler.ServeHTTP(w, httptest.NewRequest("PUT", "/api/log", strings.NewReader(body)))
+
+	// THEN each of them is listed with its path
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.JSONEq(t, `{
+		"error": "2 fields of the logs are invalid",
+		"code": "invalid_request",
+		"errors": [
+			{"error": "expected int, got string \"heavy\"", "field": "logs[0].weight"},
+			{"error": "field was not specified in the schema", "field": "logs[2].age"}
+		],
+		"inserted": 0
+	}`, w.Body.String())
+}
+
+func TestServiceErrors(t *testing.T) {
+	cases := []struct {
+		name   string
+		err    error
+		status int
+	}{
+		{name: "a validation error is a bad request", err: errors.Wrap(logs.ErrValidation, "parsing query"), status: http.StatusBadRequest},
+		{name: "a read only error is a bad request", err: logs.ErrReadOnly, status: http.StatusBadRequest},
+		{name: "an empty query is a bad request", err: logs.ErrEmptyQuery, status: http.StatusBadRequest},
+		{name: "a query with several statements is a bad request", err: errors.Wrap(logs.ErrMultipleStatements, "parsing query"), status: http.StatusBadRequest},
+		{name: "a not found error is not found", err: errors.Wrap(logs.ErrNotFound, "finding table"), status: http.StatusNotFound},
+		{name: "a database error is an internal server error", err: errors.New("connection refused"), status: http.StatusInternalServerError},
+	}
+	requests := []struct {
+		method string
+		path   string
+		body   string
+	}{
+		{"POST", "/api/query", `{"query":"SELECT * FROM dog_registry"}`},
+		{"POST", "/api/explain", `{"query":"SELECT * FROM dog_registry"}`},
+		{"POST", "/api/search", `{"family":"dog_registry"}`},
+		{"PUT", "/api/log", `{"family":"dog_registry","schema":{"name":"string"},"logs":[{"name":"max"}]}`},
+	}
+
+	for _, tt := range cases {
+		for _, req := range requests {
+			t.Run(tt.name+" for "+req.path, func(t *testing.T) {
+				// GIVEN a service failing with the error
+				handler := newHandler(&mockLogService{err: tt.err})
+
+				// WHEN
+				w := httptest.NewRecorder()
+				handler.ServeHTTP(w, httptest.NewRequest(req.method, req.path, strings.NewReader(req.body)))
+
+				// THEN
+				assert.Equal(t, tt.status, w.Code)
+			})
+		}
+	}
+}
+
+func TestServiceUnavailable(t *testing.T) {
+	// GIVEN a service whose database has too many connections
+	handler := newHandler(&mockLogService{err: errors.Wrap(logs.ErrUnavailable, "Error 1040: Too many connections")})
+
+	requests := []struct {
+		method, path, body string
+	}{
+		{"POST", "/api/query", `{"query":"SELECT * FROM dog_registry"}`},
+		{"POST", "/api/search", `{"family":"dog_registry"}`},
+		{"PUT", "/api/log", `{"family":"dog_registry","schema":{"name":"string"},"logs":[{"name":"max"}]}`},
+	}
+	for _, req := range requests {
+		t.Run(req.method+" "+req.path+" is retried later", func(t *testing.T) {
+			// WHEN
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, httptest.NewRequest(req.method, req.path, strings.NewReader(req.body)))
+
+			// THEN
+			assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+			assert.Equal(t, "1", w.Header().Get("Retry-After"))
+			assert.Contains(t, w.Body.String(), "Too many connections")
+		})
+	}
+}
+
+func TestErrorResponses(t *testing.T) {
+	cases := []struct {
+		name   string
+		svc    *mockLogService
+		method string
+		path   string
+		body   string
+		status int
+		result string
+	}{
+		{
+			name:   "an unknown route",
+			svc:    &mockLogService{},
+			method: "GET",
+			path:   "/api/dogs",
+			status: http.StatusNotFound,
+			result: `{"error":"Route not found: GET /api/dogs","code":"not_found"}`,
+		},
+		{
+			name:   "a method the route doesn't support",
+			svc:    &mockLogService{},
+			method: "DELETE",
+			path:   "/api/query",
+			status: http.StatusMethodNotAllowed,
+			result: `{"error":"Method not allowed: DELETE /api/query","code":"method_not_allowed"}`,
+		},
+		{
+			name:   "an invalid id",
+			svc:    &mockLogService{},
+			method: "GET",
+			path:   "/api/log/dog_registry/max",
+			status: http.StatusBadRequest,
+			result: `{"error":"Invalid request: id must be a positive integer, got \"max\"","code":"invalid_request"}`,
+		},
+		{
+			name:   "a missing family",
+			svc:    &mockLogService{},
+			method: "GET",
+			path:   "/api/count/cat_registry",
+			status: http.StatusNotFound,
+			result: `{"error":"Log family not found: cat_registry","code":"not_found"}`,
+		},
+		{
+			name:   "a database error",
+			svc:    &mockLogService{err: errors.New("connection refused")},
+			method: "POST",
+			path:   "/api/query",
+			body:   `{"query":"SELECT * FROM dog_registry"}`,
+			status: http.StatusInternalServerError,
+			result: `{"error":"An error occured querying logs: connection refused","code":"internal"}`,
+		},
+		{
+			name:   "a busy database",
+			svc:    &mockLogService{err: errors.Wrap(logs.ErrUnavailable, "Error 1040: Too many connections")},
+			method: "POST",
+			path:   "/api/search",
+			body:   `{"family":"dog_registry"}`,
+			status: http.StatusServiceUnavailable,
+			result: `{"error":"Service unavailable: Error 1040: Too many connections: database unavailable","code":"unavailable"}`,
+		},
+	}
+
+	for _, tt := range cases {
+		t.Run(tt.name+" is a JSON error", func(t *testing.T) {
+			// WHEN
+			w := httptest.NewRecorder()
+			newHandler(tt.svc).ServeHTTP(w, httptest.NewRequest(tt.method, tt.path, strings.NewReader(tt.body)))
+
+			// THEN
+			assert.Equal(t, tt.status, w.Code)
+			assert.Equal(t, "application/json; charset=UTF-8", w.Header().Get("Content-Type"))
+			assert.JSONEq(t, tt.result, w.Body.String())
+		})
+	}
+}
+
+func TestDropFamily(t *testing.T) {
+	// GIVEN
+	handler := newHandler(&mockLogService{})
+
+	// THEN
+	cases := []requestCase{
+		{
+			name:   "deleting an existing family succeeds",
+			method: "DELETE",
+			path:   "/api/log/dog_registry",
+			status: http.StatusOK,
+		},
+		{
+			name:   "deleting a missing family is not found",
+			method: "DELETE",
+			path:   "/api/log/cat_registry",
+			status: http.StatusNotFound,
+		},
+		{
+			name:   "deleting without a family is not found",
+			method: "DELETE",
+			path:   "/api/log/",
+			status: http.StatusNotFound,
+		},
+	}
+
+	for _, tt := range cases {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, httptest.NewRequest(tt.method, tt.path, strings.NewReader(tt.body)))
+			assert.Equal(t, tt.status, w.Code)
+		})
+	}
+}
+
+func TestTruncateFamily(t *testing.T) {
+	t.Run("truncating a family is routed apart from getting a log", func(t *testing.T) {
+		// GIVEN
+		handler := newHandler(&mockLogService{})
+
+		cases := []requestCase{
+			{
+				name:   "truncating an existing family succeeds",
+				method: "POST",
+				path:   "/api/log/dog_registry/truncate",
+				status: http.StatusOK,
+			},
+			{
+				name:   "truncating a missing family is not found",
+				method: "POST",
+				path:   "/api/log/cat_registry/truncate",
+				status: http.StatusNotFound,
+			},
+			{
+				name:   "truncating with another method is not allowed",
+				method: "PUT",
+				path:   "/api/log/dog_registry/truncate",
+				status: http.StatusMethodNotAllowed,
+			},
+		}
+		for _, tt := range cases {
+			t.Run(tt.name, func(t *testing.T) {
+				w := httptest.NewRecorder()
+				handler.ServeHTTP(w, httptest.NewRequest(tt.method, tt.path, strings.NewReader(tt.body)))
+				assert.Equal(t, tt.status, w.Code)
+			})
+		}
+	})
+
+	t.Run("a truncated family keeps its table", func(t *testing.T) {
+		// GIVEN a family with logs
+		svc := logs.CreateService(memdb.New(), logs.WithLogger(nil))
+		_, err := svc.Ingest(context.Background(), "dog_registry", logs.Schema{"name": "string"}, nil, logs.JSON{{"name": "max"}, {"name": "spot"}})
+		assert.NoError(t, err)
+		handler := newHandler(svc)
+
+		// WHEN
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, httptest.NewRequest("POST", "/api/log/dog_registry/truncate", nil))
+
+		// THEN
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.JSONEq(t, `{}`, w.Body.String())
+
+		// AND the family is empty rather than dropped
+		w = httptest.NewRecorder()
+		handler.ServeHTTP(w, httptest.NewRequest("GET", "/api/count/dog_registry", nil))
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.JSONEq(t, `{"count":0}`, w.Body.String())
+	})
+}
+
+func TestListFamilies(t *testing.T) {
+	// GIVEN
+	handler := newHandler(&mockLogService{})
+
+	// WHEN
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest("GET", "/api/families", nil))
+
+	// THEN the names of the families are listed
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.JSONEq(t, `{"families":["cat_registry","dog_registry"]}`, w.Body.String())
+}
+
+func TestCountFamily(t *testing.T) {
+	// GIVEN
+	handler := newHandler(&mockLogService{})
+
+	t.Run("the logs of a family are counted", func(t *testing.T) {
+		// WHEN
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, httptest.NewRequest("GET", "/api/count/dog_registry", nil))
+
+		// THEN
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.JSONEq(t, `{"count":2}`, w.Body.String())
+	})
+
+	t.Run("a missing family is not found", func(t *testing.T) {
+		// WHEN
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, httptest.NewRequest("GET", "/api/count/cat_registry", nil))
+
+		// THEN
+		assert.Equal(t, http.StatusNotFound, w.Code)
+	})
+}
+
+func TestDescribeFamily(t *testing.T) {
+	// GIVEN
+	handler := newHandler(&mockLogService{})
+
+	t.Run("describing an existing family returns its table", func(t *testing.T) {
+		// WHEN
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, httptest.NewRequest("GET", "/api/describe/dog_registry", nil))
+
+		// THEN
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.JSONEq(t, `{"tables":[{"name":"dog_registry"}]}`, w.Body.String())
+	})
+
+	t.Run("describing a missing family is not found", func(t *testing.T) {
+		// WHEN
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, httptest.NewRequest("GET", "/api/describe/cat_registry", nil))
+
+		// THEN
+		assert.Equal(t, http.StatusNotFound, w.Code)
+	})
+}
+
+func TestQueryArgs(t *testing.T) {
+	// GIVEN
+	handler := newHandler(&mockLogService{})
+
+	// THEN
+	cases := []requestCase{
+		{
+			name:   "a query with an arg per placeholder succeeds",
+			method: "POST",
+			path:   "/api/query",
+			body:   `{"query":"SELECT * FROM dog_registry WHERE breed = ? AND weight > ?","args":["husky",50]}`,
+			status: http.StatusOK,
+		},
+		{
+			name:   "a query with args that don't match its placeholders is rejected",
+			method: "POST",
+			path:   "/api/query",
+			body:   `{"query":"SELECT * FROM dog_registry WHERE breed = ?","args":["husky","labrador"]}`,
+			status: http.StatusBadRequest,
+		},
+	}
+
+	for _, tt := range cases {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, httptest.NewRequest(tt.method, tt.path, strings.NewReader(tt.body)))
+			assert.Equal(t, tt.status, w.Code)
+		})
+	}
+}
+
+func TestQueryPage(t *testing.T) {
+	// GIVEN
+	handler := newHandler(&mockLogService{})
+
+	t.Run("the applied page is returned with the results", func(t *testing.T) {
+		// WHEN
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, httptest.NewRequest("POST", "/api/query", strings.NewReader(`{"query":"SELECT * FROM dog_registry","limit":10,"offset":20}`)))
+
+		// THEN
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.JSONEq(t, `{"results":[],"page":{"limit":10,"offset":20}}`, w.Body.String())
+	})
+
+	t.Run("the page is left out when the query isn't paginated", func(t *testing.T) {
+		// WHEN
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, httptest.NewRequest("POST", "/api/query", strings.NewReader(`{"query":"SELECT * FROM dog_registry"}`)))
+
+		// THEN
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.JSONEq(t, `{"results":[]}`, w.Body.String())
+	})
+
+	cases := []requestCase{
+		{
+			name:   "a page conflicting with the query is rejected",
+			method: "POST",
+			path:   "/api/query",
+			body:   `{"query":"SELECT * FROM dog_registry LIMIT 5","limit":10}`,
+			status: http.StatusBadRequest,
+		},
+		{
+			name:   "a saved query can't be paginated",
+			method: "POST",
+			path:   "/api/query",
+			body:   `{"saved":"top_dogs","limit":10}`,
+			status: http.StatusBadRequest,
+		},
+	}
+	for _, tt := range cases {
+		t.Run(tt.name, func(t *testing.T) {
+			// GIVEN a service which rejects the page
+			handler := newHandler(&mockLogService{err: errors.Wrap(logs.ErrInvalidPage, "query already has a LIMIT")})
+
+			// WHEN
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, httptest.NewRequest(tt.method, tt.path, strings.NewReader(tt.body)))
+
+			// THEN
+			assert.Equal(t, tt.status, w.Code)
+		})
+	}
+}
+
+func TestQueryEmptyResults(t *testing.T) {
+	// GIVEN
+	handler := newHandler(&mockLogService{})
+
+	// WHEN a query matches no rows
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest("POST", "/api/query", strings.NewReader(`{"query":"SELECT * FROM dog_registry"}`)))
+
+	// THEN the results are an empty list rather than null
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.JSONEq(t, `{"results":[]}`, w.Body.String())
+}
+
+func TestQueryMeta(t *testing.T) {
+	// GIVEN
+	handler := newHandler(&mockLogService{})
+
+	t.Run("the columns are returned in order with ?meta=1", func(t *testing.T) {
+		// WHEN
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, httptest.NewRequest("POST", "/api/query?meta=1", strings.NewReader(`{"saved":"top_dogs"}`)))
+
+		// THEN
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.JSONEq(t, `{
+			"columns": [{"name":"name","type":"VARCHAR"},{"name":"weight","type":"DOUBLE"}],
+			"results": [{"name":"max","weight":3},{"name":"spot","weight":100}]
+		}`, w.Body.String())
+	})
+
+	t.Run("the columns are left out by default", func(t *testing.T) {
+		// WHEN
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, httptest.NewRequest("POST", "/api/query", strings.NewReader(`{"saved":"top_dogs"}`)))
+
+		// THEN
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.JSONEq(t, `{"results": [{"name":"max","weight":3},{"name":"spot","weight":100}]}`, w.Body.String())
+	})
+}
+
+func TestQueryGzip(t *testing.T) {
+	// GIVEN a query with a large response
+	var rows logs.JSON
+	for i := 0; i < 100; i++ {
+		rows = append(rows, map[string]interface{}{"name": "max", "breed": "chihuahua", "weight": float64(i)})
+	}
+	handler := newHandler(&mockLogService{rows: rows})
+	query := func(body string, acceptEncoding string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest("POST", "/api/query", strings.NewReader(body))
+		r.Header.Set("Accept-Encoding", acceptEncoding)
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+		return w
+	}
+	expected, err := json.Marshal(map[string]interface{}{"results": rows})
+	assert.NoError(t, err)
+
+	t.Run("a large response is compressed when accepted", func(t *testing.T) {
+		// WHEN
+		w := query(`{"query":"SELECT * FROM dog_registry"}`, "gzip, deflate")
+
+		// THEN
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Equal(t, "gzip", w.Header().Get("Content-Encoding"))
+		assert.Equal(t, "Accept-Encoding", w.Header().Get("Vary"))
+		gz, err := gzip.NewReader(w.Body)
+		if assert.NoError(t, err) {
+			decoded, err := ioutil.ReadAll(gz)
+			assert.NoError(t, err)
+			assert.JSONEq(t, string(expected), string(decoded))
+		}
+	})
+
+	t.Run("a response isn't compressed unless accepted", func(t *testing.T) {
+		// WHEN
+		w := query(`{"query":"SELECT * FROM dog_registry"}`, "gzip;q=0")
+
+		// THEN
+		assert.Empty(t, w.Header().Get("Content-Encoding"))
+		assert.JSONEq(t, string(expected), w.Body.String())
+	})
+
+	t.Run("a small response isn't compressed", func(t *testing.T) {
+		// WHEN
+		w := query(`{"query":"SELECT * FROM dog_registry WHERE name = ?"}`, "gzip")
+
+		// THEN the error is sent as is
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+		assert.Empty(t, w.Header().Get("Content-Encoding"))
+		assert.Contains(t, w.Body.String(), "Invalid query args")
+	})
+}
+
+func TestQueryTimeout(t *testing.T) {
+	// GIVEN
+	handler := newHandler(&mockLogService{err: errors.Wrap(logs.ErrQueryTimeout, "querying database client")})
+
+	// THEN
+	for _, accept := range []string{"application/json", "application/x-ndjson"} {
+		t.Run("a query that times out returns a 504 for "+accept, func(t *testing.T) {
+			r := httptest.NewRequest("POST", "/api/query", strings.NewReader(`{"query":"SELECT * FROM dog_registry"}`))
+			r.Header.Set("Accept", accept)
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, r)
+			assert.Equal(t, http.StatusGatewayTimeout, w.Code)
+		})
+	}
+}
+
+func TestExplain(t *testing.T) {
+	t.Run("the plan of a query is returned", func(t *testing.T) {
+		// GIVEN
+		handler := newHandler(&mockLogService{rows: logs.JSON{{"id": 1, "select_type": "SIMPLE", "table": "dog_registry"}}})
+
+		// WHEN
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, httptest.NewRequest("POST", "/api/explain", strings.NewReader(`{"query":"SELECT * FROM dog_registry"}`)))
+
+		// THEN
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.JSONEq(t, `{"plan":[{"id":1,"select_type":"SIMPLE","table":"dog_registry"}]}`, w.Body.String())
+	})
+
+	t.Run("a query that times out returns a 504", func(t *testing.T) {
+		// GIVEN
+		handler := newHandler(&mockLogService{err: errors.Wrap(logs.ErrQueryTimeout, "querying database client")})
+
+		// WHEN
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, httptest.NewRequest("POST", "/api/explain", strings.NewReader(`{"query":"SELECT * FROM dog_registry"}`)))
+
+		// THEN
+		assert.Equal(t, http.StatusGatewayTimeout, w.Code)
+	})
+
+	t.Run("an invalid body is a bad request", func(t *testing.T) {
+		// GIVEN
+		handler := newHandler(&mockLogService{})
+
+		cases := []struct {
+			name  string
+			body  string
+			field string
+		}{
+			{name: "a body that isn't JSON", body: `{not json`},
+			{name: "an unknown field", body: `{"query":"SELECT 1","bogus":1}`, field: "bogus"},
+			{name: "a query that isn't a string", body: `{"query":1}`, field: "query"},
+		}
+		for _, tt := range cases {
+			t.Run(tt.name, func(t *testing.T) {
+				// WHEN
+				w := httptest.NewRecorder()
+				handler.ServeHTTP(w, httptest.NewRequest("POST", "/api/explain", strings.NewReader(tt.body)))
+
+				// THEN
+				assert.Equal(t, http.StatusBadRequest, w.Code)
+				var invalid struct {
+					Code  string `json:"code"`
+					Field string `json:"field"`
+				}
+				assert.NoError(t, json.NewDecoder(w.Body).Decode(&invalid))
+				assert.Equal(t, "invalid_request", invalid.Code)
+				assert.Equal(t, tt.field, invalid.Field)
+			})
+		}
+	})
+
+	// GIVEN a service backed by an in-memory database with a family
+	svc := logs.CreateService(memdb.New(), logs.WithLogger(nil))
+	_, err := svc.Ingest(context.Background(), "dog_registry", logs.Schema{"name": "string"}, nil, logs.JSON{{"name": "max"}})
+	assert.NoError(t, err)
+	handler := newHandler(svc)
+
+	for _, query := range []string{
+		"DELETE FROM dog_registry",
+		"DROP TABLE dog_registry",
+		"SELECT SLEEP(10) FROM dog_registry",
+		"SELECT * FROM dog_registry; DELETE FROM dog_registry",
+	} {
+		t.Run("a query that isn't read only can't be explained: "+query, func(t *testing.T) {
+			// WHEN
+			body, _ := json.Marshal(map[string]string{"query": query})
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, httptest.NewRequest("POST", "/api/explain", bytes.NewReader(body)))
+
+			// THEN it's rejected, and the logs are left as they were
+			assert.Equal(t, http.StatusBadRequest, w.Code)
+			count, err := svc.CountFamily(context.Background(), "dog_registry")
+			assert.NoError(t, err)
+			assert.Equal(t, int64(1), count)
+		})
+	}
+}
+
+func TestGetLog(t *testing.T) {
+	// GIVEN a service backed by an in-memory database with a family
+	svc := logs.CreateService(memdb.New(), logs.WithLogger(nil))
+	_, err := svc.Ingest(context.Background(), "dog_registry", logs.Schema{"name": "string", "breed": "string"}, nil, logs.JSON{
+		{"name": "max", "breed": "husky"},
+		{"name": "spot", "breed": "beagle"},
+	})
+	assert.NoError(t, err)
+	handler := newHandler(svc)
+
+	cases := []struct {
+		name   string
+		path   string
+		status int
+		result string
+	}{
+		{
+			name:   "the log with the id is returned",
+			path:   "/api/log/dog_registry/2",
+			status: http.StatusOK,
+			result: `{"name":"spot","breed":"beagle"}`,
+		},
+		{
+			name:   "a missing id is not found",
+			path:   "/api/log/dog_registry/3",
+			status: http.StatusNotFound,
+		},
+		{
+			name:   "a missing family is not found",
+			path:   "/api/log/cat_registry/1",
+			status: http.StatusNotFound,
+		},
+		{
+			name:   "a non-numeric id is a bad request",
+			path:   "/api/log/dog_registry/max",
+			status: http.StatusBadRequest,
+		},
+		{
+			name:   "an injection in the id is a bad request",
+			path:   "/api/log/dog_registry/1%20OR%201=1",
+			status: http.StatusBadRequest,
+		},
+		{
+			name:   "a negative id is a bad request",
+			path:   "/api/log/dog_registry/-1",
+			status: http.StatusBadRequest,
+		},
+		{
+			name:   "an invalid family is a bad request",
+			path:   "/api/log/dog-registry/1",
+			status: http.StatusBadRequest,
+		},
+	}
+
+	for _, tt := range cases {
+		t.Run(tt.name, func(t *testing.T) {
+			// WHEN
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, httptest.NewRequest("GET", tt.path, nil))
+
+			// THEN
+			assert.Equal(t, tt.status, w.Code)
+			if tt.result != "" {
+				assert.JSONEq(t, tt.result, w.Body.String())
+			}
+		})
+	}
+}
+
+func TestSearch(t *testing.T) {
+	// GIVEN a service backed by an in-memory database with a family
+	svc := logs.CreateService(memdb.New(), logs.WithLogger(nil))
+	_, err := svc.Ingest(context.Background(), "dog_registry", logs.Schema{"name": "string", "breed": "string"}, nil, logs.JSON{
+		{"name": "max", "breed": "husky"},
+		{"name": "spot", "breed": "beagle"},
+		{"name": "rex", "breed": "boxer"},
+	})
+	assert.NoError(t, err)
+	handler := newHandler(svc)
+
+	t.Run("the logs matching the conditions are returned", func(t *testing.T) {
+		// WHEN
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, httptest.NewRequest("POST", "/api/search", strings.NewReader(
+			`{"family":"dog_registry","where":[{"field":"breed","op":"IN","value":["husky","beagle"]},{"field":"name","op":"!=","value":"spot"}],"limit":100}`)))
+
+		// THEN
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.JSONEq(t, `{"results":[{"name":"max","breed":"husky"}]}`, w.Body.String())
+	})
+
+	t.Run("the logs with or without a value for a field are returned", func(t *testing.T) {
+		// GIVEN cats with and without an owner
+		_, err := svc.Ingest(context.Background(), "cat_registry", logs.Schema{"name": "string", "owner": "string"}, nil, logs.JSON{
+			{"name": "tom", "owner": "ann"},
+			{"name": "felix"},
+			{"name": "kitty"},
+		})
+		assert.NoError(t, err)
+
+		// WHEN
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, httptest.NewRequest("POST", "/api/search", strings.NewReader(
+			`{"family":"cat_registry","where":[{"field":"owner","op":"is_null"}]}`)))
+
+		// THEN
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.JSONEq(t, `{"results":[{"name":"felix","owner":null},{"name":"kitty","owner":null}]}`, w.Body.String())
+
+		// WHEN
+		w = httptest.NewRecorder()
+		handler.ServeHTTP(w, httptest.NewRequest("POST", "/api/search", strings.NewReader(
+			`{"family":"cat_registry","where":[{"field":"owner","op":"is_not_null"}]}`)))
+
+		// THEN
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.JSONEq(t, `{"results":[{"name":"tom","owner":"ann"}]}`, w.Body.String())
+	})
+
+	t.Run("an injection in a field doesn't match anything", func(t *testing.T) {
+		// WHEN
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, httptest.NewRequest("POST", "/api/search", strings.NewReader(
+			`{"family":"dog_registry","where":[{"field":"breed = 'husky' OR 1=1 OR breed","op":"=","value":"husky"}]}`)))
+
+		// THEN the field is the name of a column that doesn't exist
+		assert.NotEqual(t, http.StatusOK, w.Code)
+		assert.NotContains(t, w.Body.String(), "spot")
+	})
+
+	cases := []struct {
+		name   string
+		body   string
+		status int
+		result string
+	}{
+		{
+			name:   "an unknown operator is a bad request",
+			body:   `{"family":"dog_registry","where":[{"field":"breed","op":"LIKE","value":"h%"}]}`,
+			status: http.StatusBadRequest,
+		},
+		{
+			name:   "a null test with a value is a bad request",
+			body:   `{"family":"dog_registry","where":[{"field":"breed","op":"is_null","value":"husky"}]}`,
+			status: http.StatusBadRequest,
+		},
+		{
+			name:   "an invalid family is a bad request",
+			body:   `{"family":"dog_registry; DROP TABLE dog_registry"}`,
+			status: http.StatusBadRequest,
+		},
+		{
+			name:   "a value of the wrong type is a bad request",
+			body:   `{"family":"dog_registry","where":[{"field":"breed","op":"=","value":{"name":"husky"}}]}`,
+			status: http.StatusBadRequest,
+			result: `{"field":"where[0].value","error":"invalid value of type object","code":"invalid_request"}`,
+		},
+		{
+			name:   "an unknown field is a bad request",
+			body:   `{"family":"dog_registry","filter":[]}`,
+			status: http.StatusBadRequest,
+			result: `{"field":"filter","error":"unknown field","code":"invalid_request"}`,
+		},
+	}
+	for _, tt := range cases {
+		t.Run(tt.name, func(t *testing.T) {
+			// WHEN
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, httptest.NewRequest("POST", "/api/search", strings.NewReader(tt.body)))
+
+			// THEN
+			assert.Equal(t, tt.status, w.Code)
+			if tt.result != "" {
+				assert.JSONEq(t, tt.result, w.Body.String())
+			}
+		})
+	}
+}
+
+func TestQueryCSV(t *testing.T) {
+	// GIVEN a service backed by an in-memory database with a family
+	svc := logs.CreateService(memdb.New(), logs.WithLogger(nil))
+	_, err := svc.Ingest(context.Background(), "dog_registry", logs.Schema{"name": "string", "nickname": "string", "weight": "int"}, nil, logs.JSON{
+		{"name": "max, the dog", "nickname": "maxy", "weight": float64(30)},
+		{"name": "spot", "weight": float64(12)},
+	})
+	assert.NoError(t, err)
+	handler := newHandler(svc)
+
+	// WHEN the results are asked for as CSV
+	r := httptest.NewRequest("POST", "/api/query", strings.NewReader(`{"query":"SELECT weight, name, nickname FROM dog_registry ORDER BY weight DESC"}`))
+	r.Header.Set("Accept", "text/csv")
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, r)
+
+	// THEN the header has the columns in the order they were selected, a
+	// value with a comma is quoted, and a null is an empty field
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "text/csv; charset=UTF-8", w.Header().Get("Content-Type"))
+	assert.Equal(t, "weight,name,nickname\n30,\"max, the dog\",maxy\n12,spot,\n", w.Body.String())
+}
+
+func TestQueryArrow(t *testing.T) {
+	// GIVEN a service backed by an in-memory database with a family, and
+	// the results of a query as JSON with the columns
+	svc := logs.CreateService(memdb.New(), logs.WithLogger(nil))
+	_, err := svc.Ingest(context.Background(), "dog_registry", logs.Schema{"name": "string", "nickname": "string", "weight": "int", "meta": "json"}, nil, logs.JSON{
+		{"name": "max", "nickname": "maxy", "weight": float64(30), "meta": map[string]interface{}{"breed": "husky"}},
+		{"name": "spot", "weight": float64(12), "meta": []interface{}{"good", "dog"}},
+	})
+	assert.NoError(t, err)
+	handler := newHandler(svc)
+	query := `{"query":"SELECT weight, name, nickname, meta FROM dog_registry ORDER BY weight DESC"}`
+
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest("POST", "/api/query?meta=1", strings.NewReader(query)))
+	assert.Equal(t, http.StatusOK, w.Code)
+	var expected struct {
+		Columns []logs.Column `json:"columns"`
+		Results logs.JSON     `json:"results"`
+	}
+	assert.NoError(t, json.NewDecoder(w.Body).Decode(&expected))
+
+	// WHEN the results are asked for as an Arrow stream
+	r := httptest.NewRequest("POST", "/api/query", strings.NewReader(query))
+	r.Header.Set("Accept", "application/vnd.apache.arrow.stream")
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, r)
+
+	// THEN the schema has a field per column in the order they were
+	// selected, typed after the column, and a record with the same values
+	// as the JSON results
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "application/vnd.apache.arrow.stream", w.Header().Get("Content-Type"))
+	reader, err := ipc.NewReader(w.Body)
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer reader.Release()
+
+	assert.Equal(t, []logs.Column{{Name: "weight", Type: "INT"}, {Name: "name", Type: "TEXT"}, {Name: "nickname", Type: "TEXT"}, {Name: "meta", Type: "JSON"}}, expected.Columns)
+	assert.Equal(t, []arrow.Field{
+		{Name: "weight", Type: arrow.PrimitiveTypes.Int64, Nullable: true},
+		{Name: "name", Type: arrow.BinaryTypes.String, Nullable: true},
+		{Name: "nickname", Type: arrow.BinaryTypes.String, Nullable: true},
+		{Name: "meta", Type: arrow.BinaryTypes.String, Nullable: true},
+	}, reader.Schema().Fields())
+
+	if !assert.True(t, reader.Next()) {
+		return
+	}
+	record := reader.Record()
+	assert.Equal(t, int64(len(expected.Results)), record.NumRows())
+	weights := record.Column(0).(*array.Int64)
+	names := record.Column(1).(*array.String)
+	nicknames := record.Column(2).(*array.String)
+	metas := record.Column(3).(*array.String)
+	for i, result := range expected.Results {
+		assert.Equal(t, result["weight"], float64(weights.Value(i)))
+		assert.Equal(t, result["name"], names.Value(i))
+		if result["nickname"] == nil {
+			assert.True(t, nicknames.IsNull(i))
+		} else {
+			assert.Equal(t, result["nickname"], nicknames.Value(i))
+		}
+		meta, err := json.Marshal(result["meta"])
+		assert.NoError(t, err)
+		assert.JSONEq(t, string(meta), metas.Value(i))
+	}
+	assert.False(t, reader.Next())
+	assert.NoError(t, reader.Err())
+}
+
+func TestQueryArrowDecimal(t *testing.T) {
+	// GIVEN a family with a decimal with more digits than a double holds
+	svc := logs.CreateService(memdb.New(), logs.WithLogger(nil))
+	_, err := svc.Ingest(context.Background(), "dog_registry", logs.Schema{"name": "string", "price": "decimal(19,2)"}, nil, logs.JSON{
+		{"name": "max", "price": "12345678901234567.89"},
+		{"name": "spot"},
+	})
+	assert.NoError(t, err)
+	handler := newHandler(svc)
+
+	// WHEN the decimals are asked for as an Arrow stream
+	r := httptest.NewRequest("POST", "/api/query", strings.NewReader(`{"query":"SELECT price FROM dog_registry ORDER BY name"}`))
+	r.Header.Set("Accept", "application/vnd.apache.arrow.stream")
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, r)
+
+	// THEN the decimal is a string with every digit, and a null is a null
+	assert.Equal(t, http.StatusOK, w.Code)
+	reader, err := ipc.NewReader(w.Body)
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer reader.Release()
+	assert.Equal(t, []arrow.Field{{Name: "price", Type: arrow.BinaryTypes.String, Nullable: true}}, reader.Schema().Fields())
+	if !assert.True(t, reader.Next()) {
+		return
+	}
+	prices := reader.Record().Column(0).(*array.String)
+	assert.Equal(t, 2, prices.Len())
+	assert.Equal(t, "12345678901234567.89", prices.Value(0))
+	assert.True(t, prices.IsNull(1))
+}
+
+func TestQueryNDJSON(t *testing.T) {
+	t.Run("results are streamed a row per line when accepted", func(t *testing.T) {
+		// GIVEN
+		handler := newHandler(&mockLogService{})
+		r := httptest.NewRequest("POST", "/api/query", strings.NewReader(`{"query":"SELECT * FROM dog_registry"}`))
+		r.Header.Set("Accept", "application/x-ndjson")
+
+		// WHEN
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+
+		// THEN
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Equal(t, "application/x-ndjson; charset=UTF-8", w.Header().Get("Content-Type"))
+		assert.Equal(t, "{\"name\":\"max\",\"weight\":3}\n{\"name\":\"spot\",\"weight\":100}\n", w.Body.String())
+	})
+
+	t.Run("saved query results are streamed a row per line when accepted", func(t *testing.T) {
+		// GIVEN
+		handler := newHandler(&mockLogService{})
+		r := httptest.NewRequest("POST", "/api/query", strings.NewReader(`{"saved":"top_dogs"}`))
+		r.Header.Set("Accept", "application/json, application/x-ndjson")
+
+		// WHEN
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+
+		// THEN
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Equal(t, "{\"name\":\"max\",\"weight\":3}\n{\"name\":\"spot\",\"weight\":100}\n", w.Body.String())
+	})
+
+	t.Run("an error before any row is an error response", func(t *testing.T) {
+		// GIVEN
+		handler := newHandler(&mockLogService{err: errors.New("querying database: connection refused")})
+		r := httptest.NewRequest("POST", "/api/query", strings.NewReader(`{"query":"SELECT * FROM dog_registry"}`))
+		r.Header.Set("Accept", "application/x-ndjson")
+
+		// WHEN
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+
+		// THEN
+		assert.Equal(t, http.StatusInternalServerError, w.Code)
+	})
+}
+
+func TestSavedQueries(t *testing.T) {
+	// GIVEN
+	handler := newHandler(&mockLogService{})
+
+	// THEN
+	cases := []requestCase{
+		{
+			name:   "saving a query succeeds",
+			method: "POST",
+			path:   "/api/saved-queries",
+			body:   `{"name": "top_dogs", "query": "SELECT * FROM dog_registry WHERE breed = :breed"}`,
+			status: http.StatusOK,
+		},
+		{
+			name:   "running a saved query with params succeeds",
+			method: "POST",
+			path:   "/api/query",
+			body:   `{"saved": "top_dogs", "params": {"breed": "beagle"}}`,
+			status: http.StatusOK,
+		},
+		{
+			name:   "running a missing saved query is not found",
+			method: "POST",
+			path:   "/api/query",
+			body:   `{"saved": "bottom_dogs"}`,
+			status: http.StatusNotFound,
+		},
+		{
+			name:   "saving a body that isn't JSON is a bad request",
+			method: "POST",
+			path:   "/api/saved-queries",
+			body:   `{not json`,
+			status: http.StatusBadRequest,
+		},
+		{
+			name:   "saving a query with a name that isn't a string is a bad request",
+			method: "POST",
+			path:   "/api/saved-queries",
+			body:   `{"name": 1, "query": "SELECT * FROM dog_registry"}`,
+			status: http.StatusBadRequest,
+		},
+		{
+			name:   "saving a query that isn't a string is a bad request",
+			method: "POST",
+			path:   "/api/saved-queries",
+			body:   `{"name": "top_dogs", "query": ["SELECT * FROM dog_registry"]}`,
+			status: http.StatusBadRequest,
+		},
+		{
+			name:   "saving a query with an unknown field is a bad request",
+			method: "POST",
+			path:   "/api/saved-queries",
+			body:   `{"name": "top_dogs", "query": "SELECT * FROM dog_registry", "params": {}}`,
+			status: http.StatusBadRequest,
+		},
+	}
+
+	for _, tt := range cases {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, httptest.NewRequest(tt.method, tt.path, strings.NewReader(tt.body)))
+			assert.Equal(t, tt.status, w.Code)
+		})
+	}
+}
+
+func TestSavedQueryParams(t *testing.T) {
+	// GIVEN a query saved with a param
+	svc := logs.CreateService(memdb.New(), logs.WithLogger(nil))
+	assert.NoError(t, svc.SaveQuery(context.Background(), "top_dogs", "SELECT * FROM dog_registry WHERE breed = :breed"))
+	handler := newHandler(svc)
+
+	cases := []requestCase{
+		{
+			name:   "a missing param is a bad request",
+			method: "POST",
+			path:   "/api/query",
+			body:   `{"saved": "top_dogs", "params": {}}`,
+			status: http.StatusBadRequest,
+		},
+		{
+			name:   "an object param is a bad request",
+			method: "POST",
+			path:   "/api/query",
+			body:   `{"saved": "top_dogs", "params": {"breed": {"name": "beagle"}}}`,
+			status: http.StatusBadRequest,
+		},
+	}
+
+	for _, tt := range cases {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, httptest.NewRequest(tt.method, tt.path, strings.NewReader(tt.body)))
+			assert.Equal(t, tt.status, w.Code)
+			assert.Contains(t, w.Body.String(), `"code":"invalid_request"`)
+		})
+	}
+}
+
+func TestMergeFamilies(t *testing.T) {
+	// GIVEN
+	handler := newHandler(&mockLogService{})
+
+	// THEN
+	cases := []requestCase{
+		{
+			name:   "merging families succeeds",
+			method: "POST",
+			path:   "/api/admin/merge",
+			body:   `{"target": "pets", "sources": ["dog_registry", "cat_registry"], "drop_sources": true}`,
+			status: http.StatusOK,
+		},
+		{
+			name:   "an invalid merge is a bad request",
+			method: "POST",
+			path:   "/api/admin/merge",
+			body:   `{"sources": ["dog_registry"]}`,
+			status: http.StatusBadRequest,
+		},
+		{
+			name:   "merging incompatible families is a conflict",
+			method: "POST",
+			path:   "/api/admin/merge",
+			body:   `{"target": "conflicts", "sources": ["dog_registry", "cat_registry"]}`,
+			status: http.StatusConflict,
+		},
+		{
+			name:   "a body that isn't JSON is a bad request",
+			method: "POST",
+			path:   "/api/admin/merge",
+			body:   `{not json`,
+			status: http.StatusBadRequest,
+		},
+		{
+			name:   "a source that isn't a string is a bad request",
+			method: "POST",
+			path:   "/api/admin/merge",
+			body:   `{"target": "pets", "sources": ["dog_registry", 1]}`,
+			status: http.StatusBadRequest,
+		},
+		{
+			name:   "an unknown field is a bad request",
+			method: "POST",
+			path:   "/api/admin/merge",
+			body:   `{"target": "pets", "sources": ["dog_registry"], "drop_source": true}`,
+			status: http.StatusBadRequest,
+		},
+	}
+
+	for _, tt := range cases {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, httptest.NewRequest(tt.method, tt.path, strings.NewReader(tt.body)))
+			assert.Equal(t, tt.status, w.Code)
+		})
+	}
+}
+
+func TestHealthz(t *testing.T) {
+	t.Run("a healthy service responds ok", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		newHandler(&mockLogService{}).ServeHTTP(w, httptest.NewRequest("GET", "/healthz", nil))
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.JSONEq(t, `{"status":"ok"}`, w.Body.String())
+	})
+
+	t.Run("an unhealthy service responds unavailable", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		service := &mockLogService{err: errors.New("pinging database: connection refused")}
+		newHandler(service).ServeHTTP(w, httptest.NewRequest("GET", "/healthz", nil))
+		assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+		assert.JSONEq(t, `{"status":"unavailable","error":"pinging database: connection refused"}`, w.Body.String())
+	})
+}
+
+func TestHTTPShutdown(t *testing.T) {
+	// GIVEN a running server
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() {
+		done <- server.HTTP(ctx, "127.0.0.1:0", &mockLogService{}, server.WithLogger(nil))
+	}()
+
+	// WHEN its context is done
+	cancel()
+
+	// THEN it shuts down without an error
+	select {
+	case err := <-done:
+		assert.NoError(t, err)
+	case <-time.After(time.Second):
+		t.Fatal("server did not shut down")
+	}
+}
+
+func TestLogger(t *testing.T) {
+	// GIVEN a handler logging to a buffer, backed by a failing service
+	var buf bytes.Buffer
+	handler := newHandler(&mockLogService{err: errors.New("database is down")},
+		server.WithLogger(slog.New(slog.NewTextHandler(&buf, nil))),
+	)
+
+	// WHEN logs are ingested
+	w := httptest.NewRecorder()
+	body := `{"family":"dog_registry","schema":{"name":"string"},"logs":[{"name":"max"}]}`
+	handler.ServeHTTP(w, httptest.NewRequest("PUT", "/api/log", strings.NewReader(body)))
+
+	// THEN the error is logged along with the family
+	assert.Equal(t, http.StatusInternalServerError, w.Code)
+	assert.Contains(t, buf.String(), "level=ERROR")
+	assert.Contains(t, buf.String(), "family=dog_registry")
+	assert.Contains(t, buf.String(), "database is down")
+}
+
+func TestRequestID(t *testing.T) {
+	// GIVEN a handler logging to a buffer, backed by a failing service
+	var buf bytes.Buffer
+	handler := newHandler(&mockLogService{err: errors.New("database is down")},
+		server.WithLogger(slog.New(slog.NewTextHandler(&buf, nil))),
+	)
+	body := `{"query":"SELECT * FROM dog_registry"}`
+
+	t.Run("the ID of the request is echoed back and logged", func(t *testing.T) {
+		// WHEN
+		buf.Reset()
+		r := httptest.NewRequest("POST", "/api/query", strings.NewReader(body))
+		r.Header.Set("X-Request-ID", "req-1234")
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+
+		// THEN
+		assert.Equal(t, "req-1234", w.Header().Get("X-Request-ID"))
+		assert.Contains(t, buf.String(), "request_id=req-1234")
+	})
+
+	t.Run("an ID is generated for a request without one", func(t *testing.T) {
+		// WHEN
+		buf.Reset()
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, httptest.NewRequest("POST", "/api/query", strings.NewReader(body)))
+
+		// THEN the ID is a UUID
+		id := w.Header().Get("X-Request-ID")
+		assert.Regexp(t, `^[0-9a-f]{8}-[0-9a-f]{4}-4[0-9a-f]{3}-[89ab][0-9a-f]{3}-[0-9a-f]{12}$`, id)
+		assert.Contains(t, buf.String(), "request_id="+id)
+	})
+
+	t.Run("an ID which can't be logged as is is replaced", func(t *testing.T) {
+		// WHEN
+		r := httptest.NewRequest("GET", "/healthz", nil)
+		r.Header.Set("X-Request-ID", "req 1234\nlevel=ERROR")
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+
+		// THEN
+		assert.Len(t, w.Header().Get("X-Request-ID"), 36)
+	})
+
+	t.Run("each request gets its own ID", func(t *testing.T) {
+		// WHEN
+		first, second := httptest.NewRecorder(), httptest.NewRecorder()
+		handler.ServeHTTP(first, httptest.NewRequest("GET", "/api/stats", nil))
+		handler.ServeHTTP(second, httptest.NewRequest("GET", "/api/stats", nil))
+
+		// THEN
+		assert.NotEqual(t, first.Header().Get("X-Request-ID"), second.Header().Get("X-Request-ID"))
+	})
+}
+
+func TestMiddleware(t *testing.T) {
+	// GIVEN middlewares recording the order they see a request and its response
+	var calls []string
+	record := func(name string) server.Middleware {
+		return func(next http.Handler) http.Handler {
+			return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				calls = append(calls, name+" request "+r.Header.Get("X-Request-ID"))
+				next.ServeHTTP(w, r)
+				calls = append(calls, name+" response")
+			})
+		}
+	}
+
+	t.Run("the first middleware of a chain is the outermost", func(t *testing.T) {
+		// WHEN
+		calls = nil
+		handler := server.Chain(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			calls = append(calls, "handler")
+		}), record("first"), record("second"))
+		handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/healthz", nil))
+
+		// THEN
+		assert.Equal(t, []string{"first request ", "second request ", "handler", "second response", "first response"}, calls)
+	})
+
+	t.Run("the middlewares of the handler run in order around its routes", func(t *testing.T) {
+		// GIVEN
+		calls = nil
+		handler := newHandler(&mockLogService{}, server.WithMiddleware(record("first")), server.WithMiddleware(record("second")))
+
+		// WHEN
+		r := httptest.NewRequest("GET", "/healthz", nil)
+		r.Header.Set("X-Request-ID", "req-1234")
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+
+		// THEN the request is routed, and tagged with its ID before the middlewares
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Equal(t, "req-1234", w.Header().Get("X-Request-ID"))
+		assert.Equal(t, []string{"first request req-1234", "second request req-1234", "second response", "first response"}, calls)
+	})
+
+	t.Run("a middleware can answer a request without routing it", func(t *testing.T) {
+		// GIVEN
+		svc := &mockLogService{}
+		deny := func(next http.Handler) http.Handler {
+			return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				http.Error(w, "Forbidden", http.StatusForbidden)
+			})
+		}
+		handler := newHandler(svc, server.WithMiddleware(deny))
+
+		// WHEN
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, httptest.NewRequest("PUT", "/api/log", strings.NewReader(`{"family":"dog_registry","logs":[{"name":"spot"}]}`)))
+
+		// THEN
+		assert.Equal(t, http.StatusForbidden, w.Code)
+		assert.NotEmpty(t, w.Header().Get("X-Request-ID"))
+		assert.Nil(t, svc.ingested)
+	})
+}
+
+func TestStats(t *testing.T) {
+	w := httptest.NewRecorder()
+	newHandler(&mockLogService{}).ServeHTTP(w, httptest.NewRequest("GET", "/api/stats", nil))
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.JSONEq(t, `{"families":[{"family":"dog_registry","records":3,"records_per_second":0.05}]}`, w.Body.String())
+}
+
+func TestRoutes(t *testing.T) {
+	// GIVEN
+	handler := newHandler(&mockLogService{})
+
+	// THEN
+	cases := []requestCase{
+		{
+			name:   "a known path with another method is not allowed",
+			method: "GET",
+			path:   "/api/log",
+			status: http.StatusMethodNotAllowed,
+		},
+		{
+			name:   "a known path with a parameter and another method is not allowed",
+			method: "GET",
+			path:   "/api/log/dog_registry",
+			status: http.StatusMethodNotAllowed,
+		},
+		{
+			name:   "an unknown path is not found",
+			method: "GET",
+			path:   "/api/unknown",
+			status: http.StatusNotFound,
+		},
+		{
+			name:   "a known path with extra segments is not found",
+			method: "GET",
+			path:   "/api/stats/dog_registry",
+			status: http.StatusNotFound,
+		},
+	}
+
+	for _, tt := range cases {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, httptest.NewRequest(tt.method, tt.path, strings.NewReader(tt.body)))
+			assert.Equal(t, tt.status, w.Code)
+		})
+	}
+
+	t.Run("a method not allowed lists the allowed methods", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, httptest.NewRequest("GET", "/api/log", nil))
+		assert.Equal(t, "PUT, OPTIONS", w.Header().Get("Allow"))
+	})
+}
+
+func TestOptions(t *testing.T) {
+	// GIVEN
+	handler := newHandler(&mockLogService{})
+
+	t.Run("the methods of a path are allowed", func(t *testing.T) {
+		// WHEN
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, httptest.NewRequest("OPTIONS", "/api/log", nil))
+
+		// THEN
+		assert.Equal(t, http.StatusNoContent, w.Code)
+		assert.Equal(t, "PUT, OPTIONS", w.Header().Get("Allow"))
+		assert.Empty(t, w.Body.String())
+	})
+
+	t.Run("HEAD is allowed with GET", func(t *testing.T) {
+		// WHEN
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, httptest.NewRequest("OPTIONS", "/api/describe/dog_registry", nil))
+
+		// THEN
+		assert.Equal(t, http.StatusNoContent, w.Code)
+		assert.Equal(t, "GET, HEAD, OPTIONS", w.Header().Get("Allow"))
+	})
+
+	t.Run("an unknown path is not found", func(t *testing.T) {
+		// WHEN
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, httptest.NewRequest("OPTIONS", "/api/unknown", nil))
+
+		// THEN
+		assert.Equal(t, http.StatusNotFound, w.Code)
+	})
+}
+
+func TestCORS(t *testing.T) {
+	// GIVEN a handler allowing a web UI
+	handler := newHandler(&mockLogService{}, server.WithCORS(server.CORS{Origins: []string{"https://ui.example.com"}}))
+
+	t.Run("a preflight request from an allowed origin is answered", func(t *testing.T) {
+		// WHEN
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest("OPTIONS", "/api/query", nil)
+		r.Header.Set("Origin", "https://ui.example.com")
+		r.Header.Set("Access-Control-Request-Method", "POST")
+		r.Header.Set("Access-Control-Request-Headers", "Content-Type")
+		handler.ServeHTTP(w, r)
+
+		// THEN
+		assert.Equal(t, http.StatusNoContent, w.Code)
+		assert.Equal(t, "https://ui.example.com", w.Header().Get("Access-Control-Allow-Origin"))
+		assert.Equal(t, "POST, OPTIONS", w.Header().Get("Access-Control-Allow-Methods"))
+		assert.Equal(t, "Content-Type, Content-Encoding, X-Request-ID", w.Header().Get("Access-Control-Allow-Headers"))
+		assert.Equal(t, "600", w.Header().Get("Access-Control-Max-Age"))
+	})
+
+	t.Run("a cross-origin GET from an allowed origin can be read", func(t *testing.T) {
+		// WHEN
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest("GET", "/api/describe", nil)
+		r.Header.Set("Origin", "https://ui.example.com")
+		handler.ServeHTTP(w, r)
+
+		// THEN
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Equal(t, "https://ui.example.com", w.Header().Get("Access-Control-Allow-Origin"))
+		assert.Equal(t, "X-Request-ID", w.Header().Get("Access-Control-Expose-Headers"))
+		assert.Contains(t, w.Header()["Vary"], "Origin")
+	})
+
+	t.Run("another origin gets no CORS headers", func(t *testing.T) {
+		// WHEN
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest("OPTIONS", "/api/query", nil)
+		r.Header.Set("Origin", "https://evil.example.com")
+		r.Header.Set("Access-Control-Request-Method", "POST")
+		handler.ServeHTTP(w, r)
+
+		// THEN
+		assert.Empty(t, w.Header().Get("Access-Control-Allow-Origin"))
+		assert.Empty(t, w.Header().Get("Access-Control-Allow-Methods"))
+	})
+
+	t.Run("the configured methods and headers are allowed", func(t *testing.T) {
+		// GIVEN
+		handler := newHandler(&mockLogService{}, server.WithCORS(server.CORS{
+			Origins: []string{"*"},
+			Methods: []string{"GET", "POST"},
+			Headers: []string{"Content-Type"},
+		}))
+
+		// WHEN
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest("OPTIONS", "/api/query", nil)
+		r.Header.Set("Origin", "https://ui.example.com")
+		r.Header.Set("Access-Control-Request-Method", "POST")
+		handler.ServeHTTP(w, r)
+
+		// THEN
+		assert.Equal(t, "https://ui.example.com", w.Header().Get("Access-Control-Allow-Origin"))
+		assert.Equal(t, "GET, POST", w.Header().Get("Access-Control-Allow-Methods"))
+		assert.Equal(t, "Content-Type", w.Header().Get("Access-Control-Allow-Headers"))
+	})
+
+	t.Run("CORS is disabled by default", func(t *testing.T) {
+		// WHEN
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest("GET", "/api/describe", nil)
+		r.Header.Set("Origin", "https://ui.example.com")
+		newHandler(&mockLogService{}).ServeHTTP(w, r)
+
+		// THEN
+		assert.Empty(t, w.Header().Get("Access-Control-Allow-Origin"))
+		assert.NotContains(t, w.Header()["Vary"], "Origin")
+	})
+}
+
+func TestHead(t *testing.T) {
+	// GIVEN
+	handler := newHandler(&mockLogService{})
+
+	t.Run("the GET route responds without a body", func(t *testing.T) {
+		// WHEN
+		get, head := httptest.NewRecorder(), httptest.NewRecorder()
+		handler.ServeHTTP(get, httptest.NewRequest("GET", "/api/describe", nil))
+		handler.ServeHTTP(head, httptest.NewRequest("HEAD", "/api/describe", nil))
+
+		// THEN
+		assert.Equal(t, http.StatusOK, head.Code)
+		assert.Equal(t, get.Header().Get("Content-Type"), head.Header().Get("Content-Type"))
+		assert.NotEmpty(t, get.Body.String())
+		assert.Empty(t, head.Body.String())
+	})
+
+	t.Run("a path without a GET route is not allowed", func(t *testing.T) {
+		// WHEN
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, httptest.NewRequest("HEAD", "/api/query", nil))
+
+		// THEN
+		assert.Equal(t, http.StatusMethodNotAllowed, w.Code)
+		assert.Equal(t, "POST, OPTIONS", w.Header().Get("Allow"))
+	})
+}
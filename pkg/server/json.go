@@ -0,0 +1,259 @@
+package server
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+var (
+	// errJSONTooDeep is returned when a request body is nested too deeply
+	errJSONTooDeep = errors.New("JSON nesting is too deep")
+	// errJSONArrayTooLarge is returned when a request body contains an
+	// array with too many elements
+	errJSONArrayTooLarge = errors.New("JSON array has too many elements")
+	// errTooManyLogs is returned when an ingest request has more logs than
+	// the handler accepts
+	errTooManyLogs = errors.New("request has too many logs")
+	// errInvalidGzip is returned when a gzip request body can't be
+	// decompressed
+	errInvalidGzip = errors.New("invalid gzip body")
+)
+
+// decodeRequest decodes the body of the request into v, after checking that
+// it doesn't exceed the JSON limits of the handler, against the shape of the
+// request, and with the checks specific to the request. A body that isn't
+// valid JSON, that doesn't have the shape, with a field v doesn't have, or
+// with a value that doesn't fit into v returns a `*validationError`.
+func (h *handler) decodeRequest(r *http.Request, v interface{}, s *shape, checks ...func(data []byte) error) error {
+	data, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		return errors.Wrap(err, "reading request body")
+	}
+	if len(bytes.TrimSpace(data)) == 0 {
+		return &validationError{Message: "request body is required"}
+	}
+	if err := checkJSONLimits(data, h.maxJSONDepth, h.maxJSONArray); err != nil {
+		if isJSONSyntaxError(err) {
+			return &validationError{Message: "invalid JSON: " + err.Error()}
+		}
+		return err
+	}
+	for _, check := range checks {
+		if err := check(data); err != nil {
+			return err
+		}
+	}
+	if invalid := checkShape(data, s, ""); invalid != nil {
+		return invalid
+	}
+	// reject unknown fields, so that a misspelled field isn't ignored
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.DisallowUnknownFields()
+	err = decoder.Decode(v)
+	if typeErr, ok := err.(*json.UnmarshalTypeError); ok {
+		return &validationError{Field: typeErr.Field, Message: "invalid value of type " + typeErr.Value}
+	}
+	if field, ok := unknownField(err); ok {
+		return &validationError{Field: field, Message: "unknown field"}
+	}
+	if isJSONSyntaxError(err) {
+		return &validationError{Message: "invalid JSON: " + err.Error()}
+	}
+	if err != nil {
+		return err
+	}
+	if decoder.More() {
+		return &validationError{Message: "invalid JSON: unexpected data after the body"}
+	}
+	return nil
+}
+
+// unknownField returns the name of the field of the error returned by a
+// decoder disallowing unknown fields, which isn't a type of its own
+func unknownField(err error) (string, bool) {
+	const prefix = `json: unknown field "`
+	if err == nil || !strings.HasPrefix(err.Error(), prefix) {
+		return "", false
+	}
+	return strings.TrimSuffix(strings.TrimPrefix(err.Error(), prefix), `"`), true
+}
+
+// isJSONSyntaxError reports whether the error is due to a body that isn't
+// valid JSON, including one that ends early
+func isJSONSyntaxError(err error) bool {
+	_, ok := errors.Cause(err).(*json.SyntaxError)
+	return ok || errors.Cause(err) == io.ErrUnexpectedEOF
+}
+
+// checkJSONLimits walks the tokens of the JSON document without decoding it,
+// and returns an error as soon as the nesting depth or the element count of an
+// array exceeds its limit. A limit of 0 or less is not enforced.
+func checkJSONLimits(data []byte, maxDepth, maxArray int) error {
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	// the number of elements seen in each open array, or -1 for open objects
+	var open []int
+	for {
+		token, err := decoder.Token()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		delim, isDelim := token.(json.Delim)
+		if isDelim && (delim == ']' || delim == '}') {
+			open = open[:len(open)-1]
+			continue
+		}
+
+		// count the element if we're inside an array
+		if n := len(open); n > 0 && open[n-1] >= 0 {
+			open[n-1]++
+			if maxArray > 0 && open[n-1] > maxArray {
+				return errors.Wrapf(errJSONArrayTooLarge, "maximum is %d", maxArray)
+			}
+		}
+
+		if isDelim {
+			if delim == '[' {
+				open = append(open, 0)
+			} else {
+				open = append(open, -1)
+			}
+			if maxDepth > 0 && len(open) > maxDepth {
+				return errors.Wrapf(errJSONTooDeep, "maximum is %d", maxDepth)
+			}
+		}
+	}
+}
+
+// checkIngestLogs returns an `errTooManyLogs` if the `logs` array of the body
+// of an ingest request has more logs than the handler accepts. The logs are
+// counted by walking the tokens of the body, stopping as soon as the limit is
+// exceeded, so that they're never decoded. A body that isn't an object, or
+// whose logs aren't an array, is left to the checks of its shape.
+func (h *handler) checkIngestLogs(data []byte) error {
+	if h.maxIngestLogs <= 0 {
+		return nil
+	}
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	if token, err := decoder.Token(); err != nil || token != json.Delim('{') {
+		return nil
+	}
+	for decoder.More() {
+		key, err := decoder.Token()
+		if err != nil {
+			return nil
+		}
+		if key != "logs" {
+			if err := skipJSONValue(decoder); err != nil {
+				return nil
+			}
+			continue
+		}
+		if token, err := decoder.Token(); err != nil || token != json.Delim('[') {
+			return nil
+		}
+		for count := 1; decoder.More(); count++ {
+			if count > h.maxIngestLogs {
+				return errors.Wrapf(errTooManyLogs, "maximum is %d", h.maxIngestLogs)
+			}
+			if err := skipJSONValue(decoder); err != nil {
+				return nil
+			}
+		}
+		if _, err := decoder.Token(); err != nil {
+			return nil
+		}
+	}
+	return nil
+}
+
+// skipJSONValue reads the next value of the decoder without decoding it,
+// token by token
+func skipJSONValue(decoder *json.Decoder) error {
+	depth := 0
+	for {
+		token, err := decoder.Token()
+		if err != nil {
+			return err
+		}
+		switch token {
+		case json.Delim('{'), json.Delim('['):
+			depth++
+		case json.Delim('}'), json.Delim(']'):
+			depth--
+		}
+		if depth == 0 {
+			return nil
+		}
+	}
+}
+
+// isJSONLimitError reports whether the error is due to the JSON limits
+func isJSONLimitError(err error) bool {
+	cause := errors.Cause(err)
+	return cause == errJSONTooDeep || cause == errJSONArrayTooLarge
+}
+
+// limitBody limits the request body to the maximum body size of the handler,
+// if it has one
+func (h *handler) limitBody(w http.ResponseWriter, body io.ReadCloser) io.ReadCloser {
+	if h.maxBodyBytes <= 0 {
+		return body
+	}
+	return http.MaxBytesReader(w, body, h.maxBodyBytes)
+}
+
+// decompressBody decompresses the body of the request if it has a
+// `Content-Encoding: gzip` header. The decompressed body is limited to the
+// maximum body size too, so that a small compressed body can't expand into
+// more than the handler accepts.
+func (h *handler) decompressBody(w http.ResponseWriter, r *http.Request) error {
+	if !strings.EqualFold(r.Header.Get("Content-Encoding"), "gzip") {
+		return nil
+	}
+	gz, err := gzip.NewReader(r.Body)
+	if err != nil {
+		return errors.Wrap(errInvalidGzip, err.Error())
+	}
+	r.Body = h.limitBody(w, gzipBody{gz})
+	return nil
+}
+
+// gzipBody decompresses a request body. The request body itself is still
+// closed by the handler.
+type gzipBody struct {
+	*gzip.Reader
+}
+
+// Read reads decompressed bytes of the body. An error decompressing the body
+// is an `errInvalidGzip`, while errors reading the body are left as is.
+func (b gzipBody) Read(p []byte) (int, error) {
+	n, err := b.Reader.Read(p)
+	if err != nil && err != io.EOF && !isBodyTooLargeError(err) {
+		err = errors.Wrap(errInvalidGzip, err.Error())
+	}
+	return n, err
+}
+
+// isInvalidGzipError reports whether the error is due to a gzip body that
+// can't be decompressed
+func isInvalidGzipError(err error) bool {
+	return errors.Cause(err) == errInvalidGzip
+}
+
+// isBodyTooLargeError reports whether the error is due to a request body
+// exceeding the maximum body size
+func isBodyTooLargeError(err error) bool {
+	_, ok := errors.Cause(err).(*http.MaxBytesError)
+	return ok
+}
@@ -0,0 +1,32 @@
+package server
+
+import "net/http"
+
+// Middleware wraps a handler with behavior shared by every request, ie:
+// authentication or metrics, so that the handlers of the routes only handle
+// their own request
+type Middleware func(http.Handler) http.Handler
+
+// Chain wraps the handler with the middlewares, in order: the first
+// middleware is the outermost, so it's the first to handle a request and the
+// last to see its response.
+func Chain(handler http.Handler, middlewares ...Middleware) http.Handler {
+	for i := len(middlewares) - 1; i >= 0; i-- {
+		handler = middlewares[i](handler)
+	}
+	return handler
+}
+
+// WithMiddleware adds middlewares around the routes of the handler, which
+// run in order after the request is tagged with its ID, so that they can log
+// it
+func WithMiddleware(middlewares ...Middleware) Option {
+	return func(h *handler) {
+		h.middlewares = append(h.middlewares, middlewares...)
+	}
+}
+
+// chain returns the routes of the handler wrapped with its middlewares
+func (h *handler) chain() http.Handler {
+	return Chain(h, append([]Middleware{requestIDs}, h.middlewares...)...)
+}
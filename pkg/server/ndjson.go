@@ -0,0 +1,89 @@
+package server
+
+import (
+	"encoding/json"
+	"mime"
+	"net/http"
+	"strings"
+
+	"github.com/kolide/databalancer-logan/pkg/logs"
+	"github.com/pkg/errors"
+)
+
+// ndjsonContentType is the media type of newline delimited JSON, where each
+// line of the response is a row of the results
+const ndjsonContentType = "application/x-ndjson"
+
+// acceptsNDJSON reports whether the request accepts newline delimited JSON
+func acceptsNDJSON(r *http.Request) bool {
+	return accepts(r, ndjsonContentType)
+}
+
+// accepts reports whether the `Accept` header of the request lists the media
+// type
+func accepts(r *http.Request, contentType string) bool {
+	for _, accept := range strings.Split(r.Header.Get("Accept"), ",") {
+		mediaType, _, err := mime.ParseMediaType(strings.TrimSpace(accept))
+		if err == nil && mediaType == contentType {
+			return true
+		}
+	}
+	return false
+}
+
+// streamQuery writes the results of the query as newline delimited JSON, a
+// row at a time as they're read from the database, so that the memory of
+// the service stays flat however large the results are. Saved queries are
+// run with their params, and their rows are written as they're returned.
+func (h *handler) streamQuery(w http.ResponseWriter, r *http.Request, query string, args []interface{}, saved string, params map[string]interface{}) {
+	encoder := json.NewEncoder(w)
+	// whether the response has started, after which errors can't change
+	// the status of the response anymore
+	started := false
+	writeRow := func(row map[string]interface{}) error {
+		if !started {
+			w.Header().Set("Content-Type", ndjsonContentType+"; charset=UTF-8")
+			started = true
+		}
+		return encoder.Encode(row)
+	}
+
+	var err error
+	if saved != "" {
+		var results logs.JSON
+		results, _, err = h.logSvc.QuerySaved(r.Context(), saved, params)
+		for _, row := range results {
+			if err = writeRow(row); err != nil {
+				break
+			}
+		}
+	} else {
+		err = h.logSvc.QueryStream(r.Context(), query, args, writeRow)
+	}
+	if err == logs.ErrSavedQueryNotFound {
+		writeJSONError(w, http.StatusNotFound, codeNotFound, "Saved query not found: "+saved)
+		return
+	}
+	if errors.Cause(err) == logs.ErrQueryArgs {
+		writeJSONError(w, http.StatusBadRequest, codeInvalidRequest, "Invalid query args: "+err.Error())
+		return
+	}
+	if errors.Cause(err) == logs.ErrQueryTimeout && !started {
+		writeJSONError(w, http.StatusGatewayTimeout, codeTimeout, "Query timed out: "+err.Error())
+		return
+	}
+	if err != nil && !started {
+		h.writeServiceError(w, err, "querying logs", "query", query, "saved", saved)
+		return
+	}
+	if err != nil {
+		// the rows written so far were already sent, so the response is cut short
+		h.logger.Error("error streaming query results", "query", query, "saved", saved, "err", err)
+		return
+	}
+	if !started {
+		// no rows, so the response is empty
+		w.Header().Set("Content-Type", ndjsonContentType+"; charset=UTF-8")
+		w.WriteHeader(http.StatusOK)
+	}
+}
@@ -0,0 +1,62 @@
+package server
+
+import (
+	"crypto/rand"
+	"fmt"
+	"net/http"
+
+	"github.com/kolide/databalancer-logan/pkg/logs"
+)
+
+// RequestIDHeader is the header of the ID of a request, which is echoed back
+// in the response
+const RequestIDHeader = "X-Request-ID"
+
+// maxRequestIDLength is the maximum length of a request ID sent by a client
+const maxRequestIDLength = 128
+
+// requestIDs tags each request with an ID, which is echoed back in the
+// `X-Request-ID` header of the response, and added to the context of the
+// request for the handlers and the log service
+func requestIDs(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := requestID(r)
+		w.Header().Set(RequestIDHeader, id)
+		next.ServeHTTP(w, r.WithContext(logs.WithRequestID(r.Context(), id)))
+	})
+}
+
+// requestID returns the ID of the request from its `X-Request-ID` header, or
+// a new random UUID if it doesn't have one. An ID longer than
+// `maxRequestIDLength` or with characters other than printable ASCII is
+// replaced as well, so that it can't garble the lines it's logged in.
+func requestID(r *http.Request) string {
+	if id := r.Header.Get(RequestIDHeader); isValidRequestID(id) {
+		return id
+	}
+	return newUUID()
+}
+
+// isValidRequestID reports whether the ID of a request can be used as is
+func isValidRequestID(id string) bool {
+	if id == "" || len(id) > maxRequestIDLength {
+		return false
+	}
+	for i := 0; i < len(id); i++ {
+		if id[i] < '!' || id[i] > '~' {
+			return false
+		}
+	}
+	return true
+}
+
+// newUUID returns a random (version 4) UUID, ie:
+// `f47ac10b-58cc-4372-a567-0e02b2c3d479`
+func newUUID() string {
+	var b [16]byte
+	// crypto/rand doesn't fail on supported platforms
+	rand.Read(b[:])
+	b[6] = b[6]&0x0f | 0x40 // version 4
+	b[8] = b[8]&0x3f | 0x80 // RFC 4122 variant
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:16])
+}
@@ -0,0 +1,155 @@
+package server
+
+import (
+	"context"
+	"log/slog"
+	"net/http"
+	"strings"
+
+	"github.com/kolide/databalancer-logan/pkg/logs"
+)
+
+// route is an API route, which handles the requests with its method and path
+type route struct {
+	method  string
+	path    string // path of the route, where a `{name}` segment matches any segment
+	handler func(h *handler, w http.ResponseWriter, r *http.Request)
+}
+
+// routes are the API routes of the handler
+var routes = []route{
+	{"PUT", "/api/log", (*handler).ingestLogHandler},
+	{"DELETE", "/api/log/{family}", (*handler).dropFamilyHandler},
+	{"GET", "/api/log/{family}/{id}", (*handler).getLogHandler},
+	{"POST", "/api/log/{family}/truncate", (*handler).truncateFamilyHandler},
+	{"POST", "/api/admin/merge", (*handler).mergeFamiliesHandler},
+	{"POST", "/api/query", (*handler).queryHandler},
+	{"POST", "/api/explain", (*handler).explainHandler},
+	{"POST", "/api/search", (*handler).searchHandler},
+	{"POST", "/api/saved-queries", (*handler).saveQueryHandler},
+	{"GET", "/api/describe", (*handler).describeHandler},
+	{"GET", "/api/describe/{family}", (*handler).describeFamilyHandler},
+	{"GET", "/api/families", (*handler).familiesHandler},
+	{"GET", "/api/count/{family}", (*handler).countFamilyHandler},
+	{"GET", "/api/stats", (*handler).statsHandler},
+	{"GET", "/healthz", (*handler).healthzHandler},
+}
+
+// ServeHTTP implements the HandlerFunc interface in the net/http package.
+// It routes the request to the route with its method and path, responding
+// with a 405 if the path only has routes for other methods, and a 404 if
+// no route has the path. A HEAD request is answered by the GET route of its
+// path without the body, and an OPTIONS request with the methods of its path
+// in the `Allow` header. The errors of a request are logged with the ID it
+// was tagged with by the `requestIDs` middleware. Requests from the origins
+// allowed by the CORS policy of the handler get its CORS headers.
+func (h *handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	h = h.withLogger(h.logger.With("request_id", logs.RequestID(r.Context())))
+	crossOrigin := h.setCORSHeaders(w, r)
+
+	// methods of the routes with the path of the request
+	var allowed []string
+	for _, route := range routes {
+		params, ok := matchPath(route.path, r.URL.Path)
+		if !ok {
+			continue
+		}
+		if r.Method == http.MethodHead && route.method == http.MethodGet {
+			w = headResponseWriter{w}
+		} else if route.method != r.Method {
+			allowed = append(allowed, route.method)
+			continue
+		}
+		route.handler(h, w, r.WithContext(context.WithValue(r.Context(), pathParamsKey{}, params)))
+		return
+	}
+
+	if len(allowed) > 0 {
+		methods := allowedMethods(allowed)
+		w.Header().Set("Allow", strings.Join(methods, ", "))
+
+		// handle the methods allowed on the path, which is also the
+		// preflight request of a cross-origin request
+		if r.Method == http.MethodOptions {
+			if crossOrigin && r.Header.Get("Access-Control-Request-Method") != "" {
+				h.setPreflightHeaders(w, methods)
+			}
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+
+		// handle method not allowed
+		writeJSONError(w, http.StatusMethodNotAllowed, codeMethodNotAllowed, "Method not allowed: "+r.Method+" "+r.URL.Path)
+		return
+	}
+
+	// handle route not found
+	writeJSONError(w, http.StatusNotFound, codeNotFound, "Route not found: "+r.Method+" "+r.URL.Path)
+}
+
+// allowedMethods returns the methods allowed on a path with routes for the
+// methods, which are also HEAD for a GET route, and OPTIONS
+func allowedMethods(methods []string) []string {
+	allowed := append([]string(nil), methods...)
+	for _, method := range methods {
+		if method == http.MethodGet {
+			allowed = append(allowed, http.MethodHead)
+			break
+		}
+	}
+	return append(allowed, http.MethodOptions)
+}
+
+// headResponseWriter responds to a HEAD request with the status and headers
+// of the response of its GET route, discarding the body
+type headResponseWriter struct {
+	http.ResponseWriter
+}
+
+// Write discards the body of the response
+func (w headResponseWriter) Write(p []byte) (int, error) {
+	return len(p), nil
+}
+
+// withLogger returns a copy of the handler logging with the logger, ie: to
+// log the lines of a single request with its ID
+func (h *handler) withLogger(logger *slog.Logger) *handler {
+	copied := *h
+	copied.logger = logger
+	return &copied
+}
+
+// pathParamsKey is the context key of the path parameters of a request
+type pathParamsKey struct{}
+
+// pathParam returns a parameter of the path of the request, ie: `family`
+// for a route with a `/api/log/{family}` path
+func pathParam(r *http.Request, name string) string {
+	params, _ := r.Context().Value(pathParamsKey{}).(map[string]string)
+	return params[name]
+}
+
+// matchPath reports whether the path matches the path of a route, and
+// returns the parameters of the path
+func matchPath(routePath, path string) (map[string]string, bool) {
+	routeSegments := strings.Split(routePath, "/")
+	segments := strings.Split(path, "/")
+	if len(routeSegments) != len(segments) {
+		return nil, false
+	}
+
+	params := make(map[string]string)
+	for i, routeSegment := range routeSegments {
+		if strings.HasPrefix(routeSegment, "{") && strings.HasSuffix(routeSegment, "}") {
+			if segments[i] == "" {
+				return nil, false
+			}
+			params[strings.Trim(routeSegment, "{}")] = segments[i]
+			continue
+		}
+		if routeSegment != segments[i] {
+			return nil, false
+		}
+	}
+	return params, true
+}
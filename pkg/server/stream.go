@@ -0,0 +1,233 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+
+	"github.com/kolide/databalancer-logan/pkg/logs"
+	"github.com/pkg/errors"
+)
+
+// ingestStream decodes the logs of an ingest request as its body is read, a
+// batch at a time, so that the logs of a large request are never all held
+// at once
+type ingestStream struct {
+	h       *handler
+	decoder *json.Decoder
+	body    *ingestRequest // fields of the request before its logs
+	batch   logs.JSON      // first batch of logs, decoded before the request is streamed
+	count   int            // number of logs decoded
+	done    bool           // whether the whole body was decoded
+}
+
+// streamIngest returns the stream of the logs of an ingest request, if the
+// request has more logs than a batch, and its family and schema come before
+// its logs. Otherwise, the logs fit in a batch or can't be checked until the
+// whole body is read, so it returns nil, and the request body is replaced
+// with the whole body, to be decoded at once. Anything unexpected before the
+// logs, ie: an unknown field, also leaves the body to be decoded at once, so
+// that it's reported the same way.
+func (h *handler) streamIngest(r *http.Request) *ingestStream {
+	if h.ingestBatchSize <= 0 {
+		return nil
+	}
+	read := &replayBuffer{}
+	body := r.Body
+	stream := &ingestStream{
+		h:       h,
+		decoder: json.NewDecoder(io.TeeReader(body, read)),
+		body:    &ingestRequest{},
+	}
+	if !stream.start() {
+		r.Body = ioutil.NopCloser(io.MultiReader(bytes.NewReader(read.Bytes()), body))
+		return nil
+	}
+	// the body won't be decoded again, so it's no longer kept
+	read.stop()
+	return stream
+}
+
+// start decodes the fields of the request before its logs, and its first
+// batch of logs, and reports whether the rest of its logs can be streamed
+func (s *ingestStream) start() bool {
+	// the logs are nested in an object and an array
+	if s.h.maxJSONDepth > 0 && s.h.maxJSONDepth <= 2 {
+		return false
+	}
+	if token, err := s.decoder.Token(); err != nil || token != json.Delim('{') {
+		return false
+	}
+	for {
+		if !s.decoder.More() {
+			return false
+		}
+		token, err := s.decoder.Token()
+		if err != nil {
+			return false
+		}
+		field, _ := token.(string)
+		if field == "logs" {
+			break
+		}
+		fieldShape, ok := ingestShape.fields[field]
+		if !ok {
+			return false
+		}
+		var value json.RawMessage
+		if err := s.decoder.Decode(&value); err != nil {
+			return false
+		}
+		if checkShape(value, fieldShape, field) != nil || checkJSONLimits(value, 0, s.h.maxJSONArray) != nil {
+			return false
+		}
+		switch field {
+		case "family":
+			err = json.Unmarshal(value, &s.body.Family)
+		case "schema":
+			err = json.Unmarshal(value, &s.body.Schema)
+		case "unique":
+			err = json.Unmarshal(value, &s.body.Unique)
+		}
+		if err != nil {
+			return false
+		}
+	}
+	if s.body.Schema == nil || s.body.validateFields() != nil {
+		return false
+	}
+	if token, err := s.decoder.Token(); err != nil || token != json.Delim('[') {
+		return false
+	}
+
+	for len(s.batch) < s.h.ingestBatchSize && s.decoder.More() {
+		record, err := s.decodeLog()
+		if err != nil {
+			return false
+		}
+		s.batch = append(s.batch, record)
+	}
+	return s.decoder.More()
+}
+
+// next returns the next batch of logs, or `io.EOF` once every log was
+// returned and the rest of the body decoded
+func (s *ingestStream) next() (logs.JSON, error) {
+	if s.batch != nil {
+		batch := s.batch
+		s.batch = nil
+		return batch, nil
+	}
+	if s.done {
+		return nil, io.EOF
+	}
+
+	batch := make(logs.JSON, 0, s.h.ingestBatchSize)
+	for len(batch) < s.h.ingestBatchSize && s.decoder.More() {
+		record, err := s.decodeLog()
+		if err != nil {
+			return nil, decodeError(err)
+		}
+		batch = append(batch, record)
+	}
+	if !s.decoder.More() {
+		if err := s.finish(); err != nil {
+			return nil, decodeError(err)
+		}
+		s.done = true
+	}
+	if len(batch) == 0 {
+		return nil, io.EOF
+	}
+	return batch, nil
+}
+
+// decodeLog decodes the next log of the request, after checking it against
+// the limits of the handler
+func (s *ingestStream) decodeLog() (map[string]interface{}, error) {
+	if s.h.maxIngestLogs > 0 && s.count >= s.h.maxIngestLogs {
+		return nil, errors.Wrapf(errTooManyLogs, "maximum is %d", s.h.maxIngestLogs)
+	}
+	if s.h.maxJSONArray > 0 && s.count >= s.h.maxJSONArray {
+		return nil, errors.Wrapf(errJSONArrayTooLarge, "maximum is %d", s.h.maxJSONArray)
+	}
+	var data json.RawMessage
+	if err := s.decoder.Decode(&data); err != nil {
+		return nil, err
+	}
+	maxDepth := s.h.maxJSONDepth
+	if maxDepth > 0 {
+		maxDepth -= 2
+	}
+	if err := checkJSONLimits(data, maxDepth, s.h.maxJSONArray); err != nil {
+		return nil, err
+	}
+	var record interface{}
+	if err := json.Unmarshal(data, &record); err != nil {
+		return nil, err
+	}
+	object, ok := record.(map[string]interface{})
+	if !ok {
+		return nil, invalidLog(s.count)
+	}
+	s.count++
+	return object, nil
+}
+
+// finish decodes the end of the body after its logs. Since the logs before
+// are already ingested, a field after them is rejected rather than applied.
+func (s *ingestStream) finish() error {
+	if _, err := s.decoder.Token(); err != nil {
+		return err
+	}
+	if s.decoder.More() {
+		token, err := s.decoder.Token()
+		if err != nil {
+			return err
+		}
+		return &validationError{
+			Field:   fmt.Sprint(token),
+			Message: fmt.Sprintf("field must come before the logs of a request with more than %d logs", s.h.ingestBatchSize),
+		}
+	}
+	if _, err := s.decoder.Token(); err != nil {
+		return err
+	}
+	if _, err := s.decoder.Token(); err != io.EOF {
+		return &validationError{Message: "invalid JSON: unexpected data after the body"}
+	}
+	return nil
+}
+
+// decodeError returns a `*validationError` for an error decoding a body that
+// isn't valid JSON, like `decodeRequest`, and any other error as is
+func decodeError(err error) error {
+	if isJSONSyntaxError(err) {
+		return &validationError{Message: "invalid JSON: " + err.Error()}
+	}
+	return err
+}
+
+// replayBuffer keeps the bytes read from a body until it's stopped, so that
+// they can be read again
+type replayBuffer struct {
+	bytes.Buffer
+	stopped bool
+}
+
+// Write keeps the bytes, unless the buffer is stopped
+func (b *replayBuffer) Write(p []byte) (int, error) {
+	if b.stopped {
+		return len(p), nil
+	}
+	return b.Buffer.Write(p)
+}
+
+// stop stops keeping the bytes read, and releases those kept
+func (b *replayBuffer) stop() {
+	b.stopped = true
+	b.Buffer = bytes.Buffer{}
+}
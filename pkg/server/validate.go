@@ -0,0 +1,323 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/kolide/databalancer-logan/pkg/logs"
+	"github.com/pkg/errors"
+)
+
+// validationError describes the field of a request that is invalid, which
+// is reported in the body of the 400 response to the request
+type validationError struct {
+	Message string // what's wrong with the field
+	Field   string // path of the field in the request, ie: `logs[2]`, empty for the whole body
+}
+
+func (e *validationError) Error() string {
+	if e.Field == "" {
+		return e.Message
+	}
+	return e.Field + ": " + e.Message
+}
+
+// writeValidationError responds to a request with an invalid field, with
+// the path of the field in the error
+func (h *handler) writeValidationError(w http.ResponseWriter, err *validationError) {
+	writeErrorResponse(w, http.StatusBadRequest, validationErrorResponse(err))
+}
+
+// validationErrorResponse returns the body of the response to a request with
+// an invalid field
+func validationErrorResponse(err *validationError) errorResponse {
+	return errorResponse{Message: err.Message, Code: codeInvalidRequest, Field: err.Field}
+}
+
+// writeFieldErrors responds to an ingest request with every field of its
+// logs that doesn't match the schema
+func writeFieldErrors(w http.ResponseWriter, fieldErrs logs.FieldErrors) {
+	writeErrorResponse(w, http.StatusBadRequest, fieldErrorsResponse(fieldErrs))
+}
+
+// fieldErrorsResponse returns the body of the response to an ingest request
+// with every field of its logs that doesn't match the schema
+func fieldErrorsResponse(fieldErrs logs.FieldErrors) errorResponse {
+	body := errorResponse{
+		Message: fmt.Sprintf("%d fields of the logs are invalid", len(fieldErrs)),
+		Code:    codeInvalidRequest,
+	}
+	for _, fieldErr := range fieldErrs {
+		body.Errors = append(body.Errors, fieldErrorResponse{Message: fieldErr.Message, Field: logFieldPath(fieldErr)})
+	}
+	return body
+}
+
+// logFieldPath returns the path of the invalid field of a log in an ingest
+// request, ie: `logs[2].age`
+func logFieldPath(fieldErr *logs.FieldError) string {
+	return fmt.Sprintf("logs[%d].%s", fieldErr.Index, fieldErr.Field)
+}
+
+// shape is the expected structure of a JSON value of a request body. A body
+// is checked against the shape of its request before it's decoded, so that a
+// value of the wrong type is reported with its path, ie: `unique[1]`. A null
+// value has any shape, since it's decoded as the zero value.
+type shape struct {
+	types  []string          // JSON types of the value, ie: "string", or any type if empty
+	fields map[string]*shape // shapes of the fields of an object, whose other fields aren't checked
+	values *shape            // shape of every value of an object
+	items  *shape            // shape of every item of an array
+}
+
+// ingestShape is the shape of the body of an ingest request
+var ingestShape = &shape{
+	types: []string{"object"},
+	fields: map[string]*shape{
+		"family": {types: []string{"string"}},
+		"schema": {types: []string{"object"}, values: &shape{types: []string{"string"}}},
+		"unique": {types: []string{"array"}, items: &shape{types: []string{"string"}}},
+		"logs":   {types: []string{"array", "object"}},
+	},
+}
+
+// queryShape is the shape of the body of a query request
+var queryShape = &shape{
+	types: []string{"object"},
+	fields: map[string]*shape{
+		"query":  {types: []string{"string"}},
+		"args":   {types: []string{"array"}},
+		"saved":  {types: []string{"string"}},
+		"params": {types: []string{"object"}},
+		"limit":  {types: []string{"number"}},
+		"offset": {types: []string{"number"}},
+	},
+}
+
+// explainShape is the shape of the body of an explain request
+var explainShape = &shape{
+	types: []string{"object"},
+	fields: map[string]*shape{
+		"query": {types: []string{"string"}},
+		"args":  {types: []string{"array"}},
+	},
+}
+
+// saveQueryShape is the shape of the body of a request saving a query
+var saveQueryShape = &shape{
+	types: []string{"object"},
+	fields: map[string]*shape{
+		"name":  {types: []string{"string"}},
+		"query": {types: []string{"string"}},
+	},
+}
+
+// mergeShape is the shape of the body of a request merging families
+var mergeShape = &shape{
+	types: []string{"object"},
+	fields: map[string]*shape{
+		"target":        {types: []string{"string"}},
+		"sources":       {types: []string{"array"}, items: &shape{types: []string{"string"}}},
+		"pattern":       {types: []string{"string"}},
+		"source_column": {types: []string{"string"}},
+		"drop_sources":  {types: []string{"bool"}},
+	},
+}
+
+// searchShape is the shape of the body of a search request
+var searchShape = &shape{
+	types: []string{"object"},
+	fields: map[string]*shape{
+		"family": {types: []string{"string"}},
+		"where": {types: []string{"array"}, items: &shape{
+			types: []string{"object"},
+			fields: map[string]*shape{
+				"field": {types: []string{"string"}},
+				"op":    {types: []string{"string"}},
+				"value": {types: []string{"string", "number", "bool", "array"}},
+			},
+		}},
+		"limit": {types: []string{"number"}},
+	},
+}
+
+// jsonType returns the type of a JSON value, named like the types of
+// `json.UnmarshalTypeError`
+func jsonType(data json.RawMessage) string {
+	data = bytes.TrimSpace(data)
+	if len(data) == 0 {
+		return ""
+	}
+	switch data[0] {
+	case '{':
+		return "object"
+	case '[':
+		return "array"
+	case '"':
+		return "string"
+	case 't', 'f':
+		return "bool"
+	case 'n':
+		return "null"
+	default:
+		return "number"
+	}
+}
+
+// checkShape checks a JSON value against its shape, and returns the first
+// value with the wrong type, in the order of the fields and items of the
+// value. The path is the path of the value in the body.
+func checkShape(data json.RawMessage, s *shape, path string) *validationError {
+	valueType := jsonType(data)
+	if valueType == "null" {
+		return nil
+	}
+	if len(s.types) > 0 && !contains(s.types, valueType) {
+		return &validationError{Field: path, Message: "invalid value of type " + valueType}
+	}
+
+	switch {
+	case valueType == "object" && (s.fields != nil || s.values != nil):
+		var object map[string]json.RawMessage
+		if err := json.Unmarshal(data, &object); err != nil {
+			return &validationError{Message: "invalid JSON: " + err.Error()}
+		}
+		keys := make([]string, 0, len(object))
+		for key := range object {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			fieldShape := s.values
+			if known, ok := s.fields[key]; ok {
+				fieldShape = known
+			}
+			if fieldShape == nil {
+				continue
+			}
+			fieldPath := key
+			if path != "" {
+				fieldPath = path + "." + key
+			}
+			if invalid := checkShape(object[key], fieldShape, fieldPath); invalid != nil {
+				return invalid
+			}
+		}
+	case valueType == "array" && s.items != nil:
+		var items []json.RawMessage
+		if err := json.Unmarshal(data, &items); err != nil {
+			return &validationError{Message: "invalid JSON: " + err.Error()}
+		}
+		for i, item := range items {
+			if invalid := checkShape(item, s.items, fmt.Sprintf("%s[%d]", path, i)); invalid != nil {
+				return invalid
+			}
+		}
+	}
+	return nil
+}
+
+// contains reports whether the values contain the value
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+// ingestRequest is the body of a request to ingest logs
+type ingestRequest struct {
+	Family logs.Family `json:"family"`
+	Schema logs.Schema `json:"schema"`
+	Unique []string    `json:"unique"` // fields that logs are upserted on, if set
+	Logs   ingestLogs  `json:"logs"`   // decoded as is, so that logs which aren't objects can be reported
+}
+
+// ingestLogs are the logs of an ingest request, which are either a list of
+// logs or a single log object
+type ingestLogs []interface{}
+
+// UnmarshalJSON decodes a list of logs, or a single log object as a list of
+// one log
+func (l *ingestLogs) UnmarshalJSON(data []byte) error {
+	if trimmed := bytes.TrimSpace(data); len(trimmed) > 0 && trimmed[0] == '{' {
+		var record map[string]interface{}
+		if err := json.Unmarshal(trimmed, &record); err != nil {
+			return err
+		}
+		*l = ingestLogs{record}
+		return nil
+	}
+	var records []interface{}
+	if err := json.Unmarshal(data, &records); err != nil {
+		// name the field, since its decoder doesn't know the field it's in
+		if typeErr, ok := err.(*json.UnmarshalTypeError); ok {
+			typeErr.Field = "logs"
+		}
+		return err
+	}
+	*l = records
+	return nil
+}
+
+// validate checks the request before any logs are ingested, and returns its
+// logs if it's valid
+func (req *ingestRequest) validate() (logs.JSON, *validationError) {
+	if invalid := req.validateFields(); invalid != nil {
+		return nil, invalid
+	}
+	if len(req.Logs) == 0 {
+		return nil, &validationError{Field: "logs", Message: "logs are required"}
+	}
+
+	records := make(logs.JSON, 0, len(req.Logs))
+	for i, record := range req.Logs {
+		object, ok := record.(map[string]interface{})
+		if !ok {
+			return nil, invalidLog(i)
+		}
+		records = append(records, object)
+	}
+	return records, nil
+}
+
+// invalidLog describes a log of a request that isn't an object
+func invalidLog(index int) *validationError {
+	return &validationError{Field: fmt.Sprintf("logs[%d]", index), Message: "log is not an object"}
+}
+
+// validateFields checks the fields of the request besides its logs
+func (req *ingestRequest) validateFields() *validationError {
+	if req.Family == "" {
+		return &validationError{Field: "family", Message: "family is required"}
+	}
+	if err := req.Family.Validate(); err != nil {
+		return &validationError{Field: "family", Message: errors.Cause(err).Error()}
+	}
+	for field, fieldType := range req.Schema {
+		if strings.TrimSpace(fieldType) == "" {
+			return &validationError{Field: "schema." + field, Message: "type is required"}
+		}
+		parsed, err := logs.ParseFieldType(fieldType)
+		if err != nil {
+			return &validationError{Field: "schema." + field, Message: err.Error()}
+		}
+		if !logs.SupportedType(parsed.Name) {
+			return &validationError{Field: "schema." + field, Message: fmt.Sprintf("unknown type %s", parsed.Name)}
+		}
+	}
+	// without a schema, the service checks the unique fields against the
+	// schema of the table of the family
+	for i, field := range req.Unique {
+		if _, ok := req.Schema[field]; !ok && req.Schema != nil {
+			return &validationError{Field: fmt.Sprintf("unique[%d]", i), Message: fmt.Sprintf("field %s is not in the schema", field)}
+		}
+	}
+	return nil
+}